@@ -2,6 +2,7 @@ package SingleKVDataSet
 
 import (
 	"SingleKVDataSet/data"
+	"SingleKVDataSet/index"
 	"encoding/binary"
 	"sync"
 	"sync/atomic"
@@ -120,24 +121,31 @@ func (wb *WriteBatch) Commit() error {
 		}
 	}
 
-	// 更新内存索引
+	// 等待其他写入正在进行的索引更新完成，保证同一个 key 的索引按照写入顺序更新
+	wb.db.lockAllKeys()
+	defer wb.db.unlockAllKeys()
+
+	// 更新内存索引，一批数据一次更新完成
+	ops := make([]index.IndexOp, 0, len(wb.pendingWrites))
 	for _, record := range wb.pendingWrites {
-		pos := positions[string(record.Key)]
-		var oldPos *data.LogRecordPos
-		if record.Type == data.LogRecordNormal {
-			oldPos = wb.db.index.Put(record.Key, pos)
-		}
-		if record.Type == data.LogRecordDeleted {
-			oldPos, _ = wb.db.index.Delete(record.Key)
+		if record.Type != data.LogRecordNormal && record.Type != data.LogRecordDeleted {
+			continue
 		}
+		ops = append(ops, index.IndexOp{
+			Key:    record.Key,
+			Pos:    positions[string(record.Key)],
+			Delete: record.Type == data.LogRecordDeleted,
+		})
+	}
+	for _, oldPos := range wb.db.index.ApplyBatch(ops) {
 		if oldPos != nil {
-			wb.db.reclaimSize += int64(oldPos.Size)
+			atomic.AddInt64(&wb.db.reclaimSize, int64(oldPos.Size))
 		}
 	}
 
 	// 清空暂存数据
 	wb.pendingWrites = make(map[string]*data.LogRecord)
-	return nil
+	return wb.db.indexErr()
 }
 
 // key+Seq Number 编码
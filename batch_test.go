@@ -119,3 +119,39 @@ func TestDB_NewReadBatch2(t *testing.T) {
 //	err = wb.Commit()
 //	assert.Nil(t, err)
 //}
+
+func TestDB_WriteBatch_BPlusTree(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("./TestingFile", "bitcask-go-batch-bptree")
+	// B+ 树索引只能在新建的数据库上直接使用 WriteBatch
+	opts.DirPath = dir + "/db"
+	opts.IndexType = BPlusTree
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	// 一批数据的索引在一个事务中更新
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), utils.RandomValue(10)))
+	}
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, wb.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, wb.Commit())
+	assert.Equal(t, 990, len(db.ListKeys()))
+	// 被覆盖和删除的数据可以回收
+	assert.True(t, db.Stat().ReclaimableSize > 0)
+
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 990, len(db2.ListKeys()))
+	_, err = db2.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db2.Get(utils.GetTestKey(100))
+	assert.Nil(t, err)
+}
@@ -0,0 +1,107 @@
+package benchmark
+
+import (
+	bitcask "SingleKVDataSet"
+	"SingleKVDataSet/data"
+	"SingleKVDataSet/index"
+	"SingleKVDataSet/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync/atomic"
+	"testing"
+)
+
+// 对比不同索引类型在多个协程并发读写时的性能
+var indexTypes = []struct {
+	name      string
+	indexType bitcask.IndexerType
+}{
+	{"BTree", bitcask.BTree},
+	{"ART", bitcask.ART},
+	{"BPlusTree", bitcask.BPlusTree},
+	{"ShardedBTree", bitcask.ShardedBTree},
+	{"HashIndex", bitcask.HashIndex},
+	{"SkipList", bitcask.SkipList},
+	{"HybridIndex", bitcask.HybridIndex},
+}
+
+func Benchmark_Index_Put_Parallel(b *testing.B) {
+	for _, typ := range indexTypes {
+		b.Run(typ.name, func(b *testing.B) {
+			dir, _ := os.MkdirTemp("", "bitcask-go-index-bench")
+			defer os.RemoveAll(dir)
+			indexer, err := index.NewIndexer(typ.indexType, dir, false)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer indexer.Close()
+
+			var counter int64
+			b.ResetTimer()
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					i := atomic.AddInt64(&counter, 1)
+					indexer.Put(utils.GetTestKey(int(i)), &data.LogRecordPos{Fid: 1, Offset: i})
+				}
+			})
+		})
+	}
+}
+
+func Benchmark_Index_Get_Parallel(b *testing.B) {
+	for _, typ := range indexTypes {
+		b.Run(typ.name, func(b *testing.B) {
+			dir, _ := os.MkdirTemp("", "bitcask-go-index-bench")
+			defer os.RemoveAll(dir)
+			indexer, err := index.NewIndexer(typ.indexType, dir, false)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer indexer.Close()
+			for i := 0; i < 100000; i++ {
+				indexer.Put(utils.GetTestKey(i), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+			}
+
+			var counter int64
+			b.ResetTimer()
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					i := atomic.AddInt64(&counter, 1)
+					indexer.Get(utils.GetTestKey(int(i % 100000)))
+				}
+			})
+		})
+	}
+}
+
+func Benchmark_DB_Put_Parallel(b *testing.B) {
+	for _, typ := range indexTypes {
+		b.Run(typ.name, func(b *testing.B) {
+			opts := bitcask.DefaultOptions
+			dir, _ := os.MkdirTemp("", "bitcask-go-db-bench")
+			opts.DirPath = dir
+			opts.IndexType = typ.indexType
+			db, err := bitcask.Open(opts)
+			assert.Nil(b, err)
+			defer func() {
+				_ = db.Close()
+				_ = os.RemoveAll(dir)
+			}()
+
+			var counter int64
+			value := utils.RandomValue(128)
+			b.ResetTimer()
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					i := atomic.AddInt64(&counter, 1)
+					if err := db.Put(utils.GetTestKey(int(i)), value); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// 每个缓存项除 value 之外额外占用的内存估算值（key + 链表节点 + map 项）
+const entryOverhead = 64
+
+// Key 缓存的 key，由数据文件 id 和记录在文件中的偏移组成
+// 数据文件只追加写入，同一个位置的记录不会改变；merge 生成的文件只在下一次打开数据库时替换旧文件，
+// 此时缓存还是空的，所以缓存项在数据库运行期间不会失效
+type Key struct {
+	Fid    uint32
+	Offset int64
+}
+
+type entry struct {
+	key   Key
+	value []byte
+}
+
+// Stats 缓存的统计信息
+type Stats struct {
+	Hits      uint64 // 命中次数
+	Misses    uint64 // 未命中次数
+	Evictions uint64 // 淘汰次数
+	Size      int64  // 当前占用的内存大小，单位为字节
+}
+
+// LRU 基于最近最少使用策略的 value 缓存
+// 读写都会调整链表顺序，所以内部使用互斥锁，可以被持有 db.mu 读锁的多个协程并发访问
+type LRU struct {
+	capacity  int64 // 内存上限，单位为字节
+	size      int64 // 当前占用的内存
+	ll        *list.List
+	items     map[Key]*list.Element
+	mu        *sync.Mutex
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+// NewLRU 初始化 LRU 缓存，capacity 为内存上限
+func NewLRU(capacity int64) *LRU {
+	return &LRU{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[Key]*list.Element),
+		mu:       new(sync.Mutex),
+	}
+}
+
+// Get 获取缓存的 value，返回的是一份拷贝，调用方可以随意修改
+func (c *LRU) Get(key Key) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		atomic.AddUint64(&c.misses, 1)
+		return nil, false
+	}
+	atomic.AddUint64(&c.hits, 1)
+	c.ll.MoveToFront(elem)
+	value := elem.Value.(*entry).value
+	buf := make([]byte, len(value))
+	copy(buf, value)
+	return buf, true
+}
+
+// Put 写入缓存，超过内存上限时淘汰最久未被访问的数据
+func (c *LRU) Put(key Key, value []byte) {
+	cost := int64(len(value)) + entryOverhead
+	// 单个 value 比整个缓存还大，没有缓存的必要
+	if cost > c.capacity {
+		return
+	}
+	buf := make([]byte, len(value))
+	copy(buf, value)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		ent := elem.Value.(*entry)
+		c.size += int64(len(buf)) - int64(len(ent.value))
+		ent.value = buf
+		c.ll.MoveToFront(elem)
+	} else {
+		c.items[key] = c.ll.PushFront(&entry{key: key, value: buf})
+		c.size += cost
+	}
+
+	for c.size > c.capacity {
+		c.removeOldest()
+	}
+}
+
+// Remove 删除指定的缓存项
+func (c *LRU) Remove(key Key) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[key]; ok {
+		c.removeElement(elem)
+	}
+}
+
+// Purge 清空所有缓存
+func (c *LRU) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.items = make(map[Key]*list.Element)
+	c.size = 0
+}
+
+// Stats 返回缓存的统计信息
+func (c *LRU) Stats() Stats {
+	c.mu.Lock()
+	size := c.size
+	c.mu.Unlock()
+	return Stats{
+		Hits:      atomic.LoadUint64(&c.hits),
+		Misses:    atomic.LoadUint64(&c.misses),
+		Evictions: atomic.LoadUint64(&c.evictions),
+		Size:      size,
+	}
+}
+
+// 淘汰最久未访问的数据，调用前需要持有锁
+func (c *LRU) removeOldest() {
+	elem := c.ll.Back()
+	if elem == nil {
+		return
+	}
+	c.removeElement(elem)
+	atomic.AddUint64(&c.evictions, 1)
+}
+
+func (c *LRU) removeElement(elem *list.Element) {
+	ent := c.ll.Remove(elem).(*entry)
+	delete(c.items, ent.key)
+	c.size -= int64(len(ent.value)) + entryOverhead
+}
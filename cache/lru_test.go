@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func TestLRU_PutGet(t *testing.T) {
+	c := NewLRU(1024)
+
+	_, ok := c.Get(Key{Fid: 1, Offset: 0})
+	assert.False(t, ok)
+
+	c.Put(Key{Fid: 1, Offset: 0}, []byte("value-a"))
+	val, ok := c.Get(Key{Fid: 1, Offset: 0})
+	assert.True(t, ok)
+	assert.Equal(t, []byte("value-a"), val)
+
+	// 修改返回值不能影响缓存中的数据
+	val[0] = 'x'
+	val2, ok := c.Get(Key{Fid: 1, Offset: 0})
+	assert.True(t, ok)
+	assert.Equal(t, []byte("value-a"), val2)
+
+	stats := c.Stats()
+	assert.Equal(t, uint64(2), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+}
+
+func TestLRU_Evict(t *testing.T) {
+	// 只能放下两个缓存项
+	c := NewLRU(2 * (entryOverhead + 10))
+	c.Put(Key{Fid: 0, Offset: 0}, make([]byte, 10))
+	c.Put(Key{Fid: 0, Offset: 10}, make([]byte, 10))
+
+	// 访问第一个，让第二个成为最久未访问的
+	_, ok := c.Get(Key{Fid: 0, Offset: 0})
+	assert.True(t, ok)
+
+	c.Put(Key{Fid: 0, Offset: 20}, make([]byte, 10))
+	_, ok = c.Get(Key{Fid: 0, Offset: 10})
+	assert.False(t, ok)
+	_, ok = c.Get(Key{Fid: 0, Offset: 0})
+	assert.True(t, ok)
+	assert.Equal(t, uint64(1), c.Stats().Evictions)
+
+	// 超过容量的 value 不会被缓存
+	c.Put(Key{Fid: 0, Offset: 30}, make([]byte, 1024))
+	_, ok = c.Get(Key{Fid: 0, Offset: 30})
+	assert.False(t, ok)
+}
+
+func TestLRU_Purge(t *testing.T) {
+	c := NewLRU(1024)
+	c.Put(Key{Fid: 1, Offset: 0}, []byte("a"))
+	c.Put(Key{Fid: 1, Offset: 1}, []byte("b"))
+	c.Remove(Key{Fid: 1, Offset: 0})
+	_, ok := c.Get(Key{Fid: 1, Offset: 0})
+	assert.False(t, ok)
+
+	c.Purge()
+	_, ok = c.Get(Key{Fid: 1, Offset: 1})
+	assert.False(t, ok)
+	assert.Equal(t, int64(0), c.Stats().Size)
+}
+
+func TestLRU_Concurrent(t *testing.T) {
+	c := NewLRU(4096)
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				key := Key{Fid: uint32(n), Offset: int64(j % 50)}
+				c.Put(key, []byte("value"))
+				c.Get(key)
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.True(t, c.Stats().Size <= 4096)
+}
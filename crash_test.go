@@ -0,0 +1,186 @@
+package SingleKVDataSet
+
+import (
+	"SingleKVDataSet/fio"
+	"SingleKVDataSet/utils"
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"testing"
+)
+
+// 崩溃恢复测试：在随机的写入位置让数据库崩溃，重启后校验数据是一致的前缀
+
+// 打开一个注入了故障的内存数据库
+func openFaultDB(t *testing.T, name string, round int) (*DB, *fio.FaultInjector, Options) {
+	opts := memoryTestOptions(t, fmt.Sprintf("bitcask-go-crash-%s-%d", name, round))
+	opts.DataFileSize = 16 * 1024
+	opts.DataFileMergeRatio = 0
+
+	injector := fio.NewFaultInjector(int64(round))
+	opts.FaultInjector = injector
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	return db, injector, opts
+}
+
+func crashTestValue(i int) []byte {
+	return bytes.Repeat(utils.GetTestKey(i), 4)
+}
+
+// 模拟进程被杀掉之后重启，断电时会丢弃未持久化的数据
+func restartAfterCrash(t *testing.T, injector *fio.FaultInjector, opts Options, powerLoss bool) *DB {
+	if powerLoss {
+		assert.Nil(t, injector.PowerLoss())
+	}
+	injector.Reset()
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	return db
+}
+
+func TestDB_Crash_Put(t *testing.T) {
+	const keyNum = 500
+	for round := 0; round < 30; round++ {
+		r := rand.New(rand.NewSource(int64(round)))
+		db, injector, opts := openFaultDB(t, "put", round)
+		db.options.SyncWrites = round%2 == 0
+
+		injector.CrashAt(1 + r.Intn(keyNum+100))
+		var acked int
+		for ; acked < keyNum; acked++ {
+			if err := db.Put(utils.GetTestKey(acked), crashTestValue(acked)); err != nil {
+				break
+			}
+		}
+
+		powerLoss := round%3 == 0
+		opts.SyncWrites = db.options.SyncWrites
+		db2 := restartAfterCrash(t, injector, opts, powerLoss)
+
+		// 恢复的数据必须是写入顺序的一个前缀
+		var recovered int
+		for ; recovered < keyNum; recovered++ {
+			val, err := db2.Get(utils.GetTestKey(recovered))
+			if err == ErrKeyNotFound {
+				break
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, crashTestValue(recovered), val)
+		}
+		for i := recovered; i < keyNum; i++ {
+			_, err := db2.Get(utils.GetTestKey(i))
+			assert.Equal(t, ErrKeyNotFound, err)
+		}
+		// 没有断电或者每次写入都持久化时，已经返回成功的写入不能丢失
+		if !powerLoss || opts.SyncWrites {
+			assert.True(t, recovered >= acked, "round %d: recovered %d, acked %d", round, recovered, acked)
+		}
+
+		// 恢复后可以继续写入
+		err := db2.Put(utils.GetTestKey(keyNum), crashTestValue(keyNum))
+		assert.Nil(t, err)
+		val, err := db2.Get(utils.GetTestKey(keyNum))
+		assert.Nil(t, err)
+		assert.Equal(t, crashTestValue(keyNum), val)
+		assert.Nil(t, db2.Close())
+	}
+}
+
+func TestDB_Crash_WriteBatch(t *testing.T) {
+	const batchNum, batchSize = 50, 10
+	for round := 0; round < 30; round++ {
+		r := rand.New(rand.NewSource(int64(round)))
+		db, injector, opts := openFaultDB(t, "batch", round)
+
+		injector.CrashAt(1 + r.Intn(batchNum*(batchSize+1)+50))
+		var acked int
+		for ; acked < batchNum; acked++ {
+			wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+			for i := acked * batchSize; i < (acked+1)*batchSize; i++ {
+				assert.Nil(t, wb.Put(utils.GetTestKey(i), crashTestValue(i)))
+			}
+			if err := wb.Commit(); err != nil {
+				break
+			}
+		}
+
+		db2 := restartAfterCrash(t, injector, opts, round%2 == 0)
+
+		// 每个批次要么全部可见，要么全部不可见，并且可见的批次是一个前缀
+		var recovered int
+		for batch := 0; batch < batchNum; batch++ {
+			var found int
+			for i := batch * batchSize; i < (batch+1)*batchSize; i++ {
+				val, err := db2.Get(utils.GetTestKey(i))
+				if err == ErrKeyNotFound {
+					continue
+				}
+				assert.Nil(t, err)
+				assert.Equal(t, crashTestValue(i), val)
+				found++
+			}
+			assert.True(t, found == 0 || found == batchSize, "round %d: batch %d partially visible", round, batch)
+			if found == batchSize {
+				assert.Equal(t, recovered, batch, "round %d: batch %d visible after a missing batch", round, batch)
+				recovered++
+			}
+		}
+		// 事务提交时会持久化，已经提交成功的批次即使断电也不能丢失
+		assert.True(t, recovered >= acked, "round %d: recovered %d, acked %d", round, recovered, acked)
+		assert.Nil(t, db2.Close())
+	}
+}
+
+func TestDB_Crash_Merge(t *testing.T) {
+	const keyNum = 1000
+	for round := 0; round < 20; round++ {
+		r := rand.New(rand.NewSource(int64(round)))
+		db, injector, opts := openFaultDB(t, "merge", round)
+
+		for i := 0; i < keyNum; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), crashTestValue(i)))
+		}
+		for i := 0; i < keyNum; i += 2 {
+			assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+		}
+
+		// merge 会写入有效的数据以及 hint 索引，崩溃点可能落在 merge 完成之后
+		injector.CrashAt(1 + r.Intn(keyNum+200))
+		_ = db.Merge()
+
+		db2 := restartAfterCrash(t, injector, opts, round%2 == 0)
+		assert.Equal(t, keyNum/2, len(db2.ListKeys()), "round %d", round)
+		for i := 0; i < keyNum; i++ {
+			val, err := db2.Get(utils.GetTestKey(i))
+			if i%2 == 0 {
+				assert.Equal(t, ErrKeyNotFound, err)
+				continue
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, crashTestValue(i), val)
+		}
+		assert.Nil(t, db2.Close())
+	}
+}
+
+func TestDB_Fault_Read_Sync(t *testing.T) {
+	db, injector, _ := openFaultDB(t, "read-sync", 0)
+
+	assert.Nil(t, db.Put(utils.GetTestKey(1), crashTestValue(1)))
+
+	injector.FailRead(fio.ErrInjectedRead)
+	_, err := db.Get(utils.GetTestKey(1))
+	assert.Equal(t, fio.ErrInjectedRead, err)
+	injector.FailRead(nil)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, crashTestValue(1), val)
+
+	injector.FailSync(fio.ErrInjectedSync)
+	assert.Equal(t, fio.ErrInjectedSync, db.Sync())
+	injector.FailSync(nil)
+	assert.Nil(t, db.Sync())
+}
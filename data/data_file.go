@@ -15,9 +15,12 @@ var (
 
 const (
 	DataFileNameSuffix    = ".data"
+	HintFileNameSuffix    = ".hint"
 	HintFileName          = "hint-index"
 	MergeFinishedFileName = "merge-finished"
 	SeqNoFileName         = "seq-no"
+	IndexSnapshotFileName = "index-snapshot"
+	IndexSnapshotTempName = "index-snapshot.tmp"
 )
 
 // DataFile 数据文件
@@ -28,27 +31,44 @@ type DataFile struct {
 }
 
 // OpenDataFile 打开新的数据文件
-func OpenDataFile(dirPath string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
+func OpenDataFile(dirPath string, fileId uint32, ioConfig fio.IOConfig) (*DataFile, error) {
 	fileName := GetDataFileName(dirPath, fileId)
-	return newDataFile(fileName, fileId, ioType)
+	return newDataFile(fileName, fileId, ioConfig)
 }
 
 // OpenHintFile 打开hint索引文件
-func OpenHintFile(dirPath string) (*DataFile, error) {
+func OpenHintFile(dirPath string, ioConfig fio.IOConfig) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, HintFileName)
-	return newDataFile(fileName, 0, fio.StandardFIO)
+	return newDataFile(fileName, 0, ioConfig)
 }
 
 // 用来表示完成merge的文件
-func OpenMergeFinishedFile(dirPath string) (*DataFile, error) {
+func OpenMergeFinishedFile(dirPath string, ioConfig fio.IOConfig) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, MergeFinishedFileName)
-	return newDataFile(fileName, 0, fio.StandardFIO)
+	return newDataFile(fileName, 0, ioConfig)
 }
 
 // OpenSeqNoFile 存储事务序列号的文件
-func OpenSeqNoFile(dirPath string) (*DataFile, error) {
+func OpenSeqNoFile(dirPath string, ioConfig fio.IOConfig) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, SeqNoFileName)
-	return newDataFile(fileName, 0, fio.StandardFIO)
+	return newDataFile(fileName, 0, ioConfig)
+}
+
+// OpenDataHintFile 打开数据文件对应的 hint 文件，记录了这个数据文件中所有记录的位置
+func OpenDataHintFile(dirPath string, fileId uint32, ioConfig fio.IOConfig) (*DataFile, error) {
+	return newDataFile(GetHintFileName(dirPath, fileId), fileId, ioConfig)
+}
+
+// OpenIndexSnapshotFile 打开内存索引的快照文件
+func OpenIndexSnapshotFile(dirPath string, ioConfig fio.IOConfig) (*DataFile, error) {
+	fileName := filepath.Join(dirPath, IndexSnapshotFileName)
+	return newDataFile(fileName, 0, ioConfig)
+}
+
+// OpenIndexSnapshotTempFile 打开正在写入的索引快照文件，写完后重命名为快照文件
+func OpenIndexSnapshotTempFile(dirPath string, ioConfig fio.IOConfig) (*DataFile, error) {
+	fileName := filepath.Join(dirPath, IndexSnapshotTempName)
+	return newDataFile(fileName, 0, ioConfig)
 }
 
 // 打开新的数据文件
@@ -56,9 +76,19 @@ func GetDataFileName(dirPath string, fileId uint32) string {
 	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+DataFileNameSuffix)
 }
 
-func newDataFile(fileName string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
+// GetHintFileName 数据文件对应的 hint 文件名
+func GetHintFileName(dirPath string, fileId uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+HintFileNameSuffix)
+}
+
+// NewClosedDataFile 初始化一个尚未打开的数据文件，读取之前需要调用 SetIOManager 打开
+func NewClosedDataFile(fileId uint32) *DataFile {
+	return &DataFile{FileId: fileId}
+}
+
+func newDataFile(fileName string, fileId uint32, ioConfig fio.IOConfig) (*DataFile, error) {
 	// 初始化 IOManager 管理器接口
-	ioManager, err := fio.NewIOManager(fileName, ioType)
+	ioManager, err := ioConfig.Open(fileName)
 	if err != nil {
 		return nil, err
 	}
@@ -91,6 +121,7 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	// 对header信息解码
 	header, headerSize := DecodeLogRecordHeader(headerBuf)
 	// 下方两个条件标识文件读取到了文件末尾，直接返回EOF错误
+	// 预分配的空间全部是 0，读到全 0 的 header 同样说明已经没有数据了
 	if header == nil {
 		return nil, 0, io.EOF
 	}
@@ -144,6 +175,49 @@ func (df *DataFile) WriteHintRecord(key []byte, pos *LogRecordPos) error {
 	return df.Write(encRecord)
 }
 
+// Allocate 预分配数据文件的空间，IO 类型不支持时直接忽略
+func (df *DataFile) Allocate(size int64) error {
+	allocator, ok := df.IoManager.(fio.Allocator)
+	if !ok {
+		return nil
+	}
+	return allocator.Allocate(size)
+}
+
+// SetWriteOff 设置数据文件的写入位置
+// 预分配空间后文件的物理大小不是实际数据的结尾，需要同步给 IO 管理器
+func (df *DataFile) SetWriteOff(offset int64) {
+	df.WriteOff = offset
+	if allocator, ok := df.IoManager.(fio.Allocator); ok {
+		allocator.SetWriteOffset(offset)
+	}
+}
+
+// TrimAllocation 将预分配的文件截断到实际数据的结尾，文件写满不再写入时调用
+// IO 类型不支持截断时直接忽略
+func (df *DataFile) TrimAllocation() error {
+	truncater, ok := df.IoManager.(interface{ Truncate(int64) error })
+	if !ok {
+		return nil
+	}
+	return truncater.Truncate(df.WriteOff)
+}
+
+// ScanWriteOff 从头读取全部记录，返回最后一条有效记录的结尾位置
+func (df *DataFile) ScanWriteOff() (int64, error) {
+	var offset int64 = 0
+	for {
+		_, size, err := df.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				return offset, nil
+			}
+			return 0, err
+		}
+		offset += size
+	}
+}
+
 // Sync 数据文件持久化
 func (df *DataFile) Sync() error {
 	return df.IoManager.Sync()
@@ -154,11 +228,13 @@ func (df *DataFile) Close() error {
 	return df.IoManager.Close()
 }
 
-func (df *DataFile) SetIOManager(dirPath string, ioType fio.FileIOType) error {
-	if err := df.IoManager.Close(); err != nil {
-		return err
+func (df *DataFile) SetIOManager(dirPath string, ioConfig fio.IOConfig) error {
+	if df.IoManager != nil {
+		if err := df.IoManager.Close(); err != nil {
+			return err
+		}
 	}
-	ioManager, err := fio.NewIOManager(GetDataFileName(dirPath, df.FileId), ioType)
+	ioManager, err := ioConfig.Open(GetDataFileName(dirPath, df.FileId))
 	if err != nil {
 		return err
 	}
@@ -166,6 +242,21 @@ func (df *DataFile) SetIOManager(dirPath string, ioType fio.FileIOType) error {
 	return nil
 }
 
+// IsOpen 数据文件是否处于打开状态
+func (df *DataFile) IsOpen() bool {
+	return df.IoManager != nil
+}
+
+// Release 关闭文件并释放文件描述符，之后可以通过 SetIOManager 重新打开
+func (df *DataFile) Release() error {
+	if df.IoManager == nil {
+		return nil
+	}
+	err := df.IoManager.Close()
+	df.IoManager = nil
+	return err
+}
+
 // 指定读取字节数去读取
 func (df *DataFile) readNBytes(n int64, offset int64) (b []byte, err error) {
 	b = make([]byte, n)
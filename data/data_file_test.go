@@ -9,21 +9,21 @@ import (
 func TestOpenDataFile(t *testing.T) {
 	tmpDir := "../TestingFile"
 	t.Log(tmpDir)
-	dataFile1, err := OpenDataFile(tmpDir, 0, fio.StandardFIO)
+	dataFile1, err := OpenDataFile(tmpDir, 0, fio.IOConfig{Type: fio.StandardFIO})
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile1)
 
-	dataFile2, err := OpenDataFile(tmpDir, 1, fio.StandardFIO)
+	dataFile2, err := OpenDataFile(tmpDir, 1, fio.IOConfig{Type: fio.StandardFIO})
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile2)
 
-	dataFile3, err := OpenDataFile(tmpDir, 1, fio.StandardFIO)
+	dataFile3, err := OpenDataFile(tmpDir, 1, fio.IOConfig{Type: fio.StandardFIO})
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile3)
 }
 
 func TestDataFile_Write(t *testing.T) {
-	dataFile, err := OpenDataFile("../TestingFile", 0, fio.StandardFIO)
+	dataFile, err := OpenDataFile("../TestingFile", 0, fio.IOConfig{Type: fio.StandardFIO})
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -38,7 +38,7 @@ func TestDataFile_Write(t *testing.T) {
 }
 
 func TestDataFile_Close(t *testing.T) {
-	dataFile, err := OpenDataFile("../TestingFile", 2, fio.StandardFIO)
+	dataFile, err := OpenDataFile("../TestingFile", 2, fio.IOConfig{Type: fio.StandardFIO})
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -50,7 +50,7 @@ func TestDataFile_Close(t *testing.T) {
 }
 
 func TestDataFile_Sync(t *testing.T) {
-	dataFile, err := OpenDataFile("../TestingFile", 3, fio.StandardFIO)
+	dataFile, err := OpenDataFile("../TestingFile", 3, fio.IOConfig{Type: fio.StandardFIO})
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -62,7 +62,7 @@ func TestDataFile_Sync(t *testing.T) {
 }
 
 func TestDataFile_ReadLogRecord(t *testing.T) {
-	dataFile, err := OpenDataFile("../TestingFile", 4, fio.StandardFIO)
+	dataFile, err := OpenDataFile("../TestingFile", 4, fio.IOConfig{Type: fio.StandardFIO})
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
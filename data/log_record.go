@@ -120,10 +120,17 @@ func DecodeLogRecordHeader(buf []byte) (*logRecordHeader, int64) {
 	// 取出 key size
 
 	keySize, n := binary.Varint(buf[index:])
+	// header 不完整，例如崩溃时只写入了一部分数据
+	if n <= 0 {
+		return nil, 0
+	}
 	header.keySize = uint32(keySize)
 	index += n
 
 	valueSize, n := binary.Varint(buf[index:])
+	if n <= 0 {
+		return nil, 0
+	}
 	header.valueSize = uint32(valueSize)
 	index += n
 
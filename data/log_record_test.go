@@ -108,3 +108,15 @@ func TestGetLogRecordCRC(t *testing.T) {
 	//t.Log(crc3)
 	assert.Equal(t, uint32(1176708373), crc3)
 }
+
+func TestDecodeLogRecordHeader_Torn(t *testing.T) {
+	// 只写入了 crc 和 type，key size 还没有写入
+	h1, size1 := DecodeLogRecordHeader([]byte{86, 238, 133, 193, 0})
+	assert.Nil(t, h1)
+	assert.Equal(t, int64(0), size1)
+
+	// value size 的变长编码不完整
+	h2, size2 := DecodeLogRecordHeader([]byte{86, 238, 133, 193, 0, 8, 0x80})
+	assert.Nil(t, h2)
+	assert.Equal(t, int64(0), size2)
+}
@@ -1,6 +1,7 @@
 package SingleKVDataSet
 
 import (
+	"SingleKVDataSet/cache"
 	"SingleKVDataSet/data"
 	"SingleKVDataSet/fio"
 	"SingleKVDataSet/index"
@@ -15,38 +16,58 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 const (
 	seqNoKey     = "seq.no"
 	fileLockName = "flock"
+	keyLockNum   = 256
+
+	// 启动时加载索引，每次批量更新的数据量
+	indexBatchSize = 1024
 )
 
 // 存放面向用户的操作接口
 
 // bitcask 存储引擎实例
 type DB struct {
-	options         Options // 数据库配置项
-	mu              *sync.RWMutex
-	fileIds         []int                     // 文件Id，只能在加载索引时使用，不能在其他地方更新和使用
-	activeFile      *data.DataFile            // 当前活跃数据文件，用于写入
-	oldFiles        map[uint32]*data.DataFile // 旧的数据文件，用于读取
-	index           index.Indexer             //内存索引
-	seqNo           uint64                    // 事务序列号，全局递增
-	isMerging       bool                      // 是否在进行Merge
-	seqNoFileExists bool                      // 存储事务序列号文件是否存在
-	isInitial       bool                      // 是否是第一次初始化此数据目录
-	fileLock        *flock.Flock              //文件锁，保证多进程之间的互斥
-	bytesWrite      uint                      //累计写了多少个字节
-	reclaimSize     int64                     // 表示有多少数据是无效的
+	options          Options // 数据库配置项
+	mu               *sync.RWMutex
+	fileIds          []int                     // 文件Id，只能在加载索引时使用，不能在其他地方更新和使用
+	activeFile       *data.DataFile            // 当前活跃数据文件，用于写入
+	oldFiles         map[uint32]*data.DataFile // 旧的数据文件，用于读取
+	index            index.Indexer             //内存索引
+	seqNo            uint64                    // 事务序列号，全局递增
+	isMerging        bool                      // 是否在进行Merge
+	seqNoFileExists  bool                      // 存储事务序列号文件是否存在
+	isInitial        bool                      // 是否是第一次初始化此数据目录
+	fileLock         *flock.Flock              //文件锁，保证多进程之间的互斥
+	bytesWrite       uint                      //累计写了多少个字节
+	reclaimSize      int64                     // 表示有多少数据是无效的
+	cache            *cache.LRU                // 读缓存，未开启时为 nil
+	fileLRU          *dataFileLRU              // 限制打开的旧数据文件数量，未开启时为 nil
+	snapshotMu       *sync.Mutex               // 保证同一时间只有一个索引快照在写入
+	lastCheckpoint   *indexCheckpoint          // 最近一次保存或加载的索引快照
+	checkpointStop   chan struct{}             // 通知定期保存索引快照的协程退出
+	checkpointDone   chan struct{}             // 定期保存索引快照的协程已经退出
+	hintWg           *sync.WaitGroup           // 等待后台生成 hint 文件的协程
+	keyLocks         [keyLockNum]sync.Mutex    // 按 key 分段的锁，写入数据后在全局锁之外更新索引
+	missingHintFiles []uint32                  // 加载索引时没有可用 hint 文件的旧数据文件id
+	isMergeInstance  bool                      // 是否是 merge 使用的临时实例，临时实例不生成 hint 文件
 }
 
 // Stat 存储 引擎统计信息
 type Stat struct {
-	KeyNum          uint  // key的总数量
-	DataFileNum     uint  // 数据文件数量
-	ReclaimableSize int64 // 可以进行merge回收的数据量，单位为字节
-	DiskSize        int64 // 数据目录所占磁盘空间大小
+	KeyNum          uint   // key的总数量
+	DataFileNum     uint   // 数据文件数量
+	ReclaimableSize int64  // 可以进行merge回收的数据量，单位为字节
+	DiskSize        int64  // 数据目录所占磁盘空间大小
+	CacheHits       uint64 // 读缓存命中次数
+	CacheMisses     uint64 // 读缓存未命中次数
+	CacheEvictions  uint64 // 读缓存淘汰次数
+	OpenFileNum     uint   // 当前打开的数据文件数量
+	IndexMemorySize int64  // 内存索引占用的内存，单位为字节，B+ 树索引为 0
 }
 
 // Open 打开bitcask存储引擎实例
@@ -57,41 +78,63 @@ func Open(options Options) (*DB, error) {
 	}
 
 	var isInitial bool
+	var fileLock *flock.Flock
+
+	if options.InMemory {
+		// 内存模式下数据只属于当前进程，不需要创建目录和文件锁
+		isInitial = len(fio.ListMemoryFiles(options.DirPath)) == 0
+	} else {
+		// 对目录进行校验，若目录不存在，则需要创建
+		if _, err := os.Stat(options.DirPath); os.IsNotExist(err) {
+			isInitial = true
+			if err := os.MkdirAll(options.DirPath, os.ModePerm); err != nil {
+				return nil, err
+			}
+		}
 
-	// 对目录进行校验，若目录不存在，则需要创建
-	if _, err := os.Stat(options.DirPath); os.IsNotExist(err) {
-		isInitial = true
-		if err := os.MkdirAll(options.DirPath, os.ModePerm); err != nil {
+		// 判断当前数据目录是否正在使用
+		fileLock = flock.New(filepath.Join(options.DirPath, fileLockName))
+		hold, err := fileLock.TryLock()
+		if err != nil {
 			return nil, err
 		}
-	}
+		if !hold {
+			return nil, ErrDatabaseIsUsing
+		}
 
-	// 判断当前数据目录是否正在使用
-	fileLock := flock.New(filepath.Join(options.DirPath, fileLockName))
-	hold, err := fileLock.TryLock()
-	if err != nil {
-		return nil, err
-	}
-	if !hold {
-		return nil, ErrDatabaseIsUsing
+		entries, err := os.ReadDir(options.DirPath)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) == 0 {
+			isInitial = true
+		}
 	}
 
-	entries, err := os.ReadDir(options.DirPath)
+	indexer, err := newIndexer(options)
 	if err != nil {
+		if fileLock != nil {
+			_ = fileLock.Unlock()
+		}
 		return nil, err
 	}
-	if len(entries) == 0 {
-		isInitial = true
-	}
 
 	// 初始化DB实例结构体
 	db := &DB{
-		options:   options,
-		mu:        new(sync.RWMutex),
-		oldFiles:  make(map[uint32]*data.DataFile),
-		index:     index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrites),
-		isInitial: isInitial,
-		fileLock:  fileLock,
+		options:    options,
+		mu:         new(sync.RWMutex),
+		snapshotMu: new(sync.Mutex),
+		hintWg:     new(sync.WaitGroup),
+		oldFiles:   make(map[uint32]*data.DataFile),
+		index:      indexer,
+		isInitial:  isInitial,
+		fileLock:   fileLock,
+	}
+	if options.CacheSize > 0 {
+		db.cache = cache.NewLRU(options.CacheSize)
+	}
+	if options.MaxOpenFiles > 0 {
+		db.fileLRU = newDataFileLRU(options.DirPath, db.ioConfig(), options.MaxOpenFiles)
 	}
 
 	// 加载数据目录
@@ -106,22 +149,44 @@ func Open(options Options) (*DB, error) {
 
 	// 如果是B+树索引，则不需要从数据文件加载索引
 	if options.IndexType != BPlusTree {
+		// 从索引快照中加载索引，快照有效时不需要再加载hint文件
+		var checkpoint *indexCheckpoint
+		if options.IndexSnapshot {
+			var err error
+			if checkpoint, err = db.loadIndexFromSnapshot(); err != nil {
+				return nil, err
+			}
+		}
+
 		// 从hint文件中加载索引
-		if err := db.loadIndexFromHintFile(); err != nil {
-			return nil, err
+		if checkpoint == nil {
+			if err := db.loadIndexFromHintFile(); err != nil {
+				return nil, err
+			}
 		}
 
 		// 从数据文件加载索引
-		if err := db.loadIndexFromDataFiles(); err != nil {
+		if err := db.loadIndexFromDataFiles(checkpoint); err != nil {
 			return nil, err
 		}
 
 		// 重置IO类型为标准文件IO
-		if db.options.MMapAtStartup {
+		if db.options.MMapAtStartup && !db.options.InMemory {
 			if err := db.resetIoType(); err != nil {
 				return nil, err
 			}
 		}
+
+		// 为没有 hint 文件的旧数据文件生成 hint 文件
+		for _, fileId := range db.missingHintFiles {
+			db.writeHintFileAsync(fileId)
+		}
+		db.missingHintFiles = nil
+
+		// 加载过程中索引读写磁盘出错时，加载的索引不完整
+		if err := db.indexErr(); err != nil {
+			return nil, err
+		}
 	}
 
 	// 取出当前事务的序列号
@@ -134,32 +199,59 @@ func Open(options Options) (*DB, error) {
 			if err != nil {
 				return nil, err
 			}
-			db.activeFile.WriteOff = size
+			// 预分配的文件物理大小不是数据的结尾，需要扫描找到最后一条记录
+			if options.PreallocateDataFile {
+				if size, err = db.activeFile.ScanWriteOff(); err != nil {
+					return nil, err
+				}
+			}
+			db.activeFile.SetWriteOff(size)
 		}
 	}
 
+	if options.IndexSnapshot && options.IndexSnapshotInterval > 0 && options.IndexType != BPlusTree {
+		db.startCheckpointLoop(options.IndexSnapshotInterval)
+	}
+
 	return db, nil
 }
 
 // Close 关闭数据库
 func (db *DB) Close() error {
 	defer func() {
+		if db.fileLock == nil {
+			return
+		}
 		if err := db.fileLock.Unlock(); err != nil {
 			panic(fmt.Sprintf("failed to unlock the directory,  %v", err))
 		}
 	}()
+	db.stopCheckpointLoop()
+	db.hintWg.Wait()
 	if db.activeFile == nil {
 		return nil
 	}
+
+	// 保存索引快照，下次启动时不需要重放全部数据文件
+	if db.options.IndexSnapshot {
+		if err := db.Checkpoint(); err != nil {
+			return err
+		}
+	}
+
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	// 等待正在进行的索引更新完成
+	db.lockAllKeys()
+	defer db.unlockAllKeys()
+
 	if err := db.index.Close(); err != nil {
 		return err
 	}
 
 	// 保存当前事务序列号
-	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath)
+	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath, db.ioConfig())
 	defer seqNoFile.Close()
 	if err != nil {
 		return err
@@ -185,11 +277,17 @@ func (db *DB) Close() error {
 
 	// 关闭旧的数据文件
 	for _, file := range db.oldFiles {
-		if err := file.Close(); err != nil {
+		if err := file.Release(); err != nil {
 			return err
 		}
 	}
 
+	// 内存模式的数据文件只属于这个数据库，没有要求保留时在关闭后释放
+	if db.options.InMemory && !db.options.InMemoryKeepOnClose {
+		fio.RemoveMemoryDir(db.options.DirPath)
+		fio.RemoveMemoryDir(db.getMergePath())
+	}
+
 	return nil
 }
 
@@ -210,25 +308,44 @@ func (db *DB) Stat() *Stat {
 	defer db.mu.RUnlock()
 
 	var dataFiles = uint(len(db.oldFiles))
+	var openFiles = dataFiles
+	if db.fileLRU != nil {
+		openFiles = uint(db.fileLRU.openCount())
+	}
 	if db.activeFile != nil {
 		dataFiles += 1
+		openFiles += 1
 	}
-	dirSize, err := utils.DirSize(db.options.DirPath)
+	dirSize, err := db.dirSize()
 	if err != nil {
 		panic(fmt.Sprintf("failed to get dir size,  %v", err))
 	}
-	return &Stat{
+	stat := &Stat{
 		KeyNum:          uint(db.index.Size()),
 		DataFileNum:     dataFiles,
-		ReclaimableSize: db.reclaimSize,
+		ReclaimableSize: atomic.LoadInt64(&db.reclaimSize),
 		DiskSize:        dirSize,
+		OpenFileNum:     openFiles,
 	}
+	if measurer, ok := db.index.(index.MemoryMeasurer); ok {
+		stat.IndexMemorySize = measurer.MemorySize()
+	}
+	if db.cache != nil {
+		cacheStats := db.cache.Stats()
+		stat.CacheHits = cacheStats.Hits
+		stat.CacheMisses = cacheStats.Misses
+		stat.CacheEvictions = cacheStats.Evictions
+	}
+	return stat
 }
 
 // Backup 备份数据库，将数据文件拷贝到新的目录中
 func (db *DB) Backup(dir string) error {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
+	if db.options.InMemory {
+		return db.backupMemoryFiles(dir)
+	}
 	return utils.CopyDir(db.options.DirPath, dir, []string{fileLockName})
 }
 
@@ -247,16 +364,17 @@ func (db *DB) Put(key []byte, value []byte) error {
 	}
 
 	// 追加写入到当前活跃数据文件
-	pos, err := db.appendLogRecordWithLock(log_record)
+	keyLock, pos, err := db.appendLogRecordWithKeyLock(key, log_record)
 	if err != nil {
 		return err
 	}
+	defer keyLock.Unlock()
 
 	// 获取到索引信息后，更新内存索引
 	if oldPos := db.index.Put(key, pos); oldPos != nil {
-		db.reclaimSize += int64(oldPos.Size)
+		atomic.AddInt64(&db.reclaimSize, int64(oldPos.Size))
 	}
-	return nil
+	return db.indexErr()
 }
 
 // Delete 根据Key删除对应的数据
@@ -268,7 +386,7 @@ func (db *DB) Delete(key []byte) error {
 
 	// 先检查Key是否存在，如果不存在，则直接返回。
 	if pos := db.index.Get(key); pos == nil {
-		return nil
+		return db.indexErr()
 	}
 
 	// 构造logRecord，标识其是被删除的
@@ -278,19 +396,23 @@ func (db *DB) Delete(key []byte) error {
 	}
 
 	// 写入到数据文件中
-	pos, err := db.appendLogRecordWithLock(logRecord)
+	keyLock, pos, err := db.appendLogRecordWithKeyLock(key, logRecord)
 	if err != nil {
 		return err
 	}
-	db.reclaimSize += int64(pos.Size)
+	defer keyLock.Unlock()
+	atomic.AddInt64(&db.reclaimSize, int64(pos.Size))
 
 	// 从内存索引中将Key删除
 	oldPos, ok := db.index.Delete(key)
+	if err := db.indexErr(); err != nil {
+		return err
+	}
 	if !ok {
 		return ErrIndexUpdateFailed
 	}
 	if oldPos != nil {
-		db.reclaimSize += int64(oldPos.Size)
+		atomic.AddInt64(&db.reclaimSize, int64(oldPos.Size))
 	}
 	return nil
 }
@@ -310,6 +432,9 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 
 	// 未找到，说明key不存在
 	if logRecordPos == nil {
+		if err := db.indexErr(); err != nil {
+			return nil, err
+		}
 		return nil, ErrKeyNotFound
 	}
 
@@ -319,13 +444,13 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 
 // ListKeys 获取数据中所有的key
 func (db *DB) ListKeys() [][]byte {
+	// 先取容量再打开迭代器：B+树迭代器持有只读事务，
+	// 此时再开事务读取 Size 会和等待 remap 的写事务互相阻塞
+	keys := make([][]byte, 0, db.index.Size())
 	iterator := db.index.Iterator(false)
 	defer iterator.Close()
-	keys := make([][]byte, db.index.Size())
-	var idx int
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-		keys[idx] = iterator.Key()
-		idx++
+		keys = append(keys, iterator.Key())
 	}
 	return keys
 }
@@ -352,18 +477,22 @@ func (db *DB) Fold(fn func(key, value []byte) bool) error {
 
 // getValueByPosition 根据索引信息获取对应的 value
 func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error) {
-	var dataFile *data.DataFile
-	if db.activeFile.FileId == logRecordPos.Fid {
-		dataFile = db.activeFile
-	} else {
-		dataFile = db.oldFiles[logRecordPos.Fid]
-	}
-	// 数据文件为空
-	if dataFile == nil {
-		return nil, ErrDataFileNotFound
+	// 先从读缓存中查找
+	var cacheKey = cache.Key{Fid: logRecordPos.Fid, Offset: logRecordPos.Offset}
+	if db.cache != nil {
+		if value, ok := db.cache.Get(cacheKey); ok {
+			return value, nil
+		}
 	}
 
+	dataFile, err := db.acquireDataFile(logRecordPos.Fid)
+	if err != nil {
+		return nil, err
+	}
 	logRecord, _, err := dataFile.ReadLogRecord(logRecordPos.Offset)
+	if releaseErr := db.releaseDataFile(dataFile); err == nil {
+		err = releaseErr
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -371,13 +500,47 @@ func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error
 	if logRecord.Type == data.LogRecordDeleted {
 		return nil, ErrKeyNotFound
 	}
+	if db.cache != nil {
+		db.cache.Put(cacheKey, logRecord.Value)
+	}
 	return logRecord.Value, nil
 }
 
-func (db *DB) appendLogRecordWithLock(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
+// appendLogRecordWithKeyLock 写入数据，返回时持有 key 对应的锁，更新完索引后需要释放
+// 先拿到 key 的锁再释放全局锁，同一个 key 的索引更新顺序和写入顺序一致，不同 key 的索引可以并发更新
+func (db *DB) appendLogRecordWithKeyLock(key []byte, logRecord *data.LogRecord) (*sync.Mutex, *data.LogRecordPos, error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	return db.appendLogRecord(logRecord)
+	pos, err := db.appendLogRecord(logRecord)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyLock := db.keyLock(key)
+	keyLock.Lock()
+	return keyLock, pos, nil
+}
+
+// keyLock 根据 key 的哈希值找到对应的锁
+func (db *DB) keyLock(key []byte) *sync.Mutex {
+	var hash uint32 = 2166136261
+	for _, b := range key {
+		hash ^= uint32(b)
+		hash *= 16777619
+	}
+	return &db.keyLocks[hash%keyLockNum]
+}
+
+// lockAllKeys 等待所有正在进行的索引更新完成，调用前需要持有全局锁，保证没有新的写入
+func (db *DB) lockAllKeys() {
+	for i := range db.keyLocks {
+		db.keyLocks[i].Lock()
+	}
+}
+
+func (db *DB) unlockAllKeys() {
+	for i := range db.keyLocks {
+		db.keyLocks[i].Unlock()
+	}
 }
 
 // appendLogRecord 将对应数据写入到活跃数据文件当中
@@ -401,9 +564,21 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 		if err := db.activeFile.Sync(); err != nil {
 			return nil, err
 		}
+		if err := db.sealActiveDataFile(); err != nil {
+			return nil, err
+		}
 
 		// 持久化完成后，转换文件状态，活跃文件->旧的文件
 		db.oldFiles[db.activeFile.FileId] = db.activeFile
+		if db.fileLRU != nil {
+			if err := db.fileLRU.add(db.activeFile); err != nil {
+				return nil, err
+			}
+		}
+		// 在后台为写满的文件生成 hint 文件，加快下次启动
+		if !db.isMergeInstance {
+			db.writeHintFileAsync(db.activeFile.FileId)
+		}
 
 		// 打开新的数据文件
 		if err := db.setActiveDataFile(); err != nil {
@@ -452,20 +627,55 @@ func (db *DB) setActiveDataFile() error {
 	}
 
 	// 打开新的数据文件
-	dataFile, err := data.OpenDataFile(db.options.DirPath, initialFileId, fio.StandardFIO)
+	dataFile, err := data.OpenDataFile(db.options.DirPath, initialFileId, db.ioConfig())
 
 	if err != nil {
 		return err
 	}
 
+	// 预分配文件空间，避免追加写时文件碎片化以及每次 sync 都要更新元数据
+	if db.options.PreallocateDataFile {
+		if err := dataFile.Allocate(db.options.DataFileSize); err != nil {
+			return err
+		}
+	}
+
 	db.activeFile = dataFile
 	return nil
 }
 
+// sealActiveDataFile 活跃文件不再写入时，释放预分配但没有使用的空间
+// 这样旧的数据文件的物理大小就是实际数据的大小
+func (db *DB) sealActiveDataFile() error {
+	if !db.options.PreallocateDataFile {
+		return nil
+	}
+	return db.activeFile.TrimAllocation()
+}
+
+// dataSize 数据目录中实际数据的大小，不包含活跃文件预分配但还没有写入的空间
+func (db *DB) dataSize() (int64, error) {
+	size, err := db.dirSize()
+	if err != nil {
+		return 0, err
+	}
+	if !db.options.PreallocateDataFile || db.activeFile == nil {
+		return size, nil
+	}
+	allocated, err := db.activeFile.IoManager.Size()
+	if err != nil {
+		return 0, err
+	}
+	if allocated > db.activeFile.WriteOff {
+		size -= allocated - db.activeFile.WriteOff
+	}
+	return size, nil
+}
+
 // 从磁盘中加载数据文件
 func (db *DB) loadDataFiles() error {
 	// 根据配置项读取目录
-	dirEntries, err := os.ReadDir(db.options.DirPath)
+	fileNames, err := db.readDirNames(db.options.DirPath)
 	if err != nil {
 		return err
 	}
@@ -473,10 +683,10 @@ func (db *DB) loadDataFiles() error {
 	var fileIds []int
 
 	// 遍历目录中所有文件，找到.data结尾的数据文件
-	for _, entry := range dirEntries {
-		if strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
+	for _, fileName := range fileNames {
+		if strings.HasSuffix(fileName, data.DataFileNameSuffix) {
 			// 00001.data -> 分割为 00001 和 data，用1作为文件Id
-			splitNames := strings.Split(entry.Name(), ".")
+			splitNames := strings.Split(fileName, ".")
 			fileId, err := strconv.Atoi(splitNames[0])
 			// 数据目录有可能损坏
 			if err != nil {
@@ -492,11 +702,16 @@ func (db *DB) loadDataFiles() error {
 
 	// 遍历每个文件的id，打开对应的数据文件
 	for i, fid := range fileIds {
-		ioType := fio.StandardFIO
-		if db.options.MMapAtStartup {
-			ioType = fio.MemoryMap
+		// 限制了打开文件数量时，旧的数据文件在读取时才打开
+		if db.fileLRU != nil && i != len(fileIds)-1 {
+			db.oldFiles[uint32(fid)] = data.NewClosedDataFile(uint32(fid))
+			continue
 		}
-		dataFile, err := data.OpenDataFile(db.options.DirPath, uint32(fid), ioType)
+		ioConfig := db.ioConfig()
+		if db.options.MMapAtStartup && !db.options.InMemory {
+			ioConfig.Type = fio.MemoryMap
+		}
+		dataFile, err := data.OpenDataFile(db.options.DirPath, uint32(fid), ioConfig)
 		if err != nil {
 			return err
 		}
@@ -512,7 +727,8 @@ func (db *DB) loadDataFiles() error {
 
 // loadIndexFromDataFiles 从数据文件中加载索引
 // 遍历文件中所有记录，并更新到内存索引中
-func (db *DB) loadIndexFromDataFiles() error {
+// 从索引快照中加载过索引时，只需要处理快照位置之后的记录
+func (db *DB) loadIndexFromDataFiles(checkpoint *indexCheckpoint) error {
 	// 没有文件，当前数据库为空
 	if len(db.fileIds) == 0 {
 		return nil
@@ -521,7 +737,7 @@ func (db *DB) loadIndexFromDataFiles() error {
 	// 查看是否发生过merge
 	hasMerge, nonMergeFileId := false, uint32(0)
 	mergeFinFileName := filepath.Join(db.options.DirPath, data.MergeFinishedFileName)
-	if _, err := os.Stat(mergeFinFileName); err == nil {
+	if db.fileExists(mergeFinFileName) {
 		fid, err := db.getNonMergeFileId(db.options.DirPath)
 		if err != nil {
 			return err
@@ -530,47 +746,115 @@ func (db *DB) loadIndexFromDataFiles() error {
 		nonMergeFileId = fid
 	}
 
-	updateIndex := func(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
-		var oldPos *data.LogRecordPos
-		if typ == data.LogRecordDeleted {
-			oldPos, _ = db.index.Delete(key)
-			db.reclaimSize += int64(pos.Size)
-		} else {
-			oldPos = db.index.Put(key, pos)
-		}
+	// 从这个位置开始处理数据文件中的记录
+	var startFileId, startOffset = uint32(0), int64(0)
+	if hasMerge {
+		startFileId = nonMergeFileId
+	}
+	if checkpoint != nil && checkpoint.fid >= startFileId {
+		startFileId, startOffset = checkpoint.fid, checkpoint.offset
+	}
 
-		if oldPos != nil {
-			db.reclaimSize += int64(oldPos.Size)
+	// 按批更新索引，B+ 树索引可以在一个事务中完成一批更新
+	var ops []index.IndexOp
+	flushIndex := func() {
+		oldPositions := db.index.ApplyBatch(ops)
+		for i, op := range ops {
+			if op.Delete {
+				db.reclaimSize += int64(op.Pos.Size)
+			}
+			if oldPositions[i] != nil {
+				db.reclaimSize += int64(oldPositions[i].Size)
+			}
+		}
+		ops = ops[:0]
+	}
+	updateIndex := func(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
+		ops = append(ops, index.IndexOp{Key: key, Pos: pos, Delete: typ == data.LogRecordDeleted})
+		if len(ops) >= indexBatchSize {
+			flushIndex()
 		}
 	}
 
 	// 暂存事务数据
 	transactionRecords := make(map[uint64][]*data.TransactionRecord)
 	var currentSeqNo = nonTransactionSeqNo
+	if checkpoint != nil {
+		currentSeqNo = checkpoint.seqNo
+	}
+
+	// 处理一条记录，数据文件和 hint 文件中的记录使用相同的逻辑
+	handleRecord := func(logRecord *data.LogRecord, logRecordPos *data.LogRecordPos) {
+		// 解析key，拿到事务序列号
+		realKey, seqNo := parseLogRecordKey(logRecord.Key)
+		if seqNo == nonTransactionSeqNo {
+			// 非事务操作，直接更新内存索引
+			updateIndex(realKey, logRecord.Type, logRecordPos)
+		} else {
+			// 事务完成，对应的seqNo的数据可以更新到内存索引当中
+			if logRecord.Type == data.LogRecordTxnFinished {
+				for _, txnRecord := range transactionRecords[seqNo] {
+					updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
+				}
+				delete(transactionRecords, seqNo)
+			} else {
+				logRecord.Key = realKey
+				transactionRecords[seqNo] = append(transactionRecords[seqNo], &data.TransactionRecord{
+					Record: logRecord,
+					Pos:    logRecordPos,
+				})
+			}
+		}
+
+		// 更新事务序列号
+		if seqNo > currentSeqNo {
+			currentSeqNo = seqNo
+		}
+	}
 
 	// 遍历所有文件id，处理文件中的记录
 	for i, fid := range db.fileIds {
 		var fileId = uint32(fid)
 
-		// 如果比最近未参与merge的文件id更小，则说明已经从Hint文件中加载了索引
-		if hasMerge && fileId < nonMergeFileId {
+		// 如果比最近未参与merge的文件id更小，则说明已经从Hint文件或者索引快照中加载了索引
+		if fileId < startFileId {
 			continue
 		}
 
-		var dataFile *data.DataFile
-		if fileId == db.activeFile.FileId {
-			dataFile = db.activeFile
-		} else {
-			dataFile = db.oldFiles[fileId]
+		dataFile, err := db.acquireDataFile(fileId)
+		if err != nil {
+			return err
 		}
 
 		var offset int64 = 0
+		if fileId == startFileId {
+			offset = startOffset
+		}
+
+		// 旧的数据文件优先从对应的 hint 文件中加载，没有可用的 hint 文件时在后台重新生成
+		if i != len(db.fileIds)-1 {
+			records, ok := db.readHintFile(dataFile)
+			if ok {
+				for _, record := range records {
+					if record.Pos.Offset >= offset {
+						handleRecord(record.Record, record.Pos)
+					}
+				}
+				if err := db.releaseDataFile(dataFile); err != nil {
+					return err
+				}
+				continue
+			}
+			db.missingHintFiles = append(db.missingHintFiles, fileId)
+		}
+
 		for {
 			logRecord, size, err := dataFile.ReadLogRecord(offset)
 			if err != nil {
 				if err == io.EOF {
 					break
 				}
+				_ = db.releaseDataFile(dataFile)
 				return err
 			}
 			// 构造内存索引并保存
@@ -579,47 +863,53 @@ func (db *DB) loadIndexFromDataFiles() error {
 				Offset: offset,
 				Size:   uint32(size),
 			}
-
-			// 解析key，拿到事务序列号
-			realKey, seqNo := parseLogRecordKey(logRecord.Key)
-			if seqNo == nonTransactionSeqNo {
-				// 非事务操作，直接更新内存索引
-				updateIndex(realKey, logRecord.Type, logRecordPos)
-			} else {
-				// 事务完成，对应的seqNo的数据可以更新到内存索引当中
-				if logRecord.Type == data.LogRecordTxnFinished {
-					for _, txnRecord := range transactionRecords[seqNo] {
-						updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
-					}
-					delete(transactionRecords, seqNo)
-				} else {
-					logRecord.Key = realKey
-					transactionRecords[seqNo] = append(transactionRecords[seqNo], &data.TransactionRecord{
-						Record: logRecord,
-						Pos:    logRecordPos,
-					})
-				}
-			}
-
-			// 更新事务序列号
-			if seqNo > currentSeqNo {
-				currentSeqNo = seqNo
-			}
+			handleRecord(logRecord, logRecordPos)
 
 			// 递增 offset，下一次从新的位置开始读取
 			offset += size
 		}
+		if err := db.releaseDataFile(dataFile); err != nil {
+			return err
+		}
 		// 如果是当前活跃文件，更新这个文件的 WriteOff
 		if i == len(db.fileIds)-1 {
-			db.activeFile.WriteOff = offset
+			db.activeFile.SetWriteOff(offset)
 		}
 	}
+	flushIndex()
 
 	db.seqNo = currentSeqNo
 
 	return nil
 }
 
+// newIndexer 根据配置项初始化索引
+func newIndexer(options Options) (index.Indexer, error) {
+	switch {
+	case options.IndexType == HybridIndex:
+		memLimit := options.IndexMemoryLimit
+		if memLimit == 0 {
+			memLimit = index.DefaultIndexMemoryLimit
+		}
+		return index.NewHybridIndex(options.DirPath, memLimit)
+	case options.IndexType == BTree && options.IndexKeyCompression:
+		return index.NewCompressedBTree(), nil
+	case options.IndexType == ShardedBTree && options.IndexKeyCompression:
+		return index.NewCompressedShardedBTree(index.DefaultShardNum), nil
+	case options.IndexType == ART && options.IndexKeyCompression:
+		return index.NewCompressedART(), nil
+	}
+	return index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrites)
+}
+
+// indexErr 索引读写磁盘时遇到的错误，只有混合索引会出错
+func (db *DB) indexErr() error {
+	if reporter, ok := db.index.(index.ErrorReporter); ok {
+		return reporter.Err()
+	}
+	return nil
+}
+
 // checkOptions 校验配置项
 func checkOptions(options Options) error {
 	if options.DirPath == "" {
@@ -631,28 +921,44 @@ func checkOptions(options Options) error {
 	if options.DataFileMergeRatio < 0 || options.DataFileMergeRatio > 1 {
 		return errors.New("invalid ratio, databse data file merge ratio must be between 0 and 1")
 	}
+	if options.IndexSnapshotInterval < 0 {
+		return errors.New("index snapshot interval must not be negative")
+	}
+	if options.InMemory && options.IndexType == BPlusTree {
+		return errors.New("B+ tree index stores data on disk, can not be used in memory mode")
+	}
+	if options.IndexMemoryLimit < 0 {
+		return errors.New("index memory limit must not be negative")
+	}
+	if options.InMemory && options.IndexType == HybridIndex {
+		return errors.New("hybrid index spills data to disk, can not be used in memory mode")
+	}
 
 	return nil
 }
 
 func (db *DB) loadSeqNo() error {
 	fileName := filepath.Join(db.options.DirPath, data.SeqNoFileName)
-	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+	if !db.fileExists(fileName) {
 		return nil
 	}
-	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath)
+	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath, db.ioConfig())
 	if err != nil {
 		return err
 	}
 	record, _, err := seqNoFile.ReadLogRecord(0)
-	seqNo, err := strconv.ParseUint(string(record.Key), 10, 64)
+	_ = seqNoFile.Close()
+	if err != nil {
+		return err
+	}
+	seqNo, err := strconv.ParseUint(string(record.Value), 10, 64)
 	if err != nil {
 		return err
 	}
 	db.seqNo = seqNo
 	db.seqNoFileExists = true
 
-	return os.Remove(fileName)
+	return db.removeFile(fileName)
 }
 
 // 将数据文件的IO类型设置为标准文件IO
@@ -660,13 +966,47 @@ func (db *DB) resetIoType() error {
 	if db.activeFile == nil {
 		return nil
 	}
-	if err := db.activeFile.SetIOManager(db.options.DirPath, fio.StandardFIO); err != nil {
+	ioConfig := db.ioConfig()
+	ioConfig.Type = fio.StandardFIO
+	if err := db.activeFile.SetIOManager(db.options.DirPath, ioConfig); err != nil {
 		return err
 	}
+	db.activeFile.SetWriteOff(db.activeFile.WriteOff)
 	for _, dataFile := range db.oldFiles {
-		if err := dataFile.SetIOManager(db.options.DirPath, fio.StandardFIO); err != nil {
+		// 未打开的文件在读取时会以标准文件IO打开
+		if !dataFile.IsOpen() {
+			continue
+		}
+		if err := dataFile.SetIOManager(db.options.DirPath, ioConfig); err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+// acquireDataFile 根据文件id获取数据文件，限制了打开文件数量时会按需打开文件
+// 使用完成后需要调用 releaseDataFile
+func (db *DB) acquireDataFile(fileId uint32) (*data.DataFile, error) {
+	if db.activeFile != nil && db.activeFile.FileId == fileId {
+		return db.activeFile, nil
+	}
+	dataFile := db.oldFiles[fileId]
+	// 数据文件为空
+	if dataFile == nil {
+		return nil, ErrDataFileNotFound
+	}
+	if db.fileLRU != nil {
+		if err := db.fileLRU.acquire(dataFile); err != nil {
+			return nil, err
+		}
+	}
+	return dataFile, nil
+}
+
+// releaseDataFile 释放 acquireDataFile 获取的数据文件
+func (db *DB) releaseDataFile(dataFile *data.DataFile) error {
+	if db.fileLRU == nil {
+		return nil
+	}
+	return db.fileLRU.release(dataFile)
+}
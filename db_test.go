@@ -1,13 +1,30 @@
 package SingleKVDataSet
 
 import (
+	"SingleKVDataSet/fio"
 	"SingleKVDataSet/utils"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 )
 
+// memoryTestOptions 内存模式的测试配置，关闭后保留数据用于重新打开，测试结束时释放内存中的数据文件
+func memoryTestOptions(t *testing.T, name string) Options {
+	opts := DefaultOptions
+	opts.DirPath = filepath.Join(t.TempDir(), name)
+	opts.InMemory = true
+	opts.InMemoryKeepOnClose = true
+	t.Cleanup(func() {
+		fio.RemoveMemoryDir(opts.DirPath)
+		fio.RemoveMemoryDir(opts.DirPath + mergeDirName)
+	})
+	return opts
+}
+
 // 测试完成后销毁DB数据目录
 func destroyDB(db *DB) {
 	if db != nil {
@@ -391,6 +408,58 @@ func TestDB_Stat(t *testing.T) {
 	assert.NotNil(t, stat)
 }
 
+func TestDB_Stat_Concurrent(t *testing.T) {
+	indexTypes := []IndexerType{BTree, ART, BPlusTree, ShardedBTree, HashIndex, SkipList, HybridIndex}
+	for _, indexType := range indexTypes {
+		opts := DefaultOptions
+		opts.DirPath = t.TempDir()
+		opts.IndexType = indexType
+		opts.IndexKeyCompression = indexType == BTree
+		opts.IndexMemoryLimit = 16 * 1024
+		db, err := Open(opts)
+		assert.Nil(t, err)
+
+		// 写入只持有 key 的锁，Stat 和 ListKeys 读取索引时不能和写入冲突
+		var wg sync.WaitGroup
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < 500; i++ {
+					key := utils.GetTestKey(g*500 + i)
+					assert.Nil(t, db.Put(key, key))
+					if i%2 == 0 {
+						assert.Nil(t, db.Delete(key))
+					}
+				}
+			}(g)
+		}
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		for running := true; running; {
+			select {
+			case <-done:
+				running = false
+			default:
+			}
+			assert.True(t, db.Stat().KeyNum <= 2000)
+			assert.True(t, len(db.ListKeys()) <= 2000)
+		}
+		assert.Equal(t, uint(1000), db.Stat().KeyNum)
+		keys := db.ListKeys()
+		assert.Equal(t, 1000, len(keys))
+		for _, key := range keys {
+			value, err := db.Get(key)
+			assert.Nil(t, err)
+			assert.Equal(t, key, value)
+		}
+		assert.Nil(t, db.Close())
+	}
+}
+
 func TestDB_Backup(t *testing.T) {
 	opts := DefaultOptions
 	dir, _ := os.MkdirTemp("./TestingFile", "bitcask-go-origin")
@@ -415,3 +484,404 @@ func TestDB_Backup(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotNil(t, db2)
 }
+
+func TestDB_Cache(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("./TestingFile", "bitcask-go-cache")
+	opts.DirPath = dir
+	opts.CacheSize = 1024 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 100; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+	stat := db.Stat()
+	assert.Equal(t, uint64(100), stat.CacheMisses)
+	assert.Equal(t, uint64(0), stat.CacheHits)
+
+	// 再次读取全部命中缓存
+	for i := 0; i < 100; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+	stat = db.Stat()
+	assert.Equal(t, uint64(100), stat.CacheHits)
+
+	// 覆盖写之后读到的是新值
+	err = db.Put(utils.GetTestKey(1), []byte("new-value"))
+	assert.Nil(t, err)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new-value"), val)
+}
+
+func TestDB_MaxOpenFiles(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("./TestingFile", "bitcask-go-max-open-files")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.MaxOpenFiles = 2
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 5000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	stat := db.Stat()
+	assert.True(t, stat.DataFileNum > 3)
+	assert.True(t, stat.OpenFileNum <= 3)
+
+	for i := 0; i < 5000; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+	assert.True(t, db.Stat().OpenFileNum <= 3)
+
+	// 重启后旧的数据文件按需打开
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.True(t, db2.Stat().OpenFileNum <= 3)
+	for i := 0; i < 5000; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+	assert.True(t, db2.Stat().OpenFileNum <= 3)
+}
+
+func TestDB_PreallocateDataFile(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("./TestingFile", "bitcask-go-prealloc")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.PreallocateDataFile = true
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	size, err := db.activeFile.IoManager.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, opts.DataFileSize, size)
+	assert.True(t, db.activeFile.WriteOff < size)
+
+	// 重启后能区分预分配的空间和实际的数据
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+	// 继续写入，直到切换活跃文件
+	for i := 100; i < 3000; i++ {
+		err := db2.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	assert.True(t, len(db2.oldFiles) > 0)
+
+	err = db2.Close()
+	assert.Nil(t, err)
+	db3, err := Open(opts)
+	defer destroyDB(db3)
+	assert.Nil(t, err)
+	assert.Equal(t, 3000, len(db3.ListKeys()))
+	for i := 0; i < 3000; i++ {
+		val, err := db3.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+}
+
+func TestDB_InMemory(t *testing.T) {
+	opts := memoryTestOptions(t, "bitcask-go-in-memory")
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 3000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 1000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	// 不会在磁盘上创建目录
+	_, err = os.Stat(opts.DirPath)
+	assert.True(t, os.IsNotExist(err))
+
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	_ = wb.Put(utils.GetTestKey(5000), utils.GetTestKey(5000))
+	assert.Nil(t, wb.Commit())
+
+	// 同一进程内重新打开，数据仍然存在，merge 的结果也会被加载
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 2001, len(db2.ListKeys()))
+	for i := 1000; i < 3000; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+	assert.True(t, db2.Stat().DiskSize > 0)
+
+	// 备份到磁盘后可以用普通模式打开
+	backupDir := t.TempDir()
+	err = db2.Backup(backupDir)
+	assert.Nil(t, err)
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	backupOpts := DefaultOptions
+	backupOpts.DirPath = backupDir
+	db3, err := Open(backupOpts)
+	defer destroyDB(db3)
+	assert.Nil(t, err)
+	assert.Equal(t, 2001, len(db3.ListKeys()))
+}
+
+func TestDB_InMemory_Close(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = filepath.Join(t.TempDir(), "bitcask-go-in-memory-close")
+	opts.InMemory = true
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 3000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Merge())
+	assert.True(t, fio.MemoryDirSize(opts.DirPath) > 0)
+
+	// 没有要求保留时关闭后释放数据目录和 merge 目录中的所有文件
+	assert.Nil(t, db.Close())
+	assert.Empty(t, fio.ListMemoryFiles(opts.DirPath))
+	assert.Empty(t, fio.ListMemoryFiles(opts.DirPath+mergeDirName))
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(db2.ListKeys()))
+	assert.Nil(t, db2.Close())
+	assert.Empty(t, fio.ListMemoryFiles(opts.DirPath))
+}
+
+func TestDB_ShardedIndex(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("./TestingFile", "bitcask-go-sharded")
+	opts.DirPath = dir
+	opts.IndexType = ShardedBTree
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	// 多个协程并发写入，同一个 key 的索引按照写入顺序更新
+	wg := new(sync.WaitGroup)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				assert.Nil(t, db.Put(utils.GetTestKey(g*500+i), utils.GetTestKey(i)))
+				assert.Nil(t, db.Put([]byte("shared"), utils.GetTestKey(g*500+i)))
+			}
+		}(g)
+	}
+	wg.Wait()
+	assert.Equal(t, 4001, len(db.ListKeys()))
+
+	// 最后一次写入的 shared 在数据文件的末尾
+	var last []byte
+	iterator := db.NewIterator(DefaultIteratorOptions)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		assert.True(t, last == nil || string(last) < string(iterator.Key()))
+		last = iterator.Key()
+	}
+	iterator.Close()
+	val, err := db.Get([]byte("shared"))
+	assert.Nil(t, err)
+
+	// 重启后从数据文件加载的值一致
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	val2, err := db2.Get([]byte("shared"))
+	assert.Nil(t, err)
+	assert.Equal(t, val, val2)
+}
+
+func TestDB_HashIndex(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("./TestingFile", "bitcask-go-hash-index")
+	opts.DirPath = dir
+	opts.IndexType = HashIndex
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	for i := 0; i < 1000; i += 2 {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+
+	// 迭代器按照 key 排序
+	iterator := db.NewIterator(IteratorOptions{Prefix: []byte("bitcask-go-key-00000000")})
+	var keys [][]byte
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		keys = append(keys, iterator.Key())
+	}
+	iterator.Close()
+	assert.Equal(t, [][]byte{utils.GetTestKey(1), utils.GetTestKey(3), utils.GetTestKey(5), utils.GetTestKey(7), utils.GetTestKey(9)}, keys)
+
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 500, len(db2.ListKeys()))
+	for i := 0; i < 1000; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		if i%2 == 0 {
+			assert.Equal(t, ErrKeyNotFound, err)
+		} else {
+			assert.Nil(t, err)
+			assert.Equal(t, utils.GetTestKey(i), val)
+		}
+	}
+}
+
+func TestDB_SkipList(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("./TestingFile", "bitcask-go-skiplist")
+	opts.DirPath = dir
+	opts.IndexType = SkipList
+	opts.IndexSnapshot = true
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+
+	// 遍历的同时写入和删除数据
+	iterator := db.NewIterator(IteratorOptions{Reverse: true})
+	var count int
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		if count%2 == 0 {
+			assert.Nil(t, db.Put(iterator.Key(), []byte("new value")))
+		}
+		count++
+	}
+	iterator.Close()
+	assert.Equal(t, 1000, count)
+	for i := 0; i < 1000; i += 2 {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+
+	// 重启后从索引快照中加载
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 500, len(db2.ListKeys()))
+	for i := 1; i < 1000; i += 2 {
+		_, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+}
+
+func TestDB_HybridIndex(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("./TestingFile", "bitcask-go-hybrid-index")
+	opts.DirPath = dir
+	opts.IndexType = HybridIndex
+	opts.IndexMemoryLimit = 32 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	for i := 0; i < 2000; i += 2 {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Equal(t, 1000, len(db.ListKeys()))
+
+	// 重启后重建索引，磁盘上的索引文件被重新创建
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(db2.ListKeys()))
+	for i := 0; i < 2000; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		if i%2 == 0 {
+			assert.Equal(t, ErrKeyNotFound, err)
+		} else {
+			assert.Nil(t, err)
+			assert.Equal(t, utils.GetTestKey(i), val)
+		}
+	}
+
+	// 内存模式下不能使用混合索引
+	opts.InMemory = true
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}
+
+func TestDB_IndexKeyCompression(t *testing.T) {
+	for _, indexType := range []IndexerType{BTree, ART} {
+		var memSize [2]int64
+		for i, compression := range []bool{false, true} {
+			opts := DefaultOptions
+			opts.DirPath = t.TempDir()
+			opts.IndexType = indexType
+			opts.IndexKeyCompression = compression
+			db, err := Open(opts)
+			assert.Nil(t, err)
+
+			for n := 0; n < 1000; n++ {
+				key := []byte(fmt.Sprintf("tenant/%036d/object/%036d", n%10, n))
+				assert.Nil(t, db.Put(key, utils.RandomValue(16)))
+			}
+			val, err := db.Get([]byte(fmt.Sprintf("tenant/%036d/object/%036d", 1, 1)))
+			assert.Nil(t, err)
+			assert.NotNil(t, val)
+			assert.Equal(t, 1000, len(db.ListKeys()))
+			memSize[i] = db.Stat().IndexMemorySize
+			destroyDB(db)
+		}
+		// 压缩后索引占用的内存更少
+		assert.True(t, memSize[0] > 0)
+		assert.True(t, memSize[1] < memSize[0])
+	}
+}
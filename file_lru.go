@@ -0,0 +1,107 @@
+package SingleKVDataSet
+
+import (
+	"SingleKVDataSet/data"
+	"SingleKVDataSet/fio"
+	"container/list"
+	"sync"
+)
+
+// dataFileLRU 限制同时打开的旧数据文件数量
+// 旧数据文件按需打开，超过上限时关闭最久未被访问的文件，正在被读取的文件不会被关闭
+type dataFileLRU struct {
+	capacity int          // 最多同时打开的旧数据文件数量
+	dirPath  string       // 数据目录
+	ioConfig fio.IOConfig // 重新打开文件时使用的 IO 配置
+	mu       *sync.Mutex
+	ll       *list.List               // 已打开的旧数据文件，最近访问的在前面
+	items    map[uint32]*list.Element // 文件id -> 链表节点
+	refs     map[uint32]int           // 文件id -> 正在读取的引用计数
+}
+
+func newDataFileLRU(dirPath string, ioConfig fio.IOConfig, capacity int) *dataFileLRU {
+	return &dataFileLRU{
+		capacity: capacity,
+		dirPath:  dirPath,
+		ioConfig: ioConfig,
+		mu:       new(sync.Mutex),
+		ll:       list.New(),
+		items:    make(map[uint32]*list.Element),
+		refs:     make(map[uint32]int),
+	}
+}
+
+// acquire 引用数据文件，如果文件已经被关闭则重新打开
+// 使用完成后必须调用 release
+func (l *dataFileLRU) acquire(dataFile *data.DataFile) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !dataFile.IsOpen() {
+		if err := dataFile.SetIOManager(l.dirPath, l.ioConfig); err != nil {
+			return err
+		}
+	}
+	l.touch(dataFile)
+	l.refs[dataFile.FileId]++
+	return l.evict()
+}
+
+// release 释放对数据文件的引用
+func (l *dataFileLRU) release(dataFile *data.DataFile) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// 获取时还是活跃文件，没有被引用
+	if l.refs[dataFile.FileId] == 0 {
+		return nil
+	}
+	l.refs[dataFile.FileId]--
+	if l.refs[dataFile.FileId] == 0 {
+		delete(l.refs, dataFile.FileId)
+	}
+	return l.evict()
+}
+
+// add 记录一个已经打开的旧数据文件，例如活跃文件写满后转换成的旧文件
+func (l *dataFileLRU) add(dataFile *data.DataFile) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.touch(dataFile)
+	return l.evict()
+}
+
+// openCount 当前打开的旧数据文件数量
+func (l *dataFileLRU) openCount() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.ll.Len()
+}
+
+// 调用前需要持有锁
+func (l *dataFileLRU) touch(dataFile *data.DataFile) {
+	if elem, ok := l.items[dataFile.FileId]; ok {
+		l.ll.MoveToFront(elem)
+		return
+	}
+	l.items[dataFile.FileId] = l.ll.PushFront(dataFile)
+}
+
+// 关闭超出上限的文件，从最久未访问的开始，跳过正在被读取的文件
+// 调用前需要持有锁
+func (l *dataFileLRU) evict() error {
+	elem := l.ll.Back()
+	for l.ll.Len() > l.capacity && elem != nil {
+		prev := elem.Prev()
+		dataFile := elem.Value.(*data.DataFile)
+		if l.refs[dataFile.FileId] == 0 {
+			l.ll.Remove(elem)
+			delete(l.items, dataFile.FileId)
+			if err := dataFile.Release(); err != nil {
+				return err
+			}
+		}
+		elem = prev
+	}
+	return nil
+}
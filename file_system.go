@@ -0,0 +1,112 @@
+package SingleKVDataSet
+
+import (
+	"SingleKVDataSet/fio"
+	"SingleKVDataSet/utils"
+	"os"
+	"path/filepath"
+)
+
+// 对数据目录的文件操作，内存模式下操作的是 fio 中的内存文件
+
+// ioConfig 数据文件使用的 IO 配置
+func (db *DB) ioConfig() fio.IOConfig {
+	config := fio.IOConfig{Type: fio.StandardFIO, Faults: db.options.FaultInjector}
+	if db.options.InMemory {
+		config.Type = fio.InMemory
+	}
+	return config
+}
+
+// fileExists 判断文件是否存在
+func (db *DB) fileExists(fileName string) bool {
+	if db.options.InMemory {
+		return fio.MemoryFileExists(fileName)
+	}
+	_, err := os.Stat(fileName)
+	return err == nil
+}
+
+// dirExists 判断目录是否存在，内存模式下目录中有文件就认为存在
+func (db *DB) dirExists(dirPath string) bool {
+	if db.options.InMemory {
+		return len(fio.ListMemoryFiles(dirPath)) > 0
+	}
+	_, err := os.Stat(dirPath)
+	return err == nil
+}
+
+// readDirNames 读取目录下所有文件的文件名
+func (db *DB) readDirNames(dirPath string) ([]string, error) {
+	if db.options.InMemory {
+		return fio.ListMemoryFiles(dirPath), nil
+	}
+	dirEntries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(dirEntries))
+	for i, entry := range dirEntries {
+		names[i] = entry.Name()
+	}
+	return names, nil
+}
+
+// removeFile 删除文件
+func (db *DB) removeFile(fileName string) error {
+	if db.options.InMemory {
+		fio.RemoveMemoryFile(fileName)
+		return nil
+	}
+	return os.Remove(fileName)
+}
+
+// removeDir 删除目录以及目录下的所有文件
+func (db *DB) removeDir(dirPath string) error {
+	if db.options.InMemory {
+		fio.RemoveMemoryDir(dirPath)
+		return nil
+	}
+	return os.RemoveAll(dirPath)
+}
+
+// renameFile 移动文件
+func (db *DB) renameFile(src, dest string) error {
+	if db.options.InMemory {
+		return fio.RenameMemoryFile(src, dest)
+	}
+	return os.Rename(src, dest)
+}
+
+// dirSize 数据目录所占空间大小，内存模式下为所有内存文件的大小
+func (db *DB) dirSize() (int64, error) {
+	if db.options.InMemory {
+		return fio.MemoryDirSize(db.options.DirPath), nil
+	}
+	return utils.DirSize(db.options.DirPath)
+}
+
+// backupMemoryFiles 将内存中的数据文件写到磁盘目录中
+func (db *DB) backupMemoryFiles(dest string) error {
+	if err := os.MkdirAll(dest, os.ModePerm); err != nil {
+		return err
+	}
+	for _, name := range fio.ListMemoryFiles(db.options.DirPath) {
+		memoryIO, err := fio.NewMemoryIOManager(filepath.Join(db.options.DirPath, name))
+		if err != nil {
+			return err
+		}
+		size, err := memoryIO.Size()
+		if err != nil {
+			return err
+		}
+		buf := make([]byte, size)
+		if _, err := memoryIO.Read(buf, 0); err != nil && size > 0 {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dest, name), buf, fio.DataFilePerm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build linux
+
+package fio
+
+import (
+	"os"
+	"syscall"
+)
+
+// 使用 fallocate 预分配磁盘空间，未写入的部分读取出来全部是 0
+func allocate(fd *os.File, size int64) error {
+	return syscall.Fallocate(int(fd.Fd()), 0, 0, size)
+}
@@ -0,0 +1,225 @@
+package fio
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+var (
+	ErrCrashed      = errors.New("fault injection: process crashed")
+	ErrInjectedSync = errors.New("fault injection: sync failed")
+	ErrInjectedRead = syscall.EIO
+	ErrNoTruncate   = errors.New("fault injection: file can not be truncated")
+)
+
+// FaultInjector 故障注入器，由测试代码控制在什么时候注入什么故障
+// 可以模拟部分写入、sync 失败、读取时的 EIO 以及断电丢失未持久化的数据
+type FaultInjector struct {
+	mu           *sync.Mutex
+	rand         *rand.Rand
+	writes       int              // 累计写入次数
+	shortWriteAt int              // 第 n 次写入只写入一部分数据，为 0 时不注入
+	crashAt      int              // 第 n 次写入时崩溃，为 0 时不注入
+	crashed      bool             // 是否已经崩溃，崩溃后所有的写入和 sync 都会失败
+	syncErr      error            // sync 时返回的错误
+	readErr      error            // 读取时返回的错误
+	synced       map[string]int64 // 文件名 -> 已经持久化的数据大小
+	files        map[string]*FaultIO
+}
+
+// NewFaultInjector 初始化故障注入器，seed 用于决定崩溃时写入了多少数据
+func NewFaultInjector(seed int64) *FaultInjector {
+	return &FaultInjector{
+		mu:     new(sync.Mutex),
+		rand:   rand.New(rand.NewSource(seed)),
+		synced: make(map[string]int64),
+		files:  make(map[string]*FaultIO),
+	}
+}
+
+// ShortWriteAt 第 n 次写入（从当前开始计数）时只写入一部分数据并返回 io.ErrShortWrite
+func (fi *FaultInjector) ShortWriteAt(n int) {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	fi.shortWriteAt = fi.writes + n
+}
+
+// CrashAt 第 n 次写入（从当前开始计数）时崩溃，这次写入只有随机长度的前缀落到文件中
+func (fi *FaultInjector) CrashAt(n int) {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	fi.crashAt = fi.writes + n
+}
+
+// FailSync 之后所有的 sync 都返回 err，传入 nil 时恢复正常
+func (fi *FaultInjector) FailSync(err error) {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	fi.syncErr = err
+}
+
+// FailRead 之后所有的读取都返回 err，传入 nil 时恢复正常
+func (fi *FaultInjector) FailRead(err error) {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	fi.readErr = err
+}
+
+// Crashed 是否已经崩溃
+func (fi *FaultInjector) Crashed() bool {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	return fi.crashed
+}
+
+// Writes 累计的写入次数
+func (fi *FaultInjector) Writes() int {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	return fi.writes
+}
+
+// PowerLoss 模拟断电，所有文件丢弃最后一次 sync 之后写入的数据
+func (fi *FaultInjector) PowerLoss() error {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	fi.crashed = true
+	for name, file := range fi.files {
+		truncater, ok := file.inner.(interface{ Truncate(int64) error })
+		if !ok {
+			return ErrNoTruncate
+		}
+		if err := truncater.Truncate(fi.synced[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reset 清除所有的故障，模拟进程重启
+func (fi *FaultInjector) Reset() {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	fi.shortWriteAt = 0
+	fi.crashAt = 0
+	fi.crashed = false
+	fi.syncErr = nil
+	fi.readErr = nil
+}
+
+// FaultIO 包装其他 IOManager，根据故障注入器的设置返回错误
+type FaultIO struct {
+	name     string
+	inner    IOManager
+	injector *FaultInjector
+}
+
+// NewFaultIOManager 使用故障注入器包装 IOManager
+func NewFaultIOManager(filename string, inner IOManager, injector *FaultInjector) (*FaultIO, error) {
+	filename = filepath.Clean(filename)
+	injector.mu.Lock()
+	defer injector.mu.Unlock()
+	// 第一次打开文件时，文件中已有的数据认为是已经持久化的
+	if _, ok := injector.synced[filename]; !ok {
+		size, err := inner.Size()
+		if err != nil {
+			return nil, err
+		}
+		injector.synced[filename] = size
+	}
+	fio := &FaultIO{name: filename, inner: inner, injector: injector}
+	injector.files[filename] = fio
+	return fio, nil
+}
+
+// Read 从文件的给定位置读取对应的数据
+func (fio *FaultIO) Read(b []byte, offset int64) (int, error) {
+	fio.injector.mu.Lock()
+	readErr := fio.injector.readErr
+	fio.injector.mu.Unlock()
+	if readErr != nil {
+		return 0, readErr
+	}
+	return fio.inner.Read(b, offset)
+}
+
+// Write 写入字节数组到文件中
+func (fio *FaultIO) Write(b []byte) (int, error) {
+	injector := fio.injector
+	injector.mu.Lock()
+	defer injector.mu.Unlock()
+
+	if injector.crashed {
+		return 0, ErrCrashed
+	}
+	injector.writes++
+	switch injector.writes {
+	case injector.crashAt:
+		// 崩溃时只有一部分数据写入了文件
+		injector.crashed = true
+		n, err := fio.inner.Write(b[:injector.rand.Intn(len(b)+1)])
+		if err != nil {
+			return n, err
+		}
+		return n, ErrCrashed
+	case injector.shortWriteAt:
+		n, err := fio.inner.Write(b[:len(b)/2])
+		if err != nil {
+			return n, err
+		}
+		return n, io.ErrShortWrite
+	}
+	return fio.inner.Write(b)
+}
+
+// Sync 持久化数据
+func (fio *FaultIO) Sync() error {
+	injector := fio.injector
+	injector.mu.Lock()
+	defer injector.mu.Unlock()
+
+	if injector.crashed {
+		return ErrCrashed
+	}
+	if injector.syncErr != nil {
+		return injector.syncErr
+	}
+	if err := fio.inner.Sync(); err != nil {
+		return err
+	}
+	size, err := fio.inner.Size()
+	if err != nil {
+		return err
+	}
+	injector.synced[fio.name] = size
+	return nil
+}
+
+// Close 关闭文件
+func (fio *FaultIO) Close() error {
+	return fio.inner.Close()
+}
+
+// Size 获取到文件大小
+func (fio *FaultIO) Size() (int64, error) {
+	return fio.inner.Size()
+}
+
+// Allocate 预分配空间，被包装的 IOManager 不支持时直接忽略
+func (fio *FaultIO) Allocate(size int64) error {
+	if allocator, ok := fio.inner.(Allocator); ok {
+		return allocator.Allocate(size)
+	}
+	return nil
+}
+
+// SetWriteOffset 设置逻辑写入位置
+func (fio *FaultIO) SetWriteOffset(offset int64) {
+	if allocator, ok := fio.inner.(Allocator); ok {
+		allocator.SetWriteOffset(offset)
+	}
+}
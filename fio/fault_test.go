@@ -0,0 +1,92 @@
+package fio
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func TestFaultIO_ShortWrite(t *testing.T) {
+	dir := "fault-short-write"
+	injector := NewFaultInjector(1)
+	defer RemoveMemoryDir(dir)
+
+	mio, err := IOConfig{Type: InMemory, Faults: injector}.Open(filepath.Join(dir, "a.data"))
+	assert.Nil(t, err)
+	_, ok := mio.(*FaultIO)
+	assert.True(t, ok)
+	// 没有设置故障注入器时不会被包装
+	other, err := IOConfig{Type: InMemory}.Open(filepath.Join(dir, "b.data"))
+	assert.Nil(t, err)
+	_, ok = other.(*FaultIO)
+	assert.False(t, ok)
+
+	injector.ShortWriteAt(2)
+	n, err := mio.Write([]byte("key-a"))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	n, err = mio.Write([]byte("key-b"))
+	assert.Equal(t, io.ErrShortWrite, err)
+	assert.Equal(t, 2, n)
+	size, err := mio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), size)
+}
+
+func TestFaultIO_SyncAndRead(t *testing.T) {
+	dir := "fault-sync-read"
+	injector := NewFaultInjector(1)
+	defer RemoveMemoryDir(dir)
+
+	mio, err := IOConfig{Type: InMemory, Faults: injector}.Open(filepath.Join(dir, "a.data"))
+	assert.Nil(t, err)
+	_, err = mio.Write([]byte("key-a"))
+	assert.Nil(t, err)
+
+	injector.FailSync(ErrInjectedSync)
+	assert.Equal(t, ErrInjectedSync, mio.Sync())
+	injector.FailSync(nil)
+	assert.Nil(t, mio.Sync())
+
+	injector.FailRead(ErrInjectedRead)
+	_, err = mio.Read(make([]byte, 5), 0)
+	assert.Equal(t, ErrInjectedRead, err)
+	injector.FailRead(nil)
+	b := make([]byte, 5)
+	_, err = mio.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-a"), b)
+}
+
+func TestFaultIO_CrashAndPowerLoss(t *testing.T) {
+	dir := "fault-crash"
+	injector := NewFaultInjector(1)
+	defer RemoveMemoryDir(dir)
+
+	mio, err := IOConfig{Type: InMemory, Faults: injector}.Open(filepath.Join(dir, "a.data"))
+	assert.Nil(t, err)
+	_, err = mio.Write([]byte("synced"))
+	assert.Nil(t, err)
+	assert.Nil(t, mio.Sync())
+	_, err = mio.Write([]byte("unsynced"))
+	assert.Nil(t, err)
+
+	injector.CrashAt(1)
+	_, err = mio.Write([]byte("crash"))
+	assert.Equal(t, ErrCrashed, err)
+	assert.True(t, injector.Crashed())
+	_, err = mio.Write([]byte("after crash"))
+	assert.Equal(t, ErrCrashed, err)
+	assert.Equal(t, ErrCrashed, mio.Sync())
+
+	// 断电后只保留 sync 过的数据
+	assert.Nil(t, injector.PowerLoss())
+	size, err := mio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), size)
+
+	injector.Reset()
+	_, err = mio.Write([]byte("!"))
+	assert.Nil(t, err)
+}
@@ -4,24 +4,33 @@ import "os"
 
 // FileIO 标准系统文件 IO
 type FileIO struct {
-	fd *os.File // 系统文件描述符
+	fd       *os.File // 系统文件描述符
+	writeOff int64    // 逻辑写入位置，预分配空间后与文件的物理大小不再相同
 }
 
 // NewFileIOManager 初始化标准文件IO
 func NewFileIOManager(filename string) (*FileIO, error) {
-	fd, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR|os.O_APPEND, DataFilePerm)
+	fd, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR, DataFilePerm)
 	if err != nil {
 		return nil, err
 	}
-	return &FileIO{fd: fd}, nil
+	stat, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+	return &FileIO{fd: fd, writeOff: stat.Size()}, nil
 }
 
 func (fio *FileIO) Read(b []byte, offset int64) (int, error) {
 	return fio.fd.ReadAt(b, offset)
 }
 
+// Write 从逻辑写入位置开始写，未预分配空间时等同于追加写
 func (fio *FileIO) Write(b []byte) (int, error) {
-	return fio.fd.Write(b)
+	n, err := fio.fd.WriteAt(b, fio.writeOff)
+	fio.writeOff += int64(n)
+	return n, err
 }
 
 func (fio *FileIO) Sync() (err error) {
@@ -32,6 +41,7 @@ func (fio *FileIO) Close() (err error) {
 	return fio.fd.Close()
 }
 
+// Size 文件的物理大小，包含预分配的空间
 func (fio *FileIO) Size() (int64, error) {
 	stat, err := fio.fd.Stat()
 	if err != nil {
@@ -39,3 +49,24 @@ func (fio *FileIO) Size() (int64, error) {
 	}
 	return stat.Size(), nil
 }
+
+// Allocate 为文件预分配 size 大小的空间，逻辑写入位置保持不变
+func (fio *FileIO) Allocate(size int64) error {
+	return allocate(fio.fd, size)
+}
+
+// SetWriteOffset 设置逻辑写入位置，重新打开预分配的文件后需要根据实际数据设置
+func (fio *FileIO) SetWriteOffset(offset int64) {
+	fio.writeOff = offset
+}
+
+// Truncate 将文件截断为 size 大小，文件关闭之后也可以调用
+func (fio *FileIO) Truncate(size int64) error {
+	if err := os.Truncate(fio.fd.Name(), size); err != nil {
+		return err
+	}
+	if fio.writeOff > size {
+		fio.writeOff = size
+	}
+	return nil
+}
@@ -109,3 +109,35 @@ func TestFileIO_Close(t *testing.T) {
 	err = fio.Close()
 	assert.Nil(t, err)
 }
+
+func TestFileIO_Allocate(t *testing.T) {
+	path := filepath.Join("../TestingFile", "a.data")
+	fio, err := NewFileIOManager(path)
+	defer destoryFile(path)
+	defer fio.Close()
+	assert.Nil(t, err)
+
+	err = fio.Allocate(1024)
+	assert.Nil(t, err)
+	size, err := fio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1024), size)
+
+	// 预分配之后仍然从逻辑写入位置开始写
+	_, err = fio.Write([]byte("key-a"))
+	assert.Nil(t, err)
+	b := make([]byte, 5)
+	_, err = fio.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-a"), b)
+
+	fio.SetWriteOffset(100)
+	_, err = fio.Write([]byte("key-b"))
+	assert.Nil(t, err)
+	_, err = fio.Read(b, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-b"), b)
+	size, err = fio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1024), size)
+}
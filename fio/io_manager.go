@@ -10,6 +10,9 @@ const (
 
 	// MemoryMap 内存文件映射
 	MemoryMap
+
+	// InMemory 数据只保存在进程内存中
+	InMemory
 )
 
 // 抽象IO管理器接口，可以接入不同的IO类型，目前支持标准文件IO
@@ -30,14 +33,39 @@ type IOManager interface {
 	Size() (int64, error)
 }
 
-// 初始化 IOManager，目前仅支持标准IO
+// Allocator 支持预分配文件空间的 IO 管理器
+type Allocator interface {
+	// Allocate 预分配 size 大小的空间
+	Allocate(size int64) error
+	// SetWriteOffset 设置逻辑写入位置
+	SetWriteOffset(offset int64)
+}
+
+// 初始化 IOManager
 func NewIOManager(filename string, ioType FileIOType) (IOManager, error) {
 	switch ioType {
 	case StandardFIO:
 		return NewFileIOManager(filename)
 	case MemoryMap:
 		return NewMMapIOManager(filename)
+	case InMemory:
+		return NewMemoryIOManager(filename)
 	default:
 		panic("unsupported io type")
 	}
 }
+
+// IOConfig 打开文件时使用的 IO 配置
+type IOConfig struct {
+	Type   FileIOType     // IO 类型
+	Faults *FaultInjector // 故障注入器，只在测试中使用，为空时不注入故障
+}
+
+// Open 按照配置打开文件，设置了故障注入器时包装为 FaultIO
+func (c IOConfig) Open(filename string) (IOManager, error) {
+	ioManager, err := NewIOManager(filename, c.Type)
+	if err != nil || c.Faults == nil {
+		return ioManager, err
+	}
+	return NewFaultIOManager(filename, ioManager, c.Faults)
+}
@@ -0,0 +1,188 @@
+package fio
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// 进程内的内存文件，按文件名保存，关闭之后重新打开仍然可以读到之前的数据
+var memoryFiles = struct {
+	mu    *sync.Mutex
+	files map[string]*memoryFile
+}{
+	mu:    new(sync.Mutex),
+	files: make(map[string]*memoryFile),
+}
+
+type memoryFile struct {
+	mu   *sync.RWMutex
+	data []byte
+}
+
+// MemoryIO 内存文件 IO，数据只保存在当前进程的内存中
+type MemoryIO struct {
+	file     *memoryFile
+	writeOff int64 // 逻辑写入位置
+}
+
+// NewMemoryIOManager 初始化内存文件IO，文件不存在时新建
+func NewMemoryIOManager(filename string) (*MemoryIO, error) {
+	filename = filepath.Clean(filename)
+	memoryFiles.mu.Lock()
+	defer memoryFiles.mu.Unlock()
+
+	file, ok := memoryFiles.files[filename]
+	if !ok {
+		file = &memoryFile{mu: new(sync.RWMutex)}
+		memoryFiles.files[filename] = file
+	}
+	file.mu.RLock()
+	size := int64(len(file.data))
+	file.mu.RUnlock()
+	return &MemoryIO{file: file, writeOff: size}, nil
+}
+
+// Read 从文件的给定位置读取对应的数据
+func (mio *MemoryIO) Read(b []byte, offset int64) (int, error) {
+	mio.file.mu.RLock()
+	defer mio.file.mu.RUnlock()
+
+	if offset >= int64(len(mio.file.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, mio.file.data[offset:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Write 从逻辑写入位置开始写，之后的数据会被丢弃
+func (mio *MemoryIO) Write(b []byte) (int, error) {
+	mio.file.mu.Lock()
+	defer mio.file.mu.Unlock()
+	if mio.writeOff > int64(len(mio.file.data)) {
+		mio.writeOff = int64(len(mio.file.data))
+	}
+	mio.file.data = append(mio.file.data[:mio.writeOff], b...)
+	mio.writeOff += int64(len(b))
+	return len(b), nil
+}
+
+// Sync 持久化数据，内存文件无需持久化
+func (mio *MemoryIO) Sync() error {
+	return nil
+}
+
+// Close 关闭文件，数据仍然保留在内存中
+func (mio *MemoryIO) Close() error {
+	return nil
+}
+
+// Size 获取到文件大小
+func (mio *MemoryIO) Size() (int64, error) {
+	mio.file.mu.RLock()
+	defer mio.file.mu.RUnlock()
+	return int64(len(mio.file.data)), nil
+}
+
+// Allocate 内存文件不需要预分配空间
+func (mio *MemoryIO) Allocate(size int64) error {
+	return nil
+}
+
+// SetWriteOffset 设置逻辑写入位置
+func (mio *MemoryIO) SetWriteOffset(offset int64) {
+	mio.writeOff = offset
+}
+
+// Truncate 将文件截断为 size 大小
+func (mio *MemoryIO) Truncate(size int64) error {
+	mio.file.mu.Lock()
+	defer mio.file.mu.Unlock()
+	if size < int64(len(mio.file.data)) {
+		mio.file.data = mio.file.data[:size]
+	}
+	if mio.writeOff > size {
+		mio.writeOff = size
+	}
+	return nil
+}
+
+// MemoryFileExists 判断内存文件是否存在
+func MemoryFileExists(filename string) bool {
+	memoryFiles.mu.Lock()
+	defer memoryFiles.mu.Unlock()
+	_, ok := memoryFiles.files[filepath.Clean(filename)]
+	return ok
+}
+
+// ListMemoryFiles 列出目录下所有内存文件的文件名，按文件名排序
+func ListMemoryFiles(dirPath string) []string {
+	dirPath = filepath.Clean(dirPath)
+	memoryFiles.mu.Lock()
+	defer memoryFiles.mu.Unlock()
+
+	var names []string
+	for filename := range memoryFiles.files {
+		if filepath.Dir(filename) == dirPath {
+			names = append(names, filepath.Base(filename))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// MemoryDirSize 目录下所有内存文件的大小之和
+func MemoryDirSize(dirPath string) int64 {
+	dirPath = filepath.Clean(dirPath)
+	memoryFiles.mu.Lock()
+	defer memoryFiles.mu.Unlock()
+
+	var size int64
+	for filename, file := range memoryFiles.files {
+		if filepath.Dir(filename) == dirPath {
+			file.mu.RLock()
+			size += int64(len(file.data))
+			file.mu.RUnlock()
+		}
+	}
+	return size
+}
+
+// RenameMemoryFile 重命名内存文件，目标文件存在时会被覆盖
+func RenameMemoryFile(src, dest string) error {
+	src, dest = filepath.Clean(src), filepath.Clean(dest)
+	memoryFiles.mu.Lock()
+	defer memoryFiles.mu.Unlock()
+
+	file, ok := memoryFiles.files[src]
+	if !ok {
+		return &os.PathError{Op: "rename", Path: src, Err: os.ErrNotExist}
+	}
+	delete(memoryFiles.files, src)
+	memoryFiles.files[dest] = file
+	return nil
+}
+
+// RemoveMemoryFile 删除内存文件
+func RemoveMemoryFile(filename string) {
+	memoryFiles.mu.Lock()
+	defer memoryFiles.mu.Unlock()
+	delete(memoryFiles.files, filepath.Clean(filename))
+}
+
+// RemoveMemoryDir 删除目录下的所有内存文件，释放内存
+func RemoveMemoryDir(dirPath string) {
+	dirPath = filepath.Clean(dirPath)
+	memoryFiles.mu.Lock()
+	defer memoryFiles.mu.Unlock()
+	for filename := range memoryFiles.files {
+		if filepath.Dir(filename) == dirPath {
+			delete(memoryFiles.files, filename)
+		}
+	}
+}
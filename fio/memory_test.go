@@ -0,0 +1,71 @@
+package fio
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func TestMemoryIO_Read_Write(t *testing.T) {
+	path := filepath.Join("memory-dir", "a.data")
+	defer RemoveMemoryDir("memory-dir")
+	mio, err := NewMemoryIOManager(path)
+	assert.Nil(t, err)
+
+	b := make([]byte, 5)
+	n, err := mio.Read(b, 0)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, io.EOF, err)
+
+	_, err = mio.Write([]byte("key-a"))
+	assert.Nil(t, err)
+	_, err = mio.Write([]byte("key-b"))
+	assert.Nil(t, err)
+
+	n, err = mio.Read(b, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, []byte("key-b"), b)
+
+	size, err := mio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), size)
+
+	// 关闭后重新打开，数据仍然存在
+	assert.Nil(t, mio.Close())
+	mio2, err := NewMemoryIOManager(path)
+	assert.Nil(t, err)
+	n, err = mio2.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-a"), b)
+}
+
+func TestMemoryIO_Files(t *testing.T) {
+	defer RemoveMemoryDir("memory-dir")
+	_, err := NewMemoryIOManager(filepath.Join("memory-dir", "b.data"))
+	assert.Nil(t, err)
+	mio, err := NewMemoryIOManager(filepath.Join("memory-dir", "a.data"))
+	assert.Nil(t, err)
+	_, err = mio.Write([]byte("hello"))
+	assert.Nil(t, err)
+	_, err = NewMemoryIOManager(filepath.Join("memory-dir", "sub", "c.data"))
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"a.data", "b.data"}, ListMemoryFiles("memory-dir"))
+	assert.Equal(t, int64(5), MemoryDirSize("memory-dir"))
+	assert.True(t, MemoryFileExists(filepath.Join("memory-dir", "a.data")))
+
+	err = RenameMemoryFile(filepath.Join("memory-dir", "a.data"), filepath.Join("memory-dir", "d.data"))
+	assert.Nil(t, err)
+	assert.False(t, MemoryFileExists(filepath.Join("memory-dir", "a.data")))
+	assert.True(t, MemoryFileExists(filepath.Join("memory-dir", "d.data")))
+	err = RenameMemoryFile(filepath.Join("memory-dir", "a.data"), filepath.Join("memory-dir", "e.data"))
+	assert.NotNil(t, err)
+
+	RemoveMemoryFile(filepath.Join("memory-dir", "b.data"))
+	assert.Equal(t, []string{"d.data"}, ListMemoryFiles("memory-dir"))
+
+	RemoveMemoryDir(filepath.Join("memory-dir", "sub"))
+	assert.False(t, MemoryFileExists(filepath.Join("memory-dir", "sub", "c.data")))
+}
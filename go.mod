@@ -5,9 +5,9 @@ go 1.24
 require (
 	github.com/gofrs/flock v0.12.1
 	github.com/google/btree v1.1.3
-	github.com/plar/go-adaptive-radix-tree/v2 v2.0.3
 	github.com/shirou/gopsutil/v4 v4.25.4
 	github.com/stretchr/testify v1.10.0
+	github.com/tidwall/redcon v1.6.2
 	go.etcd.io/bbolt v1.4.0
 	golang.org/x/exp v0.0.0-20250506013437-ce4c2cf36ca6
 )
@@ -23,7 +23,6 @@ require (
 	github.com/rogpeppe/go-internal v1.14.1 // indirect
 	github.com/tidwall/btree v1.7.0 // indirect
 	github.com/tidwall/match v1.1.1 // indirect
-	github.com/tidwall/redcon v1.6.2 // indirect
 	github.com/tklauser/go-sysconf v0.3.12 // indirect
 	github.com/tklauser/numcpus v0.6.1 // indirect
 	github.com/yusufpapurcu/wmi v1.2.4 // indirect
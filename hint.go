@@ -0,0 +1,149 @@
+package SingleKVDataSet
+
+import (
+	"SingleKVDataSet/data"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+)
+
+// 每个写满的数据文件都会在后台生成一个 hint 文件，启动时读取 hint 文件代替扫描整个数据文件
+// hint 文件中的记录和数据文件中的记录一一对应，key 保留事务序列号，value 是记录的位置，类型标识是否被删除
+// 文件末尾是 8 字节的数据文件大小和 4 字节的 crc 校验值，校验值覆盖之前的全部内容
+const hintFooterSize = 8 + crc32.Size
+
+// writeHintFileAsync 在后台为旧的数据文件生成 hint 文件
+func (db *DB) writeHintFileAsync(fileId uint32) {
+	db.hintWg.Add(1)
+	go func() {
+		defer db.hintWg.Done()
+		// 生成失败时不影响正常读写，启动时会直接扫描数据文件
+		_ = db.writeHintFile(fileId)
+	}()
+}
+
+// writeHintFile 扫描数据文件中所有的记录，生成对应的 hint 文件
+// 单独打开数据文件进行读取，不占用旧数据文件的打开数量限制，也不受读取时切换 IO 类型的影响
+func (db *DB) writeHintFile(fileId uint32) error {
+	dataFile, err := data.OpenDataFile(db.options.DirPath, fileId, db.ioConfig())
+	if err != nil {
+		return err
+	}
+	defer dataFile.Close()
+
+	// 之前生成了一部分的 hint 文件需要删除
+	hintFileName := data.GetHintFileName(db.options.DirPath, dataFile.FileId)
+	if db.fileExists(hintFileName) {
+		if err := db.removeFile(hintFileName); err != nil {
+			return err
+		}
+	}
+	hintFile, err := data.OpenDataHintFile(db.options.DirPath, dataFile.FileId, db.ioConfig())
+	if err != nil {
+		return err
+	}
+	defer hintFile.Close()
+
+	hash := crc32.NewIEEE()
+	buf := make([]byte, 0, indexSnapshotBufferSize)
+	flush := func() error {
+		hash.Write(buf)
+		if err := hintFile.Write(buf); err != nil {
+			return err
+		}
+		buf = buf[:0]
+		return nil
+	}
+
+	var offset int64 = 0
+	for {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		pos := &data.LogRecordPos{Fid: dataFile.FileId, Offset: offset, Size: uint32(size)}
+		encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+			Key:   logRecord.Key,
+			Value: data.EncodeLogRecordPos(pos),
+			Type:  logRecord.Type,
+		})
+		buf = append(buf, encRecord...)
+		if len(buf) >= indexSnapshotBufferSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		offset += size
+	}
+
+	// 写入数据文件大小和校验值
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(offset))
+	hash.Write(buf)
+	buf = binary.LittleEndian.AppendUint32(buf, hash.Sum32())
+	if err := hintFile.Write(buf); err != nil {
+		return err
+	}
+	return hintFile.Sync()
+}
+
+// readHintFile 读取数据文件对应的 hint 文件
+// hint 文件不存在、校验失败或者和数据文件不匹配时返回 false，需要扫描数据文件
+func (db *DB) readHintFile(dataFile *data.DataFile) ([]*data.TransactionRecord, bool) {
+	hintFileName := data.GetHintFileName(db.options.DirPath, dataFile.FileId)
+	if !db.fileExists(hintFileName) {
+		return nil, false
+	}
+	hintFile, err := data.OpenDataHintFile(db.options.DirPath, dataFile.FileId, db.ioConfig())
+	if err != nil {
+		return nil, false
+	}
+	defer hintFile.Close()
+
+	// 先校验整个文件的 crc，hint 文件可能只写了一部分
+	hintSize, err := hintFile.IoManager.Size()
+	if err != nil || hintSize < hintFooterSize {
+		return nil, false
+	}
+	buf := make([]byte, hintSize)
+	if _, err := hintFile.IoManager.Read(buf, 0); err != nil {
+		return nil, false
+	}
+	crc := binary.LittleEndian.Uint32(buf[hintSize-crc32.Size:])
+	if crc32.ChecksumIEEE(buf[:hintSize-crc32.Size]) != crc {
+		return nil, false
+	}
+
+	// 数据文件中的数据必须完整
+	dataSize := int64(binary.LittleEndian.Uint64(buf[hintSize-hintFooterSize:]))
+	fileSize, err := dataFile.IoManager.Size()
+	if err != nil || fileSize < dataSize {
+		return nil, false
+	}
+
+	var records []*data.TransactionRecord
+	var offset int64 = 0
+	for offset < hintSize-hintFooterSize {
+		logRecord, size, err := hintFile.ReadLogRecord(offset)
+		if err != nil {
+			return nil, false
+		}
+		records = append(records, &data.TransactionRecord{
+			Record: &data.LogRecord{Key: logRecord.Key, Type: logRecord.Type},
+			Pos:    data.DecodeLogRecordPos(logRecord.Value),
+		})
+		offset += size
+	}
+	return records, true
+}
+
+// removeHintFile 删除数据文件对应的 hint 文件
+func (db *DB) removeHintFile(fileId uint32) error {
+	hintFileName := data.GetHintFileName(db.options.DirPath, fileId)
+	if !db.fileExists(hintFileName) {
+		return nil
+	}
+	return db.removeFile(hintFileName)
+}
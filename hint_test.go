@@ -0,0 +1,136 @@
+package SingleKVDataSet
+
+import (
+	"SingleKVDataSet/data"
+	"SingleKVDataSet/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_HintFile(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("./TestingFile", "bitcask-go-hint")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 3000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	for i := 0; i < 3000; i += 3 {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	// 事务跨越多个数据文件
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 3000; i < 4000; i++ {
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Nil(t, wb.Commit())
+	activeFileId := db.activeFile.FileId
+	reclaimSize := db.Stat().ReclaimableSize
+	assert.Nil(t, db.Close())
+
+	// 每个写满的数据文件都有对应的 hint 文件
+	for fid := uint32(0); fid < activeFileId; fid++ {
+		assert.FileExists(t, data.GetHintFileName(dir, fid))
+	}
+	assert.NoFileExists(t, data.GetHintFileName(dir, activeFileId))
+
+	// 破坏第一个数据文件中的记录，从 hint 文件加载索引时不会读取数据文件
+	dataFileName := data.GetDataFileName(dir, 0)
+	dataFile, err := os.OpenFile(dataFileName, os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	_, err = dataFile.WriteAt([]byte{0xff}, 30)
+	assert.Nil(t, err)
+	assert.Nil(t, dataFile.Close())
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, reclaimSize, db2.Stat().ReclaimableSize)
+	for i := 100; i < 4000; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		if i < 3000 && i%3 == 0 {
+			assert.Equal(t, ErrKeyNotFound, err)
+		} else {
+			assert.Nil(t, err)
+			assert.Equal(t, utils.GetTestKey(i), val)
+		}
+	}
+}
+
+func TestDB_HintFile_Torn(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("./TestingFile", "bitcask-go-hint-torn")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 3000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Close())
+
+	// hint 文件只写了一部分，校验失败后扫描数据文件，并在后台重新生成 hint 文件
+	hintFileName := data.GetHintFileName(dir, 1)
+	info, err := os.Stat(hintFileName)
+	assert.Nil(t, err)
+	assert.Nil(t, os.Truncate(hintFileName, info.Size()-1))
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 3000, len(db2.ListKeys()))
+	for i := 0; i < 3000; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+	assert.Nil(t, db2.Close())
+
+	repaired, err := os.Stat(hintFileName)
+	assert.Nil(t, err)
+	assert.Equal(t, info.Size(), repaired.Size())
+
+	db3, err := Open(opts)
+	defer destroyDB(db3)
+	assert.Nil(t, err)
+	_, ok := db3.readHintFile(db3.oldFiles[1])
+	assert.True(t, ok)
+}
+
+func TestDB_HintFile_Merge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("./TestingFile", "bitcask-go-hint-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 3000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	for i := 0; i < 3000; i += 2 {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Merge())
+	nonMergeFileId := db.activeFile.FileId
+	assert.Nil(t, db.Close())
+
+	// merge 之后旧数据文件的 hint 文件被删除
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	for fid := uint32(0); fid < nonMergeFileId; fid++ {
+		assert.NoFileExists(t, data.GetHintFileName(dir, fid))
+	}
+	assert.Equal(t, 1500, len(db2.ListKeys()))
+	for i := 1; i < 3000; i += 2 {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+}
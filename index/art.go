@@ -3,154 +3,478 @@ package index
 import (
 	"SingleKVDataSet/data"
 	"bytes"
-	goart "github.com/plar/go-adaptive-radix-tree/v2"
 	"sort"
 	"sync"
+	"unsafe"
 )
 
 // AdaptiveRadixTree 自适应基数树索引
-// 主要封装了 https://github.com/plar/go-adaptive-radix-tree/v2
+// 内部节点压缩了公共前缀，子节点较少时使用有序数组保存，较多时使用 256 个槽位的数组
+// 按照 key 的字节序保存数据，遍历时可以直接从 Seek 的位置开始
 type AdaptiveRadixTree struct {
-	tree goart.Tree
-	lock *sync.RWMutex
+	root     *artNode
+	size     int
+	memSize  int64 // 叶子节点中的 key 和位置信息占用的内存，压缩时为所有节点的前缀和节点本身占用的内存
+	compress bool  // 是否只保存每个节点的前缀，不在叶子节点中保存完整的 key
+	lock     *sync.RWMutex
+}
+
+// 每个叶子节点除了 key 之外的内存开销估算，包括节点结构体、位置信息和父节点中的指针
+// 内部节点本身已经压缩了公共前缀，叶子节点中保存的是完整的 key
+var artLeafMemSize = int64(80 + unsafe.Sizeof(data.LogRecordPos{}))
+
+const (
+	// 子节点超过这个数量后改为使用 256 个槽位的数组
+	artNarrowMax = 48
+	// 使用数组的节点，子节点减少到这个数量后改回有序数组
+	artWideMin = 32
+)
+
+// artNode 基数树的节点
+// 从根节点到这个节点的路径为：父节点的路径 + 父节点中指向这个节点的字节 + prefix
+type artNode struct {
+	prefix   []byte             // 压缩的公共前缀
+	key      []byte             // 路径正好是一个完整的 key 时保存这个 key，压缩时不保存
+	pos      *data.LogRecordPos // key 对应的位置信息，为 nil 时这个节点没有数据
+	labels   []byte             // 子节点的首字节，升序排列，和 children 一一对应
+	children []*artNode
+	wide     *[256]*artNode // 子节点较多时使用，此时 labels 和 children 为空
+	wideNum  int            // wide 中的子节点数量
 }
 
 func NewART() *AdaptiveRadixTree {
 	return &AdaptiveRadixTree{
-		tree: goart.New(),
 		lock: new(sync.RWMutex),
 	}
 }
 
+// NewCompressedART 新建 key 按照前缀压缩保存的 ART 索引
+// 每个节点只保存自己的前缀，公共前缀只保存一份，遍历时按照路径拼接出完整的 key
+func NewCompressedART() *AdaptiveRadixTree {
+	art := NewART()
+	art.compress = true
+	return art
+}
+
 // Put 向索引中存储key对应的数据位置信息
 func (art *AdaptiveRadixTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
 	art.lock.Lock()
-	oldItem, _ := art.tree.Insert(key, pos)
-	art.lock.Unlock()
-	if oldItem == nil {
-		return nil
+	defer art.lock.Unlock()
+	oldPos := art.insert(&art.root, key, pos, 0)
+	if oldPos == nil {
+		art.size++
+		if !art.compress {
+			art.memSize += int64(len(key)) + artLeafMemSize
+		}
 	}
-	return oldItem.(*data.LogRecordPos)
+	return oldPos
 }
 
 // Get 根据key取出对应索引的位置信息
 func (art *AdaptiveRadixTree) Get(key []byte) *data.LogRecordPos {
 	art.lock.RLock()
 	defer art.lock.RUnlock()
-	value, found := art.tree.Search(key)
-	if !found {
-		return nil
+	n, depth := art.root, 0
+	for n != nil {
+		if !bytes.HasPrefix(key[depth:], n.prefix) {
+			return nil
+		}
+		depth += len(n.prefix)
+		if depth == len(key) {
+			return n.pos
+		}
+		n = n.child(key[depth])
+		depth++
 	}
-	return value.(*data.LogRecordPos)
+	return nil
 }
 
 // Delete 根据 key 删除对应的索引位置信息
 func (art *AdaptiveRadixTree) Delete(key []byte) (*data.LogRecordPos, bool) {
 	art.lock.Lock()
-	oldValue, deleted := art.tree.Delete(key)
-	art.lock.Unlock()
-	if oldValue == nil {
+	defer art.lock.Unlock()
+	oldPos := art.delete(&art.root, key, 0)
+	if oldPos == nil {
 		return nil, false
 	}
-	return oldValue.(*data.LogRecordPos), deleted
+	art.size--
+	if !art.compress {
+		art.memSize -= int64(len(key)) + artLeafMemSize
+	}
+	return oldPos, true
+}
+
+func (art *AdaptiveRadixTree) ApplyBatch(ops []IndexOp) []*data.LogRecordPos {
+	return applyBatch(art, ops)
 }
 
 // Size 索引中的数据量
 func (art *AdaptiveRadixTree) Size() int {
 	art.lock.RLock()
-	size := art.tree.Size()
-	art.lock.RUnlock()
-	return size
+	defer art.lock.RUnlock()
+	return art.size
 }
 
-// Iterator 索引迭代器
-func (art *AdaptiveRadixTree) Iterator(reverse bool) Iterator {
+// MemorySize 叶子节点占用的内存
+func (art *AdaptiveRadixTree) MemorySize() int64 {
 	art.lock.RLock()
 	defer art.lock.RUnlock()
-	return newARTIterator(art.tree, reverse)
+	return art.memSize
 }
 
-func (art *AdaptiveRadixTree) Close() error {
-	return nil
+// Iterator 迭代器从树中分批读取数据，创建时不复制全部数据
+func (art *AdaptiveRadixTree) Iterator(reverse bool) Iterator {
+	return newARTIterator(art, reverse)
 }
 
-// ART 索引迭代器
-type artIterator struct {
-	currIndex int     // 当前遍历到的位置下标
-	reverse   bool    // 是否反向遍历
-	values    []*Item // 存放key+位置索引信息
+func (art *AdaptiveRadixTree) Close() error {
+	return nil
 }
 
-// 新建ART索引迭代器的方法
-func newARTIterator(tree goart.Tree, reverse bool) *artIterator {
-	var idx int
+// 新建ART索引迭代器的方法，Seek 和读取下一批数据时直接从树上的对应位置开始遍历
+func newARTIterator(art *AdaptiveRadixTree, reverse bool) *batchIterator {
+	return newBatchIterator(func(start []byte, seek, inclusive bool, items []*Item) []*Item {
+		saveValues := func(n *artNode, path []byte) bool {
+			key := n.key
+			if art.compress {
+				key = append([]byte{}, path...)
+			}
+			if !inclusive && seek && bytes.Equal(key, start) {
+				return true
+			}
+			items = append(items, &Item{key: key, pos: n.pos})
+			return len(items) < iteratorBatchSize
+		}
 
-	if reverse {
-		idx = tree.Size() - 1
-	}
-	values := make([]*Item, tree.Size())
-	saveValues := func(node goart.Node) bool {
-		item := &Item{
-			key: node.Key(),
-			pos: node.Value().(*data.LogRecordPos),
+		art.lock.RLock()
+		defer art.lock.RUnlock()
+		if art.root == nil {
+			return items
 		}
-		values[idx] = item
 		if reverse {
-			idx--
+			art.root.descend(start, seek, 0, nil, saveValues)
 		} else {
-			idx++
+			art.root.ascend(start, seek, 0, nil, saveValues)
 		}
-		return true
+		return items
+	})
+}
+
+// insert 在 ref 指向的子树中写入数据，depth 为子树的路径长度，返回 key 之前的位置信息
+func (art *AdaptiveRadixTree) insert(ref **artNode, key []byte, pos *data.LogRecordPos, depth int) *data.LogRecordPos {
+	n := *ref
+	if n == nil {
+		*ref = art.newNode(key, depth, pos)
+		return nil
 	}
 
-	tree.ForEach(saveValues)
+	// 公共前缀不完全匹配时拆分出新的父节点
+	matched := commonPrefixLen(n.prefix, key[depth:])
+	if matched < len(n.prefix) {
+		parent := art.newNode(n.prefix[:matched], 0, nil)
+		parent.addChild(n.prefix[matched], n)
+		art.setPrefix(n, n.prefix[matched+1:])
+		if depth+matched == len(key) {
+			parent.pos = pos
+			if !art.compress {
+				parent.key = key
+			}
+		} else {
+			parent.addChild(key[depth+matched], art.newNode(key, depth+matched+1, pos))
+		}
+		*ref = parent
+		return nil
+	}
 
-	return &artIterator{
-		currIndex: 0,
-		reverse:   reverse,
-		values:    values,
+	depth += len(n.prefix)
+	if depth == len(key) {
+		oldPos := n.pos
+		n.pos = pos
+		if !art.compress {
+			n.key = key
+		}
+		return oldPos
 	}
+	child := n.childRef(key[depth])
+	if child == nil {
+		n.addChild(key[depth], art.newNode(key, depth+1, pos))
+		return nil
+	}
+	return art.insert(child, key, pos, depth+1)
 }
 
-// Rewind 重新回到迭代器起点，即第一个数据
-func (ai *artIterator) Rewind() {
-	ai.currIndex = 0
-}
+// delete 在 ref 指向的子树中删除数据，返回被删除的位置信息，key 不存在时返回 nil
+func (art *AdaptiveRadixTree) delete(ref **artNode, key []byte, depth int) *data.LogRecordPos {
+	n := *ref
+	if n == nil || !bytes.HasPrefix(key[depth:], n.prefix) {
+		return nil
+	}
+	depth += len(n.prefix)
 
-// Seek 根据传入的key查找第一个大于/小于等于的目标key，根据这个key开始遍历
-func (ai *artIterator) Seek(key []byte) {
-	if ai.reverse {
-		ai.currIndex = sort.Search(len(ai.values), func(i int) bool {
-			return bytes.Compare(ai.values[i].key, key) <= 0
-		})
+	var oldPos *data.LogRecordPos
+	if depth == len(key) {
+		oldPos = n.pos
+		n.key, n.pos = nil, nil
 	} else {
-		ai.currIndex = sort.Search(len(ai.values), func(i int) bool {
-			return bytes.Compare(ai.values[i].key, key) >= 0
+		child := n.childRef(key[depth])
+		if child == nil {
+			return nil
+		}
+		oldPos = art.delete(child, key, depth+1)
+		if *child == nil {
+			n.removeChild(key[depth])
+		}
+	}
+	if oldPos != nil {
+		*ref = art.compact(n)
+	}
+	return oldPos
+}
+
+// newNode 新建前缀为 key[depth:] 的节点，pos 不为 nil 时节点保存 key 对应的数据
+// 压缩时复制一份前缀，不持有完整的 key
+func (art *AdaptiveRadixTree) newNode(key []byte, depth int, pos *data.LogRecordPos) *artNode {
+	n := &artNode{prefix: key[depth:], pos: pos}
+	if art.compress {
+		n.prefix = append([]byte{}, n.prefix...)
+		art.memSize += int64(len(n.prefix)) + artLeafMemSize
+	} else if pos != nil {
+		n.key = key
+	}
+	return n
+}
+
+// setPrefix 修改节点的前缀，压缩时复制一份，不再持有之前的前缀
+func (art *AdaptiveRadixTree) setPrefix(n *artNode, prefix []byte) {
+	if art.compress {
+		art.memSize += int64(len(prefix)) - int64(len(n.prefix))
+		prefix = append([]byte{}, prefix...)
+	}
+	n.prefix = prefix
+}
+
+// compact 删除数据后整理节点，没有数据的节点只剩一个子节点时和子节点合并
+func (art *AdaptiveRadixTree) compact(n *artNode) *artNode {
+	if n.pos != nil {
+		return n
+	}
+	switch n.childNum() {
+	case 0:
+		art.removeNode(n)
+		return nil
+	case 1:
+		var label byte
+		var child *artNode
+		n.eachChild(false, func(b byte, c *artNode) bool {
+			label, child = b, c
+			return false
 		})
+		prefix := make([]byte, 0, len(n.prefix)+1+len(child.prefix))
+		prefix = append(prefix, n.prefix...)
+		prefix = append(prefix, label)
+		prefix = append(prefix, child.prefix...)
+		art.removeNode(n)
+		if art.compress {
+			art.memSize += int64(len(prefix) - len(child.prefix))
+		}
+		child.prefix = prefix
+		return child
 	}
+	return n
 }
 
-// Next 跳转到下一个key
-func (ai *artIterator) Next() {
-	ai.currIndex++
+// removeNode 节点从树中移除，压缩时减去它占用的内存
+func (art *AdaptiveRadixTree) removeNode(n *artNode) {
+	if art.compress {
+		art.memSize -= int64(len(n.prefix)) + artLeafMemSize
+	}
 }
 
-// Valid 是否有效，即是否已经遍历完了所有key，用于退出遍历
-func (ai *artIterator) Valid() bool {
-	return ai.currIndex < len(ai.values)
+// ascend 按照升序遍历子树中的数据，seek 为 true 时从大于等于 start 的第一个 key 开始
+// depth 为子树的路径长度，path 为到达这个节点之前的路径，fn 收到的路径是节点对应的完整 key，只在调用期间有效
+// fn 返回 false 时停止遍历，返回值表示是否继续遍历
+func (n *artNode) ascend(start []byte, seek bool, depth int, path []byte, fn func(*artNode, []byte) bool) bool {
+	if seek {
+		rest := start[depth:]
+		m := min(len(rest), len(n.prefix))
+		switch bytes.Compare(n.prefix[:m], rest[:m]) {
+		case -1:
+			// 子树中所有的 key 都小于 start
+			return true
+		case 1:
+			seek = false
+		default:
+			// start 在公共前缀之内结束，子树中所有的 key 都以 start 开头
+			if len(rest) <= len(n.prefix) {
+				seek = false
+			}
+		}
+		depth += len(n.prefix)
+	}
+
+	path = append(path, n.prefix...)
+	// 节点本身的 key 是子树中最小的，还需要 seek 时说明它是 start 的前缀，小于 start
+	if !seek && n.pos != nil && !fn(n, path) {
+		return false
+	}
+	return n.eachChild(false, func(b byte, child *artNode) bool {
+		if !seek {
+			return child.ascend(nil, false, 0, append(path, b), fn)
+		}
+		if b < start[depth] {
+			return true
+		}
+		return child.ascend(start, b == start[depth], depth+1, append(path, b), fn)
+	})
 }
 
-// Key 当前遍历位置的key数据
-func (ai *artIterator) Key() []byte {
-	return ai.values[ai.currIndex].key
+// descend 按照降序遍历子树中的数据，seek 为 true 时从小于等于 start 的第一个 key 开始
+func (n *artNode) descend(start []byte, seek bool, depth int, path []byte, fn func(*artNode, []byte) bool) bool {
+	if seek {
+		rest := start[depth:]
+		m := min(len(rest), len(n.prefix))
+		switch bytes.Compare(n.prefix[:m], rest[:m]) {
+		case -1:
+			seek = false
+		case 1:
+			// 子树中所有的 key 都大于 start
+			return true
+		default:
+			// 子树中所有的 key 都以 start 开头，只有节点本身可能等于 start
+			if len(rest) < len(n.prefix) {
+				return true
+			}
+			if len(rest) == len(n.prefix) {
+				return n.pos == nil || fn(n, append(path, n.prefix...))
+			}
+		}
+		depth += len(n.prefix)
+	}
+
+	path = append(path, n.prefix...)
+	ok := n.eachChild(true, func(b byte, child *artNode) bool {
+		if !seek {
+			return child.descend(nil, false, 0, append(path, b), fn)
+		}
+		if b > start[depth] {
+			return true
+		}
+		return child.descend(start, b == start[depth], depth+1, append(path, b), fn)
+	})
+	// 节点本身的 key 是子树中最小的，最后遍历
+	if !ok || n.pos == nil {
+		return ok
+	}
+	return fn(n, path)
 }
 
-// Value 当前遍历位置的value数据
-func (ai *artIterator) Value() *data.LogRecordPos {
-	return ai.values[ai.currIndex].pos
+// child 首字节为 b 的子节点
+func (n *artNode) child(b byte) *artNode {
+	if ref := n.childRef(b); ref != nil {
+		return *ref
+	}
+	return nil
 }
 
-// Close 关闭迭代器，释放相应资源
-func (ai *artIterator) Close() {
-	ai.values = nil
+// childRef 首字节为 b 的子节点在父节点中的位置，不存在时返回 nil
+func (n *artNode) childRef(b byte) **artNode {
+	if n.wide != nil {
+		if n.wide[b] == nil {
+			return nil
+		}
+		return &n.wide[b]
+	}
+	i := sort.Search(len(n.labels), func(i int) bool { return n.labels[i] >= b })
+	if i < len(n.labels) && n.labels[i] == b {
+		return &n.children[i]
+	}
+	return nil
+}
+
+func (n *artNode) childNum() int {
+	if n.wide != nil {
+		return n.wideNum
+	}
+	return len(n.children)
+}
+
+// addChild 添加首字节为 b 的子节点，调用前需要确认子节点不存在
+func (n *artNode) addChild(b byte, child *artNode) {
+	if n.wide == nil && len(n.children) >= artNarrowMax {
+		n.wide = new([256]*artNode)
+		for i, label := range n.labels {
+			n.wide[label] = n.children[i]
+		}
+		n.wideNum = len(n.children)
+		n.labels, n.children = nil, nil
+	}
+	if n.wide != nil {
+		n.wide[b] = child
+		n.wideNum++
+		return
+	}
+	i := sort.Search(len(n.labels), func(i int) bool { return n.labels[i] >= b })
+	n.labels = append(n.labels, 0)
+	copy(n.labels[i+1:], n.labels[i:])
+	n.labels[i] = b
+	n.children = append(n.children, nil)
+	copy(n.children[i+1:], n.children[i:])
+	n.children[i] = child
+}
+
+// removeChild 删除首字节为 b 的子节点
+func (n *artNode) removeChild(b byte) {
+	if n.wide != nil {
+		n.wide[b] = nil
+		n.wideNum--
+		if n.wideNum <= artWideMin {
+			for label, child := range n.wide {
+				if child != nil {
+					n.labels = append(n.labels, byte(label))
+					n.children = append(n.children, child)
+				}
+			}
+			n.wide, n.wideNum = nil, 0
+		}
+		return
+	}
+	i := sort.Search(len(n.labels), func(i int) bool { return n.labels[i] >= b })
+	n.labels = append(n.labels[:i], n.labels[i+1:]...)
+	copy(n.children[i:], n.children[i+1:])
+	n.children[len(n.children)-1] = nil
+	n.children = n.children[:len(n.children)-1]
+}
+
+// eachChild 按照首字节的顺序遍历子节点，fn 返回 false 时停止，返回值表示是否遍历完了所有子节点
+func (n *artNode) eachChild(reverse bool, fn func(b byte, child *artNode) bool) bool {
+	if n.wide != nil {
+		for i := 0; i < 256; i++ {
+			b := byte(i)
+			if reverse {
+				b = byte(255 - i)
+			}
+			if n.wide[b] != nil && !fn(b, n.wide[b]) {
+				return false
+			}
+		}
+		return true
+	}
+	for i := range n.children {
+		if reverse {
+			i = len(n.children) - 1 - i
+		}
+		if !fn(n.labels[i], n.children[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// commonPrefixLen 两个字节数组的公共前缀长度
+func commonPrefixLen(a, b []byte) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
 }
@@ -3,6 +3,7 @@ package index
 import (
 	"SingleKVDataSet/data"
 	"github.com/stretchr/testify/assert"
+	"math/rand"
 	"testing"
 )
 
@@ -93,3 +94,77 @@ func TestAdaptiveRadixTree_Iterator(t *testing.T) {
 		assert.NotNil(t, iter1.Key())
 	}
 }
+
+func TestAdaptiveRadixTree_Iterator_Stream(t *testing.T) {
+	testIteratorStream(t, NewART())
+	testIteratorStream(t, NewCompressedART())
+}
+
+// 压缩时节点不持有调用方传入的 key，遍历时拼接出完整的 key
+func TestAdaptiveRadixTree_Compressed(t *testing.T) {
+	art := NewCompressedART()
+	keys := []string{"tenant/a/object/1", "tenant/a/object/2", "tenant/a/obj", "tenant/b/object/1", "tenant"}
+	for i, key := range keys {
+		buf := []byte(key)
+		assert.Nil(t, art.Put(buf, &data.LogRecordPos{Fid: 1, Offset: int64(i)}))
+		// 修改调用方的 key 不影响索引
+		for j := range buf {
+			buf[j] = 'x'
+		}
+	}
+	for i, key := range keys {
+		assert.Equal(t, int64(i), art.Get([]byte(key)).Offset)
+	}
+
+	iter := art.Iterator(false)
+	var got []string
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		got = append(got, string(iter.Key()))
+	}
+	iter.Close()
+	assert.Equal(t, []string{"tenant", "tenant/a/obj", "tenant/a/object/1", "tenant/a/object/2", "tenant/b/object/1"}, got)
+
+	// 删除所有的 key 之后节点占用的内存全部释放
+	for _, key := range keys {
+		_, ok := art.Delete([]byte(key))
+		assert.True(t, ok)
+	}
+	assert.Equal(t, int64(0), art.MemorySize())
+}
+
+// 节点的子节点数量在有序数组和 256 个槽位的数组之间变化，删除后节点会和子节点合并
+func TestAdaptiveRadixTree_WideNode(t *testing.T) {
+	art := NewART()
+	ref := make(refIndex)
+	var seekKeys [][]byte
+	for i := 0; i < 256; i++ {
+		for _, key := range [][]byte{{'k', byte(i)}, {'k', byte(i), 'x', 'y'}, {'k', byte(i), 'x', 'z'}} {
+			pos := &data.LogRecordPos{Fid: 1, Offset: int64(i)}
+			assert.Nil(t, art.Put(key, pos))
+			ref[string(key)] = pos
+		}
+		seekKeys = append(seekKeys, []byte{'k', byte(i), 'x'})
+	}
+	seekKeys = append(seekKeys, []byte("k"), []byte("j"), []byte("l"))
+	checkIndexer(t, art, ref, seekKeys)
+
+	// 删除大部分子节点后改回有序数组
+	r := rand.New(rand.NewSource(1))
+	for _, i := range r.Perm(256)[:240] {
+		for _, key := range [][]byte{{'k', byte(i)}, {'k', byte(i), 'x', 'y'}, {'k', byte(i), 'x', 'z'}} {
+			_, ok := art.Delete(key)
+			assert.True(t, ok)
+			delete(ref, string(key))
+		}
+	}
+	assert.True(t, art.root.wide == nil)
+	assert.Equal(t, 16, art.root.childNum())
+	checkIndexer(t, art, ref, seekKeys)
+
+	for key := range ref {
+		_, ok := art.Delete([]byte(key))
+		assert.True(t, ok)
+	}
+	assert.Nil(t, art.root)
+	assert.Equal(t, int64(0), art.MemorySize())
+}
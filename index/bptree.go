@@ -2,6 +2,7 @@ package index
 
 import (
 	"SingleKVDataSet/data"
+	"bytes"
 	"go.etcd.io/bbolt"
 	"path/filepath"
 )
@@ -18,40 +19,55 @@ type BPlusTree struct {
 
 // NewBPlusTree 初始化B+树索引
 func NewBPlusTree(dirPath string, syncWrites bool) *BPlusTree {
-	opts := bbolt.DefaultOptions
-	opts.NoSync = !syncWrites
-	bptree, err := bbolt.Open(filepath.Join(dirPath, bptreeIndexFileName), 0644, opts)
+	return &BPlusTree{
+		tree: openBPlusTree(filepath.Join(dirPath, bptreeIndexFileName), syncWrites),
+	}
+}
+
+// openBPlusTree 打开 B+ 树文件，并创建存放索引的 bucket
+func openBPlusTree(path string, syncWrites bool) *bbolt.DB {
+	bptree, err := openBoltDB(path, syncWrites)
 	if err != nil {
 		//fmt.Println("open bptree failed, error: ", err)
 		panic("failed to open bptree")
 	}
+	return bptree
+}
+
+// openBoltDB 打开 B+ 树文件并创建存放索引的 bucket，出错时返回错误
+func openBoltDB(path string, syncWrites bool) (*bbolt.DB, error) {
+	opts := bbolt.DefaultOptions
+	opts.NoSync = !syncWrites
+	bptree, err := bbolt.Open(path, 0644, opts)
+	if err != nil {
+		return nil, err
+	}
 
 	// 创建对应的bucket
 	if err := bptree.Update(func(tx *bbolt.Tx) error {
 		_, err := tx.CreateBucketIfNotExists(indexBucketName)
 		return err
 	}); err != nil {
-		panic("failed to create bucket in bptree")
-	}
-	return &BPlusTree{
-		tree: bptree,
+		_ = bptree.Close()
+		return nil, err
 	}
+	return bptree, nil
 }
 
 // Put 向索引中存储key对应的数据位置信息
 func (bpt *BPlusTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
-	var oldVal []byte
+	var oldPos *data.LogRecordPos
 	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(indexBucketName)
-		oldVal = bucket.Get(key)
+		// Get 返回的切片指向 mmap，事务结束后可能被 remap 释放，必须在事务内解码
+		if oldVal := bucket.Get(key); len(oldVal) != 0 {
+			oldPos = data.DecodeLogRecordPos(oldVal)
+		}
 		return bucket.Put(key, data.EncodeLogRecordPos(pos))
 	}); err != nil {
 		panic("failed to put key in BPlusTree")
 	}
-	if len(oldVal) == 0 {
-		return nil
-	}
-	return data.DecodeLogRecordPos(oldVal)
+	return oldPos
 }
 
 // Get 根据key取出对应索引的位置信息
@@ -72,20 +88,49 @@ func (bpt *BPlusTree) Get(key []byte) *data.LogRecordPos {
 
 // Delete 根据 key 删除对应的索引位置信息
 func (bpt *BPlusTree) Delete(key []byte) (*data.LogRecordPos, bool) {
-	var oldVal []byte
+	var oldPos *data.LogRecordPos
 	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(indexBucketName)
-		if oldVal = bucket.Get(key); len(oldVal) != 0 {
+		if oldVal := bucket.Get(key); len(oldVal) != 0 {
+			oldPos = data.DecodeLogRecordPos(oldVal)
 			return bucket.Delete(key)
 		}
 		return nil
 	}); err != nil {
 		panic("failed to delete key in BPlusTree")
 	}
-	if len(oldVal) == 0 {
-		return nil, false
+	return oldPos, oldPos != nil
+}
+
+// ApplyBatch 在一个事务中执行全部操作，避免每个 key 都提交一次事务
+func (bpt *BPlusTree) ApplyBatch(ops []IndexOp) []*data.LogRecordPos {
+	oldPositions := make([]*data.LogRecordPos, len(ops))
+	if len(ops) == 0 {
+		return oldPositions
 	}
-	return data.DecodeLogRecordPos(oldVal), true
+	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(indexBucketName)
+		for i, op := range ops {
+			if oldVal := bucket.Get(op.Key); len(oldVal) != 0 {
+				oldPositions[i] = data.DecodeLogRecordPos(oldVal)
+			}
+			var err error
+			if op.Delete {
+				if oldPositions[i] != nil {
+					err = bucket.Delete(op.Key)
+				}
+			} else {
+				err = bucket.Put(op.Key, data.EncodeLogRecordPos(op.Pos))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		panic("failed to apply batch in BPlusTree")
+	}
+	return oldPositions
 }
 
 // Size 索引中的数据量
@@ -111,9 +156,9 @@ func (bpt *BPlusTree) Close() error {
 	return bpt.tree.Close()
 }
 
-// B+树迭代器
+// B+树迭代器，整个遍历过程使用同一个只读事务，关闭迭代器时结束事务
 type bptreeIterator struct {
-	tx        *bbolt.Tx
+	tx        *bbolt.Tx // 只读事务
 	cursor    *bbolt.Cursor
 	reverse   bool
 	currKey   []byte
@@ -121,6 +166,7 @@ type bptreeIterator struct {
 }
 
 func newBptreeIterator(tree *bbolt.DB, reverse bool) *bptreeIterator {
+	// 只读事务不会阻塞写入，但是需要在使用完之后回滚
 	tx, err := tree.Begin(false)
 	if err != nil {
 		panic("failed to begin transaction")
@@ -146,6 +192,15 @@ func (bpi *bptreeIterator) Rewind() {
 // Seek 根据传入的key查找第一个大于/小于等于的目标key，根据这个key开始遍历
 func (bpi *bptreeIterator) Seek(key []byte) {
 	bpi.currKey, bpi.currValue = bpi.cursor.Seek(key)
+	if !bpi.reverse {
+		return
+	}
+	// 反向遍历时定位到最后一个小于等于 key 的数据
+	if bpi.currKey == nil {
+		bpi.currKey, bpi.currValue = bpi.cursor.Last()
+	} else if bytes.Compare(bpi.currKey, key) > 0 {
+		bpi.currKey, bpi.currValue = bpi.cursor.Prev()
+	}
 }
 
 // Next 跳转到下一个key
@@ -163,8 +218,9 @@ func (bpi *bptreeIterator) Valid() bool {
 }
 
 // Key 当前遍历位置的key数据
+// currKey 指向 mmap，迭代器关闭后可能被 remap 释放，返回副本供调用方持有
 func (bpi *bptreeIterator) Key() []byte {
-	return bpi.currKey
+	return bytes.Clone(bpi.currKey)
 }
 
 // Value 当前遍历位置的value数据
@@ -174,5 +230,10 @@ func (bpi *bptreeIterator) Value() *data.LogRecordPos {
 
 // Close 关闭迭代器，释放相应资源
 func (bpi *bptreeIterator) Close() {
+	if bpi.tx == nil {
+		return
+	}
 	_ = bpi.tx.Rollback()
+	bpi.tx = nil
+	bpi.currKey, bpi.currValue = nil, nil
 }
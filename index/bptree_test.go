@@ -130,3 +130,71 @@ func TestBPlusTree_Iterator(t *testing.T) {
 		assert.NotNil(t, iter.Value())
 	}
 }
+
+func TestBPlusTree_ApplyBatch(t *testing.T) {
+	path := filepath.Join("../TestingFile", "bptree-batch")
+	_ = os.Mkdir(path, os.ModePerm)
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+
+	tree := NewBPlusTree(path, false)
+	defer tree.Close()
+
+	tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	// 同一批中的操作按顺序执行
+	oldPositions := tree.ApplyBatch([]IndexOp{
+		{Key: []byte("aac"), Pos: &data.LogRecordPos{Fid: 2, Offset: 20}},
+		{Key: []byte("abc"), Pos: &data.LogRecordPos{Fid: 2, Offset: 30}},
+		{Key: []byte("abc"), Delete: true},
+		{Key: []byte("not-exist"), Delete: true},
+		{Key: []byte("dfc"), Pos: &data.LogRecordPos{Fid: 2, Offset: 40}},
+	})
+	assert.Equal(t, 5, len(oldPositions))
+	assert.Equal(t, int64(10), oldPositions[0].Offset)
+	assert.Nil(t, oldPositions[1])
+	assert.Equal(t, int64(30), oldPositions[2].Offset)
+	assert.Nil(t, oldPositions[3])
+	assert.Nil(t, oldPositions[4])
+
+	assert.Equal(t, int64(20), tree.Get([]byte("aac")).Offset)
+	assert.Nil(t, tree.Get([]byte("abc")))
+	assert.Equal(t, int64(40), tree.Get([]byte("dfc")).Offset)
+	assert.Equal(t, 2, tree.Size())
+	assert.Equal(t, 0, len(tree.ApplyBatch(nil)))
+}
+
+func TestBPlusTree_Iterator_Seek(t *testing.T) {
+	path := filepath.Join("../TestingFile", "bptree-seek")
+	_ = os.Mkdir(path, os.ModePerm)
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+
+	tree := NewBPlusTree(path, false)
+	defer tree.Close()
+	for _, key := range []string{"aaa", "abc", "dfc", "kbc"} {
+		tree.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: 10})
+	}
+
+	iter1 := tree.Iterator(false)
+	iter1.Seek([]byte("b"))
+	assert.Equal(t, []byte("dfc"), iter1.Key())
+	iter1.Close()
+	// 重复关闭不会出错
+	iter1.Close()
+
+	// 反向遍历时定位到最后一个小于等于目标的 key
+	iter2 := tree.Iterator(true)
+	defer iter2.Close()
+	iter2.Seek([]byte("b"))
+	assert.Equal(t, []byte("abc"), iter2.Key())
+	iter2.Seek([]byte("dfc"))
+	assert.Equal(t, []byte("dfc"), iter2.Key())
+	iter2.Seek([]byte("zz"))
+	assert.Equal(t, []byte("kbc"), iter2.Key())
+	iter2.Next()
+	assert.Equal(t, []byte("dfc"), iter2.Key())
+	iter2.Seek([]byte("a"))
+	assert.False(t, iter2.Valid())
+}
@@ -4,15 +4,16 @@ import (
 	"SingleKVDataSet/data"
 	"bytes"
 	"github.com/google/btree"
-	"sort"
 	"sync"
 )
 
 // BTree 索引，封装了google的 btree ku
 // https://github.com/google/btree
 type BTree struct {
-	tree *btree.BTree
-	lock *sync.RWMutex
+	tree     *btree.BTree
+	prefixes *prefixPool // 不为 nil 时，索引中的 key 按照前缀压缩保存
+	memSize  int64       // 索引项占用的内存，不包括 BTree 的内部节点
+	lock     *sync.RWMutex
 }
 
 // NewBTree 新建 BTree 索引结构
@@ -23,10 +24,42 @@ func NewBTree() *BTree {
 	}
 }
 
+// NewCompressedBTree 新建 key 按照前缀压缩保存的 BTree 索引
+// 相同的前缀只保存一份，适合 key 中有大量重复前缀的场景，遍历时需要重新拼接出完整的 key
+func NewCompressedBTree() *BTree {
+	return newCompressedBTree(newPrefixPool())
+}
+
+func newCompressedBTree(prefixes *prefixPool) *BTree {
+	bt := NewBTree()
+	bt.prefixes = prefixes
+	return bt
+}
+
 func (bt *BTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	if bt.prefixes != nil {
+		it := bt.prefixes.newItem(key, pos)
+		bt.lock.Lock()
+		oldItem := bt.tree.ReplaceOrInsert(it)
+		bt.memSize += it.memSize()
+		if oldItem != nil {
+			bt.memSize -= oldItem.(*prefixItem).memSize()
+		}
+		bt.lock.Unlock()
+		if oldItem == nil {
+			return nil
+		}
+		bt.prefixes.release(oldItem.(*prefixItem))
+		return oldItem.(*prefixItem).pos
+	}
+
 	it := &Item{key: key, pos: pos}
 	bt.lock.Lock()
 	oldItem := bt.tree.ReplaceOrInsert(it)
+	bt.memSize += it.memSize()
+	if oldItem != nil {
+		bt.memSize -= oldItem.(*Item).memSize()
+	}
 	bt.lock.Unlock()
 	if oldItem == nil {
 		return nil
@@ -35,113 +68,119 @@ func (bt *BTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
 }
 
 func (bt *BTree) Get(key []byte) *data.LogRecordPos {
-	it := &Item{key: key}
-	btreeItem := bt.tree.Get(it)
+	bt.lock.RLock()
+	btreeItem := bt.tree.Get(bt.pivot(key))
+	bt.lock.RUnlock()
 	if btreeItem == nil {
 		return nil
 	}
-	return btreeItem.(*Item).pos
+	return itemPos(btreeItem)
 }
 
 func (bt *BTree) Delete(key []byte) (*data.LogRecordPos, bool) {
-	it := &Item{key: key}
 	bt.lock.Lock()
-	oldItem := bt.tree.Delete(it)
+	oldItem := bt.tree.Delete(bt.pivot(key))
+	if oldItem != nil {
+		bt.memSize -= itemMemSizeOf(oldItem)
+	}
 	bt.lock.Unlock()
 	if oldItem == nil {
 		return nil, false
 	}
-	return oldItem.(*Item).pos, true
+	if bt.prefixes != nil {
+		bt.prefixes.release(oldItem.(*prefixItem))
+	}
+	return itemPos(oldItem), true
 }
 
-func (bt *BTree) Size() int {
-	return bt.tree.Len()
+func (bt *BTree) ApplyBatch(ops []IndexOp) []*data.LogRecordPos {
+	return applyBatch(bt, ops)
 }
 
-func (bt *BTree) Iterator(reverse bool) Iterator {
-	if bt.tree == nil {
-		return nil
-	}
+func (bt *BTree) Size() int {
 	bt.lock.RLock()
 	defer bt.lock.RUnlock()
-	return newBTreeIterator(bt.tree, reverse)
-}
-
-func (bt *BTree) Close() error {
-	return nil
-}
-
-// Btree 索引迭代器
-type btreeIterator struct {
-	currIndex int     // 当前遍历到的位置下标
-	reverse   bool    // 是否反向遍历
-	values    []*Item // 存放key+位置索引信息
+	return bt.tree.Len()
 }
 
-// 新建BTree索引迭代器的方法
-func newBTreeIterator(tree *btree.BTree, reverse bool) *btreeIterator {
-	var idx int
-	values := make([]*Item, tree.Len())
-
-	// 将所有的数据存放数组中
-	saveValues := func(it btree.Item) bool {
-		values[idx] = it.(*Item)
-		idx++
-		return true
-	}
-
-	if reverse {
-		tree.Descend(saveValues)
-	} else {
-		tree.Ascend(saveValues)
-	}
-
-	return &btreeIterator{
-		currIndex: 0,
-		reverse:   reverse,
-		values:    values,
+// MemorySize 索引项和共享前缀占用的内存
+func (bt *BTree) MemorySize() int64 {
+	size := bt.itemsMemSize()
+	if bt.prefixes != nil {
+		size += bt.prefixes.size()
 	}
+	return size
 }
 
-// Rewind 重新回到迭代器起点，即第一个数据
-func (bti *btreeIterator) Rewind() {
-	bti.currIndex = 0
+func (bt *BTree) itemsMemSize() int64 {
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
+	return bt.memSize
 }
 
-// Seek 根据传入的key查找第一个大于/小于等于的目标key，根据这个key开始遍历
-func (bti *btreeIterator) Seek(key []byte) {
-	if bti.reverse {
-		bti.currIndex = sort.Search(len(bti.values), func(i int) bool {
-			return bytes.Compare(bti.values[i].key, key) <= 0
-		})
-	} else {
-		bti.currIndex = sort.Search(len(bti.values), func(i int) bool {
-			return bytes.Compare(bti.values[i].key, key) >= 0
-		})
+// pivot 用于在 BTree 中查找 key 的索引项
+func (bt *BTree) pivot(key []byte) btree.Item {
+	if bt.prefixes != nil {
+		return &prefixItem{suffix: key}
 	}
+	return &Item{key: key}
 }
 
-// Next 跳转到下一个key
-func (bti *btreeIterator) Next() {
-	bti.currIndex++
+func itemPos(it btree.Item) *data.LogRecordPos {
+	if item, ok := it.(*prefixItem); ok {
+		return item.pos
+	}
+	return it.(*Item).pos
 }
 
-// Valid 是否有效，即是否已经遍历完了所有key，用于退出遍历
-func (bti *btreeIterator) Valid() bool {
-	return bti.currIndex < len(bti.values)
+func itemMemSizeOf(it btree.Item) int64 {
+	if item, ok := it.(*prefixItem); ok {
+		return item.memSize()
+	}
+	return it.(*Item).memSize()
 }
 
-// Key 当前遍历位置的key数据
-func (bti *btreeIterator) Key() []byte {
-	return bti.values[bti.currIndex].key
+// Iterator 迭代器从 BTree 中分批读取数据，创建时不复制全部数据
+func (bt *BTree) Iterator(reverse bool) Iterator {
+	if bt.tree == nil {
+		return nil
+	}
+	return newBTreeIterator(bt, reverse)
 }
 
-// Value 当前遍历位置的value数据
-func (bti *btreeIterator) Value() *data.LogRecordPos {
-	return bti.values[bti.currIndex].pos
+func (bt *BTree) Close() error {
+	return nil
 }
 
-// Close 关闭迭代器，释放相应资源
-func (bti *btreeIterator) Close() {
-	bti.values = nil
+// 新建BTree索引迭代器的方法
+func newBTreeIterator(bt *BTree, reverse bool) *batchIterator {
+	return newBatchIterator(func(start []byte, seek, inclusive bool, items []*Item) []*Item {
+		saveValues := func(it btree.Item) bool {
+			item, ok := it.(*Item)
+			if !ok {
+				// 前缀压缩的索引项需要拼接出完整的 key
+				pi := it.(*prefixItem)
+				item = &Item{key: pi.key(), pos: pi.pos}
+			}
+			if !inclusive && seek && bytes.Equal(item.key, start) {
+				return true
+			}
+			items = append(items, item)
+			return len(items) < iteratorBatchSize
+		}
+
+		bt.lock.RLock()
+		defer bt.lock.RUnlock()
+		switch {
+		case !seek && reverse:
+			bt.tree.Descend(saveValues)
+		case !seek:
+			bt.tree.Ascend(saveValues)
+		case reverse:
+			bt.tree.DescendLessOrEqual(bt.pivot(start), saveValues)
+		default:
+			bt.tree.AscendGreaterOrEqual(bt.pivot(start), saveValues)
+		}
+		return items
+	})
 }
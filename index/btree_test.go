@@ -2,6 +2,7 @@ package index
 
 import (
 	"SingleKVDataSet/data"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
@@ -112,3 +113,64 @@ func TestBTree_Iterator(t *testing.T) {
 		assert.NotNil(t, iter6.Key())
 	}
 }
+
+func TestBTree_Iterator_Stream(t *testing.T) {
+	testIteratorStream(t, NewBTree())
+}
+
+// testIteratorStream 数据量超过一批时的遍历顺序和 Seek，以及遍历过程中修改索引
+func testIteratorStream(t *testing.T, indexer Indexer) {
+	const keyNum = iteratorBatchSize*3 + 10
+	for i := 0; i < keyNum; i++ {
+		indexer.Put([]byte(fmt.Sprintf("key-%05d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	// 正向遍历
+	iter1 := indexer.Iterator(false)
+	var i int
+	for iter1.Rewind(); iter1.Valid(); iter1.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%05d", i)), iter1.Key())
+		assert.Equal(t, int64(i), iter1.Value().Offset)
+		i++
+	}
+	assert.Equal(t, keyNum, i)
+	iter1.Seek([]byte("key-00500"))
+	assert.Equal(t, []byte("key-00500"), iter1.Key())
+	iter1.Seek([]byte("key-005005"))
+	assert.Equal(t, []byte("key-00501"), iter1.Key())
+	iter1.Seek([]byte("zz"))
+	assert.False(t, iter1.Valid())
+	iter1.Close()
+
+	// 反向遍历
+	iter2 := indexer.Iterator(true)
+	i = keyNum - 1
+	for iter2.Rewind(); iter2.Valid(); iter2.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%05d", i)), iter2.Key())
+		i--
+	}
+	assert.Equal(t, -1, i)
+	iter2.Seek([]byte("key-005005"))
+	assert.Equal(t, []byte("key-00500"), iter2.Key())
+	iter2.Seek([]byte("a"))
+	assert.False(t, iter2.Valid())
+	iter2.Close()
+
+	// 遍历过程中删除和插入数据，已经遍历过的 key 不会重复出现
+	iter3 := indexer.Iterator(false)
+	var prev []byte
+	var count int
+	for iter3.Rewind(); iter3.Valid(); iter3.Next() {
+		if prev != nil {
+			assert.True(t, string(prev) < string(iter3.Key()))
+		}
+		prev = iter3.Key()
+		if count%2 == 0 {
+			indexer.Delete(iter3.Key())
+			indexer.Put(append(iter3.Key(), 'x'), &data.LogRecordPos{Fid: 2})
+		}
+		count++
+	}
+	iter3.Close()
+	assert.Equal(t, keyNum, indexer.Size())
+}
@@ -0,0 +1,324 @@
+package index
+
+import (
+	"SingleKVDataSet/data"
+	"bytes"
+	"encoding/binary"
+	"hash/maphash"
+	"sort"
+	"sync"
+	"unsafe"
+)
+
+const (
+	hashIndexInitCap  = 16
+	hashIndexLoadRate = 0.8 // 装载因子超过这个值时扩容
+	hashKeyLenBits    = 24  // key 的引用中低 24 位是 key 的长度
+	hashMaxKeyLen     = 1<<hashKeyLenBits - 1
+	// 长度不小于 hashMaxKeyLen 的 key，引用中的长度为 hashMaxKeyLen，真实长度以 8 字节保存在 key 之前
+	hashLongKeyHeader = 8
+)
+
+// HashIndex 哈希索引，使用开放寻址法（线性探测）存储数据，只适合点查询的场景
+// 为了减少每个 key 的内存开销：
+//   - key 被复制到连续的内存中，不持有调用方的 key
+//   - 位置信息直接保存在数组中，Get 和 Put 返回的是位置信息的副本
+//   - 哈希表的槽位只保存哈希值的高位和数据的下标
+//
+// 迭代器在创建时复制全部数据并按 key 排序，时间复杂度为 O(nlogn)，需要频繁遍历时应该使用有序索引
+type HashIndex struct {
+	slots   []uint64    // 高 32 位是哈希值的高 32 位，低 32 位是 entries 的下标加 1，为 0 表示空槽位
+	entries []hashEntry // 保存 key 和位置信息
+	free    []uint32    // 已经删除的 entries 下标，可以复用
+	keys    []byte      // 存放所有 key 的连续内存
+	garbage int         // keys 中已经删除的字节数
+	size    int
+	seed    maphash.Seed
+	lock    *sync.RWMutex
+}
+
+type hashEntry struct {
+	keyRef uint64 // 高 40 位是 key 在 keys 中的偏移，低 24 位是 key 的长度，过长的 key 见 hashLongKeyHeader
+	fid    uint32
+	size   uint32
+	offset int64
+}
+
+// NewHashIndex 新建哈希索引
+func NewHashIndex() *HashIndex {
+	return &HashIndex{
+		slots: make([]uint64, hashIndexInitCap),
+		seed:  maphash.MakeSeed(),
+		lock:  new(sync.RWMutex),
+	}
+}
+
+func (hi *HashIndex) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	hash := maphash.Bytes(hi.seed, key)
+	hi.lock.Lock()
+	defer hi.lock.Unlock()
+
+	if slot := hi.find(key, hash); slot >= 0 {
+		entry := &hi.entries[hi.slots[slot]&0xffffffff-1]
+		oldPos := entry.pos()
+		entry.fid, entry.size, entry.offset = pos.Fid, pos.Size, pos.Offset
+		return oldPos
+	}
+
+	if float64(hi.size+1) > float64(len(hi.slots))*hashIndexLoadRate {
+		hi.resize(len(hi.slots) * 2)
+	}
+	if hi.garbage > len(hi.keys)/2 && hi.garbage > 4096 {
+		hi.compact()
+	}
+
+	// 保存 key 和位置信息
+	var keyRef uint64
+	hi.keys, keyRef = appendHashKey(hi.keys, key)
+	entry := hashEntry{
+		keyRef: keyRef,
+		fid:    pos.Fid,
+		size:   pos.Size,
+		offset: pos.Offset,
+	}
+	var idx uint32
+	if n := len(hi.free); n > 0 {
+		idx = hi.free[n-1]
+		hi.free = hi.free[:n-1]
+		hi.entries[idx] = entry
+	} else {
+		idx = uint32(len(hi.entries))
+		hi.entries = append(hi.entries, entry)
+	}
+	hi.insert(hash>>32<<32 | uint64(idx+1))
+	hi.size++
+	return nil
+}
+
+func (hi *HashIndex) Get(key []byte) *data.LogRecordPos {
+	hash := maphash.Bytes(hi.seed, key)
+	hi.lock.RLock()
+	defer hi.lock.RUnlock()
+
+	if slot := hi.find(key, hash); slot >= 0 {
+		return hi.entries[hi.slots[slot]&0xffffffff-1].pos()
+	}
+	return nil
+}
+
+func (hi *HashIndex) Delete(key []byte) (*data.LogRecordPos, bool) {
+	hash := maphash.Bytes(hi.seed, key)
+	hi.lock.Lock()
+	defer hi.lock.Unlock()
+
+	slot := hi.find(key, hash)
+	if slot < 0 {
+		return nil, false
+	}
+	idx := uint32(hi.slots[slot]&0xffffffff - 1)
+	oldPos := hi.entries[idx].pos()
+	hi.garbage += hi.storedKeySize(&hi.entries[idx])
+	hi.entries[idx] = hashEntry{}
+	hi.free = append(hi.free, idx)
+
+	// 向后移动同一个探测序列上的数据，填补删除后的空位，不需要墓碑标记
+	mask := uint64(len(hi.slots) - 1)
+	hole := uint64(slot)
+	for i := (hole + 1) & mask; hi.slots[i] != 0; i = (i + 1) & mask {
+		home := hi.slots[i] >> 32 & mask
+		// home 不在 (hole, i] 区间内时，这个数据可以移动到空位上
+		if (i-home)&mask >= (i-hole)&mask {
+			hi.slots[hole] = hi.slots[i]
+			hole = i
+		}
+	}
+	hi.slots[hole] = 0
+	hi.size--
+	return oldPos, true
+}
+
+func (hi *HashIndex) ApplyBatch(ops []IndexOp) []*data.LogRecordPos {
+	return applyBatch(hi, ops)
+}
+
+func (hi *HashIndex) Size() int {
+	hi.lock.RLock()
+	defer hi.lock.RUnlock()
+	return hi.size
+}
+
+// MemorySize 槽位、位置信息和 key 占用的内存
+func (hi *HashIndex) MemorySize() int64 {
+	hi.lock.RLock()
+	defer hi.lock.RUnlock()
+	return int64(cap(hi.slots))*8 + int64(cap(hi.entries))*int64(unsafe.Sizeof(hashEntry{})) +
+		int64(cap(hi.free))*4 + int64(cap(hi.keys))
+}
+
+// Iterator 哈希索引本身是无序的，创建迭代器时复制全部数据并按 key 排序
+func (hi *HashIndex) Iterator(reverse bool) Iterator {
+	hi.lock.RLock()
+	values := make([]*Item, 0, hi.size)
+	for _, slot := range hi.slots {
+		if slot == 0 {
+			continue
+		}
+		entry := &hi.entries[slot&0xffffffff-1]
+		values = append(values, &Item{key: hi.entryKey(entry), pos: entry.pos()})
+	}
+	hi.lock.RUnlock()
+
+	sort.Slice(values, func(i, j int) bool {
+		if reverse {
+			return bytes.Compare(values[i].key, values[j].key) > 0
+		}
+		return bytes.Compare(values[i].key, values[j].key) < 0
+	})
+	return &sliceIterator{
+		currIndex: 0,
+		reverse:   reverse,
+		values:    values,
+	}
+}
+
+func (hi *HashIndex) Close() error {
+	return nil
+}
+
+func (entry *hashEntry) pos() *data.LogRecordPos {
+	return &data.LogRecordPos{Fid: entry.fid, Offset: entry.offset, Size: entry.size}
+}
+
+// entryKey 返回的 key 和 keys 共享内存，压缩 keys 时会分配新的内存，不会修改已经返回的 key
+// 调用前需要持有锁
+func (hi *HashIndex) entryKey(entry *hashEntry) []byte {
+	off, n := entry.keyRef>>hashKeyLenBits, entry.keyRef&hashMaxKeyLen
+	if n == hashMaxKeyLen {
+		n = binary.LittleEndian.Uint64(hi.keys[off:])
+		off += hashLongKeyHeader
+	}
+	return hi.keys[off : off+n : off+n]
+}
+
+// appendHashKey 将 key 复制到 keys 的末尾，返回新的 keys 和 key 的引用
+func appendHashKey(keys, key []byte) ([]byte, uint64) {
+	ref := uint64(len(keys)) << hashKeyLenBits
+	if len(key) < hashMaxKeyLen {
+		return append(keys, key...), ref | uint64(len(key))
+	}
+	keys = binary.LittleEndian.AppendUint64(keys, uint64(len(key)))
+	return append(keys, key...), ref | hashMaxKeyLen
+}
+
+// storedKeySize key 在 keys 中占用的字节数，包括过长的 key 前面的长度
+// 调用前需要持有锁
+func (hi *HashIndex) storedKeySize(entry *hashEntry) int {
+	n := len(hi.entryKey(entry))
+	if n >= hashMaxKeyLen {
+		n += hashLongKeyHeader
+	}
+	return n
+}
+
+// find 查找 key 所在的槽位，不存在时返回 -1
+// 调用前需要持有锁
+func (hi *HashIndex) find(key []byte, hash uint64) int {
+	mask := uint64(len(hi.slots) - 1)
+	tag := hash >> 32
+	for i := tag & mask; ; i = (i + 1) & mask {
+		slot := hi.slots[i]
+		if slot == 0 {
+			return -1
+		}
+		if slot>>32 == tag && bytes.Equal(hi.entryKey(&hi.entries[slot&0xffffffff-1]), key) {
+			return int(i)
+		}
+	}
+}
+
+// insert 将槽位放到哈希值对应位置之后的第一个空槽位上，槽位中哈希值的高 32 位决定了位置
+// 调用前需要持有锁，并且 key 不存在
+func (hi *HashIndex) insert(slot uint64) {
+	mask := uint64(len(hi.slots) - 1)
+	i := slot >> 32 & mask
+	for hi.slots[i] != 0 {
+		i = (i + 1) & mask
+	}
+	hi.slots[i] = slot
+}
+
+// 调用前需要持有锁
+func (hi *HashIndex) resize(capacity int) {
+	oldSlots := hi.slots
+	hi.slots = make([]uint64, capacity)
+	for _, slot := range oldSlots {
+		if slot != 0 {
+			hi.insert(slot)
+		}
+	}
+}
+
+// compact 删除的 key 占用的空间超过一半时，将剩余的 key 复制到新的内存中
+// 调用前需要持有锁
+func (hi *HashIndex) compact() {
+	keys := make([]byte, 0, len(hi.keys)-hi.garbage)
+	for _, slot := range hi.slots {
+		if slot == 0 {
+			continue
+		}
+		entry := &hi.entries[slot&0xffffffff-1]
+		keys, entry.keyRef = appendHashKey(keys, hi.entryKey(entry))
+	}
+	hi.keys = keys
+	hi.garbage = 0
+}
+
+// sliceIterator 遍历已经排好序的数据
+type sliceIterator struct {
+	currIndex int     // 当前遍历到的位置下标
+	reverse   bool    // 是否反向遍历
+	values    []*Item // 存放key+位置索引信息
+}
+
+// Rewind 重新回到迭代器起点，即第一个数据
+func (si *sliceIterator) Rewind() {
+	si.currIndex = 0
+}
+
+// Seek 根据传入的key查找第一个大于/小于等于的目标key，根据这个key开始遍历
+func (si *sliceIterator) Seek(key []byte) {
+	if si.reverse {
+		si.currIndex = sort.Search(len(si.values), func(i int) bool {
+			return bytes.Compare(si.values[i].key, key) <= 0
+		})
+	} else {
+		si.currIndex = sort.Search(len(si.values), func(i int) bool {
+			return bytes.Compare(si.values[i].key, key) >= 0
+		})
+	}
+}
+
+// Next 跳转到下一个key
+func (si *sliceIterator) Next() {
+	si.currIndex++
+}
+
+// Valid 是否有效，即是否已经遍历完了所有key，用于退出遍历
+func (si *sliceIterator) Valid() bool {
+	return si.currIndex < len(si.values)
+}
+
+// Key 当前遍历位置的key数据
+func (si *sliceIterator) Key() []byte {
+	return si.values[si.currIndex].key
+}
+
+// Value 当前遍历位置的value数据
+func (si *sliceIterator) Value() *data.LogRecordPos {
+	return si.values[si.currIndex].pos
+}
+
+// Close 关闭迭代器，释放相应资源
+func (si *sliceIterator) Close() {
+	si.values = nil
+}
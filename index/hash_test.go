@@ -0,0 +1,161 @@
+package index
+
+import (
+	"SingleKVDataSet/data"
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestHashIndex_Put_Get(t *testing.T) {
+	hi := NewHashIndex()
+
+	res1 := hi.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100})
+	assert.Nil(t, res1)
+	assert.Equal(t, int64(100), hi.Get(nil).Offset)
+
+	res2 := hi.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 2})
+	assert.Nil(t, res2)
+	res3 := hi.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 3})
+	assert.Equal(t, int64(2), res3.Offset)
+	assert.Equal(t, int64(3), hi.Get([]byte("a")).Offset)
+	assert.Nil(t, hi.Get([]byte("not exist")))
+	assert.Equal(t, 2, hi.Size())
+
+	// 扩容后数据仍然可以读取
+	for i := 0; i < 10000; i++ {
+		hi.Put([]byte(fmt.Sprintf("key-%d", i)), &data.LogRecordPos{Fid: 2, Offset: int64(i)})
+	}
+	assert.Equal(t, 10002, hi.Size())
+	for i := 0; i < 10000; i++ {
+		assert.Equal(t, int64(i), hi.Get([]byte(fmt.Sprintf("key-%d", i))).Offset)
+	}
+}
+
+func TestHashIndex_Delete(t *testing.T) {
+	hi := NewHashIndex()
+	res1, ok := hi.Delete([]byte("not exist"))
+	assert.False(t, ok)
+	assert.Nil(t, res1)
+
+	for i := 0; i < 10000; i++ {
+		hi.Put([]byte(fmt.Sprintf("key-%d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	// 删除一部分数据后，同一个探测序列上的其他数据仍然可以找到
+	for i := 0; i < 10000; i += 3 {
+		pos, ok := hi.Delete([]byte(fmt.Sprintf("key-%d", i)))
+		assert.True(t, ok)
+		assert.Equal(t, int64(i), pos.Offset)
+	}
+	for i := 0; i < 10000; i++ {
+		pos := hi.Get([]byte(fmt.Sprintf("key-%d", i)))
+		if i%3 == 0 {
+			assert.Nil(t, pos)
+		} else {
+			assert.Equal(t, int64(i), pos.Offset)
+		}
+	}
+	assert.Equal(t, 6666, hi.Size())
+}
+
+func TestHashIndex_LargeKey(t *testing.T) {
+	hi := NewHashIndex()
+
+	// 长度超过 24 位能表示的范围的 key，以及刚好等于边界的 key
+	large := bytes.Repeat([]byte("a"), hashMaxKeyLen+10)
+	boundary := bytes.Repeat([]byte("b"), hashMaxKeyLen)
+	assert.Nil(t, hi.Put(large, &data.LogRecordPos{Fid: 1, Offset: 1}))
+	assert.Nil(t, hi.Put(boundary, &data.LogRecordPos{Fid: 1, Offset: 2}))
+	assert.Nil(t, hi.Put([]byte("small"), &data.LogRecordPos{Fid: 1, Offset: 3}))
+	assert.Equal(t, int64(1), hi.Get(large).Offset)
+	assert.Equal(t, int64(2), hi.Get(boundary).Offset)
+	assert.Nil(t, hi.Get(large[:len(large)-1]))
+
+	iter := hi.Iterator(false)
+	assert.Equal(t, large, iter.Key())
+	iter.Next()
+	assert.Equal(t, boundary, iter.Key())
+	iter.Close()
+
+	// 删除后触发压缩，剩余的 key 仍然可以找到
+	_, ok := hi.Delete(large)
+	assert.True(t, ok)
+	assert.Equal(t, len(large)+hashLongKeyHeader, hi.garbage)
+	assert.Nil(t, hi.Put([]byte("other"), &data.LogRecordPos{Fid: 1, Offset: 4}))
+	assert.Equal(t, 0, hi.garbage)
+	assert.Nil(t, hi.Get(large))
+	assert.Equal(t, int64(2), hi.Get(boundary).Offset)
+	assert.Equal(t, int64(3), hi.Get([]byte("small")).Offset)
+	assert.Equal(t, int64(4), hi.Get([]byte("other")).Offset)
+}
+
+func TestHashIndex_Iterator(t *testing.T) {
+	hi := NewHashIndex()
+	iter1 := hi.Iterator(false)
+	assert.False(t, iter1.Valid())
+
+	for i := 0; i < 100; i++ {
+		hi.Put([]byte(fmt.Sprintf("key-%03d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	// 迭代器按照 key 排序
+	iter2 := hi.Iterator(false)
+	var i int
+	for iter2.Rewind(); iter2.Valid(); iter2.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%03d", i)), iter2.Key())
+		i++
+	}
+	assert.Equal(t, 100, i)
+	iter2.Seek([]byte("key-0505"))
+	assert.Equal(t, []byte("key-051"), iter2.Key())
+
+	iter3 := hi.Iterator(true)
+	iter3.Rewind()
+	assert.Equal(t, []byte("key-099"), iter3.Key())
+	iter3.Seek([]byte("key-0505"))
+	assert.Equal(t, []byte("key-050"), iter3.Key())
+}
+
+// 对比不同索引保存相同数据时占用的内存，包括索引持有的 key 和位置信息
+func BenchmarkIndex_Memory(b *testing.B) {
+	const keyNum = 100000
+	indexTypes := []struct {
+		name string
+		typ  IndexType
+	}{
+		{"BTree", Btree},
+		{"ART", ART},
+		{"Sharded", Sharded},
+		{"Hash", Hash},
+		{"Skip", Skip},
+	}
+
+	for _, indexType := range indexTypes {
+		b.Run(indexType.name, func(b *testing.B) {
+			var bytesPerKey float64
+			for n := 0; n < b.N; n++ {
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+
+				indexer, err := NewIndexer(indexType.typ, os.TempDir(), false)
+				if err != nil {
+					b.Fatal(err)
+				}
+				for i := 0; i < keyNum; i++ {
+					key := []byte(fmt.Sprintf("bitcask-key-%09d", i))
+					indexer.Put(key, &data.LogRecordPos{Fid: 1, Offset: int64(i), Size: 128})
+				}
+
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				bytesPerKey = float64(after.HeapAlloc-before.HeapAlloc) / keyNum
+				runtime.KeepAlive(indexer)
+			}
+			b.ReportMetric(bytesPerKey, "bytes/key")
+		})
+	}
+}
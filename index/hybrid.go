@@ -0,0 +1,526 @@
+package index
+
+import (
+	"SingleKVDataSet/data"
+	"bytes"
+	"github.com/google/btree"
+	"go.etcd.io/bbolt"
+	"hash/maphash"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// HybridIndexFileName 混合索引溢出到磁盘的数据文件
+	HybridIndexFileName = "hybrid-index"
+
+	// DefaultIndexMemoryLimit 混合索引默认的内存上限
+	DefaultIndexMemoryLimit = 256 * 1024 * 1024
+
+	hybridItemOverhead   = 96   // 内存中每个索引项除了 key 之外的估算开销，包括 Item、位置信息和 BTree 中的指针
+	hybridSpillRangeSize = 1024 // 溢出到磁盘时，每一段连续 key 的数量
+	hybridSpillRatio     = 0.75 // 溢出后内存占用降到上限的这个比例，避免每次写入都触发溢出
+
+	coldFilterBitsPerKey  = 10 // 布隆过滤器中每个 key 占用的位数，误判率约为 1%
+	coldFilterHashes      = 7  // 布隆过滤器的哈希函数数量
+	coldFilterMinCapacity = 1024
+)
+
+// HybridIndex 混合索引，最近写入的 key 保存在内存的 BTree 中，内存占用超过上限后，将较冷的 key 范围溢出到磁盘上的 B+ 树中
+// 一个 key 只会存在于其中一层，磁盘上的 key 再次写入时会移回内存
+// 数据文件是追加写入的，位置信息越大说明写入得越晚，因此用一段 key 中最新的位置信息判断这段 key 的冷热
+// 磁盘上的 key 记录在布隆过滤器中，写入新的 key 时不需要为了查找磁盘上的旧数据打开读事务
+// 磁盘上的数据和内存索引一样在启动时重建，打开和关闭索引时都会删除磁盘文件
+// 读写磁盘出错时不会 panic，第一个错误通过 Err 返回，之后不再溢出数据
+type HybridIndex struct {
+	hot      *BTree
+	cold     *bbolt.DB
+	filter   *coldFilter // 磁盘上的 key 的布隆过滤器
+	path     string
+	memLimit int64
+	memSize  int64                 // 内存中索引的估算大小
+	coldSize int                   // 磁盘上的索引数量
+	spills   uint64                // 溢出的次数，迭代器据此判断读取的数据是否需要重新定位
+	err      atomic.Pointer[error] // 读写磁盘时遇到的第一个错误
+	lock     *sync.RWMutex
+}
+
+// NewHybridIndex 新建混合索引，memLimit 为内存中索引的大小上限
+func NewHybridIndex(dirPath string, memLimit int64) (*HybridIndex, error) {
+	path := filepath.Join(dirPath, HybridIndexFileName)
+	// 上次没有正常关闭时遗留的文件
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	cold, err := openBoltDB(path, false)
+	if err != nil {
+		return nil, err
+	}
+	return &HybridIndex{
+		hot:      NewBTree(),
+		cold:     cold,
+		filter:   newColdFilter(coldFilterMinCapacity),
+		path:     path,
+		memLimit: memLimit,
+		lock:     new(sync.RWMutex),
+	}, nil
+}
+
+func (hi *HybridIndex) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	hi.lock.Lock()
+	defer hi.lock.Unlock()
+
+	if oldPos := hi.hot.Put(key, pos); oldPos != nil {
+		return oldPos
+	}
+	hi.memSize += hybridItemSize(key)
+
+	// key 在磁盘上时移回内存
+	var oldPos *data.LogRecordPos
+	if hi.mayBeCold(key) {
+		oldPos = hi.deleteCold(key)
+	}
+	if hi.memSize > hi.memLimit && hi.Err() == nil {
+		hi.spill()
+	}
+	return oldPos
+}
+
+func (hi *HybridIndex) Get(key []byte) *data.LogRecordPos {
+	hi.lock.RLock()
+	defer hi.lock.RUnlock()
+
+	if pos := hi.hot.Get(key); pos != nil {
+		return pos
+	}
+	if hi.mayBeCold(key) {
+		return hi.getCold(key)
+	}
+	return nil
+}
+
+func (hi *HybridIndex) Delete(key []byte) (*data.LogRecordPos, bool) {
+	hi.lock.Lock()
+	defer hi.lock.Unlock()
+
+	if oldPos, ok := hi.hot.Delete(key); ok {
+		hi.memSize -= hybridItemSize(key)
+		return oldPos, true
+	}
+	if hi.mayBeCold(key) {
+		if oldPos := hi.deleteCold(key); oldPos != nil {
+			return oldPos, true
+		}
+	}
+	return nil, false
+}
+
+func (hi *HybridIndex) ApplyBatch(ops []IndexOp) []*data.LogRecordPos {
+	return applyBatch(hi, ops)
+}
+
+func (hi *HybridIndex) Size() int {
+	hi.lock.RLock()
+	defer hi.lock.RUnlock()
+	return hi.hot.Size() + hi.coldSize
+}
+
+// MemorySize 内存中的索引占用的内存
+func (hi *HybridIndex) MemorySize() int64 {
+	return hi.hot.MemorySize()
+}
+
+// Iterator 合并内存和磁盘中的数据，按照 key 的顺序遍历，两层都是分批读取的
+// 遍历过程中可以继续溢出，溢出之后迭代器从当前的 key 之后重新读取两层的数据
+func (hi *HybridIndex) Iterator(reverse bool) Iterator {
+	// 加锁保证两层的数据是在同一次溢出之后读取的
+	hi.lock.RLock()
+	defer hi.lock.RUnlock()
+	it := &hybridIterator{
+		index:   hi,
+		hot:     newBTreeIterator(hi.hot, reverse),
+		cold:    newBatchIterator(hi.coldFill(reverse)),
+		reverse: reverse,
+		spills:  hi.spills,
+	}
+	it.pick()
+	return it
+}
+
+// Close 关闭并删除磁盘上的数据
+func (hi *HybridIndex) Close() error {
+	if err := hi.cold.Close(); err != nil {
+		return err
+	}
+	return os.Remove(hi.path)
+}
+
+// Err 读写磁盘上的数据时遇到的第一个错误，出错之后磁盘上的数据可能没有被读到或删除
+// 索引会在下次打开数据库时从数据文件重建
+func (hi *HybridIndex) Err() error {
+	if err := hi.err.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+// fail 记录读写磁盘时遇到的错误，只保留第一个
+func (hi *HybridIndex) fail(err error) {
+	hi.err.CompareAndSwap(nil, &err)
+}
+
+// mayBeCold key 是否可能在磁盘上，调用前需要持有锁
+func (hi *HybridIndex) mayBeCold(key []byte) bool {
+	return hi.coldSize > 0 && hi.filter.mayContain(key)
+}
+
+func hybridItemSize(key []byte) int64 {
+	return int64(len(key)) + hybridItemOverhead
+}
+
+// 调用前需要持有锁
+func (hi *HybridIndex) getCold(key []byte) *data.LogRecordPos {
+	var pos *data.LogRecordPos
+	if err := hi.cold.View(func(tx *bbolt.Tx) error {
+		if value := tx.Bucket(indexBucketName).Get(key); len(value) != 0 {
+			pos = data.DecodeLogRecordPos(value)
+		}
+		return nil
+	}); err != nil {
+		hi.fail(err)
+		return nil
+	}
+	return pos
+}
+
+// deleteCold 删除磁盘上的 key，大部分 key 不在磁盘上，先用只读事务查找
+// 调用前需要持有锁
+func (hi *HybridIndex) deleteCold(key []byte) *data.LogRecordPos {
+	oldPos := hi.getCold(key)
+	if oldPos == nil {
+		return nil
+	}
+	if err := hi.cold.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(indexBucketName).Delete(key)
+	}); err != nil {
+		hi.fail(err)
+		return nil
+	}
+	hi.coldSize--
+	return oldPos
+}
+
+// spillRange 内存中一段连续的 key
+type spillRange struct {
+	start  []byte
+	count  int
+	size   int64
+	newest *data.LogRecordPos // 这段 key 中最新的位置信息
+}
+
+// spill 将最冷的几段 key 写到磁盘上，直到内存占用降到上限的 hybridSpillRatio 以下
+// 调用前需要持有锁，内存中的数据只会在持有锁时修改
+func (hi *HybridIndex) spill() {
+	var ranges []*spillRange
+	var curr *spillRange
+	hi.hot.tree.Ascend(func(it btree.Item) bool {
+		item := it.(*Item)
+		// B+ 树不支持空的 key，只保存在内存中
+		if len(item.key) == 0 {
+			return true
+		}
+		if curr == nil || curr.count >= hybridSpillRangeSize {
+			curr = &spillRange{start: item.key}
+			ranges = append(ranges, curr)
+		}
+		curr.count++
+		curr.size += hybridItemSize(item.key)
+		if curr.newest == nil || posAfter(item.pos, curr.newest) {
+			curr.newest = item.pos
+		}
+		return true
+	})
+	sort.Slice(ranges, func(i, j int) bool {
+		return posAfter(ranges[j].newest, ranges[i].newest)
+	})
+
+	var spilled []*Item
+	target := hi.memSize - int64(float64(hi.memLimit)*hybridSpillRatio)
+	if err := hi.cold.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(indexBucketName)
+		var freed int64
+		for _, r := range ranges {
+			if freed >= target {
+				break
+			}
+			count := 0
+			var err error
+			hi.hot.tree.AscendGreaterOrEqual(&Item{key: r.start}, func(it btree.Item) bool {
+				item := it.(*Item)
+				if err = bucket.Put(item.key, data.EncodeLogRecordPos(item.pos)); err != nil {
+					return false
+				}
+				spilled = append(spilled, item)
+				count++
+				return count < r.count
+			})
+			if err != nil {
+				return err
+			}
+			freed += r.size
+		}
+		// 过滤器中的 key 超过容量时按照磁盘上现有的 key 重建，同时去掉已经移回内存的 key
+		if hi.filter.count+len(spilled) > hi.filter.capacity {
+			filter := newColdFilter(2 * (hi.coldSize + len(spilled)))
+			if err := bucket.ForEach(func(k, _ []byte) error {
+				filter.add(k)
+				return nil
+			}); err != nil {
+				return err
+			}
+			hi.filter = filter
+		} else {
+			for _, item := range spilled {
+				hi.filter.add(item.key)
+			}
+		}
+		return nil
+	}); err != nil {
+		// 事务已经回滚，溢出失败的 key 仍然保存在内存中
+		hi.fail(err)
+		return
+	}
+
+	for _, item := range spilled {
+		hi.hot.Delete(item.key)
+		hi.memSize -= hybridItemSize(item.key)
+	}
+	hi.coldSize += len(spilled)
+	hi.spills++
+}
+
+// coldFilter 磁盘上的 key 的布隆过滤器，判断为不存在的 key 一定不在磁盘上
+// 从磁盘上删除 key 时不修改过滤器，只会增加误判，加入的 key 超过容量时重建
+type coldFilter struct {
+	bits     []uint64
+	capacity int // 误判率符合预期时最多可以加入的 key 数量
+	count    int // 已经加入的 key 数量
+	seed     maphash.Seed
+}
+
+func newColdFilter(capacity int) *coldFilter {
+	if capacity < coldFilterMinCapacity {
+		capacity = coldFilterMinCapacity
+	}
+	return &coldFilter{
+		bits:     make([]uint64, (capacity*coldFilterBitsPerKey+63)/64),
+		capacity: capacity,
+		seed:     maphash.MakeSeed(),
+	}
+}
+
+func (f *coldFilter) add(key []byte) {
+	h1, h2 := f.hash(key)
+	n := uint32(len(f.bits) * 64)
+	for i := uint32(0); i < coldFilterHashes; i++ {
+		bit := (h1 + i*h2) % n
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+	f.count++
+}
+
+func (f *coldFilter) mayContain(key []byte) bool {
+	h1, h2 := f.hash(key)
+	n := uint32(len(f.bits) * 64)
+	for i := uint32(0); i < coldFilterHashes; i++ {
+		bit := (h1 + i*h2) % n
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// hash 将一个 64 位的哈希值拆成两个，组合出多个哈希函数
+func (f *coldFilter) hash(key []byte) (uint32, uint32) {
+	h := maphash.Bytes(f.seed, key)
+	return uint32(h), uint32(h>>32) | 1
+}
+
+// posAfter a 是否比 b 写入得晚
+func posAfter(a, b *data.LogRecordPos) bool {
+	if a.Fid != b.Fid {
+		return a.Fid > b.Fid
+	}
+	return a.Offset > b.Offset
+}
+
+// coldFill 分批读取磁盘上的数据，每一批使用一个只读事务，不会长时间持有事务
+func (hi *HybridIndex) coldFill(reverse bool) fillFunc {
+	return func(start []byte, seek, inclusive bool, items []*Item) []*Item {
+		if err := hi.cold.View(func(tx *bbolt.Tx) error {
+			cursor := tx.Bucket(indexBucketName).Cursor()
+			var k, v []byte
+			switch {
+			case !seek && reverse:
+				k, v = cursor.Last()
+			case !seek:
+				k, v = cursor.First()
+			default:
+				k, v = cursor.Seek(start)
+				// 反向遍历时定位到最后一个小于等于 start 的 key
+				if reverse && k == nil {
+					k, v = cursor.Last()
+				} else if reverse && bytes.Compare(k, start) > 0 {
+					k, v = cursor.Prev()
+				}
+			}
+			for k != nil && len(items) < iteratorBatchSize {
+				if !seek || inclusive || !bytes.Equal(k, start) {
+					// bbolt 返回的数据只在事务中有效，需要复制一份
+					items = append(items, &Item{key: append([]byte{}, k...), pos: data.DecodeLogRecordPos(v)})
+				}
+				if reverse {
+					k, v = cursor.Prev()
+				} else {
+					k, v = cursor.Next()
+				}
+			}
+			return nil
+		}); err != nil {
+			hi.fail(err)
+		}
+		return items
+	}
+}
+
+// hybridIterator 合并内存和磁盘两层数据的迭代器
+// 两层的迭代器都是在持有索引读锁时读取一批数据，读取期间不会发生溢出
+// 溢出会把 key 从内存移到磁盘，之前读取的一批数据可能漏掉这些 key，所以溢出之后从当前的 key 之后重新读取两层的数据
+type hybridIterator struct {
+	index   *HybridIndex
+	hot     Iterator
+	cold    Iterator
+	reverse bool
+	curr    Iterator // 当前遍历位置所在的迭代器
+	last    []byte   // 上一次返回的 key，之后只返回遍历顺序在它之后的 key
+	hasLast bool     // 是否已经返回过 key，空的 key 也是合法的 key
+	spills  uint64   // 读取当前这批数据时索引已经溢出的次数
+}
+
+// Rewind 重新回到迭代器起点，即第一个数据
+func (it *hybridIterator) Rewind() {
+	it.index.lock.RLock()
+	defer it.index.lock.RUnlock()
+	it.spills = it.index.spills
+	it.hasLast = false
+	it.hot.Rewind()
+	it.cold.Rewind()
+	it.pick()
+}
+
+// Seek 根据传入的key查找第一个大于/小于等于的目标key，根据这个key开始遍历
+func (it *hybridIterator) Seek(key []byte) {
+	it.index.lock.RLock()
+	defer it.index.lock.RUnlock()
+	it.spills = it.index.spills
+	it.hasLast = false
+	it.hot.Seek(key)
+	it.cold.Seek(key)
+	it.pick()
+}
+
+// Next 跳转到下一个key
+func (it *hybridIterator) Next() {
+	if it.curr == nil {
+		return
+	}
+	it.index.lock.RLock()
+	defer it.index.lock.RUnlock()
+	if it.spills != it.index.spills {
+		// 上次读取之后发生了溢出，从当前的 key 重新读取，pick 会跳过当前的 key
+		it.spills = it.index.spills
+		it.hot.Seek(it.last)
+		it.cold.Seek(it.last)
+	} else {
+		it.curr.Next()
+	}
+	it.pick()
+}
+
+// Valid 是否有效，即是否已经遍历完了所有key，用于退出遍历
+func (it *hybridIterator) Valid() bool {
+	return it.curr != nil
+}
+
+// Key 当前遍历位置的key数据
+func (it *hybridIterator) Key() []byte {
+	return it.curr.Key()
+}
+
+// Value 当前遍历位置的value数据
+func (it *hybridIterator) Value() *data.LogRecordPos {
+	return it.curr.Value()
+}
+
+// Close 关闭迭代器，释放相应资源
+func (it *hybridIterator) Close() {
+	it.hot.Close()
+	it.cold.Close()
+	it.curr = nil
+	it.last = nil
+	it.hasLast = false
+}
+
+// pick 选择两个迭代器中按照遍历顺序靠前的一个，调用前需要持有索引的读锁
+func (it *hybridIterator) pick() {
+	// key 在两层之间移动之后可能被再次读到，跳过已经返回过的 key
+	it.skipReturned(it.hot)
+	it.skipReturned(it.cold)
+	// 遍历过程中 key 从磁盘移回了内存，两层都可能读到，以内存中的为准
+	for it.hot.Valid() && it.cold.Valid() && bytes.Equal(it.hot.Key(), it.cold.Key()) {
+		it.cold.Next()
+	}
+	switch {
+	case it.hot.Valid() && it.cold.Valid():
+		cmp := bytes.Compare(it.hot.Key(), it.cold.Key())
+		if it.reverse {
+			cmp = -cmp
+		}
+		if cmp < 0 {
+			it.curr = it.hot
+		} else {
+			it.curr = it.cold
+		}
+	case it.hot.Valid():
+		it.curr = it.hot
+	case it.cold.Valid():
+		it.curr = it.cold
+	default:
+		it.curr = nil
+	}
+	it.hasLast = it.curr != nil
+	if it.hasLast {
+		it.last = append(it.last[:0], it.curr.Key()...)
+	}
+}
+
+// skipReturned 跳过遍历顺序不在上一次返回的 key 之后的数据
+func (it *hybridIterator) skipReturned(iter Iterator) {
+	if !it.hasLast {
+		return
+	}
+	for iter.Valid() {
+		cmp := bytes.Compare(iter.Key(), it.last)
+		if it.reverse {
+			cmp = -cmp
+		}
+		if cmp > 0 {
+			return
+		}
+		iter.Next()
+	}
+}
@@ -0,0 +1,217 @@
+package index
+
+import (
+	"SingleKVDataSet/data"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestHybridIndex(t *testing.T, name string, memLimit int64) *HybridIndex {
+	path := filepath.Join("../TestingFile", name)
+	_ = os.MkdirAll(path, os.ModePerm)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(path)
+	})
+	hi, err := NewHybridIndex(path, memLimit)
+	assert.Nil(t, err)
+	return hi
+}
+
+func TestHybridIndex_Put_Get_Delete(t *testing.T) {
+	hi := newTestHybridIndex(t, "hybrid-put", 64*1024)
+	defer hi.Close()
+
+	for i := 0; i < 10000; i++ {
+		res := hi.Put([]byte(fmt.Sprintf("key-%05d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+		assert.Nil(t, res)
+	}
+	assert.Equal(t, 10000, hi.Size())
+	// 超过内存上限后溢出到磁盘
+	assert.True(t, hi.coldSize > 0)
+	assert.True(t, hi.memSize <= hi.memLimit)
+	for i := 0; i < 10000; i++ {
+		pos := hi.Get([]byte(fmt.Sprintf("key-%05d", i)))
+		assert.Equal(t, int64(i), pos.Offset)
+	}
+	assert.Nil(t, hi.Get([]byte("not exist")))
+
+	// 最早写入的 key 在磁盘上，更新后移回内存
+	key := []byte("key-00000")
+	assert.Nil(t, hi.hot.Get(key))
+	res1 := hi.Put(key, &data.LogRecordPos{Fid: 2, Offset: 100})
+	assert.Equal(t, int64(0), res1.Offset)
+	assert.Equal(t, int64(100), hi.hot.Get(key).Offset)
+	assert.Equal(t, int64(100), hi.Get(key).Offset)
+	assert.Equal(t, 10000, hi.Size())
+
+	// 删除内存和磁盘上的 key
+	for i := 0; i < 10000; i += 2 {
+		pos, ok := hi.Delete([]byte(fmt.Sprintf("key-%05d", i)))
+		assert.True(t, ok)
+		assert.NotNil(t, pos)
+	}
+	res2, ok := hi.Delete([]byte("key-00000"))
+	assert.False(t, ok)
+	assert.Nil(t, res2)
+	assert.Equal(t, 5000, hi.Size())
+	for i := 0; i < 10000; i++ {
+		pos := hi.Get([]byte(fmt.Sprintf("key-%05d", i)))
+		if i%2 == 0 {
+			assert.Nil(t, pos)
+		} else {
+			assert.Equal(t, int64(i), pos.Offset)
+		}
+	}
+}
+
+func TestHybridIndex_Iterator(t *testing.T) {
+	hi := newTestHybridIndex(t, "hybrid-iter", 16*1024)
+	defer hi.Close()
+	testIteratorStream(t, hi)
+	assert.True(t, hi.coldSize > 0)
+
+	// 有迭代器时继续溢出，内存占用不会超过上限
+	iter := hi.Iterator(false)
+	coldSize := hi.coldSize
+	for i := 0; i < 1000; i++ {
+		hi.Put([]byte(fmt.Sprintf("new-key-%05d", i)), &data.LogRecordPos{Fid: 3, Offset: int64(i)})
+	}
+	assert.True(t, hi.coldSize > coldSize)
+	assert.True(t, hi.memSize <= hi.memLimit)
+	iter.Close()
+
+	// 两层的数据合并后有序
+	iter2 := hi.Iterator(true)
+	var prev []byte
+	var count int
+	for iter2.Rewind(); iter2.Valid(); iter2.Next() {
+		if prev != nil {
+			assert.True(t, string(prev) > string(iter2.Key()))
+		}
+		prev = iter2.Key()
+		count++
+	}
+	iter2.Close()
+	assert.Equal(t, hi.Size(), count)
+}
+
+// 遍历过程中发生溢出，没有被修改的 key 只会被遍历到一次，并且顺序不变
+func TestHybridIndex_Iterator_Spill(t *testing.T) {
+	for _, reverse := range []bool{false, true} {
+		hi := newTestHybridIndex(t, fmt.Sprintf("hybrid-iter-spill-%v", reverse), 1<<30)
+		for i := 0; i < 3000; i++ {
+			hi.Put([]byte(fmt.Sprintf("key-%05d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+		}
+		// 全部溢出到磁盘之后再写入大部分 key，磁盘上只剩下编号是 10 的倍数的 key
+		// 磁盘上的 key 比内存中稀疏，磁盘上读取的一批数据覆盖的范围比内存中的大
+		hi.memLimit = 0
+		hi.Put([]byte("new"), &data.LogRecordPos{Fid: 2})
+		hi.memLimit = 1 << 30
+		for i := 0; i < 3000; i++ {
+			if i%10 != 0 {
+				hi.Put([]byte(fmt.Sprintf("key-%05d", i)), &data.LogRecordPos{Fid: 2, Offset: int64(i)})
+			}
+		}
+
+		seen := make(map[string]int)
+		var prev []byte
+		spills := hi.spills
+		iter := hi.Iterator(reverse)
+		for n := 0; iter.Valid(); n++ {
+			if prev != nil {
+				if reverse {
+					assert.True(t, string(prev) > string(iter.Key()))
+				} else {
+					assert.True(t, string(prev) < string(iter.Key()))
+				}
+			}
+			prev = append(prev[:0], iter.Key()...)
+			seen[string(iter.Key())]++
+			// 将还没有遍历到的 key 溢出到磁盘上
+			if n == 10 {
+				hi.memLimit = 16 * 1024
+				hi.Put([]byte(fmt.Sprintf("new-%d", n)), &data.LogRecordPos{Fid: 3, Offset: int64(n)})
+			}
+			iter.Next()
+		}
+		iter.Close()
+		assert.True(t, hi.spills > spills)
+
+		for i := 0; i < 3000; i++ {
+			assert.Equal(t, 1, seen[fmt.Sprintf("key-%05d", i)], "key-%05d", i)
+		}
+		assert.Nil(t, hi.Close())
+	}
+}
+
+func TestColdFilter(t *testing.T) {
+	filter := newColdFilter(10000)
+	for i := 0; i < 10000; i++ {
+		filter.add([]byte(fmt.Sprintf("key-%05d", i)))
+	}
+	// 加入的 key 一定能找到，没有加入的 key 误判率在 1% 左右
+	for i := 0; i < 10000; i++ {
+		assert.True(t, filter.mayContain([]byte(fmt.Sprintf("key-%05d", i))))
+	}
+	var falsePositives int
+	for i := 0; i < 10000; i++ {
+		if filter.mayContain([]byte(fmt.Sprintf("other-%05d", i))) {
+			falsePositives++
+		}
+	}
+	assert.True(t, falsePositives < 300, "false positives %d", falsePositives)
+}
+
+func TestNewIndexer_Error(t *testing.T) {
+	// 混合索引打开磁盘文件失败时返回错误
+	_, err := NewIndexer(Hybrid, filepath.Join(t.TempDir(), "not-exist", "sub"), false)
+	assert.NotNil(t, err)
+	_, err = NewIndexer(IndexType(100), t.TempDir(), false)
+	assert.NotNil(t, err)
+
+	indexer, err := NewIndexer(Hybrid, t.TempDir(), false)
+	assert.Nil(t, err)
+	assert.Nil(t, indexer.Close())
+}
+
+func TestHybridIndex_Close(t *testing.T) {
+	hi := newTestHybridIndex(t, "hybrid-close", 1024)
+	for i := 0; i < 100; i++ {
+		hi.Put([]byte(fmt.Sprintf("key-%05d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	assert.Nil(t, hi.Close())
+	// 关闭后删除磁盘上的数据
+	_, err := os.Stat(hi.path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+// 打开和读写磁盘文件出错时返回错误，不会 panic
+func TestHybridIndex_Error(t *testing.T) {
+	_, err := NewHybridIndex(filepath.Join("../TestingFile", "hybrid-not-exist", "sub"), 64*1024)
+	assert.NotNil(t, err)
+
+	hi := newTestHybridIndex(t, "hybrid-error", 16*1024)
+	for i := 0; i < 2000; i++ {
+		hi.Put([]byte(fmt.Sprintf("key-%05d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	assert.True(t, hi.coldSize > 0)
+	assert.Nil(t, hi.Err())
+
+	// 磁盘上的 B+ 树不可用之后，读取磁盘上的 key 时记录错误
+	assert.Nil(t, hi.cold.Close())
+	assert.Nil(t, hi.Get([]byte("key-00000")))
+	assert.NotNil(t, hi.Err())
+	_, ok := hi.Delete([]byte("key-00001"))
+	assert.False(t, ok)
+
+	// 出错之后不再溢出，新写入的 key 保存在内存中
+	coldSize := hi.coldSize
+	for i := 2000; i < 4000; i++ {
+		hi.Put([]byte(fmt.Sprintf("key-%05d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	assert.Equal(t, coldSize, hi.coldSize)
+	assert.Equal(t, int64(3999), hi.Get([]byte("key-03999")).Offset)
+}
@@ -3,6 +3,7 @@ package index
 import (
 	"SingleKVDataSet/data"
 	"bytes"
+	"fmt"
 	"github.com/google/btree"
 )
 
@@ -17,6 +18,9 @@ type Indexer interface {
 	// Delete 根据 key 删除对应的索引位置信息
 	Delete(key []byte) (*data.LogRecordPos, bool)
 
+	// ApplyBatch 按顺序批量写入和删除索引，返回每个操作之前 key 对应的位置信息
+	ApplyBatch(ops []IndexOp) []*data.LogRecordPos
+
 	// Size 索引中的数据量
 	Size() int
 
@@ -27,6 +31,26 @@ type Indexer interface {
 	Close() error
 }
 
+// IndexOp 批量更新索引时的一个写入或删除操作
+type IndexOp struct {
+	Key    []byte
+	Pos    *data.LogRecordPos // 删除操作不需要位置信息
+	Delete bool
+}
+
+// applyBatch 逐个执行批量操作，用于没有批量写入优化的索引
+func applyBatch(indexer Indexer, ops []IndexOp) []*data.LogRecordPos {
+	oldPositions := make([]*data.LogRecordPos, len(ops))
+	for i, op := range ops {
+		if op.Delete {
+			oldPositions[i], _ = indexer.Delete(op.Key)
+		} else {
+			oldPositions[i] = indexer.Put(op.Key, op.Pos)
+		}
+	}
+	return oldPositions
+}
+
 type IndexType = int8
 
 const (
@@ -38,19 +62,39 @@ const (
 
 	// BPTree B+ 树索引
 	BPTree
+
+	// Sharded 分片 BTree 索引
+	Sharded
+
+	// Hash 哈希索引，只适合点查询
+	Hash
+
+	// Skip 跳表索引
+	Skip
+
+	// Hybrid 混合索引，内存占用超过上限后将较冷的数据溢出到磁盘
+	Hybrid
 )
 
-// NewIndexer 根据类型，初始化索引
-func NewIndexer(typ IndexType, dirPath string, sync bool) Indexer {
+// NewIndexer 根据类型，初始化索引，混合索引打开磁盘文件失败时返回错误
+func NewIndexer(typ IndexType, dirPath string, sync bool) (Indexer, error) {
 	switch typ {
 	case Btree:
-		return NewBTree()
+		return NewBTree(), nil
 	case ART:
-		return NewART()
+		return NewART(), nil
 	case BPTree:
-		return NewBPlusTree(dirPath, sync)
+		return NewBPlusTree(dirPath, sync), nil
+	case Sharded:
+		return NewShardedBTree(DefaultShardNum), nil
+	case Hash:
+		return NewHashIndex(), nil
+	case Skip:
+		return NewSkipList(), nil
+	case Hybrid:
+		return NewHybridIndex(dirPath, DefaultIndexMemoryLimit)
 	default:
-		panic("unsupported index type")
+		return nil, fmt.Errorf("unsupported index type %d", typ)
 	}
 }
 
@@ -63,7 +107,24 @@ func (ai *Item) Less(bi btree.Item) bool {
 	return bytes.Compare(ai.key, bi.(*Item).key) == -1
 }
 
+// memSize 索引项占用的内存，key 的底层数组会被索引一直持有
+func (ai *Item) memSize() int64 {
+	return int64(cap(ai.key)) + itemMemSize
+}
+
+// ErrorReporter 读写磁盘时可能出错的索引，Indexer 的方法不返回错误，出错之后通过 Err 获取
+type ErrorReporter interface {
+	Err() error
+}
+
+// MemoryMeasurer 可以统计内存占用的索引，统计的是索引持有的 key、位置信息和主要的结构开销
+type MemoryMeasurer interface {
+	MemorySize() int64
+}
+
 // Iterator 通用索引迭代器
+// 迭代器不是创建时的快照，遍历过程中其他协程写入或删除的 key 可能会被看到，也可能看不到
+// 但是遍历的顺序始终不变：已经遍历过的 key 不会再次出现，遍历期间没有被修改的 key 一定会被遍历到
 type Iterator interface {
 	// Rewind 重新回到迭代器起点，即第一个数据
 	Rewind()
@@ -80,3 +141,75 @@ type Iterator interface {
 	// Close 关闭迭代器，释放相应资源
 	Close()
 }
+
+// 迭代器每次从索引中读取的数据量
+const iteratorBatchSize = 256
+
+// fillFunc 从索引中按照遍历顺序读取数据追加到 items 中，最多读取 iteratorBatchSize 条
+// seek 为 false 时从头开始读取，否则从 start 开始读取，inclusive 表示是否包含 start 本身
+type fillFunc func(start []byte, seek, inclusive bool, items []*Item) []*Item
+
+// batchIterator 分批读取数据的迭代器，创建时不复制全部数据，只保存当前一批数据
+// 每次读取一批数据时才对索引加锁，遍历过程中其他协程的修改可能会被看到
+type batchIterator struct {
+	fill      fillFunc
+	items     []*Item // 当前这一批数据
+	currIndex int     // 当前遍历到的位置下标
+	more      bool    // 当前这一批数据之后是否可能还有数据
+}
+
+func newBatchIterator(fill fillFunc) *batchIterator {
+	bi := &batchIterator{fill: fill}
+	bi.Rewind()
+	return bi
+}
+
+// Rewind 重新回到迭代器起点，即第一个数据
+func (bi *batchIterator) Rewind() {
+	bi.load(nil, false, false)
+}
+
+// Seek 根据传入的key查找第一个大于/小于等于的目标key，根据这个key开始遍历
+func (bi *batchIterator) Seek(key []byte) {
+	bi.load(key, true, true)
+}
+
+// Next 跳转到下一个key，当前这一批数据遍历完后从最后一个 key 之后继续读取
+func (bi *batchIterator) Next() {
+	bi.currIndex++
+	if bi.currIndex >= len(bi.items) && bi.more {
+		bi.load(bi.items[len(bi.items)-1].key, true, false)
+	}
+}
+
+// Valid 是否有效，即是否已经遍历完了所有key，用于退出遍历
+func (bi *batchIterator) Valid() bool {
+	return bi.currIndex < len(bi.items)
+}
+
+// Key 当前遍历位置的key数据
+func (bi *batchIterator) Key() []byte {
+	return bi.items[bi.currIndex].key
+}
+
+// Value 当前遍历位置的value数据
+func (bi *batchIterator) Value() *data.LogRecordPos {
+	return bi.items[bi.currIndex].pos
+}
+
+// Close 关闭迭代器，释放相应资源
+func (bi *batchIterator) Close() {
+	bi.items = nil
+	bi.currIndex = 0
+	bi.more = false
+}
+
+func (bi *batchIterator) load(start []byte, seek, inclusive bool) {
+	// 复用上一批数据的内存，已经返回的 key 不会被修改
+	for i := range bi.items {
+		bi.items[i] = nil
+	}
+	bi.items = bi.fill(start, seek, inclusive, bi.items[:0])
+	bi.currIndex = 0
+	bi.more = len(bi.items) >= iteratorBatchSize
+}
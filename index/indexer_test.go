@@ -0,0 +1,299 @@
+package index
+
+import (
+	"SingleKVDataSet/data"
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"os"
+	"sort"
+	"testing"
+)
+
+// 所有索引类型都需要通过的一致性测试，新增索引类型时在这里添加
+var indexerFactories = []struct {
+	name string
+	new  func(dir string) Indexer
+}{
+	{"BTree", func(string) Indexer { return NewBTree() }},
+	{"CompressedBTree", func(string) Indexer { return NewCompressedBTree() }},
+	{"ART", func(string) Indexer { return NewART() }},
+	{"CompressedART", func(string) Indexer { return NewCompressedART() }},
+	{"BPTree", func(dir string) Indexer { return NewBPlusTree(dir, false) }},
+	{"Sharded", func(string) Indexer { return NewShardedBTree(4) }},
+	{"CompressedSharded", func(string) Indexer { return NewCompressedShardedBTree(4) }},
+	{"Hash", func(string) Indexer { return NewHashIndex() }},
+	{"Skip", func(string) Indexer { return NewSkipList() }},
+	{"Hybrid", func(dir string) Indexer {
+		hi, err := NewHybridIndex(dir, 4*1024)
+		if err != nil {
+			panic(err)
+		}
+		return hi
+	}},
+}
+
+// refIndex 作为对照的参考实现
+type refIndex map[string]*data.LogRecordPos
+
+func (ref refIndex) sortedKeys(reverse bool) []string {
+	keys := make([]string, 0, len(ref))
+	for key := range ref {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if reverse {
+		for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+	}
+	return keys
+}
+
+// seek 参考实现的 Seek，正向为第一个大于等于 key 的位置，反向为第一个小于等于 key 的位置
+func (ref refIndex) seek(key []byte, reverse bool) []string {
+	keys := ref.sortedKeys(reverse)
+	idx := sort.Search(len(keys), func(i int) bool {
+		if reverse {
+			return bytes.Compare([]byte(keys[i]), key) <= 0
+		}
+		return bytes.Compare([]byte(keys[i]), key) >= 0
+	})
+	return keys[idx:]
+}
+
+func forEachIndexer(t *testing.T, fn func(t *testing.T, indexer Indexer)) {
+	for _, factory := range indexerFactories {
+		t.Run(factory.name, func(t *testing.T) {
+			_ = os.MkdirAll("../TestingFile", os.ModePerm)
+			dir, err := os.MkdirTemp("../TestingFile", "indexer-")
+			assert.Nil(t, err)
+			defer os.RemoveAll(dir)
+			indexer := factory.new(dir)
+			defer indexer.Close()
+			fn(t, indexer)
+		})
+	}
+}
+
+// randomKey 生成的 key 有较多的公共前缀和分隔符，B+ 树不支持空的 key
+func randomKey(r *rand.Rand) []byte {
+	const alphabet = "ab/:z"
+	var prefix string
+	if r.Intn(2) == 0 {
+		prefix = fmt.Sprintf("tenant-%02d/object/", r.Intn(4))
+	}
+	key := make([]byte, 1+r.Intn(6))
+	for i := range key {
+		key[i] = alphabet[r.Intn(len(alphabet))]
+	}
+	return append([]byte(prefix), key...)
+}
+
+// checkIndexer 比较索引和参考实现的全部数据，包括 Size、Get、正反向遍历和 Seek
+func checkIndexer(t *testing.T, indexer Indexer, ref refIndex, seekKeys [][]byte) {
+	assert.Equal(t, len(ref), indexer.Size())
+	for key, pos := range ref {
+		assert.Equal(t, pos, indexer.Get([]byte(key)), "get %q", key)
+	}
+
+	for _, reverse := range []bool{false, true} {
+		iter := indexer.Iterator(reverse)
+		var keys []string
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+			assert.Equal(t, ref[string(iter.Key())], iter.Value())
+		}
+		expected := ref.sortedKeys(reverse)
+		if len(expected) == 0 {
+			assert.Empty(t, keys, "reverse %v", reverse)
+		} else {
+			assert.Equal(t, expected, keys, "reverse %v", reverse)
+		}
+
+		for _, key := range seekKeys {
+			iter.Seek(key)
+			expected := ref.seek(key, reverse)
+			if len(expected) == 0 {
+				assert.False(t, iter.Valid(), "seek %q reverse %v", key, reverse)
+				continue
+			}
+			if assert.True(t, iter.Valid(), "seek %q reverse %v", key, reverse) {
+				assert.Equal(t, expected[0], string(iter.Key()), "seek %q reverse %v", key, reverse)
+				// Seek 之后可以继续遍历
+				iter.Next()
+				if len(expected) > 1 {
+					assert.Equal(t, expected[1], string(iter.Key()), "next after seek %q reverse %v", key, reverse)
+				} else {
+					assert.False(t, iter.Valid())
+				}
+			}
+		}
+		iter.Close()
+	}
+}
+
+func TestIndexer_Conformance(t *testing.T) {
+	forEachIndexer(t, func(t *testing.T, indexer Indexer) {
+		ref := make(refIndex)
+		checkIndexer(t, indexer, ref, [][]byte{[]byte("a")})
+
+		r := rand.New(rand.NewSource(1))
+		for round := 0; round < 5; round++ {
+			for i := 0; i < 300; i++ {
+				key := randomKey(r)
+				if r.Intn(3) == 0 {
+					// Delete 返回删除之前的位置信息，key 不存在时返回 false
+					oldPos, ok := indexer.Delete(key)
+					expected, exists := ref[string(key)]
+					assert.Equal(t, exists, ok, "delete %q", key)
+					assert.Equal(t, expected, oldPos, "delete %q", key)
+					delete(ref, string(key))
+					continue
+				}
+				pos := &data.LogRecordPos{Fid: uint32(round), Offset: int64(i), Size: uint32(r.Intn(100))}
+				// Put 返回覆盖之前的位置信息
+				oldPos := indexer.Put(key, pos)
+				assert.Equal(t, ref[string(key)], oldPos, "put %q", key)
+				ref[string(key)] = pos
+			}
+
+			seekKeys := [][]byte{[]byte("\x00"), []byte("zzzzzzzzzzzz")}
+			for i := 0; i < 20; i++ {
+				seekKeys = append(seekKeys, randomKey(r))
+			}
+			checkIndexer(t, indexer, ref, seekKeys)
+		}
+	})
+}
+
+func TestIndexer_ApplyBatch(t *testing.T) {
+	forEachIndexer(t, func(t *testing.T, indexer Indexer) {
+		ref := make(refIndex)
+		r := rand.New(rand.NewSource(2))
+		for round := 0; round < 5; round++ {
+			var ops []IndexOp
+			var expected []*data.LogRecordPos
+			for i := 0; i < 200; i++ {
+				key := randomKey(r)
+				expected = append(expected, ref[string(key)])
+				if r.Intn(3) == 0 {
+					ops = append(ops, IndexOp{Key: key, Delete: true})
+					delete(ref, string(key))
+				} else {
+					pos := &data.LogRecordPos{Fid: uint32(round), Offset: int64(i)}
+					ops = append(ops, IndexOp{Key: key, Pos: pos})
+					ref[string(key)] = pos
+				}
+			}
+			// 批量操作按顺序执行，返回值和逐个执行时相同
+			assert.Equal(t, expected, indexer.ApplyBatch(ops))
+			checkIndexer(t, indexer, ref, [][]byte{randomKey(r)})
+		}
+	})
+}
+
+// fuzzKeyAlphabet fuzz 测试中 key 使用的字节，字符少时 key 之间更容易有公共前缀，同时包含最小和最大的字节
+var fuzzKeyAlphabet = []byte{0x00, 'a', 'b', 0xff}
+
+// FuzzIndexer 将输入解析为一系列写入、删除和 Seek 操作，每个索引的结果都需要和参考实现相同
+// 每个操作的第一个字节低两位是操作类型，接下来三位是 key 的长度 n，后面 n 个字节的低两位从 fuzzKeyAlphabet 中选出 key 的字节
+// key 的长度不固定，短的 key 经常是长的 key 的前缀，用来覆盖基数树的前缀和节点分裂
+func FuzzIndexer(f *testing.F) {
+	f.Add([]byte{0, 1, 4, 1, 1, 1, 8, 2, 2, 3})
+	f.Add([]byte("put/get/delete/seek:tenant"))
+	f.Add(bytes.Repeat([]byte{0x10, 0x2f, 0x21, 0x3a, 0x32, 0x2f}, 20))
+	f.Add([]byte{0x00, 0x04, 0x01, 0x08, 0x01, 0x02, 0x0c, 0x01, 0x02, 0x03, 0x06, 0x01, 0x03, 0x07, 0x00})
+
+	f.Fuzz(func(t *testing.T, program []byte) {
+		if len(program) > 512 {
+			return
+		}
+		forEachIndexer(t, func(t *testing.T, indexer Indexer) {
+			ref := make(refIndex)
+			var seekKeys [][]byte
+			for i := 0; i < len(program); {
+				op := program[i] & 3
+				n := int(program[i]>>2) & 7
+				i++
+				key := []byte{'k'}
+				for ; n > 0 && i < len(program); n-- {
+					key = append(key, fuzzKeyAlphabet[program[i]&3])
+					i++
+				}
+				switch op {
+				case 0, 1:
+					pos := &data.LogRecordPos{Fid: 1, Offset: int64(i)}
+					assert.Equal(t, ref[string(key)], indexer.Put(key, pos))
+					ref[string(key)] = pos
+				case 2:
+					expected, exists := ref[string(key)]
+					oldPos, ok := indexer.Delete(key)
+					assert.Equal(t, exists, ok)
+					assert.Equal(t, expected, oldPos)
+					delete(ref, string(key))
+				case 3:
+					seekKeys = append(seekKeys, key)
+				}
+			}
+			checkIndexer(t, indexer, ref, seekKeys)
+		})
+	})
+}
+
+// 遍历过程中其他协程修改索引，已经遍历过的 key 不会再次出现，没有被修改的 key 一定会被遍历到
+func TestIndexer_IteratorModify(t *testing.T) {
+	const keyNum = 1000
+	forEachIndexer(t, func(t *testing.T, indexer Indexer) {
+		for i := 0; i < keyNum; i++ {
+			indexer.Put([]byte(fmt.Sprintf("key-%05d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+		}
+
+		for _, reverse := range []bool{false, true} {
+			// 编号是 3 的倍数的 key 会被反复删除和写入，同时写入新的 key
+			stop := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for round := 0; ; round++ {
+					for i := 0; i < keyNum; i += 3 {
+						select {
+						case <-stop:
+							return
+						default:
+						}
+						key := []byte(fmt.Sprintf("key-%05d", i))
+						indexer.Delete(key)
+						indexer.Put(key, &data.LogRecordPos{Fid: 2, Offset: int64(i)})
+						indexer.Put([]byte(fmt.Sprintf("key-%05d-%d", i, round)), &data.LogRecordPos{Fid: 3})
+					}
+				}
+			}()
+
+			seen := make(map[string]bool)
+			var prev []byte
+			iter := indexer.Iterator(reverse)
+			for iter.Rewind(); iter.Valid(); iter.Next() {
+				if prev != nil {
+					cmp := bytes.Compare(prev, iter.Key())
+					if reverse {
+						cmp = -cmp
+					}
+					assert.Equal(t, -1, cmp, "%q after %q", iter.Key(), prev)
+				}
+				prev = append(prev[:0], iter.Key()...)
+				seen[string(iter.Key())] = true
+			}
+			iter.Close()
+			close(stop)
+			<-done
+
+			for i := 0; i < keyNum; i++ {
+				if i%3 != 0 {
+					assert.True(t, seen[fmt.Sprintf("key-%05d", i)], "key-%05d reverse %v", i, reverse)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,137 @@
+package index
+
+import (
+	"SingleKVDataSet/data"
+	"bytes"
+	"github.com/google/btree"
+	"sync"
+	"unsafe"
+)
+
+const (
+	// 前缀长度小于这个值时不值得共享，直接保存完整的 key
+	minSharedPrefixLen = 8
+)
+
+// 分隔符之前的部分作为 key 的前缀，例如 tenant/<uuid>/object/<uuid> 的前缀为 tenant/<uuid>/object/
+var keySeparators = []byte("/:")
+
+var (
+	itemMemSize       = int64(unsafe.Sizeof(Item{}) + unsafe.Sizeof(data.LogRecordPos{}) + unsafe.Sizeof(btree.Item(nil)))
+	prefixItemMemSize = int64(unsafe.Sizeof(prefixItem{}) + unsafe.Sizeof(data.LogRecordPos{}) + unsafe.Sizeof(btree.Item(nil)))
+	keyPrefixMemSize  = int64(unsafe.Sizeof(keyPrefix{})) + 48 // map 中每个前缀的 key 和指针
+)
+
+// keyPrefix 多个 key 共享的前缀
+type keyPrefix struct {
+	data []byte
+	refs int // 引用这个前缀的 key 的数量，为 0 时从前缀池中删除
+}
+
+// prefixPool 前缀池，相同的前缀只保存一份
+type prefixPool struct {
+	prefixes map[string]*keyPrefix
+	memSize  int64 // 前缀占用的内存
+	lock     *sync.Mutex
+}
+
+func newPrefixPool() *prefixPool {
+	return &prefixPool{
+		prefixes: make(map[string]*keyPrefix),
+		lock:     new(sync.Mutex),
+	}
+}
+
+// newItem 将 key 拆分为共享的前缀和单独保存的后缀
+func (pp *prefixPool) newItem(key []byte, pos *data.LogRecordPos) *prefixItem {
+	n := bytes.LastIndexAny(key, string(keySeparators)) + 1
+	if n < minSharedPrefixLen {
+		// 复制一份，不持有调用方的内存
+		return &prefixItem{suffix: append([]byte{}, key...), pos: pos}
+	}
+
+	pp.lock.Lock()
+	defer pp.lock.Unlock()
+	prefix, ok := pp.prefixes[string(key[:n])]
+	if !ok {
+		prefix = &keyPrefix{data: append([]byte{}, key[:n]...)}
+		pp.prefixes[string(prefix.data)] = prefix
+		pp.memSize += int64(len(prefix.data))*2 + keyPrefixMemSize
+	}
+	prefix.refs++
+	return &prefixItem{prefix: prefix, suffix: append([]byte{}, key[n:]...), pos: pos}
+}
+
+// release key 从索引中删除后释放对前缀的引用
+func (pp *prefixPool) release(item *prefixItem) {
+	if item.prefix == nil {
+		return
+	}
+	pp.lock.Lock()
+	defer pp.lock.Unlock()
+	item.prefix.refs--
+	if item.prefix.refs == 0 {
+		delete(pp.prefixes, string(item.prefix.data))
+		pp.memSize -= int64(len(item.prefix.data))*2 + keyPrefixMemSize
+	}
+}
+
+func (pp *prefixPool) size() int64 {
+	pp.lock.Lock()
+	defer pp.lock.Unlock()
+	return pp.memSize
+}
+
+// prefixItem 前缀压缩后的索引项，完整的 key 为 prefix + suffix
+type prefixItem struct {
+	prefix *keyPrefix
+	suffix []byte
+	pos    *data.LogRecordPos
+}
+
+func (pi *prefixItem) Less(bi btree.Item) bool {
+	other := bi.(*prefixItem)
+	return compareSplitKey(pi.prefixData(), pi.suffix, other.prefixData(), other.suffix) < 0
+}
+
+func (pi *prefixItem) prefixData() []byte {
+	if pi.prefix == nil {
+		return nil
+	}
+	return pi.prefix.data
+}
+
+// key 拼接出完整的 key
+func (pi *prefixItem) key() []byte {
+	prefix := pi.prefixData()
+	key := make([]byte, len(prefix)+len(pi.suffix))
+	copy(key, prefix)
+	copy(key[len(prefix):], pi.suffix)
+	return key
+}
+
+func (pi *prefixItem) memSize() int64 {
+	return int64(len(pi.suffix)) + prefixItemMemSize
+}
+
+// compareSplitKey 比较 a1+a2 和 b1+b2 两个 key，不需要拼接
+func compareSplitKey(a1, a2, b1, b2 []byte) int {
+	for {
+		if len(a1) == 0 {
+			a1, a2 = a2, nil
+		}
+		if len(b1) == 0 {
+			b1, b2 = b2, nil
+		}
+		if len(a1) == 0 || len(b1) == 0 {
+			break
+		}
+		n := min(len(a1), len(b1))
+		if cmp := bytes.Compare(a1[:n], b1[:n]); cmp != 0 {
+			return cmp
+		}
+		a1, b1 = a1[n:], b1[n:]
+	}
+	// 其中一个 key 已经比较完了，较短的 key 更小
+	return len(a1) + len(a2) - len(b1) - len(b2)
+}
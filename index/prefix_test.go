@@ -0,0 +1,146 @@
+package index
+
+import (
+	"SingleKVDataSet/data"
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"sort"
+	"testing"
+)
+
+func TestCompareSplitKey(t *testing.T) {
+	keys := [][]byte{nil, []byte("a"), []byte("ab"), []byte("abc"), []byte("abd"), []byte("b"), []byte("ba")}
+	for _, a := range keys {
+		for _, b := range keys {
+			expected := bytes.Compare(a, b)
+			// 在所有位置拆分 key，比较结果和完整的 key 相同
+			for i := 0; i <= len(a); i++ {
+				for j := 0; j <= len(b); j++ {
+					cmp := compareSplitKey(a[:i], a[i:], b[:j], b[j:])
+					assert.Equal(t, expected, sign(cmp), "%s %s %d %d", a, b, i, j)
+				}
+			}
+		}
+	}
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+func TestPrefixPool(t *testing.T) {
+	pp := newPrefixPool()
+	item1 := pp.newItem([]byte("tenant-1/object/a"), &data.LogRecordPos{Fid: 1})
+	item2 := pp.newItem([]byte("tenant-1/object/b"), &data.LogRecordPos{Fid: 1})
+	// 相同的前缀只保存一份
+	assert.Same(t, item1.prefix, item2.prefix)
+	assert.Equal(t, []byte("a"), item1.suffix)
+	assert.Equal(t, []byte("tenant-1/object/a"), item1.key())
+	assert.Equal(t, 1, len(pp.prefixes))
+
+	// 前缀太短时保存完整的 key
+	item3 := pp.newItem([]byte("a/b"), &data.LogRecordPos{Fid: 1})
+	assert.Nil(t, item3.prefix)
+	assert.Equal(t, []byte("a/b"), item3.key())
+
+	// 没有 key 引用时删除前缀
+	pp.release(item1)
+	assert.Equal(t, 1, len(pp.prefixes))
+	pp.release(item2)
+	pp.release(item3)
+	assert.Equal(t, 0, len(pp.prefixes))
+	assert.Equal(t, int64(0), pp.size())
+}
+
+func TestCompressedBTree(t *testing.T) {
+	bt := NewCompressedBTree()
+	keys := []string{
+		"tenant-0001/object/zz",
+		"tenant-0001/object/b/c",
+		"tenant-0001/object/",
+		"tenant-0001/objectx",
+		"tenant-0001/object:1",
+		"tenant-0001/object/b/",
+		"short",
+		"",
+	}
+	for i, key := range keys {
+		assert.Nil(t, bt.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: int64(i)}))
+	}
+	for i, key := range keys {
+		assert.Equal(t, int64(i), bt.Get([]byte(key)).Offset)
+	}
+	res1 := bt.Put([]byte("tenant-0001/object/zz"), &data.LogRecordPos{Fid: 2})
+	assert.Equal(t, int64(0), res1.Offset)
+	assert.Equal(t, len(keys), bt.Size())
+
+	// 遍历顺序和完整的 key 的顺序相同
+	sorted := append([]string{}, keys...)
+	sort.Strings(sorted)
+	iter := bt.Iterator(false)
+	var i int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, sorted[i], string(iter.Key()))
+		i++
+	}
+	assert.Equal(t, len(keys), i)
+	iter.Seek([]byte("tenant-0001/object/a"))
+	assert.Equal(t, []byte("tenant-0001/object/b/"), iter.Key())
+	iter.Close()
+
+	for _, key := range keys {
+		_, ok := bt.Delete([]byte(key))
+		assert.True(t, ok)
+	}
+	assert.Equal(t, 0, bt.Size())
+	assert.Equal(t, int64(0), bt.MemorySize())
+
+	testIteratorStream(t, NewCompressedBTree())
+	testIteratorStream(t, NewCompressedShardedBTree(4))
+}
+
+func TestIndex_MemorySize(t *testing.T) {
+	indexers := map[string]Indexer{
+		"BTree":           NewBTree(),
+		"CompressedBTree": NewCompressedBTree(),
+		"ART":             NewART(),
+		"CompressedART":   NewCompressedART(),
+		"Sharded":         NewShardedBTree(4),
+		"Skip":            NewSkipList(),
+	}
+	for name, indexer := range indexers {
+		measurer := indexer.(MemoryMeasurer)
+		for i := 0; i < 1000; i++ {
+			indexer.Put([]byte(fmt.Sprintf("tenant-%04d/object/%08d", i%10, i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+		}
+		assert.True(t, measurer.MemorySize() > 0, name)
+		for i := 0; i < 1000; i++ {
+			indexer.Delete([]byte(fmt.Sprintf("tenant-%04d/object/%08d", i%10, i)))
+		}
+		assert.Equal(t, int64(0), measurer.MemorySize(), name)
+	}
+
+	// 前缀压缩后占用的内存更少
+	plain, compressed := NewBTree(), NewCompressedBTree()
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("tenant/%036d/object/%036d", i%10, i))
+		plain.Put(key, &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+		compressed.Put(key, &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	assert.True(t, compressed.MemorySize() < plain.MemorySize())
+
+	plainART, compressedART := NewART(), NewCompressedART()
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("tenant/%036d/object/%036d", i%10, i))
+		plainART.Put(key, &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+		compressedART.Put(key, &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	assert.True(t, compressedART.MemorySize() < plainART.MemorySize())
+}
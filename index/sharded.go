@@ -0,0 +1,209 @@
+package index
+
+import (
+	"SingleKVDataSet/data"
+	"bytes"
+	"container/heap"
+)
+
+// DefaultShardNum 分片索引默认的分片数量
+const DefaultShardNum = 16
+
+// ShardedBTree 分片索引，根据 key 的哈希值将数据分散到多个 BTree 中
+// 每个分片有独立的锁，不同分片上的读写可以并发进行
+type ShardedBTree struct {
+	shards   []*BTree
+	prefixes *prefixPool // 所有分片共享的前缀池
+}
+
+// NewShardedBTree 新建分片索引
+func NewShardedBTree(shardNum int) *ShardedBTree {
+	return newShardedBTree(shardNum, nil)
+}
+
+// NewCompressedShardedBTree 新建 key 按照前缀压缩保存的分片索引，所有分片共享同一个前缀池
+func NewCompressedShardedBTree(shardNum int) *ShardedBTree {
+	return newShardedBTree(shardNum, newPrefixPool())
+}
+
+func newShardedBTree(shardNum int, prefixes *prefixPool) *ShardedBTree {
+	if shardNum <= 0 {
+		shardNum = DefaultShardNum
+	}
+	shards := make([]*BTree, shardNum)
+	for i := range shards {
+		if prefixes != nil {
+			shards[i] = newCompressedBTree(prefixes)
+		} else {
+			shards[i] = NewBTree()
+		}
+	}
+	return &ShardedBTree{shards: shards, prefixes: prefixes}
+}
+
+// shard 根据 key 的 FNV-1a 哈希值找到对应的分片
+func (sb *ShardedBTree) shard(key []byte) *BTree {
+	var hash uint32 = 2166136261
+	for _, b := range key {
+		hash ^= uint32(b)
+		hash *= 16777619
+	}
+	return sb.shards[hash%uint32(len(sb.shards))]
+}
+
+func (sb *ShardedBTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	return sb.shard(key).Put(key, pos)
+}
+
+func (sb *ShardedBTree) Get(key []byte) *data.LogRecordPos {
+	return sb.shard(key).Get(key)
+}
+
+func (sb *ShardedBTree) Delete(key []byte) (*data.LogRecordPos, bool) {
+	return sb.shard(key).Delete(key)
+}
+
+func (sb *ShardedBTree) ApplyBatch(ops []IndexOp) []*data.LogRecordPos {
+	return applyBatch(sb, ops)
+}
+
+func (sb *ShardedBTree) Size() int {
+	var size int
+	for _, shard := range sb.shards {
+		size += shard.Size()
+	}
+	return size
+}
+
+// MemorySize 所有分片的索引项和共享前缀占用的内存
+func (sb *ShardedBTree) MemorySize() int64 {
+	var size int64
+	for _, shard := range sb.shards {
+		size += shard.itemsMemSize()
+	}
+	if sb.prefixes != nil {
+		size += sb.prefixes.size()
+	}
+	return size
+}
+
+// Iterator 合并所有分片的迭代器，按照 key 的顺序遍历
+func (sb *ShardedBTree) Iterator(reverse bool) Iterator {
+	iterators := make([]Iterator, len(sb.shards))
+	for i, shard := range sb.shards {
+		iterators[i] = shard.Iterator(reverse)
+	}
+	return newMergeIterator(iterators, reverse)
+}
+
+func (sb *ShardedBTree) Close() error {
+	return nil
+}
+
+// mergeIterator 多路归并迭代器，将多个有序的迭代器合并成一个有序的迭代器
+type mergeIterator struct {
+	iterators []Iterator
+	heap      *iteratorHeap // 当前有效的迭代器，堆顶是当前遍历位置
+}
+
+func newMergeIterator(iterators []Iterator, reverse bool) *mergeIterator {
+	mi := &mergeIterator{
+		iterators: iterators,
+		heap:      &iteratorHeap{reverse: reverse},
+	}
+	mi.Rewind()
+	return mi
+}
+
+// Rewind 重新回到迭代器起点，即第一个数据
+func (mi *mergeIterator) Rewind() {
+	for _, it := range mi.iterators {
+		it.Rewind()
+	}
+	mi.rebuild()
+}
+
+// Seek 根据传入的key查找第一个大于/小于等于的目标key，根据这个key开始遍历
+func (mi *mergeIterator) Seek(key []byte) {
+	for _, it := range mi.iterators {
+		it.Seek(key)
+	}
+	mi.rebuild()
+}
+
+// Next 跳转到下一个key
+func (mi *mergeIterator) Next() {
+	top := mi.heap.items[0]
+	top.Next()
+	if top.Valid() {
+		heap.Fix(mi.heap, 0)
+	} else {
+		heap.Pop(mi.heap)
+	}
+}
+
+// Valid 是否有效，即是否已经遍历完了所有key，用于退出遍历
+func (mi *mergeIterator) Valid() bool {
+	return mi.heap.Len() > 0
+}
+
+// Key 当前遍历位置的key数据
+func (mi *mergeIterator) Key() []byte {
+	return mi.heap.items[0].Key()
+}
+
+// Value 当前遍历位置的value数据
+func (mi *mergeIterator) Value() *data.LogRecordPos {
+	return mi.heap.items[0].Value()
+}
+
+// Close 关闭迭代器，释放相应资源
+func (mi *mergeIterator) Close() {
+	for _, it := range mi.iterators {
+		it.Close()
+	}
+	mi.heap.items = nil
+}
+
+// 将有效的迭代器重新放到堆中
+func (mi *mergeIterator) rebuild() {
+	mi.heap.items = mi.heap.items[:0]
+	for _, it := range mi.iterators {
+		if it.Valid() {
+			mi.heap.items = append(mi.heap.items, it)
+		}
+	}
+	heap.Init(mi.heap)
+}
+
+// iteratorHeap 按照迭代器当前的 key 排序的堆，反向遍历时 key 最大的在堆顶
+type iteratorHeap struct {
+	items   []Iterator
+	reverse bool
+}
+
+func (h *iteratorHeap) Len() int {
+	return len(h.items)
+}
+
+func (h *iteratorHeap) Less(i, j int) bool {
+	cmp := bytes.Compare(h.items[i].Key(), h.items[j].Key())
+	if h.reverse {
+		return cmp > 0
+	}
+	return cmp < 0
+}
+
+func (h *iteratorHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+}
+
+func (h *iteratorHeap) Push(x any) {
+	h.items = append(h.items, x.(Iterator))
+}
+
+func (h *iteratorHeap) Pop() any {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
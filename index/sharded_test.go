@@ -0,0 +1,98 @@
+package index
+
+import (
+	"SingleKVDataSet/data"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func TestShardedBTree_Put_Get_Delete(t *testing.T) {
+	sb := NewShardedBTree(4)
+
+	res1 := sb.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 2})
+	assert.Nil(t, res1)
+	res2 := sb.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 3})
+	assert.Equal(t, int64(2), res2.Offset)
+	assert.Equal(t, int64(3), sb.Get([]byte("a")).Offset)
+	assert.Nil(t, sb.Get([]byte("not exist")))
+
+	for i := 0; i < 100; i++ {
+		sb.Put([]byte(fmt.Sprintf("key-%03d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	assert.Equal(t, 101, sb.Size())
+
+	res3, ok := sb.Delete([]byte("a"))
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), res3.Offset)
+	res4, ok := sb.Delete([]byte("a"))
+	assert.False(t, ok)
+	assert.Nil(t, res4)
+	assert.Equal(t, 100, sb.Size())
+}
+
+func TestShardedBTree_Iterator(t *testing.T) {
+	sb := NewShardedBTree(8)
+
+	// 没有数据
+	iter1 := sb.Iterator(false)
+	assert.False(t, iter1.Valid())
+	iter1.Close()
+
+	for i := 0; i < 100; i++ {
+		sb.Put([]byte(fmt.Sprintf("key-%03d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	// 正向遍历，所有分片的数据按照 key 排序
+	iter2 := sb.Iterator(false)
+	var i int
+	for iter2.Rewind(); iter2.Valid(); iter2.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%03d", i)), iter2.Key())
+		assert.Equal(t, int64(i), iter2.Value().Offset)
+		i++
+	}
+	assert.Equal(t, 100, i)
+	iter2.Seek([]byte("key-050"))
+	assert.Equal(t, []byte("key-050"), iter2.Key())
+	iter2.Seek([]byte("key-0505"))
+	assert.Equal(t, []byte("key-051"), iter2.Key())
+	iter2.Seek([]byte("zz"))
+	assert.False(t, iter2.Valid())
+	iter2.Close()
+
+	// 反向遍历
+	iter3 := sb.Iterator(true)
+	i = 99
+	for iter3.Rewind(); iter3.Valid(); iter3.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%03d", i)), iter3.Key())
+		i--
+	}
+	assert.Equal(t, -1, i)
+	iter3.Seek([]byte("key-0505"))
+	assert.Equal(t, []byte("key-050"), iter3.Key())
+	iter3.Close()
+}
+
+func TestShardedBTree_Concurrent(t *testing.T) {
+	sb := NewShardedBTree(DefaultShardNum)
+
+	wg := new(sync.WaitGroup)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := []byte(fmt.Sprintf("key-%d-%d", g, i))
+				sb.Put(key, &data.LogRecordPos{Fid: uint32(g), Offset: int64(i)})
+				assert.NotNil(t, sb.Get(key))
+				if i%2 == 0 {
+					_, ok := sb.Delete(key)
+					assert.True(t, ok)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	assert.Equal(t, 8*500, sb.Size())
+}
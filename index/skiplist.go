@@ -0,0 +1,385 @@
+package index
+
+import (
+	"SingleKVDataSet/data"
+	"bytes"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+const (
+	skipListMaxLevel = 32
+	skipListP        = 4 // 每一层的节点数量约为下一层的 1/4
+)
+
+// SkipList 跳表索引
+// 读取不加锁，节点之间的指针和位置信息都通过原子操作访问
+// 更新已经存在的 key 时只需要原子地替换位置信息；插入和删除节点时只锁住被修改的前驱节点，
+// 加锁之后检查前驱节点没有变化再修改指针，不相邻的 key 可以被并发地插入和删除
+type SkipList struct {
+	head    *skipNode
+	level   atomic.Int32 // 当前最高的层数
+	size    atomic.Int64
+	memSize atomic.Int64 // 节点占用的内存
+}
+
+type skipNode struct {
+	key         []byte
+	pos         atomic.Pointer[data.LogRecordPos] // 为 nil 表示节点已经被删除
+	next        []atomic.Pointer[skipNode]
+	lock        sync.Mutex  // 修改 next 指针和标记删除时使用
+	marked      atomic.Bool // 已经被标记删除，正在或者已经从链表中移除
+	fullyLinked atomic.Bool // 所有层都已经链接完成
+}
+
+// NewSkipList 新建跳表索引
+func NewSkipList() *SkipList {
+	sl := &SkipList{
+		head: &skipNode{next: make([]atomic.Pointer[skipNode], skipListMaxLevel)},
+	}
+	sl.head.fullyLinked.Store(true)
+	sl.level.Store(1)
+	return sl
+}
+
+func (sl *SkipList) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	level := randomLevel()
+	var preds, succs [skipListMaxLevel]*skipNode
+	for {
+		if found := sl.findPreds(key, &preds, &succs); found >= 0 {
+			// key 已经存在时直接替换位置信息，不需要加锁
+			node := succs[found]
+			if !node.marked.Load() {
+				for !node.fullyLinked.Load() {
+					runtime.Gosched()
+				}
+				if oldPos, ok := node.swapPos(pos); ok {
+					return oldPos
+				}
+			}
+			// 节点正在被删除，等它移除之后重新插入
+			runtime.Gosched()
+			continue
+		}
+
+		// 锁住每一层的前驱节点，检查前驱节点没有被删除，并且仍然指向查找时的后继节点
+		highestLocked, valid := -1, true
+		for i := 0; valid && i < level; i++ {
+			pred, succ := preds[i], succs[i]
+			if i == 0 || pred != preds[i-1] {
+				pred.lock.Lock()
+				highestLocked = i
+			}
+			valid = !pred.marked.Load() && (succ == nil || !succ.marked.Load()) && pred.next[i].Load() == succ
+		}
+		if !valid {
+			unlockPreds(&preds, highestLocked)
+			continue
+		}
+
+		sl.raiseLevel(level)
+		newNode := &skipNode{key: key, next: make([]atomic.Pointer[skipNode], level)}
+		newNode.pos.Store(pos)
+		for i := 0; i < level; i++ {
+			newNode.next[i].Store(succs[i])
+		}
+		// 从下往上链接，读取的协程在任意时刻看到的都是一个有序的链表
+		for i := 0; i < level; i++ {
+			preds[i].next[i].Store(newNode)
+		}
+		newNode.fullyLinked.Store(true)
+		unlockPreds(&preds, highestLocked)
+		sl.size.Add(1)
+		sl.memSize.Add(newNode.memSize())
+		return nil
+	}
+}
+
+func (sl *SkipList) Get(key []byte) *data.LogRecordPos {
+	node := sl.findNode(key)
+	if node == nil || !node.fullyLinked.Load() {
+		return nil
+	}
+	return node.pos.Load()
+}
+
+func (sl *SkipList) Delete(key []byte) (*data.LogRecordPos, bool) {
+	var preds, succs [skipListMaxLevel]*skipNode
+	var victim *skipNode
+	var oldPos *data.LogRecordPos
+	for {
+		found := sl.findPreds(key, &preds, &succs)
+		if victim == nil {
+			if found < 0 {
+				return nil, false
+			}
+			node := succs[found]
+			// 只在节点所在的最高层找到它时删除，否则节点还没有链接完成或者已经被删除
+			if !node.fullyLinked.Load() || len(node.next)-1 != found || node.marked.Load() {
+				return nil, false
+			}
+			// 先标记删除，标记之后其他协程不会再修改这个节点
+			node.lock.Lock()
+			if node.marked.Load() {
+				node.lock.Unlock()
+				return nil, false
+			}
+			node.marked.Store(true)
+			oldPos = node.pos.Swap(nil)
+			victim = node
+		}
+
+		// 锁住每一层的前驱节点，检查前驱节点没有被删除，并且仍然指向被删除的节点
+		highestLocked, valid := -1, true
+		for i := 0; valid && i < len(victim.next); i++ {
+			pred := preds[i]
+			if i == 0 || pred != preds[i-1] {
+				pred.lock.Lock()
+				highestLocked = i
+			}
+			valid = !pred.marked.Load() && pred.next[i].Load() == victim
+		}
+		if !valid {
+			unlockPreds(&preds, highestLocked)
+			continue
+		}
+
+		// 从上往下移除节点，正在遍历这个节点的协程仍然可以通过它的 next 指针继续向后遍历
+		for i := len(victim.next) - 1; i >= 0; i-- {
+			preds[i].next[i].Store(victim.next[i].Load())
+		}
+		victim.lock.Unlock()
+		unlockPreds(&preds, highestLocked)
+		sl.size.Add(-1)
+		sl.memSize.Add(-victim.memSize())
+		return oldPos, true
+	}
+}
+
+func (sl *SkipList) ApplyBatch(ops []IndexOp) []*data.LogRecordPos {
+	return applyBatch(sl, ops)
+}
+
+func (sl *SkipList) Size() int {
+	return int(sl.size.Load())
+}
+
+// MemorySize 跳表节点占用的内存
+func (sl *SkipList) MemorySize() int64 {
+	return sl.memSize.Load()
+}
+
+// Iterator 跳表迭代器直接在跳表上遍历，创建时不复制数据
+// 遍历过程中其他协程的修改可能会被看到，也可能不会
+func (sl *SkipList) Iterator(reverse bool) Iterator {
+	it := &skipListIterator{list: sl, reverse: reverse}
+	it.Rewind()
+	return it
+}
+
+func (sl *SkipList) Close() error {
+	return nil
+}
+
+// findNode 查找 key 对应的节点，不加锁
+func (sl *SkipList) findNode(key []byte) *skipNode {
+	x := sl.head
+	for i := int(sl.level.Load()) - 1; i >= 0; i-- {
+		for next := x.next[i].Load(); next != nil; next = x.next[i].Load() {
+			cmp := bytes.Compare(next.key, key)
+			if cmp == 0 {
+				return next
+			}
+			if cmp > 0 {
+				break
+			}
+			x = next
+		}
+	}
+	return nil
+}
+
+// findPreds 查找每一层中最后一个小于 key 的节点和它的后继节点
+// 返回找到 key 对应节点的最高层，没有找到时返回 -1
+// 新节点的层数可能高于当前的最高层数，所以从最高的层开始查找
+func (sl *SkipList) findPreds(key []byte, preds, succs *[skipListMaxLevel]*skipNode) int {
+	found := -1
+	x := sl.head
+	for i := skipListMaxLevel - 1; i >= 0; i-- {
+		next := x.next[i].Load()
+		for next != nil && bytes.Compare(next.key, key) < 0 {
+			x = next
+			next = x.next[i].Load()
+		}
+		if found < 0 && next != nil && bytes.Equal(next.key, key) {
+			found = i
+		}
+		preds[i], succs[i] = x, next
+	}
+	return found
+}
+
+// raiseLevel 将最高层数提高到 level，需要在链接新节点之前调用，查找时才能从新节点所在的层开始
+func (sl *SkipList) raiseLevel(level int) {
+	for {
+		currLevel := sl.level.Load()
+		if int32(level) <= currLevel || sl.level.CompareAndSwap(currLevel, int32(level)) {
+			return
+		}
+	}
+}
+
+// unlockPreds 释放 0 到 highestLocked 层中加锁的前驱节点，相邻层相同的前驱节点只加锁了一次
+func unlockPreds(preds *[skipListMaxLevel]*skipNode, highestLocked int) {
+	for i := 0; i <= highestLocked; i++ {
+		if i == 0 || preds[i] != preds[i-1] {
+			preds[i].lock.Unlock()
+		}
+	}
+}
+
+// seekGE 第一个大于等于 key 的节点
+func (sl *SkipList) seekGE(key []byte) *skipNode {
+	x := sl.head
+	for i := int(sl.level.Load()) - 1; i >= 0; i-- {
+		for next := x.next[i].Load(); next != nil && bytes.Compare(next.key, key) < 0; next = x.next[i].Load() {
+			x = next
+		}
+	}
+	return x.next[0].Load()
+}
+
+// seekLE 最后一个小于等于 key 的节点，inclusive 为 false 时返回最后一个小于 key 的节点
+func (sl *SkipList) seekLE(key []byte, inclusive bool) *skipNode {
+	x := sl.head
+	for i := int(sl.level.Load()) - 1; i >= 0; i-- {
+		for next := x.next[i].Load(); next != nil; next = x.next[i].Load() {
+			cmp := bytes.Compare(next.key, key)
+			if cmp > 0 || (cmp == 0 && !inclusive) {
+				break
+			}
+			x = next
+		}
+	}
+	if x == sl.head {
+		return nil
+	}
+	return x
+}
+
+// seekLast 最后一个节点
+func (sl *SkipList) seekLast() *skipNode {
+	x := sl.head
+	for i := int(sl.level.Load()) - 1; i >= 0; i-- {
+		for next := x.next[i].Load(); next != nil; next = x.next[i].Load() {
+			x = next
+		}
+	}
+	if x == sl.head {
+		return nil
+	}
+	return x
+}
+
+// swapPos 替换位置信息，节点已经被删除时返回 false
+func (node *skipNode) swapPos(pos *data.LogRecordPos) (*data.LogRecordPos, bool) {
+	for {
+		oldPos := node.pos.Load()
+		if oldPos == nil {
+			return nil, false
+		}
+		if node.pos.CompareAndSwap(oldPos, pos) {
+			return oldPos, true
+		}
+	}
+}
+
+func (node *skipNode) memSize() int64 {
+	return int64(len(node.key)) + int64(unsafe.Sizeof(skipNode{})+unsafe.Sizeof(data.LogRecordPos{})) +
+		int64(len(node.next))*int64(unsafe.Sizeof(atomic.Pointer[skipNode]{}))
+}
+
+func randomLevel() int {
+	level := 1
+	for level < skipListMaxLevel && rand.Intn(skipListP) == 0 {
+		level++
+	}
+	return level
+}
+
+// skipListIterator 跳表索引迭代器，只保存当前遍历到的节点
+type skipListIterator struct {
+	list    *SkipList
+	reverse bool // 是否反向遍历
+	curr    *skipNode
+	pos     *data.LogRecordPos // 移动到当前节点时读取的位置信息
+}
+
+// Rewind 重新回到迭代器起点，即第一个数据
+func (it *skipListIterator) Rewind() {
+	if it.reverse {
+		it.curr = it.list.seekLast()
+	} else {
+		it.curr = it.list.head.next[0].Load()
+	}
+	it.skipDeleted()
+}
+
+// Seek 根据传入的key查找第一个大于/小于等于的目标key，根据这个key开始遍历
+func (it *skipListIterator) Seek(key []byte) {
+	if it.reverse {
+		it.curr = it.list.seekLE(key, true)
+	} else {
+		it.curr = it.list.seekGE(key)
+	}
+	it.skipDeleted()
+}
+
+// Next 跳转到下一个key
+func (it *skipListIterator) Next() {
+	it.step()
+	it.skipDeleted()
+}
+
+// Valid 是否有效，即是否已经遍历完了所有key，用于退出遍历
+func (it *skipListIterator) Valid() bool {
+	return it.curr != nil
+}
+
+// Key 当前遍历位置的key数据
+func (it *skipListIterator) Key() []byte {
+	return it.curr.key
+}
+
+// Value 当前遍历位置的value数据
+func (it *skipListIterator) Value() *data.LogRecordPos {
+	return it.pos
+}
+
+// Close 关闭迭代器，释放相应资源
+func (it *skipListIterator) Close() {
+	it.curr = nil
+	it.pos = nil
+}
+
+// 移动到相邻的节点，反向遍历时需要重新查找前一个节点
+func (it *skipListIterator) step() {
+	if it.reverse {
+		it.curr = it.list.seekLE(it.curr.key, false)
+	} else {
+		it.curr = it.curr.next[0].Load()
+	}
+}
+
+// 跳过已经被删除的节点
+func (it *skipListIterator) skipDeleted() {
+	for it.curr != nil {
+		if it.pos = it.curr.pos.Load(); it.pos != nil {
+			return
+		}
+		it.step()
+	}
+}
@@ -0,0 +1,215 @@
+package index
+
+import (
+	"SingleKVDataSet/data"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func TestSkipList_Put_Get_Delete(t *testing.T) {
+	sl := NewSkipList()
+
+	res1 := sl.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100})
+	assert.Nil(t, res1)
+	assert.Equal(t, int64(100), sl.Get(nil).Offset)
+
+	res2 := sl.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 2})
+	assert.Nil(t, res2)
+	res3 := sl.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 3})
+	assert.Equal(t, int64(2), res3.Offset)
+	assert.Equal(t, int64(3), sl.Get([]byte("a")).Offset)
+	assert.Nil(t, sl.Get([]byte("not exist")))
+	assert.Equal(t, 2, sl.Size())
+
+	res4, ok := sl.Delete(nil)
+	assert.True(t, ok)
+	assert.Equal(t, int64(100), res4.Offset)
+	res5, ok := sl.Delete([]byte("not exist"))
+	assert.False(t, ok)
+	assert.Nil(t, res5)
+	assert.Nil(t, sl.Get(nil))
+	assert.Equal(t, 1, sl.Size())
+
+	// 删除后重新插入
+	res6 := sl.Put(nil, &data.LogRecordPos{Fid: 2, Offset: 200})
+	assert.Nil(t, res6)
+	assert.Equal(t, int64(200), sl.Get(nil).Offset)
+}
+
+func TestSkipList_Iterator(t *testing.T) {
+	sl := NewSkipList()
+
+	// 没有数据
+	iter1 := sl.Iterator(false)
+	assert.False(t, iter1.Valid())
+	iter1.Close()
+
+	for i := 0; i < 100; i++ {
+		sl.Put([]byte(fmt.Sprintf("key-%03d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	// 正向遍历
+	iter2 := sl.Iterator(false)
+	var i int
+	for iter2.Rewind(); iter2.Valid(); iter2.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%03d", i)), iter2.Key())
+		assert.Equal(t, int64(i), iter2.Value().Offset)
+		i++
+	}
+	assert.Equal(t, 100, i)
+	iter2.Seek([]byte("key-050"))
+	assert.Equal(t, []byte("key-050"), iter2.Key())
+	iter2.Seek([]byte("key-0505"))
+	assert.Equal(t, []byte("key-051"), iter2.Key())
+	iter2.Seek([]byte("zz"))
+	assert.False(t, iter2.Valid())
+	iter2.Close()
+
+	// 反向遍历
+	iter3 := sl.Iterator(true)
+	i = 99
+	for iter3.Rewind(); iter3.Valid(); iter3.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%03d", i)), iter3.Key())
+		i--
+	}
+	assert.Equal(t, -1, i)
+	iter3.Seek([]byte("key-0505"))
+	assert.Equal(t, []byte("key-050"), iter3.Key())
+	iter3.Seek([]byte("a"))
+	assert.False(t, iter3.Valid())
+	iter3.Close()
+}
+
+func TestSkipList_Iterator_Modify(t *testing.T) {
+	sl := NewSkipList()
+	for i := 0; i < 10; i++ {
+		sl.Put([]byte(fmt.Sprintf("key-%03d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	// 迭代器不复制数据，遍历过程中删除的 key 不会被遍历到
+	iter := sl.Iterator(false)
+	assert.Equal(t, []byte("key-000"), iter.Key())
+	sl.Delete([]byte("key-001"))
+	sl.Delete([]byte("key-002"))
+	iter.Next()
+	assert.Equal(t, []byte("key-003"), iter.Key())
+
+	// 当前节点被删除后仍然可以继续向后遍历
+	sl.Delete([]byte("key-003"))
+	iter.Next()
+	assert.Equal(t, []byte("key-004"), iter.Key())
+	iter.Close()
+
+	// 反向遍历时当前节点被删除
+	iter2 := sl.Iterator(true)
+	assert.Equal(t, []byte("key-009"), iter2.Key())
+	sl.Delete([]byte("key-009"))
+	sl.Delete([]byte("key-008"))
+	iter2.Next()
+	assert.Equal(t, []byte("key-007"), iter2.Key())
+	iter2.Close()
+}
+
+func TestSkipList_Concurrent(t *testing.T) {
+	sl := NewSkipList()
+
+	wg := new(sync.WaitGroup)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := []byte(fmt.Sprintf("key-%d-%d", g, i))
+				sl.Put(key, &data.LogRecordPos{Fid: uint32(g), Offset: int64(i)})
+				assert.NotNil(t, sl.Get(key))
+				sl.Put(key, &data.LogRecordPos{Fid: uint32(g), Offset: int64(i + 1)})
+				if i%2 == 0 {
+					_, ok := sl.Delete(key)
+					assert.True(t, ok)
+				}
+			}
+		}(g)
+	}
+	// 写入的同时遍历，遍历结果始终有序
+	for r := 0; r < 2; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 20; n++ {
+				iter := sl.Iterator(n%2 == 1)
+				var prev []byte
+				for ; iter.Valid(); iter.Next() {
+					if prev != nil {
+						if n%2 == 1 {
+							assert.True(t, string(prev) > string(iter.Key()))
+						} else {
+							assert.True(t, string(prev) < string(iter.Key()))
+						}
+					}
+					assert.NotNil(t, iter.Value())
+					prev = iter.Key()
+				}
+				iter.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 8*500, sl.Size())
+}
+
+func TestSkipList_Concurrent_SameKeys(t *testing.T) {
+	sl := NewSkipList()
+
+	// 多个协程同时插入和删除相同的 key，相邻的节点会被并发地修改
+	wg := new(sync.WaitGroup)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 5000; i++ {
+				key := []byte(fmt.Sprintf("key-%03d", (i*7+g)%200))
+				if (i+g)%3 == 0 {
+					sl.Delete(key)
+				} else {
+					sl.Put(key, &data.LogRecordPos{Fid: uint32(g), Offset: int64(i)})
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	// 每一层都有序，并且每一层的节点都在最底层中
+	inBottom := make(map[*skipNode]bool)
+	var size int
+	for x := sl.head.next[0].Load(); x != nil; x = x.next[0].Load() {
+		assert.False(t, x.marked.Load())
+		assert.NotNil(t, x.pos.Load())
+		assert.Equal(t, x, sl.findNode(x.key))
+		inBottom[x] = true
+		size++
+	}
+	assert.Equal(t, sl.Size(), size)
+	for i := 0; i < skipListMaxLevel; i++ {
+		var prev *skipNode
+		for x := sl.head.next[i].Load(); x != nil; x = x.next[i].Load() {
+			assert.True(t, inBottom[x])
+			if prev != nil {
+				assert.True(t, string(prev.key) < string(x.key))
+			}
+			prev = x
+		}
+	}
+
+	// 结束之后可以继续插入和删除所有的 key
+	for i := 0; i < 200; i++ {
+		sl.Put([]byte(fmt.Sprintf("key-%03d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	assert.Equal(t, 200, sl.Size())
+	for i := 0; i < 200; i++ {
+		_, ok := sl.Delete([]byte(fmt.Sprintf("key-%03d", i)))
+		assert.True(t, ok)
+	}
+	assert.Equal(t, 0, sl.Size())
+}
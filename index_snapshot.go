@@ -0,0 +1,300 @@
+package SingleKVDataSet
+
+import (
+	"SingleKVDataSet/data"
+	"SingleKVDataSet/index"
+	"encoding/binary"
+	"io"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	indexSnapshotKey = "index.snapshot"
+
+	// 写入快照时的缓冲区大小，缓冲区满了再写到文件中
+	indexSnapshotBufferSize = 1 << 20
+)
+
+// indexCheckpoint 索引快照的元数据，保存在快照文件的最后一条记录中
+// 快照包含数据文件中 (fid, offset) 之前所有记录的索引，启动时只需要重放之后的记录
+// 索引迭代器不一定是快照，遍历时可能看到 (fid, offset) 之后的更新，重放时会被再次应用，结果相同
+type indexCheckpoint struct {
+	fid         uint32
+	offset      int64
+	seqNo       uint64
+	reclaimSize int64
+	count       int64 // 快照中索引的数量，用于判断快照是否完整
+}
+
+func encodeIndexCheckpoint(cp *indexCheckpoint) []byte {
+	buf := make([]byte, binary.MaxVarintLen32+binary.MaxVarintLen64*4)
+	var index = 0
+	index += binary.PutVarint(buf[index:], int64(cp.fid))
+	index += binary.PutVarint(buf[index:], cp.offset)
+	index += binary.PutUvarint(buf[index:], cp.seqNo)
+	index += binary.PutVarint(buf[index:], cp.reclaimSize)
+	index += binary.PutVarint(buf[index:], cp.count)
+	return buf[:index]
+}
+
+func decodeIndexCheckpoint(buf []byte) *indexCheckpoint {
+	var index = 0
+	fid, n := binary.Varint(buf[index:])
+	if n <= 0 {
+		return nil
+	}
+	index += n
+	offset, n := binary.Varint(buf[index:])
+	if n <= 0 {
+		return nil
+	}
+	index += n
+	seqNo, n := binary.Uvarint(buf[index:])
+	if n <= 0 {
+		return nil
+	}
+	index += n
+	reclaimSize, n := binary.Varint(buf[index:])
+	if n <= 0 {
+		return nil
+	}
+	index += n
+	count, n := binary.Varint(buf[index:])
+	if n <= 0 {
+		return nil
+	}
+	return &indexCheckpoint{
+		fid:         uint32(fid),
+		offset:      offset,
+		seqNo:       seqNo,
+		reclaimSize: reclaimSize,
+		count:       count,
+	}
+}
+
+// Checkpoint 将内存索引保存到快照文件中，下次启动时从快照加载索引，只重放快照之后写入的数据
+// B+ 树索引本身存储在磁盘上，不需要快照
+func (db *DB) Checkpoint() error {
+	if db.options.IndexType == BPlusTree {
+		return nil
+	}
+	db.snapshotMu.Lock()
+	defer db.snapshotMu.Unlock()
+
+	// 持有读锁时没有新的写入，等待正在进行的索引更新完成后，快照的位置和索引迭代器中的数据是一致的
+	db.mu.RLock()
+	if db.activeFile == nil {
+		db.mu.RUnlock()
+		return nil
+	}
+	// 快照位置之前的数据必须已经持久化，否则断电后快照会指向不存在的数据
+	if err := db.activeFile.Sync(); err != nil {
+		db.mu.RUnlock()
+		return err
+	}
+	db.lockAllKeys()
+	cp := &indexCheckpoint{
+		fid:         db.activeFile.FileId,
+		offset:      db.activeFile.WriteOff,
+		seqNo:       atomic.LoadUint64(&db.seqNo),
+		reclaimSize: atomic.LoadInt64(&db.reclaimSize),
+	}
+	iterator := db.index.Iterator(false)
+	db.unlockAllKeys()
+	db.mu.RUnlock()
+	defer iterator.Close()
+
+	// 上次快照之后没有新的写入
+	if last := db.lastCheckpoint; last != nil && last.fid == cp.fid && last.offset == cp.offset {
+		return nil
+	}
+
+	// 先写到临时文件中，写完后再重命名，写入过程中崩溃不会破坏之前的快照
+	tempFileName := filepath.Join(db.options.DirPath, data.IndexSnapshotTempName)
+	if db.fileExists(tempFileName) {
+		if err := db.removeFile(tempFileName); err != nil {
+			return err
+		}
+	}
+	tempFile, err := data.OpenIndexSnapshotTempFile(db.options.DirPath, db.ioConfig())
+	if err != nil {
+		return err
+	}
+	if err := db.writeIndexSnapshot(tempFile, cp, iterator); err != nil {
+		_ = tempFile.Close()
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+	// 遍历过程中可能读到快照位置之后写入的索引，这些索引指向的数据也必须已经持久化
+	if err := db.Sync(); err != nil {
+		return err
+	}
+	snapshotFileName := filepath.Join(db.options.DirPath, data.IndexSnapshotFileName)
+	if err := db.renameFile(tempFileName, snapshotFileName); err != nil {
+		return err
+	}
+	db.lastCheckpoint = cp
+	return nil
+}
+
+func (db *DB) writeIndexSnapshot(snapshotFile *data.DataFile, cp *indexCheckpoint, iterator index.Iterator) error {
+	buf := make([]byte, 0, indexSnapshotBufferSize)
+	cp.count = 0
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+			Key:   iterator.Key(),
+			Value: data.EncodeLogRecordPos(iterator.Value()),
+		})
+		buf = append(buf, encRecord...)
+		cp.count++
+		if len(buf) >= indexSnapshotBufferSize {
+			if err := snapshotFile.Write(buf); err != nil {
+				return err
+			}
+			buf = buf[:0]
+		}
+	}
+
+	// 元数据写在最后，遍历完成后才知道索引的数量，也可以用来判断快照是否完整
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+		Key:   []byte(indexSnapshotKey),
+		Value: encodeIndexCheckpoint(cp),
+	})
+	buf = append(buf, encRecord...)
+	if err := snapshotFile.Write(buf); err != nil {
+		return err
+	}
+	return snapshotFile.Sync()
+}
+
+// loadIndexFromSnapshot 从索引快照中加载索引
+// 快照不存在、不完整或者已经过期时返回 nil，此时需要从 hint 文件和数据文件中加载全部索引
+func (db *DB) loadIndexFromSnapshot() (*indexCheckpoint, error) {
+	snapshotFileName := filepath.Join(db.options.DirPath, data.IndexSnapshotFileName)
+	if !db.fileExists(snapshotFileName) {
+		return nil, nil
+	}
+	snapshotFile, err := data.OpenIndexSnapshotFile(db.options.DirPath, db.ioConfig())
+	if err != nil {
+		return nil, err
+	}
+	defer snapshotFile.Close()
+
+	// 先读取全部的记录并校验，快照完整时才更新到内存索引中
+	var keys [][]byte
+	var positions []*data.LogRecordPos
+	var last *data.LogRecord
+	var offset int64 = 0
+	for {
+		record, size, err := snapshotFile.ReadLogRecord(offset)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil
+		}
+		if last != nil {
+			keys = append(keys, last.Key)
+			positions = append(positions, data.DecodeLogRecordPos(last.Value))
+		}
+		last = record
+		offset += size
+	}
+
+	// 最后一条记录是快照的元数据
+	if last == nil || string(last.Key) != indexSnapshotKey {
+		return nil, nil
+	}
+	cp := decodeIndexCheckpoint(last.Value)
+	if cp == nil || cp.count != int64(len(keys)) {
+		return nil, nil
+	}
+	valid, err := db.checkpointIsValid(cp)
+	if err != nil || !valid {
+		return nil, err
+	}
+
+	ops := make([]index.IndexOp, 0, indexBatchSize)
+	for i, key := range keys {
+		ops = append(ops, index.IndexOp{Key: key, Pos: positions[i]})
+		if len(ops) >= indexBatchSize {
+			db.index.ApplyBatch(ops)
+			ops = ops[:0]
+		}
+	}
+	db.index.ApplyBatch(ops)
+	db.reclaimSize = cp.reclaimSize
+	db.lastCheckpoint = cp
+	return cp, nil
+}
+
+// checkpointIsValid 快照对应的数据文件必须存在，并且包含快照位置之前的全部数据
+// 预分配的文件物理大小总是 DataFileSize，被截断后重新分配的文件大小也不会变，需要扫描出最后一条有效记录的结尾
+func (db *DB) checkpointIsValid(cp *indexCheckpoint) (bool, error) {
+	var exists bool
+	for _, fid := range db.fileIds {
+		if uint32(fid) == cp.fid {
+			exists = true
+			break
+		}
+	}
+	if !exists || cp.offset < 0 {
+		return false, nil
+	}
+
+	dataFile, err := db.acquireDataFile(cp.fid)
+	if err != nil {
+		return false, err
+	}
+	writeOff, err := dataFile.ScanWriteOff()
+	if releaseErr := db.releaseDataFile(dataFile); err == nil {
+		err = releaseErr
+	}
+	if err != nil {
+		return false, err
+	}
+	return writeOff >= cp.offset, nil
+}
+
+// removeIndexSnapshot 删除索引快照，数据文件被 merge 替换之后快照中的位置信息全部失效
+func (db *DB) removeIndexSnapshot() error {
+	snapshotFileName := filepath.Join(db.options.DirPath, data.IndexSnapshotFileName)
+	if !db.fileExists(snapshotFileName) {
+		return nil
+	}
+	return db.removeFile(snapshotFileName)
+}
+
+// startCheckpointLoop 定期保存索引快照，关闭数据库时停止
+func (db *DB) startCheckpointLoop(interval time.Duration) {
+	db.checkpointStop = make(chan struct{})
+	db.checkpointDone = make(chan struct{})
+	go func() {
+		defer close(db.checkpointDone)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				// 保存失败时不影响正常读写，下次启动时会从数据文件中加载索引
+				_ = db.Checkpoint()
+			case <-db.checkpointStop:
+				return
+			}
+		}
+	}()
+}
+
+// stopCheckpointLoop 停止定期保存索引快照，并等待正在进行的保存完成
+func (db *DB) stopCheckpointLoop() {
+	if db.checkpointStop == nil {
+		return
+	}
+	close(db.checkpointStop)
+	<-db.checkpointDone
+	db.checkpointStop = nil
+}
@@ -0,0 +1,214 @@
+package SingleKVDataSet
+
+import (
+	"SingleKVDataSet/data"
+	"SingleKVDataSet/fio"
+	"SingleKVDataSet/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDB_IndexSnapshot(t *testing.T) {
+	opts := DefaultOptions
+	dir := t.TempDir()
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.IndexSnapshot = true
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	for i := 0; i < 2000; i += 3 {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	reclaimSize := db.Stat().ReclaimableSize
+
+	// 关闭时保存快照，重启后从快照加载索引
+	assert.Nil(t, db.Close())
+	assert.FileExists(t, filepath.Join(dir, data.IndexSnapshotFileName))
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.NotNil(t, db2.lastCheckpoint)
+	assert.Equal(t, reclaimSize, db2.Stat().ReclaimableSize)
+	for i := 0; i < 2000; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		if i%3 == 0 {
+			assert.Equal(t, ErrKeyNotFound, err)
+		} else {
+			assert.Nil(t, err)
+			assert.Equal(t, utils.GetTestKey(i), val)
+		}
+	}
+}
+
+func TestDB_IndexSnapshot_Replay(t *testing.T) {
+	opts := memoryTestOptions(t, "bitcask-go-snapshot-replay")
+	opts.DataFileSize = 64 * 1024
+	opts.IndexSnapshot = true
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Checkpoint())
+
+	// 快照之后写入的数据，包括删除和事务，需要在启动时重放
+	for i := 0; i < 1000; i += 2 {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	for i := 1000; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(2000), utils.GetTestKey(2000)))
+	assert.Nil(t, wb.Delete(utils.GetTestKey(1001)))
+	assert.Nil(t, wb.Commit())
+	seqNo := db.seqNo
+
+	// 不关闭数据库，模拟进程崩溃后重启
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db2.lastCheckpoint)
+	assert.Equal(t, seqNo, db2.seqNo)
+	for i := 0; i <= 2000; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		if (i < 1000 && i%2 == 0) || i == 1001 {
+			assert.Equal(t, ErrKeyNotFound, err)
+		} else {
+			assert.Nil(t, err)
+			assert.Equal(t, utils.GetTestKey(i), val)
+		}
+	}
+	assert.Nil(t, db2.Close())
+}
+
+func TestDB_IndexSnapshot_Torn(t *testing.T) {
+	opts := DefaultOptions
+	dir := t.TempDir()
+	opts.DirPath = dir
+	opts.IndexSnapshot = true
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Close())
+
+	// 快照只写了一部分，启动时忽略快照，从数据文件中加载全部索引
+	snapshotFileName := filepath.Join(dir, data.IndexSnapshotFileName)
+	info, err := os.Stat(snapshotFileName)
+	assert.Nil(t, err)
+	assert.Nil(t, os.Truncate(snapshotFileName, info.Size()/2))
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Nil(t, db2.lastCheckpoint)
+	assert.Equal(t, 1000, len(db2.ListKeys()))
+	for i := 0; i < 1000; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+}
+
+func TestDB_IndexSnapshot_Stale(t *testing.T) {
+	opts := memoryTestOptions(t, "bitcask-go-snapshot-stale")
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.IndexSnapshot = true
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Checkpoint())
+
+	// merge 之后数据文件被替换，重启时需要丢弃快照
+	for i := 0; i < 1000; i += 2 {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Merge())
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db2.lastCheckpoint)
+	assert.Equal(t, 500, len(db2.ListKeys()))
+	for i := 1; i < 1000; i += 2 {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+	assert.Nil(t, db2.Close())
+
+	// 快照对应的数据文件不存在
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db3.lastCheckpoint)
+	fileName := data.GetDataFileName(opts.DirPath, db3.lastCheckpoint.fid)
+	assert.Nil(t, db3.Close())
+	fio.RemoveMemoryFile(fileName)
+	db4, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db4.lastCheckpoint)
+	assert.Nil(t, db4.Close())
+}
+
+func TestDB_IndexSnapshot_Preallocated(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = t.TempDir()
+	opts.DataFileSize = 1024 * 1024
+	opts.PreallocateDataFile = true
+	opts.IndexSnapshot = true
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Close())
+	cp := db.lastCheckpoint
+	assert.NotNil(t, cp)
+
+	// 数据文件被截断后重新分配，物理大小不变，但快照位置之前的数据已经不存在
+	fileName := data.GetDataFileName(opts.DirPath, cp.fid)
+	assert.Nil(t, os.Truncate(fileName, cp.offset/2))
+	assert.Nil(t, os.Truncate(fileName, opts.DataFileSize))
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db2)
+	assert.Nil(t, db2.lastCheckpoint)
+	keys := db2.ListKeys()
+	assert.True(t, len(keys) > 0 && len(keys) < 1000)
+	for _, key := range keys {
+		val, err := db2.Get(key)
+		assert.Nil(t, err)
+		assert.Equal(t, key, val)
+	}
+}
+
+func TestDB_IndexSnapshot_Interval(t *testing.T) {
+	opts := DefaultOptions
+	dir := t.TempDir()
+	opts.DirPath = dir
+	opts.IndexSnapshot = true
+	opts.IndexSnapshotInterval = 10 * time.Millisecond
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.Put(utils.GetTestKey(1), utils.GetTestKey(1)))
+	snapshotFileName := filepath.Join(dir, data.IndexSnapshotFileName)
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(snapshotFileName)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+}
@@ -2,13 +2,14 @@ package SingleKVDataSet
 
 import (
 	"SingleKVDataSet/data"
+	"SingleKVDataSet/index"
 	"SingleKVDataSet/utils"
 	"io"
-	"os"
 	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
+	"sync/atomic"
 )
 
 const (
@@ -30,26 +31,30 @@ func (db *DB) Merge() error {
 	}
 
 	// 查看可以merge的数据量是否达到了阈值
-	totalSize, err := utils.DirSize(db.options.DirPath)
+	// 预分配的空间不是数据，不能计入总大小，否则比例会偏小
+	totalSize, err := db.dataSize()
 	if err != nil {
 		db.mu.Unlock()
 		return err
 	}
-	if float32(db.reclaimSize)/float32(totalSize) < db.options.DataFileMergeRatio {
+	reclaimSize := atomic.LoadInt64(&db.reclaimSize)
+	if float32(reclaimSize)/float32(totalSize) < db.options.DataFileMergeRatio {
 		db.mu.Unlock()
 		return ErrMergeRatioUnreached
 	}
 
-	// 查看剩余的空间容量是否可以容纳 merge 之后的数据量
-	availableDiskSize, err := utils.AvailableDiskSize()
-	if err != nil {
-		db.mu.Unlock()
-		return err
-	}
+	// 查看剩余的空间容量是否可以容纳 merge 之后的数据量，内存模式下不需要检查磁盘
+	if !db.options.InMemory {
+		availableDiskSize, err := utils.AvailableDiskSize()
+		if err != nil {
+			db.mu.Unlock()
+			return err
+		}
 
-	if uint64(totalSize-db.reclaimSize) >= availableDiskSize {
-		db.mu.Unlock()
-		return ErrNoEnoughSpaceForMerge
+		if uint64(totalSize-reclaimSize) >= availableDiskSize {
+			db.mu.Unlock()
+			return ErrNoEnoughSpaceForMerge
+		}
 	}
 
 	db.isMerging = true
@@ -62,8 +67,18 @@ func (db *DB) Merge() error {
 		db.mu.Unlock()
 		return err
 	}
+	if err := db.sealActiveDataFile(); err != nil {
+		db.mu.Unlock()
+		return err
+	}
 	// 将当前活跃文件，转换为一个旧的数据文件
 	db.oldFiles[db.activeFile.FileId] = db.activeFile
+	if db.fileLRU != nil {
+		if err := db.fileLRU.add(db.activeFile); err != nil {
+			db.mu.Unlock()
+			return err
+		}
+	}
 	// 打开新的活跃文件
 	if err := db.setActiveDataFile(); err != nil {
 		db.mu.Unlock()
@@ -87,33 +102,45 @@ func (db *DB) Merge() error {
 
 	mergePath := db.getMergePath()
 	// 如果目录存在，说明发生过merge，将其删除
-	if _, err := os.Stat(mergePath); os.IsNotExist(err) {
-		if err := os.RemoveAll(mergeDirName); err != nil {
+	if db.dirExists(mergePath) {
+		if err := db.removeDir(mergePath); err != nil {
 			return err
 		}
 	}
-	// 新建一个 merge path 的目录
-	if err := os.MkdirAll(mergeDirName, os.ModePerm); err != nil {
-		return err
-	}
 
 	// 打开一个新的临时bitcask实例
 	mergeOptions := db.options
 	mergeOptions.DirPath = mergePath
 	mergeOptions.SyncWrites = false
+	mergeOptions.CacheSize = 0
+	mergeOptions.IndexSnapshot = false
+	mergeOptions.IndexSnapshotInterval = 0
 	mergeDB, err := Open(mergeOptions)
 	if err != nil {
 		return err
 	}
+	mergeDB.isMergeInstance = true
+	// merge 实例的索引只在重写数据时使用，出错返回时也要关闭，删除混合索引的磁盘文件
+	mergeDBClosed := false
+	defer func() {
+		if !mergeDBClosed {
+			_ = mergeDB.Close()
+		}
+	}()
 
 	// 打开hint文件存储索引
-	hintFile, err := data.OpenHintFile(mergePath)
+	hintFile, err := data.OpenHintFile(mergePath, db.ioConfig())
 	if err != nil {
 		return err
 	}
 
 	// 遍历处理每个数据文件
 	for _, dataFile := range mergeFiles {
+		if db.fileLRU != nil {
+			if err := db.fileLRU.acquire(dataFile); err != nil {
+				return err
+			}
+		}
 		var offset int64 = 0
 		for {
 			logRecord, size, err := dataFile.ReadLogRecord(offset)
@@ -121,6 +148,7 @@ func (db *DB) Merge() error {
 				if err == io.EOF {
 					break
 				}
+				_ = db.releaseDataFile(dataFile)
 				return err
 			}
 			// 解析拿到的实际的key
@@ -132,27 +160,43 @@ func (db *DB) Merge() error {
 				logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
 				pos, err := mergeDB.appendLogRecord(logRecord)
 				if err != nil {
+					_ = db.releaseDataFile(dataFile)
 					return err
 				}
 				// 将当前位置信息写到hint文件当中
 				if err := hintFile.WriteHintRecord(realKey, pos); err != nil {
+					_ = db.releaseDataFile(dataFile)
 					return err
 				}
 			}
 			offset += size
 		}
+		if err := db.releaseDataFile(dataFile); err != nil {
+			return err
+		}
 	}
 
 	// sync 保证持久化
 	if err := hintFile.Sync(); err != nil {
 		return err
 	}
+	if err := mergeDB.sealActiveDataFile(); err != nil {
+		return err
+	}
 	if err := mergeDB.Sync(); err != nil {
 		return err
 	}
+	mergeDBClosed = true
+	if err := mergeDB.Close(); err != nil {
+		return err
+	}
+	// 读取索引出错时，有效的数据可能被当作无效数据丢弃，不能完成这次 merge
+	if err := db.indexErr(); err != nil {
+		return err
+	}
 
 	// 写标识 merge 完成的文件
-	mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath)
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath, db.ioConfig())
 	if err != nil {
 		return err
 	}
@@ -181,14 +225,14 @@ func (db *DB) getMergePath() string {
 func (db *DB) loadMergeFiles() error {
 	mergePath := db.getMergePath()
 	// merge目录不存在的话直接返回
-	if _, err := os.Stat(mergePath); os.IsNotExist(err) {
+	if !db.dirExists(mergePath) {
 		return nil
 	}
 	defer func() {
-		_ = os.RemoveAll(mergePath)
+		_ = db.removeDir(mergePath)
 	}()
 
-	dirEntries, err := os.ReadDir(mergePath)
+	fileNames, err := db.readDirNames(mergePath)
 	if err != nil {
 		return err
 	}
@@ -196,17 +240,21 @@ func (db *DB) loadMergeFiles() error {
 	// 查找标识 merge 完成的文件，判断 merge 是否处理完成了
 	var mergeFinished bool
 	var mergeFileNames []string
-	for _, entry := range dirEntries {
-		if entry.Name() == data.MergeFinishedFileName {
+	for _, fileName := range fileNames {
+		if fileName == data.MergeFinishedFileName {
 			mergeFinished = true
 		}
-		if entry.Name() == data.SeqNoFileName {
+		if fileName == data.SeqNoFileName {
+			continue
+		}
+		if fileName == fileLockName {
 			continue
 		}
-		if entry.Name() == fileLockName {
+		// merge 实例使用混合索引时的磁盘文件，不能覆盖当前数据库的索引文件
+		if fileName == index.HybridIndexFileName {
 			continue
 		}
-		mergeFileNames = append(mergeFileNames, entry.Name())
+		mergeFileNames = append(mergeFileNames, fileName)
 	}
 
 	// 没有 merge 完成则直接返回
@@ -218,22 +266,31 @@ func (db *DB) loadMergeFiles() error {
 		return nil
 	}
 
+	// 数据文件将被替换，索引快照中的位置信息全部失效，需要在删除数据文件之前删除快照
+	if err := db.removeIndexSnapshot(); err != nil {
+		return err
+	}
+
 	// 删除旧的数据文件
 	var fileId uint32 = 0
 	for ; fileId < nonMergeFileId; fileId++ {
 		fileName := data.GetDataFileName(db.options.DirPath, fileId)
-		if _, err := os.Stat(fileName); err == nil {
-			if err := os.Remove(fileName); err != nil {
+		if db.fileExists(fileName) {
+			if err := db.removeFile(fileName); err != nil {
 				return err
 			}
 		}
+		// 数据文件对应的 hint 文件同样失效了
+		if err := db.removeHintFile(fileId); err != nil {
+			return err
+		}
 	}
 
 	// 将新的数据文件移动到数据目录中
 	for _, fileName := range mergeFileNames {
 		srcPath := filepath.Join(mergePath, fileName)
 		destPath := filepath.Join(db.options.DirPath, fileName)
-		if err := os.Rename(srcPath, destPath); err != nil {
+		if err := db.renameFile(srcPath, destPath); err != nil {
 			return err
 		}
 	}
@@ -242,7 +299,7 @@ func (db *DB) loadMergeFiles() error {
 
 // getNonMergeFileId 取到最近没有参与merge的文件id
 func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
-	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath)
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath, db.ioConfig())
 	if err != nil {
 		return 0, err
 	}
@@ -250,7 +307,7 @@ func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
 	if err != nil {
 		return 0, err
 	}
-	nonMergeFileId, err := strconv.Atoi(string(record.Key))
+	nonMergeFileId, err := strconv.Atoi(string(record.Value))
 	if err != nil {
 		return 0, err
 	}
@@ -261,17 +318,18 @@ func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
 func (db *DB) loadIndexFromHintFile() error {
 	// 查看hint文件是否存在
 	hintFileName := filepath.Join(db.options.DirPath, data.HintFileName)
-	if _, err := os.Stat(hintFileName); os.IsNotExist(err) {
+	if !db.fileExists(hintFileName) {
 		return nil
 	}
 
 	// 打开hint索引文件
-	hintFile, err := data.OpenHintFile(db.options.DirPath)
+	hintFile, err := data.OpenHintFile(db.options.DirPath, db.ioConfig())
 	if err != nil {
 		return err
 	}
 
 	var offset int64 = 0
+	var ops []index.IndexOp
 	for {
 		logRecord, size, err := hintFile.ReadLogRecord(offset)
 		if err != nil {
@@ -283,8 +341,13 @@ func (db *DB) loadIndexFromHintFile() error {
 
 		// 解码拿到实际的位置索引
 		pos := data.DecodeLogRecordPos(logRecord.Value)
-		db.index.Put(logRecord.Key, pos)
+		ops = append(ops, index.IndexOp{Key: logRecord.Key, Pos: pos})
+		if len(ops) >= indexBatchSize {
+			db.index.ApplyBatch(ops)
+			ops = ops[:0]
+		}
 		offset += size
 	}
+	db.index.ApplyBatch(ops)
 	return nil
 }
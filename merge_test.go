@@ -1,9 +1,11 @@
 package SingleKVDataSet
 
 import (
+	"SingleKVDataSet/index"
 	"SingleKVDataSet/utils"
 	"github.com/stretchr/testify/assert"
 	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 )
@@ -217,3 +219,181 @@ func TestDB_Merge_Insert_Delete(t *testing.T) {
 		assert.NotNil(t, val)
 	}
 }
+
+// 预分配数据文件时，merge 的比例按实际数据计算，不包含预分配的空间
+func TestDB_Merge_Preallocate(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("./TestingFile", "bitcask-go-merge-prealloc")
+	opts.DirPath = dir
+	opts.DataFileSize = 16 * 1024 * 1024
+	opts.DataFileMergeRatio = 0.3
+	opts.PreallocateDataFile = true
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 10000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 5000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	size, err := db.dataSize()
+	assert.Nil(t, err)
+	assert.True(t, size < opts.DataFileSize)
+
+	err = db.Merge()
+	assert.Nil(t, err)
+	// 写满的活跃文件被截断到实际数据的结尾
+	for _, dataFile := range db.oldFiles {
+		if !dataFile.IsOpen() {
+			continue
+		}
+		allocated, err := dataFile.IoManager.Size()
+		assert.Nil(t, err)
+		assert.Equal(t, dataFile.WriteOff, allocated)
+	}
+
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer destoryMergePath(db2)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 5000, len(db2.ListKeys()))
+	for i := 5000; i < 10000; i++ {
+		_, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+}
+
+// merge 完成的标识中记录的是最近没有参与 merge 的文件 id，重启后据此删除旧的数据文件
+func TestDB_Merge_NonMergeFileId(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("./TestingFile", "bitcask-go-merge-fid")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i%100), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	assert.True(t, db.activeFile.FileId > 1)
+
+	err = db.Merge()
+	assert.Nil(t, err)
+	nonMergeFileId, err := db.getNonMergeFileId(db.getMergePath())
+	assert.Nil(t, err)
+	assert.Equal(t, db.activeFile.FileId, nonMergeFileId)
+
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer destoryMergePath(db2)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	// 参与 merge 的数据文件被 merge 之后的文件替换，只剩下 100 条记录
+	assert.True(t, db2.activeFile.FileId <= nonMergeFileId)
+	assert.Equal(t, 100, len(db2.ListKeys()))
+	size, err := db2.dataSize()
+	assert.Nil(t, err)
+	assert.True(t, size < 2*opts.DataFileSize)
+}
+
+// 使用混合索引时，merge 实例的索引文件不会覆盖当前数据库的索引文件
+func TestDB_Merge_HybridIndex(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = t.TempDir()
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.IndexType = HybridIndex
+	opts.IndexMemoryLimit = 32 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 3000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	for i := 0; i < 3000; i += 2 {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Merge())
+	// merge 实例已经关闭，它的索引文件随之删除
+	mergeIndexFile := filepath.Join(db.getMergePath(), index.HybridIndexFileName)
+	assert.NoFileExists(t, mergeIndexFile)
+
+	// merge 目录中遗留的索引文件不会被移动到数据目录中
+	junk := []byte("stale hybrid index")
+	assert.Nil(t, os.WriteFile(mergeIndexFile, junk, 0644))
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	content, err := os.ReadFile(filepath.Join(opts.DirPath, index.HybridIndexFileName))
+	assert.Nil(t, err)
+	assert.NotEqual(t, junk, content)
+
+	assert.Equal(t, 1500, len(db2.ListKeys()))
+	for i := 1; i < 3000; i += 2 {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+	assert.Nil(t, db2.indexErr())
+}
+
+// merge 前后通过读缓存读取的数据一致，重新打开替换数据文件之后也一致
+func TestDB_Merge_Cache(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = t.TempDir()
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.CacheSize = 1024 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(32)))
+	}
+	for i := 0; i < 2000; i += 2 {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	values := make(map[int][]byte)
+	for i := 1; i < 2000; i += 2 {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		values[i] = val
+	}
+
+	assert.Nil(t, db.Merge())
+	hits := db.Stat().CacheHits
+	for i := 0; i < 2000; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		if i%2 == 0 {
+			assert.Equal(t, ErrKeyNotFound, err)
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, values[i], val)
+	}
+	// merge 之后数据文件没有被替换，缓存仍然有效
+	assert.Equal(t, hits+1000, db.Stat().CacheHits)
+
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	for i := 0; i < 2; i++ {
+		for j := 1; j < 2000; j += 2 {
+			val, err := db2.Get(utils.GetTestKey(j))
+			assert.Nil(t, err)
+			assert.Equal(t, values[j], val)
+		}
+	}
+}
@@ -1,5 +1,10 @@
 package SingleKVDataSet
 
+import (
+	"SingleKVDataSet/fio"
+	"time"
+)
+
 // 用户数据库的一些配置项
 type Options struct {
 	// 数据库数据目录
@@ -22,6 +27,41 @@ type Options struct {
 
 	// 数据文件合并的阈值
 	DataFileMergeRatio float32
+
+	// 读缓存的内存上限，单位为字节，为 0 时不开启缓存
+	CacheSize int64
+
+	// 最多同时打开的旧数据文件数量，为 0 时不限制
+	MaxOpenFiles int
+
+	// 新建活跃文件时是否按 DataFileSize 预分配磁盘空间
+	PreallocateDataFile bool
+
+	// 是否使用内存模式，数据文件只保存在进程内存中，不创建目录和文件锁
+	// 关闭数据库时释放所有数据，之后用同样的目录打开得到的是空的数据库
+	InMemory bool
+
+	// 内存模式下关闭数据库时是否保留数据，保留后同一进程内用同样的目录重新打开可以读到之前的数据
+	// 保留的数据不再属于任何数据库，不再需要时必须调用 fio.RemoveMemoryDir 释放目录和 merge 目录，否则会一直占用内存
+	InMemoryKeepOnClose bool
+
+	// 是否开启索引快照，只对内存索引生效，B+ 树索引本身存储在磁盘上
+	// 开启后关闭数据库时会保存内存索引，启动时从快照加载索引，只重放快照之后写入的数据
+	IndexSnapshot bool
+
+	// 定期保存索引快照的时间间隔，为 0 时只在关闭数据库时保存
+	IndexSnapshotInterval time.Duration
+
+	// 混合索引在内存中的大小上限，单位为字节，超过后将较冷的索引溢出到磁盘，为 0 时使用默认值 256MB
+	IndexMemoryLimit int64
+
+	// 索引中的 key 是否按照前缀压缩保存，只对 BTree、ShardedBTree 和 ART 索引生效
+	// BTree 和 ShardedBTree 将 key 中最后一个 '/' 或 ':' 之前的部分作为前缀，相同的前缀只保存一份
+	// ART 的每个节点只保存自己的前缀，不再在叶子节点中保存完整的 key，适合 key 中有大量重复前缀的场景
+	IndexKeyCompression bool
+
+	// 故障注入器，只在测试中使用，设置后打开的所有文件都会按照它的设置模拟故障
+	FaultInjector *fio.FaultInjector
 }
 
 // 索引迭代器配置项
@@ -50,16 +90,38 @@ const (
 
 	// BPlusTree B+ 树索引，将索引存储到磁盘上
 	BPlusTree
+
+	// ShardedBTree 分片 BTree 索引，不同分片的读写可以并发进行
+	ShardedBTree
+
+	// HashIndex 哈希索引，每个 key 的内存开销更小，适合只有点查询的场景
+	// 遍历数据时需要先对全部 key 排序，不适合频繁使用迭代器和前缀遍历
+	HashIndex
+
+	// SkipList 跳表索引，读取不加锁，写入只锁住被修改的相邻节点，迭代器创建时不复制数据
+	SkipList
+
+	// HybridIndex 混合索引，最近写入的索引保存在内存中，内存占用超过 IndexMemoryLimit 后将较冷的索引溢出到磁盘
+	HybridIndex
 )
 
 var DefaultOptions = Options{
-	DirPath:            "./TestingFile",
-	DataFileSize:       256 * 1024 * 1024, // 256MB
-	SyncWrites:         false,
-	BytesPerSync:       0,
-	IndexType:          BTree,
-	MMapAtStartup:      true,
-	DataFileMergeRatio: 0.5,
+	DirPath:               "./TestingFile",
+	DataFileSize:          256 * 1024 * 1024, // 256MB
+	SyncWrites:            false,
+	BytesPerSync:          0,
+	IndexType:             BTree,
+	MMapAtStartup:         true,
+	DataFileMergeRatio:    0.5,
+	CacheSize:             0,
+	MaxOpenFiles:          0,
+	PreallocateDataFile:   false,
+	InMemory:              false,
+	InMemoryKeepOnClose:   false,
+	IndexSnapshot:         false,
+	IndexSnapshotInterval: 0,
+	IndexMemoryLimit:      0,
+	IndexKeyCompression:   false,
 }
 
 var DefaultIteratorOptions = IteratorOptions{
@@ -4,36 +4,139 @@ import (
 	"SingleKVDataSet"
 	bitcask_redis "SingleKVDataSet/redis"
 	"SingleKVDataSet/utils"
+	"errors"
 	"fmt"
 	"github.com/tidwall/redcon"
+	"math"
+	"strconv"
 	"strings"
+	"time"
+)
+
+var (
+	errValueNotInteger   = errors.New("ERR value is not an integer or out of range")
+	errValueNotFloat     = errors.New("ERR value is not a valid float")
+	errInvalidCursor     = errors.New("ERR invalid cursor")
+	errSyntax            = errors.New("ERR syntax error")
+	errInvalidTimeout    = errors.New("ERR timeout is not a float or out of range")
+	errNegativeTimeout   = errors.New("ERR timeout is negative")
+	errInvalidScoreBound = errors.New("ERR min or max is not a float")
+	errDBIndexOutOfRange = errors.New("ERR DB index is out of range")
+	errSameObject        = errors.New("ERR source and destination objects are the same")
 )
 
 func newWrongNumberOfArgsError(cmd string) error {
 	return fmt.Errorf("Err wrong number of arguments for '%s' command", cmd)
 }
 
+func newInvalidExpireError(cmd string) error {
+	return fmt.Errorf("ERR invalid expire time in '%s' command", cmd)
+}
+
 type cmdHandler func(cli *BitcaskClient, args [][]byte) (interface{}, error)
 
 var supportedCommands = map[string]cmdHandler{
-	"set":       set,
-	"get":       get,
-	"hset":      hset,
-	"hget":      hget,
-	"sadd":      sadd,
-	"sismember": sismember,
-	"srem":      srem,
-	"lpush":     lpush,
-	"rpush":     rpush,
-	"lpop":      lpop,
-	"rpop":      rpop,
-	"zadd":      zadd,
-	"zscore":    zscore,
+	"set":              set,
+	"get":              get,
+	"setnx":            setnx,
+	"getset":           getset,
+	"getdel":           getdel,
+	"mset":             mset,
+	"msetnx":           msetnx,
+	"mget":             mget,
+	"incr":             incr,
+	"decr":             decr,
+	"incrby":           incrby,
+	"decrby":           decrby,
+	"incrbyfloat":      incrbyfloat,
+	"append":           appendCmd,
+	"setrange":         setrange,
+	"getrange":         getrange,
+	"strlen":           strlen,
+	"del":              del,
+	"type":             typeCmd,
+	"exists":           exists,
+	"keys":             keys,
+	"scan":             scan,
+	"rename":           rename,
+	"renamenx":         renamenx,
+	"dbsize":           dbsize,
+	"flushdb":          flushdb,
+	"randomkey":        randomkey,
+	"select":           selectCmd,
+	"swapdb":           swapdb,
+	"move":             move,
+	"info":             info,
+	"expire":           expire,
+	"pexpire":          pexpire,
+	"expireat":         expireat,
+	"pexpireat":        pexpireat,
+	"ttl":              ttl,
+	"pttl":             pttl,
+	"persist":          persist,
+	"hset":             hset,
+	"hget":             hget,
+	"hdel":             hdel,
+	"hsetnx":           hsetnx,
+	"hmset":            hmset,
+	"hmget":            hmget,
+	"hexists":          hexists,
+	"hlen":             hlen,
+	"hgetall":          hgetall,
+	"hkeys":            hkeys,
+	"hvals":            hvals,
+	"hincrby":          hincrby,
+	"hincrbyfloat":     hincrbyfloat,
+	"hscan":            hscan,
+	"sadd":             sadd,
+	"sismember":        sismember,
+	"srem":             srem,
+	"scard":            scard,
+	"smembers":         smembers,
+	"spop":             spop,
+	"srandmember":      srandmember,
+	"smove":            smove,
+	"sinter":           sinter,
+	"sunion":           sunion,
+	"sdiff":            sdiff,
+	"sinterstore":      sinterstore,
+	"sunionstore":      sunionstore,
+	"sdiffstore":       sdiffstore,
+	"sscan":            sscan,
+	"lpush":            lpush,
+	"rpush":            rpush,
+	"lpop":             lpop,
+	"rpop":             rpop,
+	"llen":             llen,
+	"lindex":           lindex,
+	"lrange":           lrange,
+	"lset":             lset,
+	"ltrim":            ltrim,
+	"linsert":          linsert,
+	"lrem":             lrem,
+	"lmove":            lmove,
+	"blpop":            blpop,
+	"brpop":            brpop,
+	"blmove":           blmove,
+	"zadd":             zadd,
+	"zscore":           zscore,
+	"zcard":            zcard,
+	"zrem":             zrem,
+	"zincrby":          zincrby,
+	"zcount":           zcount,
+	"zrange":           zrange,
+	"zrevrange":        zrevrange,
+	"zrangebyscore":    zrangebyscore,
+	"zrevrangebyscore": zrevrangebyscore,
+	"zrank":            zrank,
+	"zrevrank":         zrevrank,
 }
 
 type BitcaskClient struct {
 	server *BitcaskServer
+	conn   redcon.Conn
 	db     *bitcask_redis.RedisDataStructure
+	index  int // 当前选择的逻辑数据库
 }
 
 func execClientCommand(conn redcon.Conn, cmd redcon.Command) {
@@ -45,6 +148,13 @@ func execClientCommand(conn redcon.Conn, cmd redcon.Command) {
 	}
 
 	cilent, _ := conn.Context().(*BitcaskClient)
+	// SWAPDB 之后同一个编号对应的数据库可能已经变了，每个命令执行之前重新获取
+	db, err := cilent.server.db(cilent.index)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	cilent.db = db
 	switch command {
 	case "quit":
 		_ = conn.Close()
@@ -54,24 +164,91 @@ func execClientCommand(conn redcon.Conn, cmd redcon.Command) {
 		res, err := cmdFunc(cilent, cmd.Args[1:])
 		if err == SingleKVDataSet.ErrKeyNotFound {
 			conn.WriteNull()
-		} else {
+			return
+		}
+		if err != nil {
 			conn.WriteError(err.Error())
+			return
 		}
 		conn.WriteAny(res)
 	}
 }
 
+// set 解析 SET key value [NX|XX] [GET] [EX seconds|PX milliseconds|EXAT timestamp|PXAT milliseconds-timestamp|KEEPTTL]
 func set(cli *BitcaskClient, args [][]byte) (interface{}, error) {
-	if len(args) != 2 {
+	if len(args) < 2 {
 		return nil, newWrongNumberOfArgsError("SET")
 	}
 	key, value := args[0], args[1]
-	if err := cli.db.Set(key, 0, value); err != nil {
+
+	var opts bitcask_redis.SetOptions
+	var hasExpire bool
+	for i := 2; i < len(args); i++ {
+		switch option := strings.ToLower(string(args[i])); option {
+		case "nx":
+			opts.NX = true
+		case "xx":
+			opts.XX = true
+		case "get":
+			opts.Get = true
+		case "keepttl":
+			opts.KeepTTL = true
+		case "ex", "px", "exat", "pxat":
+			if hasExpire || i+1 >= len(args) {
+				return nil, errSyntax
+			}
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return nil, errValueNotInteger
+			}
+			expireAt, ok := parseExpireAt(option, n)
+			if n <= 0 || !ok {
+				return nil, newInvalidExpireError("set")
+			}
+			opts.ExpireAt = expireAt
+			hasExpire = true
+			i++
+		default:
+			return nil, errSyntax
+		}
+	}
+	if (opts.NX && opts.XX) || (opts.KeepTTL && hasExpire) {
+		return nil, errSyntax
+	}
+
+	old, ok, err := cli.db.SetWithOptions(key, value, opts)
+	if err != nil {
 		return nil, err
 	}
+	if opts.Get {
+		if old == nil {
+			return nil, nil
+		}
+		return old, nil
+	}
+	if !ok {
+		return nil, nil
+	}
 	return redcon.SimpleString("OK"), nil
 }
 
+// parseExpireAt 将 EX、PX、EXAT、PXAT 参数转换为过期的时间点，超出纳秒时间戳能表示的范围时返回 false
+func parseExpireAt(option string, n int64) (time.Time, bool) {
+	unit := time.Second
+	if option == "px" || option == "pxat" {
+		unit = time.Millisecond
+	}
+	if n > math.MaxInt64/int64(unit) || n < math.MinInt64/int64(unit) {
+		return time.Time{}, false
+	}
+	switch option {
+	case "ex", "px":
+		return time.Now().Add(time.Duration(n) * unit), true
+	default:
+		return time.Unix(0, n*int64(unit)), true
+	}
+}
+
 func get(cli *BitcaskClient, args [][]byte) (interface{}, error) {
 	if len(args) != 1 {
 		return nil, newWrongNumberOfArgsError("GET")
@@ -80,16 +257,19 @@ func get(cli *BitcaskClient, args [][]byte) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
+	// 已经过期
+	if value == nil {
+		return nil, nil
+	}
 	return value, nil
 }
 
-func hset(cli *BitcaskClient, args [][]byte) (interface{}, error) {
-	if len(args) != 3 {
-		return nil, newWrongNumberOfArgsError("HSET")
+func setnx(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("SETNX")
 	}
 	var ok = 0
-	key, field, value := args[0], args[1], args[2]
-	res, err := cli.db.HSet(key, field, value)
+	res, err := cli.db.SetNX(args[0], args[1])
 	if err != nil {
 		return nil, err
 	}
@@ -99,26 +279,46 @@ func hset(cli *BitcaskClient, args [][]byte) (interface{}, error) {
 	return redcon.SimpleInt(ok), nil
 }
 
-func hget(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+func getset(cli *BitcaskClient, args [][]byte) (interface{}, error) {
 	if len(args) != 2 {
-		return nil, newWrongNumberOfArgsError("HGET")
+		return nil, newWrongNumberOfArgsError("GETSET")
 	}
-	key, field := args[0], args[1]
+	res, err := cli.db.GetSet(args[0], args[1])
+	if err != nil || res == nil {
+		return nil, err
+	}
+	return res, nil
+}
 
-	res, err := cli.db.HGet(key, field)
-	if err != nil {
+func getdel(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("GETDEL")
+	}
+	res, err := cli.db.GetDel(args[0])
+	if err != nil || res == nil {
 		return nil, err
 	}
-	return redcon.SimpleString(res), nil
+	return res, nil
 }
 
-func sadd(cli *BitcaskClient, args [][]byte) (interface{}, error) {
-	if len(args) != 2 {
-		return nil, newWrongNumberOfArgsError("SADD")
+func mset(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 || len(args)%2 != 0 {
+		return nil, newWrongNumberOfArgsError("MSET")
+	}
+	keys, values := splitPairs(args)
+	if err := cli.db.MSet(keys, values); err != nil {
+		return nil, err
+	}
+	return redcon.SimpleString("OK"), nil
+}
+
+func msetnx(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 || len(args)%2 != 0 {
+		return nil, newWrongNumberOfArgsError("MSETNX")
 	}
 	var ok = 0
-	key, member := args[0], args[1]
-	res, err := cli.db.SAdd(key, member)
+	keys, values := splitPairs(args)
+	res, err := cli.db.MSetNX(keys, values)
 	if err != nil {
 		return nil, err
 	}
@@ -128,95 +328,263 @@ func sadd(cli *BitcaskClient, args [][]byte) (interface{}, error) {
 	return redcon.SimpleInt(ok), nil
 }
 
-func sismember(cli *BitcaskClient, args [][]byte) (interface{}, error) {
-	if len(args) != 2 {
-		return nil, newWrongNumberOfArgsError("SISMEMBER")
+func mget(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, newWrongNumberOfArgsError("MGET")
 	}
-	var ok = 0
-	key, member := args[0], args[1]
-	res, err := cli.db.SIsMember(key, member)
+	values, err := cli.db.MGet(args)
 	if err != nil {
 		return nil, err
 	}
-	if res {
-		ok = 1
+	// 不存在的 key 返回 nil
+	res := make([]interface{}, len(values))
+	for i, value := range values {
+		if value != nil {
+			res[i] = value
+		}
 	}
-	return redcon.SimpleInt(ok), nil
+	return res, nil
 }
 
-func srem(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+func incr(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("INCR")
+	}
+	return incrBy(cli, args[0], 1)
+}
+
+func decr(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("DECR")
+	}
+	return incrBy(cli, args[0], -1)
+}
+
+func incrby(cli *BitcaskClient, args [][]byte) (interface{}, error) {
 	if len(args) != 2 {
-		return nil, newWrongNumberOfArgsError("SREM")
+		return nil, newWrongNumberOfArgsError("INCRBY")
 	}
-	var ok = 0
-	key, member := args[0], args[1]
-	res, err := cli.db.SRem(key, member)
+	n, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return nil, errValueNotInteger
+	}
+	return incrBy(cli, args[0], n)
+}
+
+func decrby(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("DECRBY")
+	}
+	n, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil || n == math.MinInt64 {
+		return nil, errValueNotInteger
+	}
+	return incrBy(cli, args[0], -n)
+}
+
+func incrBy(cli *BitcaskClient, key []byte, n int64) (interface{}, error) {
+	res, err := cli.db.IncrBy(key, n)
 	if err != nil {
 		return nil, err
 	}
-	if res {
-		ok = 1
+	return redcon.SimpleInt(res), nil
+}
+
+func incrbyfloat(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("INCRBYFLOAT")
 	}
-	return redcon.SimpleInt(ok), nil
+	incr, err := parseFloat(args[1])
+	if err != nil {
+		return nil, err
+	}
+	res, err := cli.db.IncrByFloat(args[0], incr)
+	if err != nil {
+		return nil, err
+	}
+	return utils.Float64ToBytes(res), nil
 }
 
-func lpush(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+func appendCmd(cli *BitcaskClient, args [][]byte) (interface{}, error) {
 	if len(args) != 2 {
-		return nil, newWrongNumberOfArgsError("LPUSH")
+		return nil, newWrongNumberOfArgsError("APPEND")
+	}
+	res, err := cli.db.Append(args[0], args[1])
+	if err != nil {
+		return nil, err
 	}
+	return redcon.SimpleInt(res), nil
+}
 
-	key, value := args[0], args[1]
-	res, err := cli.db.LPush(key, value)
+func setrange(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("SETRANGE")
+	}
+	offset, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return nil, errValueNotInteger
+	}
+	res, err := cli.db.SetRange(args[0], offset, args[2])
 	if err != nil {
 		return nil, err
 	}
 	return redcon.SimpleInt(res), nil
 }
 
-func rpush(cli *BitcaskClient, args [][]byte) (interface{}, error) {
-	if len(args) != 2 {
-		return nil, newWrongNumberOfArgsError("RPUSH")
+func getrange(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("GETRANGE")
 	}
+	start, err1 := strconv.ParseInt(string(args[1]), 10, 64)
+	end, err2 := strconv.ParseInt(string(args[2]), 10, 64)
+	if err1 != nil || err2 != nil {
+		return nil, errValueNotInteger
+	}
+	return cli.db.GetRange(args[0], start, end)
+}
 
-	key, value := args[0], args[1]
-	res, err := cli.db.RPush(key, value)
+func strlen(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("STRLEN")
+	}
+	res, err := cli.db.StrLen(args[0])
 	if err != nil {
 		return nil, err
 	}
 	return redcon.SimpleInt(res), nil
 }
 
-func lpop(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+// splitPairs 将 key value key value ... 形式的参数拆分为 key 和 value
+func splitPairs(args [][]byte) ([][]byte, [][]byte) {
+	keys := make([][]byte, 0, len(args)/2)
+	values := make([][]byte, 0, len(args)/2)
+	for i := 0; i+1 < len(args); i += 2 {
+		keys = append(keys, args[i])
+		values = append(values, args[i+1])
+	}
+	return keys, values
+}
+
+// dataTypeNames TYPE 命令和 SCAN 的 TYPE 参数使用的类型名称，下标为数据类型
+var dataTypeNames = []string{"string", "hash", "set", "list", "zset"}
+
+func del(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, newWrongNumberOfArgsError("DEL")
+	}
+	var res int
+	for _, key := range args {
+		exist, err := cli.db.Del(key)
+		if err != nil {
+			return nil, err
+		}
+		if exist {
+			res++
+		}
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func typeCmd(cli *BitcaskClient, args [][]byte) (interface{}, error) {
 	if len(args) != 1 {
-		return nil, newWrongNumberOfArgsError("LPOP")
+		return nil, newWrongNumberOfArgsError("TYPE")
 	}
-	key := args[0]
-	res, err := cli.db.LPop(key)
+	n, err := cli.db.Exists(args[0])
 	if err != nil {
 		return nil, err
 	}
-	return redcon.SimpleString(res), nil
+	if n == 0 {
+		return redcon.SimpleString("none"), nil
+	}
+	typ, err := cli.db.Type(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleString(dataTypeNames[typ]), nil
 }
 
-func rpop(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+func exists(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, newWrongNumberOfArgsError("EXISTS")
+	}
+	res, err := cli.db.Exists(args...)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func keys(cli *BitcaskClient, args [][]byte) (interface{}, error) {
 	if len(args) != 1 {
-		return nil, newWrongNumberOfArgsError("RPOP")
+		return nil, newWrongNumberOfArgsError("KEYS")
 	}
-	key := args[0]
-	res, err := cli.db.RPop(key)
+	pattern := args[0]
+	if string(pattern) == "*" {
+		pattern = nil
+	}
+	res, err := cli.db.Keys(pattern)
 	if err != nil {
 		return nil, err
 	}
-	return redcon.SimpleString(res), nil
+	return emptyIfNil(res), nil
 }
 
-func zadd(cli *BitcaskClient, args [][]byte) (interface{}, error) {
-	if len(args) != 3 {
-		return nil, newWrongNumberOfArgsError("ZADD")
+// scan 解析 SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+func scan(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, newWrongNumberOfArgsError("SCAN")
+	}
+	// 先取出 TYPE 参数，其余的参数和其他 SCAN 类命令相同
+	scanArgs := [][]byte{args[0]}
+	var dataTypes []byte
+	for i := 1; i < len(args); i += 2 {
+		if i+1 < len(args) && strings.ToLower(string(args[i])) == "type" {
+			typ, ok := parseDataType(args[i+1])
+			if !ok {
+				return nil, fmt.Errorf("ERR unknown type name '%s'", args[i+1])
+			}
+			dataTypes = []byte{typ}
+			continue
+		}
+		scanArgs = append(scanArgs, args[i:min(i+2, len(args))]...)
+	}
+	cursor, match, count, err := parseScanArgs(scanArgs)
+	if err != nil {
+		return nil, err
+	}
+	next, res, err := cli.db.Scan(cursor, match, count, dataTypes...)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{strconv.FormatUint(next, 10), emptyIfNil(res)}, nil
+}
+
+// parseDataType 将类型名称转换为数据类型
+func parseDataType(name []byte) (byte, bool) {
+	for typ, typeName := range dataTypeNames {
+		if strings.EqualFold(typeName, string(name)) {
+			return byte(typ), true
+		}
+	}
+	return 0, false
+}
+
+func rename(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("RENAME")
+	}
+	if err := cli.db.Rename(args[0], args[1]); err != nil {
+		return nil, err
+	}
+	return redcon.SimpleString("OK"), nil
+}
+
+func renamenx(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("RENAMENX")
 	}
 	var ok = 0
-	key, score, value := args[0], args[1], args[2]
-	res, err := cli.db.ZAdd(key, utils.FloatFromBytes(score), value)
+	res, err := cli.db.RenameNX(args[0], args[1])
 	if err != nil {
 		return nil, err
 	}
@@ -226,15 +594,1181 @@ func zadd(cli *BitcaskClient, args [][]byte) (interface{}, error) {
 	return redcon.SimpleInt(ok), nil
 }
 
-func zscore(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+func dbsize(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 0 {
+		return nil, newWrongNumberOfArgsError("DBSIZE")
+	}
+	res, err := cli.db.DBSize()
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+// flushdb 删除当前数据库的所有数据，ASYNC 和 SYNC 参数都按照同步的方式执行
+func flushdb(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) > 1 {
+		return nil, newWrongNumberOfArgsError("FLUSHDB")
+	}
+	if len(args) == 1 {
+		if mode := strings.ToLower(string(args[0])); mode != "async" && mode != "sync" {
+			return nil, errSyntax
+		}
+	}
+	if err := cli.db.FlushDB(); err != nil {
+		return nil, err
+	}
+	return redcon.SimpleString("OK"), nil
+}
+
+func randomkey(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 0 {
+		return nil, newWrongNumberOfArgsError("RANDOMKEY")
+	}
+	res, err := cli.db.RandomKey()
+	if err != nil || res == nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func selectCmd(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("SELECT")
+	}
+	index, err := parseDBIndex(cli, args[0], errValueNotInteger)
+	if err != nil {
+		return nil, err
+	}
+	db, err := cli.server.db(index)
+	if err != nil {
+		return nil, err
+	}
+	cli.index, cli.db = index, db
+	return redcon.SimpleString("OK"), nil
+}
+
+func swapdb(cli *BitcaskClient, args [][]byte) (interface{}, error) {
 	if len(args) != 2 {
-		return nil, newWrongNumberOfArgsError("ZSCORE")
+		return nil, newWrongNumberOfArgsError("SWAPDB")
 	}
-	key, member := args[0], args[1]
-	res, err := cli.db.ZScore(key, member)
+	first, err := parseDBIndex(cli, args[0], errors.New("ERR invalid first DB index"))
+	if err != nil {
+		return nil, err
+	}
+	second, err := parseDBIndex(cli, args[1], errors.New("ERR invalid second DB index"))
+	if err != nil {
+		return nil, err
+	}
+	if err := cli.server.swapDB(first, second); err != nil {
+		return nil, err
+	}
+	return redcon.SimpleString("OK"), nil
+}
+
+func move(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("MOVE")
+	}
+	index, err := parseDBIndex(cli, args[1], errValueNotInteger)
+	if err != nil {
+		return nil, err
+	}
+	if index == cli.index {
+		return nil, errSameObject
+	}
+	dst, err := cli.server.db(index)
+	if err != nil {
+		return nil, err
+	}
+	var res = 0
+	ok, err := cli.db.Move(args[0], dst)
 	if err != nil {
 		return nil, err
 	}
-	// todo 返回是一个float
+	if ok {
+		res = 1
+	}
 	return redcon.SimpleInt(res), nil
 }
+
+// parseDBIndex 解析逻辑数据库的编号，不是整数时返回 errNotInteger
+func parseDBIndex(cli *BitcaskClient, arg []byte, errNotInteger error) (int, error) {
+	index, err := strconv.Atoi(string(arg))
+	if err != nil {
+		return 0, errNotInteger
+	}
+	if index < 0 || index >= cli.server.databases {
+		return 0, errDBIndexOutOfRange
+	}
+	return index, nil
+}
+
+// info 按照 Redis INFO 的格式返回统计信息，可以指定只返回某一部分
+func info(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) > 1 {
+		return nil, newWrongNumberOfArgsError("INFO")
+	}
+	section := "all"
+	if len(args) == 1 {
+		section = strings.ToLower(string(args[0]))
+	}
+
+	stat := cli.db.Stat()
+	gcStats := cli.db.GCStats()
+	sections := []struct {
+		name  string
+		lines []string
+	}{
+		{"Storage", []string{
+			fmt.Sprintf("keys:%d", stat.KeyNum),
+			fmt.Sprintf("data_files:%d", stat.DataFileNum),
+			fmt.Sprintf("open_files:%d", stat.OpenFileNum),
+			fmt.Sprintf("disk_size:%d", stat.DiskSize),
+			fmt.Sprintf("reclaimable_size:%d", stat.ReclaimableSize),
+			fmt.Sprintf("index_memory_size:%d", stat.IndexMemorySize),
+		}},
+		{"GC", []string{
+			fmt.Sprintf("subkey_gc_passes:%d", gcStats.Passes),
+			fmt.Sprintf("subkey_gc_scanned_keys:%d", gcStats.Scanned),
+			fmt.Sprintf("subkey_gc_reclaimed_keys:%d", gcStats.Reclaimed),
+		}},
+	}
+
+	var sb strings.Builder
+	for _, sec := range sections {
+		if section != "all" && section != "default" && section != "everything" && section != strings.ToLower(sec.name) {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteString("\r\n")
+		}
+		sb.WriteString("# " + sec.name + "\r\n")
+		for _, line := range sec.lines {
+			sb.WriteString(line + "\r\n")
+		}
+	}
+	return []byte(sb.String()), nil
+}
+
+func expire(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return expireGeneric(cli, args, "expire", "ex")
+}
+
+func pexpire(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return expireGeneric(cli, args, "pexpire", "px")
+}
+
+func expireat(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return expireGeneric(cli, args, "expireat", "exat")
+}
+
+func pexpireat(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return expireGeneric(cli, args, "pexpireat", "pxat")
+}
+
+// expireGeneric EXPIRE、PEXPIRE、EXPIREAT、PEXPIREAT 的公共部分，option 和 SET 命令的参数含义相同
+func expireGeneric(cli *BitcaskClient, args [][]byte, cmd, option string) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError(strings.ToUpper(cmd))
+	}
+	n, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return nil, errValueNotInteger
+	}
+	expireAt, ok := parseExpireAt(option, n)
+	if !ok {
+		return nil, newInvalidExpireError(cmd)
+	}
+	var res = 0
+	ok, err = cli.db.ExpireAt(args[0], expireAt)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		res = 1
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func ttl(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("TTL")
+	}
+	return ttlGeneric(cli, args[0], time.Second)
+}
+
+func pttl(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("PTTL")
+	}
+	return ttlGeneric(cli, args[0], time.Millisecond)
+}
+
+// ttlGeneric 按照 unit 返回剩余生存时间，四舍五入，key 不存在时返回 -2，没有过期时间时返回 -1
+func ttlGeneric(cli *BitcaskClient, key []byte, unit time.Duration) (interface{}, error) {
+	res, exist, err := cli.db.TTL(key)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return redcon.SimpleInt(-2), nil
+	}
+	if res == 0 {
+		return redcon.SimpleInt(-1), nil
+	}
+	return redcon.SimpleInt(int64((res + unit/2) / unit)), nil
+}
+
+func persist(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("PERSIST")
+	}
+	var res = 0
+	ok, err := cli.db.Persist(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		res = 1
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func hset(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("HSET")
+	}
+	var ok = 0
+	key, field, value := args[0], args[1], args[2]
+	res, err := cli.db.HSet(key, field, value)
+	if err != nil {
+		return nil, err
+	}
+	if res {
+		ok = 1
+	}
+	return redcon.SimpleInt(ok), nil
+}
+
+func hget(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("HGET")
+	}
+	key, field := args[0], args[1]
+
+	res, err := cli.db.HGet(key, field)
+	// key 不存在或者已经过期
+	if err != nil || res == nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func hdel(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgsError("HDEL")
+	}
+	var count = 0
+	key := args[0]
+	for _, field := range args[1:] {
+		res, err := cli.db.HDel(key, field)
+		if err != nil {
+			return nil, err
+		}
+		if res {
+			count++
+		}
+	}
+	return redcon.SimpleInt(count), nil
+}
+
+func hsetnx(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("HSETNX")
+	}
+	var ok = 0
+	key, field, value := args[0], args[1], args[2]
+	res, err := cli.db.HSetNX(key, field, value)
+	if err != nil {
+		return nil, err
+	}
+	if res {
+		ok = 1
+	}
+	return redcon.SimpleInt(ok), nil
+}
+
+func hmset(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 3 || len(args)%2 != 1 {
+		return nil, newWrongNumberOfArgsError("HMSET")
+	}
+	key := args[0]
+	var fields, values [][]byte
+	for i := 1; i < len(args); i += 2 {
+		fields = append(fields, args[i])
+		values = append(values, args[i+1])
+	}
+	if _, err := cli.db.HMSet(key, fields, values); err != nil {
+		return nil, err
+	}
+	return redcon.SimpleString("OK"), nil
+}
+
+func hmget(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgsError("HMGET")
+	}
+	values, err := cli.db.HMGet(args[0], args[1:])
+	if err != nil {
+		return nil, err
+	}
+	// 不存在的 field 返回 nil
+	res := make([]interface{}, len(values))
+	for i, value := range values {
+		if value != nil {
+			res[i] = value
+		}
+	}
+	return res, nil
+}
+
+func hexists(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("HEXISTS")
+	}
+	var ok = 0
+	key, field := args[0], args[1]
+	res, err := cli.db.HExists(key, field)
+	if err != nil {
+		return nil, err
+	}
+	if res {
+		ok = 1
+	}
+	return redcon.SimpleInt(ok), nil
+}
+
+func hlen(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("HLEN")
+	}
+	res, err := cli.db.HLen(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func hgetall(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("HGETALL")
+	}
+	res, err := cli.db.HGetAll(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return emptyIfNil(res), nil
+}
+
+func hkeys(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("HKEYS")
+	}
+	res, err := cli.db.HKeys(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return emptyIfNil(res), nil
+}
+
+func hvals(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("HVALS")
+	}
+	res, err := cli.db.HVals(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return emptyIfNil(res), nil
+}
+
+func hincrby(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("HINCRBY")
+	}
+	key, field := args[0], args[1]
+	incr, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return nil, errValueNotInteger
+	}
+	res, err := cli.db.HIncrBy(key, field, incr)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func hincrbyfloat(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("HINCRBYFLOAT")
+	}
+	key, field := args[0], args[1]
+	incr, err := strconv.ParseFloat(string(args[2]), 64)
+	if err != nil {
+		return nil, errValueNotFloat
+	}
+	res, err := cli.db.HIncrByFloat(key, field, incr)
+	if err != nil {
+		return nil, err
+	}
+	return utils.Float64ToBytes(res), nil
+}
+
+func hscan(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgsError("HSCAN")
+	}
+	key := args[0]
+	cursor, match, count, err := parseScanArgs(args[1:])
+	if err != nil {
+		return nil, err
+	}
+	next, res, err := cli.db.HScan(key, cursor, match, count)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{strconv.FormatUint(next, 10), emptyIfNil(res)}, nil
+}
+
+// parseScanArgs 解析 SCAN 类命令的 cursor [MATCH pattern] [COUNT count] 参数
+func parseScanArgs(args [][]byte) (cursor uint64, match []byte, count int, err error) {
+	if cursor, err = strconv.ParseUint(string(args[0]), 10, 64); err != nil {
+		return 0, nil, 0, errInvalidCursor
+	}
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return 0, nil, 0, errSyntax
+		}
+		switch strings.ToLower(string(args[i])) {
+		case "match":
+			match = args[i+1]
+			// * 匹配所有数据，不需要逐个匹配
+			if string(match) == "*" {
+				match = nil
+			}
+		case "count":
+			if count, err = strconv.Atoi(string(args[i+1])); err != nil {
+				return 0, nil, 0, errValueNotInteger
+			}
+			if count < 1 {
+				return 0, nil, 0, errSyntax
+			}
+		default:
+			return 0, nil, 0, errSyntax
+		}
+	}
+	return cursor, match, count, nil
+}
+
+// emptyIfNil 没有数据时返回空数组而不是 nil
+func emptyIfNil(values [][]byte) [][]byte {
+	if values == nil {
+		return [][]byte{}
+	}
+	return values
+}
+
+func sadd(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("SADD")
+	}
+	var ok = 0
+	key, member := args[0], args[1]
+	res, err := cli.db.SAdd(key, member)
+	if err != nil {
+		return nil, err
+	}
+	if res {
+		ok = 1
+	}
+	return redcon.SimpleInt(ok), nil
+}
+
+func sismember(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("SISMEMBER")
+	}
+	var ok = 0
+	key, member := args[0], args[1]
+	res, err := cli.db.SIsMember(key, member)
+	if err != nil {
+		return nil, err
+	}
+	if res {
+		ok = 1
+	}
+	return redcon.SimpleInt(ok), nil
+}
+
+func srem(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("SREM")
+	}
+	var ok = 0
+	key, member := args[0], args[1]
+	res, err := cli.db.SRem(key, member)
+	if err != nil {
+		return nil, err
+	}
+	if res {
+		ok = 1
+	}
+	return redcon.SimpleInt(ok), nil
+}
+
+func scard(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("SCARD")
+	}
+	res, err := cli.db.SCard(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func smembers(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("SMEMBERS")
+	}
+	res, err := cli.db.SMembers(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return emptyIfNil(res), nil
+}
+
+func spop(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("SPOP")
+	}
+	// 没有指定 count 时返回单个 member
+	if len(args) == 1 {
+		res, err := cli.db.SPop(args[0], 1)
+		if err != nil || len(res) == 0 {
+			return nil, err
+		}
+		return res[0], nil
+	}
+	count, err := strconv.ParseUint(string(args[1]), 10, 32)
+	if err != nil {
+		return nil, errValueNotInteger
+	}
+	res, err := cli.db.SPop(args[0], uint32(count))
+	if err != nil {
+		return nil, err
+	}
+	return emptyIfNil(res), nil
+}
+
+func srandmember(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("SRANDMEMBER")
+	}
+	if len(args) == 1 {
+		res, err := cli.db.SRandMember(args[0], 1)
+		if err != nil || len(res) == 0 {
+			return nil, err
+		}
+		return res[0], nil
+	}
+	count, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return nil, errValueNotInteger
+	}
+	res, err := cli.db.SRandMember(args[0], count)
+	if err != nil {
+		return nil, err
+	}
+	return emptyIfNil(res), nil
+}
+
+func smove(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("SMOVE")
+	}
+	var ok = 0
+	source, destination, member := args[0], args[1], args[2]
+	res, err := cli.db.SMove(source, destination, member)
+	if err != nil {
+		return nil, err
+	}
+	if res {
+		ok = 1
+	}
+	return redcon.SimpleInt(ok), nil
+}
+
+func sinter(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, newWrongNumberOfArgsError("SINTER")
+	}
+	res, err := cli.db.SInter(args...)
+	if err != nil {
+		return nil, err
+	}
+	return emptyIfNil(res), nil
+}
+
+func sunion(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, newWrongNumberOfArgsError("SUNION")
+	}
+	res, err := cli.db.SUnion(args...)
+	if err != nil {
+		return nil, err
+	}
+	return emptyIfNil(res), nil
+}
+
+func sdiff(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, newWrongNumberOfArgsError("SDIFF")
+	}
+	res, err := cli.db.SDiff(args...)
+	if err != nil {
+		return nil, err
+	}
+	return emptyIfNil(res), nil
+}
+
+func sinterstore(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgsError("SINTERSTORE")
+	}
+	res, err := cli.db.SInterStore(args[0], args[1:]...)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func sunionstore(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgsError("SUNIONSTORE")
+	}
+	res, err := cli.db.SUnionStore(args[0], args[1:]...)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func sdiffstore(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgsError("SDIFFSTORE")
+	}
+	res, err := cli.db.SDiffStore(args[0], args[1:]...)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func sscan(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgsError("SSCAN")
+	}
+	key := args[0]
+	cursor, match, count, err := parseScanArgs(args[1:])
+	if err != nil {
+		return nil, err
+	}
+	next, res, err := cli.db.SScan(key, cursor, match, count)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{strconv.FormatUint(next, 10), emptyIfNil(res)}, nil
+}
+
+func lpush(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("LPUSH")
+	}
+
+	key, value := args[0], args[1]
+	res, err := cli.db.LPush(key, value)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func rpush(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("RPUSH")
+	}
+
+	key, value := args[0], args[1]
+	res, err := cli.db.RPush(key, value)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func lpop(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("LPOP")
+	}
+	key := args[0]
+	res, err := cli.db.LPop(key)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleString(res), nil
+}
+
+func rpop(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("RPOP")
+	}
+	key := args[0]
+	res, err := cli.db.RPop(key)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleString(res), nil
+}
+
+func llen(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("LLEN")
+	}
+	res, err := cli.db.LLen(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func lindex(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("LINDEX")
+	}
+	index, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return nil, errValueNotInteger
+	}
+	res, err := cli.db.LIndex(args[0], index)
+	if err != nil || res == nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func lrange(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("LRANGE")
+	}
+	start, err1 := strconv.ParseInt(string(args[1]), 10, 64)
+	stop, err2 := strconv.ParseInt(string(args[2]), 10, 64)
+	if err1 != nil || err2 != nil {
+		return nil, errValueNotInteger
+	}
+	res, err := cli.db.LRange(args[0], start, stop)
+	if err != nil {
+		return nil, err
+	}
+	return emptyIfNil(res), nil
+}
+
+func lset(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("LSET")
+	}
+	index, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return nil, errValueNotInteger
+	}
+	if err := cli.db.LSet(args[0], index, args[2]); err != nil {
+		return nil, err
+	}
+	return redcon.SimpleString("OK"), nil
+}
+
+func ltrim(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("LTRIM")
+	}
+	start, err1 := strconv.ParseInt(string(args[1]), 10, 64)
+	stop, err2 := strconv.ParseInt(string(args[2]), 10, 64)
+	if err1 != nil || err2 != nil {
+		return nil, errValueNotInteger
+	}
+	if err := cli.db.LTrim(args[0], start, stop); err != nil {
+		return nil, err
+	}
+	return redcon.SimpleString("OK"), nil
+}
+
+func linsert(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 4 {
+		return nil, newWrongNumberOfArgsError("LINSERT")
+	}
+	var before bool
+	switch strings.ToLower(string(args[1])) {
+	case "before":
+		before = true
+	case "after":
+	default:
+		return nil, errSyntax
+	}
+	res, err := cli.db.LInsert(args[0], before, args[2], args[3])
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func lrem(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("LREM")
+	}
+	count, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return nil, errValueNotInteger
+	}
+	res, err := cli.db.LRem(args[0], count, args[2])
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func lmove(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 4 {
+		return nil, newWrongNumberOfArgsError("LMOVE")
+	}
+	srcLeft, dstLeft, err := parseListDirections(args[2], args[3])
+	if err != nil {
+		return nil, err
+	}
+	res, err := cli.db.LMove(args[0], args[1], srcLeft, dstLeft)
+	if err != nil || res == nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func blpop(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgsError("BLPOP")
+	}
+	return blockingPop(cli, args, true)
+}
+
+func brpop(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgsError("BRPOP")
+	}
+	return blockingPop(cli, args, false)
+}
+
+func blockingPop(cli *BitcaskClient, args [][]byte, isLeft bool) (interface{}, error) {
+	timeout, err := parseTimeout(args[len(args)-1])
+	if err != nil {
+		return nil, err
+	}
+	keys := args[:len(args)-1]
+	// 客户端断开连接时停止等待，避免弹出的元素没有人接收
+	ctx, stop := watchDisconnect(cli.conn.NetConn())
+	defer stop()
+	var key, element []byte
+	if isLeft {
+		key, element, err = cli.db.BLPop(ctx, keys, timeout)
+	} else {
+		key, element, err = cli.db.BRPop(ctx, keys, timeout)
+	}
+	// 超时返回 nil
+	if err != nil || key == nil {
+		return nil, err
+	}
+	return [][]byte{key, element}, nil
+}
+
+func blmove(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 5 {
+		return nil, newWrongNumberOfArgsError("BLMOVE")
+	}
+	srcLeft, dstLeft, err := parseListDirections(args[2], args[3])
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := parseTimeout(args[4])
+	if err != nil {
+		return nil, err
+	}
+	ctx, stop := watchDisconnect(cli.conn.NetConn())
+	defer stop()
+	res, err := cli.db.BLMove(ctx, args[0], args[1], srcLeft, dstLeft, timeout)
+	if err != nil || res == nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// parseListDirections 解析 LEFT|RIGHT 参数，返回是否为 LEFT
+func parseListDirections(src, dst []byte) (bool, bool, error) {
+	var res [2]bool
+	for i, arg := range [][]byte{src, dst} {
+		switch strings.ToLower(string(arg)) {
+		case "left":
+			res[i] = true
+		case "right":
+		default:
+			return false, false, errSyntax
+		}
+	}
+	return res[0], res[1], nil
+}
+
+// parseTimeout 解析以秒为单位的超时时间，0 表示一直阻塞
+func parseTimeout(arg []byte) (time.Duration, error) {
+	seconds, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return 0, errInvalidTimeout
+	}
+	if seconds < 0 {
+		return 0, errNegativeTimeout
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+func zadd(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("ZADD")
+	}
+	var ok = 0
+	key, value := args[0], args[2]
+	score, err := parseFloat(args[1])
+	if err != nil {
+		return nil, err
+	}
+	res, err := cli.db.ZAdd(key, score, value)
+	if err != nil {
+		return nil, err
+	}
+	if res {
+		ok = 1
+	}
+	return redcon.SimpleInt(ok), nil
+}
+
+func zscore(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("ZSCORE")
+	}
+	key, member := args[0], args[1]
+	res, err := cli.db.ZScore(key, member)
+	if err != nil {
+		return nil, err
+	}
+	return utils.Float64ToBytes(res), nil
+}
+
+func zcard(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("ZCARD")
+	}
+	res, err := cli.db.ZCard(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func zrem(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgsError("ZREM")
+	}
+	res, err := cli.db.ZRem(args[0], args[1:]...)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func zincrby(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("ZINCRBY")
+	}
+	incr, err := parseFloat(args[1])
+	if err != nil {
+		return nil, err
+	}
+	res, err := cli.db.ZIncrBy(args[0], incr, args[2])
+	if err != nil {
+		return nil, err
+	}
+	return utils.Float64ToBytes(res), nil
+}
+
+func zcount(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("ZCOUNT")
+	}
+	min, err1 := parseScoreBound(args[1])
+	max, err2 := parseScoreBound(args[2])
+	if err1 != nil || err2 != nil {
+		return nil, errInvalidScoreBound
+	}
+	res, err := cli.db.ZCount(args[0], min, max)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func zrange(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return zrangeByRank(cli, args, "ZRANGE", false)
+}
+
+func zrevrange(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return zrangeByRank(cli, args, "ZREVRANGE", true)
+}
+
+func zrangeByRank(cli *BitcaskClient, args [][]byte, cmd string, reverse bool) (interface{}, error) {
+	if len(args) != 3 && len(args) != 4 {
+		return nil, newWrongNumberOfArgsError(cmd)
+	}
+	start, err1 := strconv.ParseInt(string(args[1]), 10, 64)
+	stop, err2 := strconv.ParseInt(string(args[2]), 10, 64)
+	if err1 != nil || err2 != nil {
+		return nil, errValueNotInteger
+	}
+	var withScores bool
+	if len(args) == 4 {
+		if strings.ToLower(string(args[3])) != "withscores" {
+			return nil, errSyntax
+		}
+		withScores = true
+	}
+	res, err := cli.db.ZRange(args[0], start, stop, reverse)
+	if err != nil {
+		return nil, err
+	}
+	return zmembersReply(res, withScores), nil
+}
+
+func zrangebyscore(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return zrangeByScore(cli, args, "ZRANGEBYSCORE", false)
+}
+
+func zrevrangebyscore(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return zrangeByScore(cli, args, "ZREVRANGEBYSCORE", true)
+}
+
+// zrangeByScore 解析 key min max [WITHSCORES] [LIMIT offset count]，反向时参数的顺序为 max min
+func zrangeByScore(cli *BitcaskClient, args [][]byte, cmd string, reverse bool) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, newWrongNumberOfArgsError(cmd)
+	}
+	minArg, maxArg := args[1], args[2]
+	if reverse {
+		minArg, maxArg = maxArg, minArg
+	}
+	min, err1 := parseScoreBound(minArg)
+	max, err2 := parseScoreBound(maxArg)
+	if err1 != nil || err2 != nil {
+		return nil, errInvalidScoreBound
+	}
+
+	var withScores bool
+	offset, count := 0, -1
+	for i := 3; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "withscores":
+			withScores = true
+		case "limit":
+			if i+2 >= len(args) {
+				return nil, errSyntax
+			}
+			var err error
+			if offset, err = strconv.Atoi(string(args[i+1])); err != nil {
+				return nil, errValueNotInteger
+			}
+			if count, err = strconv.Atoi(string(args[i+2])); err != nil {
+				return nil, errValueNotInteger
+			}
+			i += 2
+		default:
+			return nil, errSyntax
+		}
+	}
+	// offset 为负数时返回空列表
+	if offset < 0 {
+		return [][]byte{}, nil
+	}
+	res, err := cli.db.ZRangeByScore(args[0], min, max, offset, count, reverse)
+	if err != nil {
+		return nil, err
+	}
+	return zmembersReply(res, withScores), nil
+}
+
+func zrank(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return zrankByOrder(cli, args, "ZRANK", false)
+}
+
+func zrevrank(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return zrankByOrder(cli, args, "ZREVRANK", true)
+}
+
+func zrankByOrder(cli *BitcaskClient, args [][]byte, cmd string, reverse bool) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError(cmd)
+	}
+	rank, ok, err := cli.db.ZRank(args[0], args[1], reverse)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return redcon.SimpleInt(rank), nil
+}
+
+// zmembersReply 将 member 和 score 转换为返回的数组，withScores 为 true 时 member 和 score 交替排列
+func zmembersReply(members []bitcask_redis.ZMember, withScores bool) [][]byte {
+	res := make([][]byte, 0, len(members)*2)
+	for _, zm := range members {
+		res = append(res, zm.Member)
+		if withScores {
+			res = append(res, utils.Float64ToBytes(zm.Score))
+		}
+	}
+	return res
+}
+
+// parseScoreBound 解析 score 范围的边界，( 开头表示不包括边界，支持 -inf 和 +inf
+func parseScoreBound(arg []byte) (bitcask_redis.ScoreBound, error) {
+	var bound bitcask_redis.ScoreBound
+	if len(arg) > 0 && arg[0] == '(' {
+		bound.Exclusive = true
+		arg = arg[1:]
+	}
+	score, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(score) {
+		return bound, errInvalidScoreBound
+	}
+	bound.Score = score
+	return bound, nil
+}
+
+// parseFloat 解析浮点数参数，不接受 NaN
+func parseFloat(arg []byte) (float64, error) {
+	f, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, errValueNotFloat
+	}
+	return f, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"time"
+)
+
+// maxReadAhead 阻塞的命令执行期间最多预读的数据量，超过之后和 Redis 的 client-query-buffer-limit 一样断开连接
+const maxReadAhead = 64 * 1024 * 1024
+
+var errReadAheadLimit = errors.New("client query buffer limit exceeded")
+
+// watchedListener 包装接受的连接，使阻塞的命令可以发现客户端已经断开
+type watchedListener struct {
+	net.Listener
+}
+
+func (l *watchedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &watchedConn{Conn: conn}, nil
+}
+
+// watchedConn 阻塞的命令执行期间由后台 goroutine 预读连接上的数据，读到 EOF 或者出错说明客户端已经断开
+// 客户端在流水线中发送了后续的命令再断开时，只有读完这些命令才能看到 EOF，因此不能只查看连接是否可读
+// 预读的数据保存在 buf 中，命令返回之后 redcon 先读取这部分数据，后续的命令不会丢失
+// 预读和 redcon 的读取不会同时进行，stop 返回之前预读的 goroutine 已经退出
+type watchedConn struct {
+	net.Conn
+	buf []byte
+	err error // 预读时遇到的错误，和 Redis 一样客户端断开之后不再执行它发送的命令
+}
+
+func (c *watchedConn) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	if len(c.buf) > 0 {
+		n := copy(p, c.buf)
+		c.buf = c.buf[n:]
+		if len(c.buf) == 0 {
+			c.buf = nil
+		}
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
+
+// readAhead 一直读取到连接出错，stop 设置的读超时只用于唤醒 goroutine，不算作连接断开
+func (c *watchedConn) readAhead(cancel context.CancelFunc) {
+	chunk := make([]byte, 4096)
+	for {
+		n, err := c.Conn.Read(chunk)
+		c.buf = append(c.buf, chunk[:n]...)
+		if err == nil && len(c.buf) > maxReadAhead {
+			err = errReadAheadLimit
+		}
+		if err != nil {
+			if !errors.Is(err, os.ErrDeadlineExceeded) {
+				c.err = err
+				cancel()
+			}
+			return
+		}
+	}
+}
+
+// watchDisconnect 返回的 ctx 在客户端断开连接时被取消，阻塞的命令返回之后必须调用 stop
+// 连接需要由 watchedListener 接受，其他连接返回的 ctx 只会在 stop 时取消
+// 客户端所在的主机掉线而没有关闭连接时读取不会出错，只能等到 TCP keepalive 超时之后才能发现
+func watchDisconnect(conn net.Conn) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c, ok := conn.(*watchedConn)
+	if !ok {
+		return ctx, cancel
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.readAhead(cancel)
+	}()
+
+	return ctx, func() {
+		cancel()
+		// 设置过期的读超时唤醒预读的 goroutine，退出之后再恢复，避免影响 redcon 后续的读取
+		_ = c.Conn.SetReadDeadline(time.Now())
+		<-done
+		_ = c.Conn.SetReadDeadline(time.Time{})
+	}
+}
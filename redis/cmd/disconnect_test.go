@@ -0,0 +1,76 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func newWatchedPipe() (*watchedConn, net.Conn) {
+	server, client := net.Pipe()
+	return &watchedConn{Conn: server}, client
+}
+
+func TestWatchDisconnect_Close(t *testing.T) {
+	conn, client := newWatchedPipe()
+	ctx, stop := watchDisconnect(conn)
+	defer stop()
+
+	_ = client.Close()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("disconnect not detected")
+	}
+}
+
+func TestWatchDisconnect_PipelineThenClose(t *testing.T) {
+	conn, client := newWatchedPipe()
+	ctx, stop := watchDisconnect(conn)
+	defer stop()
+
+	// 流水线中后续的命令不能让检测停止
+	_, err := client.Write([]byte("*1\r\n$4\r\nPING\r\n"))
+	assert.Nil(t, err)
+	select {
+	case <-ctx.Done():
+		t.Fatal("pipelined data treated as disconnect")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	_ = client.Close()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("disconnect after pipelined data not detected")
+	}
+	stop()
+	_, err = conn.Read(make([]byte, 16))
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestWatchDisconnect_KeepPipelinedData(t *testing.T) {
+	conn, client := newWatchedPipe()
+	defer client.Close()
+	ctx, stop := watchDisconnect(conn)
+
+	data := []byte("*1\r\n$4\r\nPING\r\n")
+	_, err := client.Write(data)
+	assert.Nil(t, err)
+	stop()
+	assert.NotNil(t, ctx.Err())
+
+	// 命令返回之后先读到预读的数据，之后的读取不受 stop 设置的读超时影响
+	buf := make([]byte, len(data))
+	_, err = io.ReadFull(conn, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, data, buf)
+
+	go func() { _, _ = client.Write([]byte("next")) }()
+	buf = make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "next", string(buf))
+}
@@ -3,57 +3,200 @@ package main
 import (
 	bitcask "SingleKVDataSet"
 	bitcask_redis "SingleKVDataSet/redis"
+	"flag"
+	"fmt"
 	"github.com/tidwall/redcon"
 	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 )
 
 const addr = "127.0.0.1:6380"
 
+// 默认的逻辑数据库数量，和 Redis 相同
+const defaultDatabases = 16
+
 type BitcaskServer struct {
-	dbs    map[int]*bitcask_redis.RedisDataStructure
-	server *redcon.Server
-	mu     *sync.RWMutex
+	dbs       map[int]*bitcask_redis.RedisDataStructure // 已经打开的逻辑数据库，第一次使用时打开
+	dirs      []int                                     // 每个逻辑数据库使用的数据目录编号，SWAPDB 之后会交换
+	databases int
+	options   bitcask.Options
+	server    *redcon.Server
+	mu        *sync.RWMutex
 }
 
 func main() {
-	// 打开 Redis 数据结构服务
-	redisDataStructure, err := bitcask_redis.NewRedisDataStructure(bitcask.DefaultOptions)
+	databases := flag.Int("databases", defaultDatabases, "number of logical databases")
+	dir := flag.String("dir", bitcask.DefaultOptions.DirPath, "data directory of database 0, other databases use <dir>-db<n>")
+	flag.Parse()
+	if *databases < 1 {
+		log.Fatalf("invalid number of databases: %d", *databases)
+	}
+
+	options := bitcask.DefaultOptions
+	options.DirPath = *dir
+	dirs, err := loadDatabaseDirs(options.DirPath, *databases)
 	if err != nil {
 		panic(err)
 	}
 
-	// 初始化 BitcaskServer
+	// 初始化 BitcaskServer，0 号数据库在启动时打开，其他数据库第一次使用时打开
 	bitcaskServer := &BitcaskServer{
-		dbs: make(map[int]*bitcask_redis.RedisDataStructure),
+		dbs:       make(map[int]*bitcask_redis.RedisDataStructure),
+		dirs:      dirs,
+		databases: *databases,
+		options:   options,
+		mu:        new(sync.RWMutex),
+	}
+	if _, err := bitcaskServer.db(0); err != nil {
+		panic(err)
 	}
-	bitcaskServer.dbs[0] = redisDataStructure
 
 	// 初始化一个 Redis 服务器
 	bitcaskServer.server = redcon.NewServer(addr, execClientCommand, bitcaskServer.accept, bitcaskServer.close)
+	go bitcaskServer.waitForShutdown()
 	bitcaskServer.listen()
 }
 
 func (svr *BitcaskServer) listen() {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("failed to listen on %s: %v", addr, err)
+	}
 	log.Println("Bitcask server running, ready to accept connections")
-	_ = svr.server.ListenAndServe()
+	// 包装接受的连接，阻塞的命令执行期间可以发现客户端断开
+	_ = svr.server.Serve(&watchedListener{Listener: ln})
 }
 
 func (svr *BitcaskServer) accept(conn redcon.Conn) bool {
-	cli := new(BitcaskClient)
-	svr.mu.Lock()
-	defer svr.mu.Unlock()
-	cli.server = svr
-	cli.db = svr.dbs[0]
-	conn.SetContext(cli)
+	db, err := svr.db(0)
+	if err != nil {
+		log.Printf("failed to open database 0: %v", err)
+		return false
+	}
+	conn.SetContext(&BitcaskClient{server: svr, conn: conn, db: db})
 	return true
 }
 
+// close 一个连接断开时调用，数据库由所有连接共享，不能在这里关闭
 func (svr *BitcaskServer) close(conn redcon.Conn, err error) {
+}
+
+// waitForShutdown 收到退出信号时停止服务器，并关闭所有数据库
+func (svr *BitcaskServer) waitForShutdown() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	<-sig
+	_ = svr.server.Close()
+
+	svr.mu.Lock()
+	defer svr.mu.Unlock()
 	for _, db := range svr.dbs {
 		_ = db.Close()
 	}
-	_ = svr.server.Close()
+	os.Exit(0)
+}
+
+// db 返回第 index 个逻辑数据库，还没有打开时打开它
+func (svr *BitcaskServer) db(index int) (*bitcask_redis.RedisDataStructure, error) {
+	svr.mu.RLock()
+	db, ok := svr.dbs[index]
+	svr.mu.RUnlock()
+	if ok {
+		return db, nil
+	}
+
+	svr.mu.Lock()
+	defer svr.mu.Unlock()
+	if db, ok := svr.dbs[index]; ok {
+		return db, nil
+	}
+	options := svr.options
+	options.DirPath = databaseDir(svr.options.DirPath, svr.dirs[index])
+	db, err := bitcask_redis.NewRedisDataStructure(options)
+	if err != nil {
+		return nil, err
+	}
+	svr.dbs[index] = db
+	return db, nil
+}
+
+// swapDB 交换两个逻辑数据库的数据，所有连接都会看到交换之后的数据
+// 交换之后的对应关系保存在数据目录旁边的文件中，重启之后仍然有效
+func (svr *BitcaskServer) swapDB(i, j int) error {
+	svr.mu.Lock()
+	defer svr.mu.Unlock()
+
+	dirs := append([]int{}, svr.dirs...)
+	dirs[i], dirs[j] = dirs[j], dirs[i]
+	if err := saveDatabaseDirs(svr.options.DirPath, dirs); err != nil {
+		return err
+	}
+	svr.dirs = dirs
+	dbI, okI := svr.dbs[i]
+	dbJ, okJ := svr.dbs[j]
+	delete(svr.dbs, i)
+	delete(svr.dbs, j)
+	if okI {
+		svr.dbs[j] = dbI
+	}
+	if okJ {
+		svr.dbs[i] = dbJ
+	}
+	return nil
+}
+
+// databaseDir 编号为 n 的数据目录，0 号使用 dir 本身，和只有一个数据库时的数据目录相同
+func databaseDir(dir string, n int) string {
+	if n == 0 {
+		return dir
+	}
+	return fmt.Sprintf("%s-db%d", path.Clean(dir), n)
+}
+
+// databaseDirsFile 保存逻辑数据库和数据目录对应关系的文件
+func databaseDirsFile(dir string) string {
+	return path.Clean(dir) + "-databases"
+}
+
+// loadDatabaseDirs 读取每个逻辑数据库使用的数据目录编号，文件不存在时第 i 个数据库使用编号 i
+// 文件中的数量可能和 databases 不同，多出的部分保留，下次保存时原样写回
+func loadDatabaseDirs(dir string, databases int) ([]int, error) {
+	var dirs []int
+	data, err := os.ReadFile(databaseDirsFile(dir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, field := range strings.Fields(string(data)) {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid database dirs file %s: %v", databaseDirsFile(dir), err)
+		}
+		dirs = append(dirs, n)
+	}
+	for i := len(dirs); i < databases; i++ {
+		dirs = append(dirs, i)
+	}
+	return dirs, nil
+}
+
+// saveDatabaseDirs 先写入临时文件再重命名，避免写入中途失败时文件损坏
+func saveDatabaseDirs(dir string, dirs []int) error {
+	var sb strings.Builder
+	for _, n := range dirs {
+		sb.WriteString(strconv.Itoa(n) + "\n")
+	}
+	tmp := databaseDirsFile(dir) + ".tmp"
+	if err := os.WriteFile(tmp, []byte(sb.String()), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, databaseDirsFile(dir))
 }
 
 // redis 协议解析示例
@@ -0,0 +1,131 @@
+package main
+
+import (
+	bitcask "SingleKVDataSet"
+	bitcask_redis "SingleKVDataSet/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/redcon"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// newTestServer 和 main 一样读取数据目录的对应关系，不监听端口
+func newTestServer(t *testing.T, dir string, databases int) *BitcaskServer {
+	options := bitcask.DefaultOptions
+	options.DirPath = dir
+	dirs, err := loadDatabaseDirs(dir, databases)
+	assert.Nil(t, err)
+	return &BitcaskServer{
+		dbs:       make(map[int]*bitcask_redis.RedisDataStructure),
+		dirs:      dirs,
+		databases: databases,
+		options:   options,
+		mu:        new(sync.RWMutex),
+	}
+}
+
+func closeTestServer(t *testing.T, svr *BitcaskServer) {
+	for _, db := range svr.dbs {
+		assert.Nil(t, db.Close())
+	}
+}
+
+// getValue 返回第 index 个数据库中 key 的值，key 不存在时返回空字符串
+func getValue(t *testing.T, svr *BitcaskServer, index int, key string) string {
+	db, err := svr.db(index)
+	assert.Nil(t, err)
+	value, err := db.Get([]byte(key))
+	if err == bitcask.ErrKeyNotFound {
+		return ""
+	}
+	assert.Nil(t, err)
+	return string(value)
+}
+
+func TestBitcaskServer_SwapDB(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "bitcask")
+	svr := newTestServer(t, dir, 4)
+	for index, value := range []string{"zero", "one"} {
+		db, err := svr.db(index)
+		assert.Nil(t, err)
+		assert.Nil(t, db.Set([]byte("key"), 0, []byte(value)))
+	}
+
+	cli := &BitcaskClient{server: svr}
+	res, err := swapdb(cli, [][]byte{[]byte("0"), []byte("1")})
+	assert.Nil(t, err)
+	assert.Equal(t, redcon.SimpleString("OK"), res)
+	assert.Equal(t, "one", getValue(t, svr, 0, "key"))
+	assert.Equal(t, "zero", getValue(t, svr, 1, "key"))
+
+	// 和还没有打开的数据库交换
+	_, err = swapdb(cli, [][]byte{[]byte("0"), []byte("2")})
+	assert.Nil(t, err)
+	assert.Equal(t, "", getValue(t, svr, 0, "key"))
+	assert.Equal(t, "one", getValue(t, svr, 2, "key"))
+
+	_, err = swapdb(cli, [][]byte{[]byte("0"), []byte("4")})
+	assert.Equal(t, errDBIndexOutOfRange, err)
+	_, err = swapdb(cli, [][]byte{[]byte("x"), []byte("1")})
+	assert.NotNil(t, err)
+
+	// 重启之后交换仍然有效
+	closeTestServer(t, svr)
+	svr = newTestServer(t, dir, 4)
+	defer closeTestServer(t, svr)
+	assert.Equal(t, []int{2, 0, 1, 3}, svr.dirs)
+	assert.Equal(t, "", getValue(t, svr, 0, "key"))
+	assert.Equal(t, "zero", getValue(t, svr, 1, "key"))
+	assert.Equal(t, "one", getValue(t, svr, 2, "key"))
+	assert.Equal(t, "", getValue(t, svr, 3, "key"))
+}
+
+func TestLoadDatabaseDirs(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "bitcask")
+
+	// 文件不存在时第 i 个数据库使用编号 i
+	dirs, err := loadDatabaseDirs(dir, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{0, 1, 2, 3}, dirs)
+
+	// 数据库数量比文件中的多时补齐，少时保留多出的部分
+	assert.Nil(t, saveDatabaseDirs(dir, []int{1, 0, 2}))
+	dirs, err = loadDatabaseDirs(dir, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1, 0, 2, 3}, dirs)
+	dirs, err = loadDatabaseDirs(dir, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1, 0, 2}, dirs)
+
+	// 文件损坏时返回错误，不能按照默认的对应关系打开错误的数据目录
+	assert.Nil(t, os.WriteFile(databaseDirsFile(dir), []byte("1\nx\n"), 0644))
+	_, err = loadDatabaseDirs(dir, 4)
+	assert.NotNil(t, err)
+}
+
+func TestSelect(t *testing.T) {
+	svr := newTestServer(t, filepath.Join(t.TempDir(), "bitcask"), 4)
+	defer closeTestServer(t, svr)
+	db, err := svr.db(0)
+	assert.Nil(t, err)
+	cli := &BitcaskClient{server: svr, db: db}
+
+	_, err = selectCmd(cli, [][]byte{[]byte("3")})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, cli.index)
+	assert.Equal(t, svr.dbs[3], cli.db)
+
+	// 超出范围或者不是整数时不切换
+	for _, arg := range []string{"4", "-1", "100"} {
+		_, err = selectCmd(cli, [][]byte{[]byte(arg)})
+		assert.Equal(t, errDBIndexOutOfRange, err)
+	}
+	_, err = selectCmd(cli, [][]byte{[]byte("one")})
+	assert.Equal(t, errValueNotInteger, err)
+	_, err = selectCmd(cli, nil)
+	assert.NotNil(t, err)
+	assert.Equal(t, 3, cli.index)
+	assert.Equal(t, svr.dbs[3], cli.db)
+}
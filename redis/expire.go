@@ -0,0 +1,97 @@
+package redis
+
+import (
+	bitcask "SingleKVDataSet"
+	"time"
+)
+
+const (
+	// 主动过期检查的间隔
+	activeExpireInterval = 100 * time.Millisecond
+	// 每轮检查的 key 数量
+	activeExpireSamples = 20
+	// 每次检查最多占用的时间
+	activeExpireTimeLimit = 25 * time.Millisecond
+)
+
+// activeExpirer 在后台按照 key 的顺序分批检查，删除已经过期的 key
+// 过期的 key 只在读取时被当作不存在，如果一直没有被访问，需要由它从磁盘上删除
+type activeExpirer struct {
+	cursor []byte // 下一轮检查开始的位置，为 nil 时从头开始
+	task   *backgroundTask
+}
+
+// startActiveExpire 启动后台的主动过期检查，由 Close 停止
+func (rds *RedisDataStructure) startActiveExpire() {
+	rds.expirer = &activeExpirer{}
+	rds.expirer.task = startBackgroundTask(activeExpireInterval, func() {
+		// 和 Redis 一样，过期的比例超过 1/4 时继续检查下一轮，直到超出时间限制
+		deadline := time.Now().Add(activeExpireTimeLimit)
+		for {
+			sampled, expired, err := rds.activeExpireCycle(activeExpireSamples)
+			if err != nil || sampled == 0 || expired*4 <= sampled || time.Now().After(deadline) {
+				return
+			}
+		}
+	})
+}
+
+// activeExpireCycle 从上一轮结束的位置开始检查 count 个 key，返回检查的数量和删除的数量
+func (rds *RedisDataStructure) activeExpireCycle(count int) (sampled, expired int, err error) {
+	var candidates [][]byte
+	iter := rds.db.NewIterator(bitcask.DefaultIteratorOptions)
+	if rds.expirer.cursor == nil {
+		seekTopLevelKeys(iter)
+	} else {
+		iter.Seek(rds.expirer.cursor)
+	}
+	var last []byte
+	for ; isTopLevelKey(iter) && sampled < count; iter.Next() {
+		sampled++
+		last = append(last[:0], iter.Key()...)
+		value, err := iter.Value()
+		if err != nil {
+			iter.Close()
+			return sampled, expired, err
+		}
+		if isExpired(value) {
+			candidates = append(candidates, append([]byte{}, last...))
+		}
+	}
+	// 遍历完用户的 key 之后下一轮从头开始，否则从最后一个 key 之后开始
+	if isTopLevelKey(iter) {
+		rds.expirer.cursor = append(last, 0)
+	} else {
+		rds.expirer.cursor = nil
+	}
+	iter.Close()
+
+	for _, key := range candidates {
+		ok, err := rds.deleteIfExpired(key)
+		if err != nil {
+			return sampled, expired, err
+		}
+		if ok {
+			expired++
+		}
+	}
+	return sampled, expired, nil
+}
+
+// deleteIfExpired 持有锁之后重新检查 key，确认已经过期之后删除，key 为存储引擎中带有标记的 key
+func (rds *RedisDataStructure) deleteIfExpired(key []byte) (bool, error) {
+	rds.lockAll()
+	defer rds.unlockAll()
+
+	value, err := rds.db.Get(key)
+	if err == bitcask.ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !isExpired(value) {
+		return false, nil
+	}
+	return true, rds.db.Delete(key)
+}
@@ -0,0 +1,197 @@
+package redis
+
+import (
+	bitcask "SingleKVDataSet"
+	"SingleKVDataSet/utils"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRedisDataStructure_Expire(t *testing.T) {
+	rds := newTestRedisDataStructure(t)
+
+	// 所有类型都可以设置过期时间
+	err := rds.Set(utils.GetTestKey(1), 0, []byte("value"))
+	assert.Nil(t, err)
+	_, err = rds.HSet(utils.GetTestKey(2), []byte("field"), []byte("value"))
+	assert.Nil(t, err)
+	_, err = rds.SAdd(utils.GetTestKey(3), []byte("member"))
+	assert.Nil(t, err)
+	_, err = rds.RPush(utils.GetTestKey(4), []byte("element"))
+	assert.Nil(t, err)
+	_, err = rds.ZAdd(utils.GetTestKey(5), 1, []byte("member"))
+	assert.Nil(t, err)
+
+	for i := 1; i <= 5; i++ {
+		ttl, exist, err := rds.TTL(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.True(t, exist)
+		assert.Equal(t, time.Duration(0), ttl)
+
+		ok, err := rds.Expire(utils.GetTestKey(i), time.Hour)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		ttl, exist, err = rds.TTL(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.True(t, exist)
+		assert.True(t, ttl > 59*time.Minute && ttl <= time.Hour)
+	}
+
+	// 修改过期时间不影响数据
+	value, err := rds.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+	value, err = rds.HGet(utils.GetTestKey(2), []byte("field"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+	size, err := rds.LLen(utils.GetTestKey(4))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), size)
+	_, err = rds.RPush(utils.GetTestKey(4), []byte("element"))
+	assert.Nil(t, err)
+	ttl, _, err := rds.TTL(utils.GetTestKey(4))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 0)
+
+	// PERSIST
+	ok, err := rds.Persist(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = rds.Persist(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ttl, exist, err := rds.TTL(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.True(t, exist)
+	assert.Equal(t, time.Duration(0), ttl)
+
+	// 过期之后当作不存在
+	ok, err = rds.Expire(utils.GetTestKey(2), 10*time.Millisecond)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = rds.Expire(utils.GetTestKey(5), 10*time.Millisecond)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	time.Sleep(20 * time.Millisecond)
+	value, err = rds.HGet(utils.GetTestKey(2), []byte("field"))
+	assert.Nil(t, err)
+	assert.Nil(t, value)
+	_, err = rds.ZScore(utils.GetTestKey(5), []byte("member"))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+	_, exist, err = rds.TTL(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.False(t, exist)
+	ok, err = rds.Expire(utils.GetTestKey(2), time.Hour)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// 过期时间不晚于当前时间时直接删除
+	ok, err = rds.ExpireAt(utils.GetTestKey(1), time.Now().Add(-time.Second))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	_, err = rds.Get(utils.GetTestKey(1))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+
+	// 不存在的 key
+	ok, err = rds.Expire(utils.GetTestKey(6), time.Hour)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = rds.Persist(utils.GetTestKey(6))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestRedisDataStructure_ActiveExpire(t *testing.T) {
+	rds := newTestRedisDataStructure(t)
+	// 停止后台检查，手动执行每一轮
+	rds.expirer.task.close()
+
+	for i := 0; i < 50; i++ {
+		err := rds.Set(utils.GetTestKey(i), 10*time.Millisecond, []byte("value"))
+		assert.Nil(t, err)
+	}
+	_, err := rds.HSet([]byte("hash"), []byte("field"), []byte("value"))
+	assert.Nil(t, err)
+	ok, err := rds.Expire([]byte("hash"), 10*time.Millisecond)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	// 数据部分的值看起来像是已经过期的 String，不能被删除
+	_, err = rds.HSet([]byte("live"), []byte("field"), encodeStringValue([]byte("value"), 1))
+	assert.Nil(t, err)
+	err = rds.Set([]byte("persistent"), 0, []byte("value"))
+	assert.Nil(t, err)
+	time.Sleep(20 * time.Millisecond)
+
+	// 每一轮最多检查 count 个 key，遍历到末尾后从头开始
+	var total int
+	for i := 0; i < 10; i++ {
+		sampled, expired, err := rds.activeExpireCycle(20)
+		assert.Nil(t, err)
+		assert.True(t, sampled <= 20)
+		total += expired
+	}
+	assert.Equal(t, 51, total)
+
+	for i := 0; i < 50; i++ {
+		_, err := rds.db.Get(topLevelKey(utils.GetTestKey(i)))
+		assert.Equal(t, bitcask.ErrKeyNotFound, err)
+	}
+	_, err = rds.db.Get(topLevelKey([]byte("hash")))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+	value, err := rds.HGet([]byte("live"), []byte("field"))
+	assert.Nil(t, err)
+	assert.Equal(t, encodeStringValue([]byte("value"), 1), value)
+	value, err = rds.Get([]byte("persistent"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+
+	// 重复停止不会出错
+	assert.Nil(t, rds.Close())
+}
+
+func TestRedisDataStructure_ActiveExpire_Background(t *testing.T) {
+	rds := newTestRedisDataStructure(t)
+
+	err := rds.Set(utils.GetTestKey(1), 10*time.Millisecond, []byte("value"))
+	assert.Nil(t, err)
+	// 没有读取的情况下也会被后台检查删除
+	assert.Eventually(t, func() bool {
+		_, err := rds.db.Get(topLevelKey(utils.GetTestKey(1)))
+		return err == bitcask.ErrKeyNotFound
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestRedisDataStructure_Expire_OtherType(t *testing.T) {
+	rds := newTestRedisDataStructure(t)
+
+	// 过期的 key 当作不存在，可以写入其他类型的数据
+	_, err := rds.HSet(utils.GetTestKey(1), []byte("field"), []byte("value"))
+	assert.Nil(t, err)
+	_, err = rds.SAdd(utils.GetTestKey(2), []byte("member"))
+	assert.Nil(t, err)
+	for _, key := range [][]byte{utils.GetTestKey(1), utils.GetTestKey(2)} {
+		ok, err := rds.Expire(key, 10*time.Millisecond)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	ok, err := rds.SAdd(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	members, err := rds.SMembers(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a")}, members)
+	_, err = rds.HGet(utils.GetTestKey(1), []byte("field"))
+	assert.Equal(t, ErrWrongTypeOperation, err)
+	dataType, err := rds.Type(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, Set, dataType)
+
+	size, err := rds.RPush(utils.GetTestKey(2), []byte("element"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), size)
+	_, err = rds.SCard(utils.GetTestKey(2))
+	assert.Equal(t, ErrWrongTypeOperation, err)
+}
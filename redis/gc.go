@@ -0,0 +1,141 @@
+package redis
+
+import (
+	bitcask "SingleKVDataSet"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// 回收孤立的数据部分的间隔
+	subKeyGCInterval = time.Second
+	// 每轮检查的 key 数量
+	subKeyGCSamples = 1000
+	// 版本号在这段时间内创建的数据部分可能和元数据一起在写入，暂时不回收
+	subKeyGCGracePeriod = time.Minute
+)
+
+// GCStats 后台回收孤立的数据部分的统计信息
+type GCStats struct {
+	Passes    uint64 // 完整遍历所有 key 的次数
+	Scanned   uint64 // 已经检查过的 key 的数量
+	Reclaimed uint64 // 已经回收的 key 的数量
+}
+
+// subKeyGC 在后台按照 key 的顺序分批检查，回收孤立的数据部分
+// DEL、过期或者被其他类型覆盖之后只会删除或者修改元数据，旧版本的数据部分不会再被访问，需要由它删除
+type subKeyGC struct {
+	cursor      []byte        // 下一轮检查开始的位置，为 nil 时从头开始
+	gracePeriod time.Duration // 版本号在这段时间内创建的 key 不回收
+	passes      uint64
+	scanned     uint64
+	reclaimed   uint64
+	task        *backgroundTask
+}
+
+// startSubKeyGC 启动后台的回收，由 Close 停止
+func (rds *RedisDataStructure) startSubKeyGC() {
+	rds.gc = &subKeyGC{gracePeriod: subKeyGCGracePeriod}
+	rds.gc.task = startBackgroundTask(subKeyGCInterval, func() {
+		_, _, _ = rds.subKeyGCCycle(subKeyGCSamples)
+	})
+}
+
+// GCStats 返回后台回收孤立的数据部分的统计信息
+func (rds *RedisDataStructure) GCStats() GCStats {
+	return GCStats{
+		Passes:    atomic.LoadUint64(&rds.gc.passes),
+		Scanned:   atomic.LoadUint64(&rds.gc.scanned),
+		Reclaimed: atomic.LoadUint64(&rds.gc.reclaimed),
+	}
+}
+
+// subKeyGCCycle 从上一轮结束的位置开始检查 count 个 key，在同一个批次中删除其中孤立的数据部分
+// 返回检查的数量和回收的数量
+func (rds *RedisDataStructure) subKeyGCCycle(count int) (sampled, reclaimed int, err error) {
+	var candidates [][]byte
+	iter := rds.db.NewIterator(bitcask.DefaultIteratorOptions)
+	if rds.gc.cursor == nil {
+		iter.Seek([]byte{subKeyTag})
+	} else {
+		iter.Seek(rds.gc.cursor)
+	}
+	// 只需要遍历数据部分的 key，不需要读取值
+	var last []byte
+	now := time.Now().UnixNano()
+	for ; isSubKey(iter) && sampled < count; iter.Next() {
+		sampled++
+		last = append(last[:0], iter.Key()...)
+		if rds.mayBeOrphan(last, now) {
+			candidates = append(candidates, append([]byte{}, last...))
+		}
+	}
+	// 遍历完数据部分之后下一轮从头开始，否则从最后一个 key 之后开始
+	if isSubKey(iter) {
+		rds.gc.cursor = append(last, 0)
+	} else {
+		rds.gc.cursor = nil
+		atomic.AddUint64(&rds.gc.passes, 1)
+	}
+	iter.Close()
+	atomic.AddUint64(&rds.gc.scanned, uint64(sampled))
+
+	var orphans [][]byte
+	for _, key := range candidates {
+		orphan, err := rds.isOrphanSubKey(key)
+		if err != nil {
+			return sampled, 0, err
+		}
+		if orphan {
+			orphans = append(orphans, key)
+		}
+	}
+	if len(orphans) == 0 {
+		return sampled, 0, nil
+	}
+	wb := rds.newWriteBatch(len(orphans))
+	for _, key := range orphans {
+		_ = wb.Delete(key)
+	}
+	if err := wb.Commit(); err != nil {
+		return sampled, 0, err
+	}
+	atomic.AddUint64(&rds.gc.reclaimed, uint64(len(orphans)))
+	return sampled, len(orphans), nil
+}
+
+// isSubKey 迭代器是否还在数据部分的 key 的范围内
+func isSubKey(iter *bitcask.Iterator) bool {
+	return iter.Valid() && len(iter.Key()) > 0 && iter.Key()[0] == subKeyTag
+}
+
+// mayBeOrphan 只根据 key 判断是否需要检查，新创建的 Hash 等类型在同一个批次中写入元数据和数据部分，
+// 数据部分可能先于元数据被读到，版本号是最近的时间戳时跳过，等下一次遍历时再检查
+func (rds *RedisDataStructure) mayBeOrphan(encKey []byte, now int64) bool {
+	_, version, ok := decodeSubKey(encKey)
+	if !ok {
+		return true
+	}
+	diff := now - int64(version&^zsetScoreVersionFlag)
+	return diff >= int64(rds.gc.gracePeriod) || diff <= -int64(rds.gc.gracePeriod)
+}
+
+// isOrphanSubKey 数据部分所属的 key 不存在、不是 Hash 等类型或者版本号不同时，数据部分不会再被访问
+// 无法解析的 key 不属于任何 key，也一起回收
+func (rds *RedisDataStructure) isOrphanSubKey(encKey []byte) (bool, error) {
+	key, version, ok := decodeSubKey(encKey)
+	if !ok {
+		return true, nil
+	}
+	metaBuf, err := rds.db.Get(topLevelKey(key))
+	if err == bitcask.ErrKeyNotFound {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if metaBuf[0] == String {
+		return true, nil
+	}
+	return decodeMetadata(metaBuf).version != int64(version&^zsetScoreVersionFlag), nil
+}
@@ -0,0 +1,153 @@
+package redis
+
+import (
+	"SingleKVDataSet/utils"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// runGCPass 从头开始完整遍历一次所有 key，返回回收的数量
+func runGCPass(t *testing.T, rds *RedisDataStructure) int {
+	var total int
+	passes := rds.GCStats().Passes
+	for rds.GCStats().Passes == passes {
+		_, reclaimed, err := rds.subKeyGCCycle(5)
+		assert.Nil(t, err)
+		total += reclaimed
+	}
+	return total
+}
+
+func TestRedisDataStructure_SubKeyGC(t *testing.T) {
+	rds := newTestRedisDataStructure(t)
+	// 停止后台回收，手动执行每一轮
+	rds.gc.task.close()
+
+	// DEL 之后的数据部分
+	for i := 0; i < 3; i++ {
+		_, err := rds.HSet([]byte("hash"), utils.GetTestKey(i), utils.RandomValue(16))
+		assert.Nil(t, err)
+		_, err = rds.RPush([]byte("list"), utils.RandomValue(16))
+		assert.Nil(t, err)
+	}
+	_, err := rds.SAdd([]byte("set"), []byte("a"))
+	assert.Nil(t, err)
+	_, err = rds.SAdd([]byte("set"), []byte("b"))
+	assert.Nil(t, err)
+	for _, key := range []string{"hash", "list", "set"} {
+		_, err := rds.Del([]byte(key))
+		assert.Nil(t, err)
+	}
+
+	// 值和 String 类型的值或者元数据格式相同的数据部分，只根据 key 判断，DEL 之后同样被回收
+	_, err = rds.HSet([]byte("shaped"), []byte("string"), []byte("\x00\x00x"))
+	assert.Nil(t, err)
+	_, err = rds.HSet([]byte("shaped"), []byte("meta"), (&metadata{dataType: Hash, version: 1, size: 1}).encode())
+	assert.Nil(t, err)
+	_, err = rds.RPush([]byte("shaped-list"), encodeStringValue([]byte("value"), 0))
+	assert.Nil(t, err)
+	for _, key := range []string{"shaped", "shaped-list"} {
+		_, err := rds.Del([]byte(key))
+		assert.Nil(t, err)
+	}
+
+	// 被其他类型覆盖之后的数据部分，每个 member 有两个 key
+	_, err = rds.ZAdd([]byte("zset"), 1, []byte("a"))
+	assert.Nil(t, err)
+	_, err = rds.ZAdd([]byte("zset"), 2, []byte("b"))
+	assert.Nil(t, err)
+	err = rds.Set([]byte("zset"), 0, []byte("value"))
+	assert.Nil(t, err)
+
+	// 过期之后重新创建，旧版本的数据部分
+	_, err = rds.HSet([]byte("expired"), []byte("old"), []byte("value"))
+	assert.Nil(t, err)
+	_, err = rds.Expire([]byte("expired"), time.Millisecond)
+	assert.Nil(t, err)
+	time.Sleep(2 * time.Millisecond)
+	_, err = rds.HSet([]byte("expired"), []byte("new"), []byte("value"))
+	assert.Nil(t, err)
+
+	// 仍然有效的数据
+	_, err = rds.HSet([]byte("live"), []byte("field"), []byte("value"))
+	assert.Nil(t, err)
+	_, err = rds.HSet([]byte("live"), []byte("encoded"), encodeStringValue([]byte("value"), 0))
+	assert.Nil(t, err)
+	_, err = rds.SAdd([]byte("live-set"), []byte("a"))
+	assert.Nil(t, err)
+	_, err = rds.LPush([]byte("live-list"), []byte("a"))
+	assert.Nil(t, err)
+	_, err = rds.ZAdd([]byte("live-zset"), 1, []byte("a"))
+	assert.Nil(t, err)
+
+	// 刚刚创建的版本在保护期内，不会被回收
+	assert.Equal(t, 0, runGCPass(t, rds))
+
+	keyNum := rds.db.Stat().KeyNum
+	rds.gc.gracePeriod = 0
+	assert.Equal(t, 3+3+2+3+4+1, runGCPass(t, rds))
+	assert.Equal(t, keyNum-16, rds.db.Stat().KeyNum)
+	// 再次遍历时没有可以回收的 key
+	assert.Equal(t, 0, runGCPass(t, rds))
+
+	stats := rds.GCStats()
+	assert.Equal(t, uint64(3), stats.Passes)
+	assert.Equal(t, uint64(16), stats.Reclaimed)
+	assert.True(t, stats.Scanned > 0)
+
+	value, err := rds.HGet([]byte("live"), []byte("field"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+	value, err = rds.HGet([]byte("live"), []byte("encoded"))
+	assert.Nil(t, err)
+	assert.Equal(t, encodeStringValue([]byte("value"), 0), value)
+	value, err = rds.HGet([]byte("expired"), []byte("new"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+	ok, err := rds.SIsMember([]byte("live-set"), []byte("a"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	element, err := rds.LPop([]byte("live-list"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), element)
+	score, err := rds.ZScore([]byte("live-zset"), []byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, float64(1), score)
+	value, err = rds.Get([]byte("zset"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+}
+
+func TestDecodeSubKey(t *testing.T) {
+	version := time.Now().UnixNano()
+	// key 是另一个 key 的前缀时也能解析出正确的 key
+	for _, key := range [][]byte{[]byte("a"), []byte("ab"), {}, {0, 1, 2}, []byte(string(utils.RandomValue(200)))} {
+		encKeys := [][]byte{
+			(&hashInternalKey{key: key, version: version, field: []byte("field")}).encode(),
+			(&setInternalKey{key: key, version: version, member: []byte("member")}).encode(),
+			(&listInternalKey{key: key, version: version, index: initialListMark}).encode(),
+			(&zsetInternalKey{key: key, version: version, member: []byte("member")}).encodeWithMeber(),
+		}
+		for _, encKey := range encKeys {
+			k, v, ok := decodeSubKey(encKey)
+			assert.True(t, ok)
+			assert.Equal(t, key, k)
+			assert.Equal(t, uint64(version), v)
+		}
+		k, v, ok := decodeSubKey((&zsetInternalKey{key: key, version: version, score: 1.5}).encodeWithScore())
+		assert.True(t, ok)
+		assert.Equal(t, key, k)
+		assert.Equal(t, uint64(version)|zsetScoreVersionFlag, v)
+
+		prefix := versionPrefix(key, uint64(version))
+		_, _, ok = decodeSubKey(prefix[:len(prefix)-1])
+		assert.False(t, ok)
+		_, _, ok = decodeSubKey(topLevelKey(prefix[1:]))
+		assert.False(t, ok)
+	}
+	_, _, ok := decodeSubKey(nil)
+	assert.False(t, ok)
+	_, _, ok = decodeSubKey(dataFormatKey)
+	assert.False(t, ok)
+}
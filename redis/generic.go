@@ -1,18 +1,579 @@
 package redis
 
-import "errors"
+import (
+	bitcask "SingleKVDataSet"
+	"SingleKVDataSet/utils"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
 
-func (rds *RedisDataStructure) Del(key []byte) error {
-	return rds.db.Delete(key)
+// Del 删除任意类型的 key，返回删除前 key 是否存在
+// 需要持有所有类型的锁，否则在 DEL 之前读取了元数据的写操作会把元数据写回，使已经删除的 key 重新出现
+func (rds *RedisDataStructure) Del(key []byte) (bool, error) {
+	rds.lockAll()
+	defer rds.unlockAll()
+
+	exist, err := rds.exists(key)
+	if err != nil {
+		return false, err
+	}
+	// 已经过期或者没有数据的 key 也删除元数据，不计入删除数量
+	if err := rds.db.Delete(topLevelKey(key)); err != nil {
+		return false, err
+	}
+	return exist, nil
 }
 
 func (rds *RedisDataStructure) Type(key []byte) (redisDataType, error) {
-	encValue, err := rds.db.Get(key)
+	encValue, err := rds.db.Get(topLevelKey(key))
 	if err != nil {
 		return 0, err
 	}
 	if len(encValue) == 0 {
 		return 0, errors.New("value is null")
 	}
+	if !isAlive(encValue) {
+		return 0, bitcask.ErrKeyNotFound
+	}
 	return encValue[0], nil
 }
+
+// exists key 是否存在，适用于所有类型
+func (rds *RedisDataStructure) exists(key []byte) (bool, error) {
+	encValue, err := rds.db.Get(topLevelKey(key))
+	if err == bitcask.ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return isAlive(encValue), nil
+}
+
+// isAlive key 是否存在，即没有过期，并且不是已经没有数据的 Hash、Set、List 或者 ZSet
+func isAlive(encValue []byte) bool {
+	if isExpired(encValue) {
+		return false
+	}
+	return encValue[0] == String || decodeMetadata(encValue).size > 0
+}
+
+// isExpired 所有类型编码后的值都以数据类型和过期时间开头，String 类型的值和其他类型的元数据都可以直接判断
+func isExpired(encValue []byte) bool {
+	expire, _ := binary.Varint(encValue[1:])
+	return expire > 0 && expire <= time.Now().UnixNano()
+}
+
+// Exists 返回 keys 中存在的 key 的数量，重复的 key 重复计算
+func (rds *RedisDataStructure) Exists(keys ...[]byte) (int, error) {
+	var n int
+	for _, key := range keys {
+		exist, err := rds.exists(key)
+		if err != nil {
+			return 0, err
+		}
+		if exist {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Scan 从 cursor 开始按照 key 的顺序检查 count 个 key，返回其中匹配 match 并且是 dataTypes 中的类型的 key，以及下一次遍历的 cursor
+// cursor 为已经检查过的 key 数量，包括已经过期的 key，所以返回的 key 可能少于 count，返回 0 表示遍历结束
+// match 为空时不过滤，dataTypes 为空时返回所有类型
+func (rds *RedisDataStructure) Scan(cursor uint64, match []byte, count int, dataTypes ...redisDataType) (uint64, [][]byte, error) {
+	if count <= 0 {
+		count = defaultScanCount
+	}
+	iter := rds.db.NewIterator(bitcask.DefaultIteratorOptions)
+	defer iter.Close()
+
+	// 跳过已经检查过的 key，不需要读取值
+	var pos uint64
+	for seekTopLevelKeys(iter); isTopLevelKey(iter) && pos < cursor; iter.Next() {
+		pos++
+	}
+	var keys [][]byte
+	for ; isTopLevelKey(iter) && pos < cursor+uint64(count); iter.Next() {
+		pos++
+		dataType, ok, err := userKeyType(iter, match)
+		if err != nil {
+			return 0, nil, err
+		}
+		if ok && (len(dataTypes) == 0 || bytes.IndexByte(dataTypes, dataType) >= 0) {
+			keys = append(keys, append([]byte{}, iter.Key()[1:]...))
+		}
+	}
+	if !isTopLevelKey(iter) {
+		return 0, keys, nil
+	}
+	return pos, keys, nil
+}
+
+// Keys 返回所有匹配 pattern 的 key，pattern 为空时返回所有 key
+func (rds *RedisDataStructure) Keys(pattern []byte) ([][]byte, error) {
+	var keys [][]byte
+	err := rds.scanUserKeys(pattern, func(key []byte) {
+		keys = append(keys, append([]byte{}, key...))
+	})
+	return keys, err
+}
+
+// DBSize 返回 key 的数量，需要遍历所有 key
+func (rds *RedisDataStructure) DBSize() (int, error) {
+	var n int
+	err := rds.scanUserKeys(nil, func([]byte) {
+		n++
+	})
+	return n, err
+}
+
+// RandomKey 随机返回一个 key，没有 key 时返回 nil
+// 从随机的位置开始向后查找第一个没有过期的 key，到末尾后再从头开始
+func (rds *RedisDataStructure) RandomKey() ([]byte, error) {
+	iter := rds.db.NewIterator(bitcask.DefaultIteratorOptions)
+	defer iter.Close()
+
+	// 存储引擎中的 key 还包括数据部分，先数出用户的 key 的数量，不需要读取值
+	var total int
+	for seekTopLevelKeys(iter); isTopLevelKey(iter); iter.Next() {
+		total++
+	}
+	if total == 0 {
+		return nil, nil
+	}
+	start := rand.Intn(total)
+
+	var pos int
+	for seekTopLevelKeys(iter); isTopLevelKey(iter) && pos < start; iter.Next() {
+		pos++
+	}
+	for round := 0; round < 2; round++ {
+		for ; isTopLevelKey(iter); iter.Next() {
+			// 第二轮从头开始，回到起始位置时说明已经检查过所有 key
+			if round == 1 && pos >= start {
+				return nil, nil
+			}
+			pos++
+			_, ok, err := userKeyType(iter, nil)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				return append([]byte{}, iter.Key()[1:]...), nil
+			}
+		}
+		seekTopLevelKeys(iter)
+		pos = 0
+	}
+	return nil, nil
+}
+
+// Rename 将 key 改名为 newKey，newKey 已经存在时被覆盖，保留原有的过期时间
+// Hash、Set、List 和 ZSet 的数据部分会复制到 newKey 的新版本下，旧版本的数据部分在同一个批次中删除
+func (rds *RedisDataStructure) Rename(key, newKey []byte) error {
+	_, err := rds.rename(key, newKey, false)
+	return err
+}
+
+// RenameNX 只在 newKey 不存在时将 key 改名为 newKey，返回是否改名
+func (rds *RedisDataStructure) RenameNX(key, newKey []byte) (bool, error) {
+	return rds.rename(key, newKey, true)
+}
+
+func (rds *RedisDataStructure) rename(key, newKey []byte, nx bool) (bool, error) {
+	rds.lockAll()
+	defer rds.unlockAll()
+
+	encValue, err := rds.db.Get(topLevelKey(key))
+	if err != nil && err != bitcask.ErrKeyNotFound {
+		return false, err
+	}
+	if err == bitcask.ErrKeyNotFound || !isAlive(encValue) {
+		return false, ErrNoSuchKey
+	}
+	if nx {
+		n, err := rds.Exists(newKey)
+		if err != nil || n > 0 {
+			return false, err
+		}
+	}
+	if bytes.Equal(key, newKey) {
+		return true, nil
+	}
+
+	if encValue[0] == String {
+		wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
+		_ = wb.Put(topLevelKey(newKey), encValue)
+		_ = wb.Delete(topLevelKey(key))
+		return true, wb.Commit()
+	}
+
+	meta := decodeMetadata(encValue)
+	subKeys, err := rds.scanSubKeys(key, meta)
+	if err != nil {
+		return false, err
+	}
+	newMeta := *meta
+	newMeta.version = time.Now().UnixNano()
+
+	wb := rds.newWriteBatch(2*len(subKeys) + 2)
+	for _, sk := range subKeys {
+		_ = wb.Delete(sk.key)
+		_ = wb.Put(sk.rekey(newKey, newMeta.version), sk.value)
+	}
+	_ = wb.Delete(topLevelKey(key))
+	_ = wb.Put(topLevelKey(newKey), newMeta.encode())
+	if err := wb.Commit(); err != nil {
+		return false, err
+	}
+	if meta.dataType == List {
+		rds.waiters.notify(newKey)
+	}
+	return true, nil
+}
+
+// moveLock 同一时间只进行一个 Move，Move 需要同时持有两个数据库的锁，避免相反方向的 Move 死锁
+var moveLock sync.Mutex
+
+// Move 将 key 移动到另一个数据库 dst，key 不存在或者 dst 中已经存在 key 时不移动，返回是否移动
+// 先写入 dst 再从当前数据库删除，中途失败时 key 可能同时存在于两个数据库中，但不会丢失
+func (rds *RedisDataStructure) Move(key []byte, dst *RedisDataStructure) (bool, error) {
+	if rds == dst {
+		return false, nil
+	}
+	moveLock.Lock()
+	defer moveLock.Unlock()
+	rds.lockAll()
+	defer rds.unlockAll()
+	dst.lockAll()
+	defer dst.unlockAll()
+
+	encValue, err := rds.db.Get(topLevelKey(key))
+	if err == bitcask.ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !isAlive(encValue) {
+		return false, nil
+	}
+	if exist, err := dst.exists(key); err != nil || exist {
+		return false, err
+	}
+
+	if encValue[0] == String {
+		if err := dst.db.Put(topLevelKey(key), encValue); err != nil {
+			return false, err
+		}
+		return true, rds.db.Delete(topLevelKey(key))
+	}
+
+	meta := decodeMetadata(encValue)
+	subKeys, err := rds.scanSubKeys(key, meta)
+	if err != nil {
+		return false, err
+	}
+	// dst 中可能有同名的已经过期的 key，使用新的版本号，避免和它的数据部分混在一起
+	newMeta := *meta
+	newMeta.version = time.Now().UnixNano()
+	dstBatch := dst.newWriteBatch(len(subKeys) + 1)
+	srcBatch := rds.newWriteBatch(len(subKeys) + 1)
+	for _, sk := range subKeys {
+		_ = dstBatch.Put(sk.rekey(key, newMeta.version), sk.value)
+		_ = srcBatch.Delete(sk.key)
+	}
+	_ = dstBatch.Put(topLevelKey(key), newMeta.encode())
+	_ = srcBatch.Delete(topLevelKey(key))
+	if err := dstBatch.Commit(); err != nil {
+		return false, err
+	}
+	if err := srcBatch.Commit(); err != nil {
+		return false, err
+	}
+	if meta.dataType == List {
+		dst.waiters.notify(key)
+	}
+	return true, nil
+}
+
+// subKey Hash、Set、List 或者 ZSet 的一个数据部分
+type subKey struct {
+	key    []byte // 完整的 key，即 key + version + 后缀
+	suffix []byte // version 之后的部分
+	flag   uint64 // 版本号上的标记，ZSet 按照 score 排序的部分为 zsetScoreVersionFlag
+	value  []byte
+}
+
+// rekey 返回数据部分移动到 key 的 version 版本下之后的 key
+func (sk *subKey) rekey(key []byte, version int64) []byte {
+	return append(versionPrefix(key, uint64(version)|sk.flag), sk.suffix...)
+}
+
+// scanSubKeys 读取 key 当前版本的所有数据部分
+// 数据部分的 key 都是 key + version + 后缀，ZSet 按照 score 排序的部分版本号的最高位为 1
+func (rds *RedisDataStructure) scanSubKeys(key []byte, meta *metadata) ([]subKey, error) {
+	flags := []uint64{0}
+	if meta.dataType == ZSet {
+		flags = append(flags, zsetScoreVersionFlag)
+	}
+	var subKeys []subKey
+	for _, flag := range flags {
+		prefix := versionPrefix(key, uint64(meta.version)|flag)
+		err := rds.scanPrefix(prefix, true, func(k, value []byte) bool {
+			subKeys = append(subKeys, subKey{key: k, suffix: k[len(prefix):], flag: flag, value: value})
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return subKeys, nil
+}
+
+// FlushDB 删除所有数据，保留数据格式的版本号等内部使用的 key
+func (rds *RedisDataStructure) FlushDB() error {
+	rds.lockAll()
+	defer rds.unlockAll()
+
+	for _, tag := range []byte{topLevelKeyTag, subKeyTag} {
+		for {
+			// 分批删除，每一批删除之后重新开始遍历
+			var keys [][]byte
+			opts := bitcask.DefaultWriteBatchOptions
+			err := rds.scanPrefix([]byte{tag}, false, func(key, _ []byte) bool {
+				keys = append(keys, key)
+				return uint(len(keys)) < opts.MaxBatchNum
+			})
+			if err != nil {
+				return err
+			}
+			if len(keys) == 0 {
+				break
+			}
+			wb := rds.db.NewWriteBatch(opts)
+			for _, key := range keys {
+				_ = wb.Delete(key)
+			}
+			if err := wb.Commit(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// scanUserKeys 按照 key 的顺序遍历所有匹配 match 的 key，跳过已经过期的 key
+func (rds *RedisDataStructure) scanUserKeys(match []byte, fn func(key []byte)) error {
+	iter := rds.db.NewIterator(bitcask.DefaultIteratorOptions)
+	defer iter.Close()
+	for seekTopLevelKeys(iter); isTopLevelKey(iter); iter.Next() {
+		_, ok, err := userKeyType(iter, match)
+		if err != nil {
+			return err
+		}
+		if ok {
+			fn(iter.Key()[1:])
+		}
+	}
+	return nil
+}
+
+// seekTopLevelKeys 将迭代器定位到第一个用户的 key
+func seekTopLevelKeys(iter *bitcask.Iterator) {
+	iter.Seek([]byte{topLevelKeyTag})
+}
+
+// isTopLevelKey 迭代器是否还在用户的 key 的范围内，之后是数据部分和内部使用的 key
+func isTopLevelKey(iter *bitcask.Iterator) bool {
+	return iter.Valid() && len(iter.Key()) > 0 && iter.Key()[0] == topLevelKeyTag
+}
+
+// userKeyType 判断迭代器当前位置的用户的 key 是否匹配 match 并且没有过期，并返回它的类型
+func userKeyType(iter *bitcask.Iterator, match []byte) (redisDataType, bool, error) {
+	// 先匹配 key，不匹配时不需要读取值
+	if len(match) > 0 && !utils.GlobMatch(match, iter.Key()[1:]) {
+		return 0, false, nil
+	}
+	value, err := iter.Value()
+	if err != nil {
+		return 0, false, err
+	}
+	if !isAlive(value) {
+		return 0, false, nil
+	}
+	return value[0], true, nil
+}
+
+// Expire 设置 key 在 ttl 之后过期，适用于所有类型，key 不存在时返回 false
+func (rds *RedisDataStructure) Expire(key []byte, ttl time.Duration) (bool, error) {
+	return rds.ExpireAt(key, time.Now().Add(ttl))
+}
+
+// ExpireAt 设置 key 在 at 时过期，at 不晚于当前时间时直接删除 key
+func (rds *RedisDataStructure) ExpireAt(key []byte, at time.Time) (bool, error) {
+	return rds.setExpire(key, at.UnixNano())
+}
+
+// Persist 移除 key 的过期时间，key 不存在或者没有过期时间时返回 false
+func (rds *RedisDataStructure) Persist(key []byte) (bool, error) {
+	return rds.setExpire(key, 0)
+}
+
+// TTL 返回 key 的剩余生存时间，key 不存在时 exist 为 false，没有过期时间时返回 0
+func (rds *RedisDataStructure) TTL(key []byte) (ttl time.Duration, exist bool, err error) {
+	encValue, err := rds.db.Get(topLevelKey(key))
+	if err == bitcask.ErrKeyNotFound {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if !isAlive(encValue) {
+		return 0, false, nil
+	}
+	if expire, _ := binary.Varint(encValue[1:]); expire > 0 {
+		ttl = time.Duration(expire - time.Now().UnixNano())
+	}
+	return ttl, true, nil
+}
+
+// setExpire 修改 key 的过期时间，expire 为 0 时移除过期时间
+func (rds *RedisDataStructure) setExpire(key []byte, expire int64) (bool, error) {
+	rds.lockAll()
+	defer rds.unlockAll()
+
+	encValue, err := rds.db.Get(topLevelKey(key))
+	if err == bitcask.ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !isAlive(encValue) {
+		return false, nil
+	}
+	oldExpire, n := binary.Varint(encValue[1:])
+	if expire == 0 && oldExpire == 0 {
+		return false, nil
+	}
+	if expire != 0 && expire <= time.Now().UnixNano() {
+		return true, rds.db.Delete(topLevelKey(key))
+	}
+
+	// String 类型的值和其他类型的元数据都是 数据类型 + 过期时间 + 其余部分，只需要替换过期时间
+	buf := make([]byte, 1+binary.MaxVarintLen64, 1+binary.MaxVarintLen64+len(encValue)-1-n)
+	buf[0] = encValue[0]
+	buf = append(buf[:1+binary.PutVarint(buf[1:], expire)], encValue[1+n:]...)
+	if err := rds.db.Put(topLevelKey(key), buf); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// lockAll 同时持有 List、Hash、Set、ZSet 和 String 的锁，修改任意类型的 key 时使用，总是按照这个顺序加锁，避免死锁
+func (rds *RedisDataStructure) lockAll() {
+	rds.listLock.Lock()
+	rds.hashLock.Lock()
+	rds.setLock.Lock()
+	rds.zsetLock.Lock()
+	rds.stringLock.Lock()
+}
+
+func (rds *RedisDataStructure) unlockAll() {
+	rds.stringLock.Unlock()
+	rds.zsetLock.Unlock()
+	rds.setLock.Unlock()
+	rds.hashLock.Unlock()
+	rds.listLock.Unlock()
+}
+
+// lockStringWrite 写入 String 类型的值之前加锁，返回对应的解锁函数
+// keys 中有其他类型的 key 时，覆盖它会和这个类型读取后修改的命令冲突，需要和 Rename 一样持有所有类型的锁
+func (rds *RedisDataStructure) lockStringWrite(keys ...[]byte) (func(), error) {
+	for {
+		replace, err := rds.hasOtherType(keys)
+		if err != nil {
+			return nil, err
+		}
+		if replace {
+			rds.lockAll()
+			return rds.unlockAll, nil
+		}
+		rds.stringLock.Lock()
+		// 检查之后加锁之前 key 可能被其他类型的命令写入，持有锁之后再检查一次
+		if replace, err = rds.hasOtherType(keys); err != nil || replace {
+			rds.stringLock.Unlock()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return rds.stringLock.Unlock, nil
+	}
+}
+
+// hasOtherType keys 中是否有不是 String 类型的 key，已经过期的 key 也算在内
+func (rds *RedisDataStructure) hasOtherType(keys [][]byte) (bool, error) {
+	for _, key := range keys {
+		encValue, err := rds.db.Get(topLevelKey(key))
+		if err == bitcask.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if encValue[0] != String {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// backgroundTask 按照固定的间隔在后台执行的任务
+type backgroundTask struct {
+	stop chan struct{}
+	done chan struct{}
+	once *sync.Once
+}
+
+func startBackgroundTask(interval time.Duration, fn func()) *backgroundTask {
+	task := &backgroundTask{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+		once: new(sync.Once),
+	}
+	go func() {
+		defer close(task.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-task.stop:
+				return
+			case <-ticker.C:
+				fn()
+			}
+		}
+	}()
+	return task
+}
+
+// close 停止任务，并等待正在执行的 fn 返回，可以重复调用
+func (task *backgroundTask) close() {
+	task.once.Do(func() {
+		close(task.stop)
+		<-task.done
+	})
+}
+
+// Stat 返回存储引擎的统计信息
+func (rds *RedisDataStructure) Stat() *bitcask.Stat {
+	return rds.db.Stat()
+}
@@ -0,0 +1,345 @@
+package redis
+
+import (
+	bitcask "SingleKVDataSet"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// prepareKeyspace 写入每种类型的数据，以及已经过期和已经没有数据的 key
+func prepareKeyspace(t *testing.T, rds *RedisDataStructure) {
+	err := rds.Set([]byte("user:1:name"), 0, []byte("alice"))
+	assert.Nil(t, err)
+	_, err = rds.HSet([]byte("user:1:profile"), []byte("age"), []byte("20"))
+	assert.Nil(t, err)
+	_, err = rds.HSet([]byte("user:1:profile"), []byte("city"), []byte("beijing"))
+	assert.Nil(t, err)
+	_, err = rds.SAdd([]byte("user:1:tags"), []byte("a"))
+	assert.Nil(t, err)
+	_, err = rds.RPush([]byte("queue"), []byte("job-1"))
+	assert.Nil(t, err)
+	_, err = rds.RPush([]byte("queue"), []byte("job-2"))
+	assert.Nil(t, err)
+	_, err = rds.ZAdd([]byte("rank"), 1, []byte("a"))
+	assert.Nil(t, err)
+	_, err = rds.ZAdd([]byte("rank"), 2, []byte("b"))
+	assert.Nil(t, err)
+
+	err = rds.Set([]byte("expired"), time.Millisecond, []byte("value"))
+	assert.Nil(t, err)
+	_, err = rds.SAdd([]byte("empty"), []byte("a"))
+	assert.Nil(t, err)
+	_, err = rds.SRem([]byte("empty"), []byte("a"))
+	assert.Nil(t, err)
+	time.Sleep(2 * time.Millisecond)
+}
+
+func sortedStrings(keys [][]byte) []string {
+	res := make([]string, len(keys))
+	for i, key := range keys {
+		res[i] = string(key)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func TestRedisDataStructure_Keys_Scan(t *testing.T) {
+	rds := newTestRedisDataStructure(t)
+	prepareKeyspace(t, rds)
+
+	// 不返回数据部分、已经过期和已经没有数据的 key
+	keys, err := rds.Keys(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"queue", "rank", "user:1:name", "user:1:profile", "user:1:tags"}, sortedStrings(keys))
+	keys, err = rds.Keys([]byte("user:*"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"user:1:name", "user:1:profile", "user:1:tags"}, sortedStrings(keys))
+
+	size, err := rds.DBSize()
+	assert.Nil(t, err)
+	assert.Equal(t, 5, size)
+
+	n, err := rds.Exists([]byte("queue"), []byte("rank"), []byte("queue"), []byte("expired"), []byte("empty"), []byte("none"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+
+	// 每次最多检查 count 个 key，包括数据部分
+	var all [][]byte
+	var cursor uint64
+	for {
+		next, res, err := rds.Scan(cursor, nil, 3)
+		assert.Nil(t, err)
+		assert.True(t, len(res) <= 3)
+		all = append(all, res...)
+		if next == 0 {
+			break
+		}
+		assert.Equal(t, cursor+3, next)
+		cursor = next
+	}
+	assert.Equal(t, []string{"queue", "rank", "user:1:name", "user:1:profile", "user:1:tags"}, sortedStrings(all))
+
+	// MATCH 和 TYPE
+	next, res, err := rds.Scan(0, []byte("user:*"), 100, Hash, Set)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), next)
+	assert.Equal(t, []string{"user:1:profile", "user:1:tags"}, sortedStrings(res))
+	_, res, err = rds.Scan(0, nil, 100, List)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"queue"}, sortedStrings(res))
+
+	typ, err := rds.Type([]byte("rank"))
+	assert.Nil(t, err)
+	assert.Equal(t, ZSet, typ)
+	_, err = rds.Type([]byte("expired"))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+}
+
+func TestRedisDataStructure_Keys_SubKeyValues(t *testing.T) {
+	rds := newTestRedisDataStructure(t)
+	rds.gc.task.close()
+
+	// 值和 String 类型的值格式相同的数据部分，不论所属的 key 是否存在，都不是用户的 key
+	shaped := []byte("\x00\x00x")
+	_, err := rds.HSet([]byte("h"), []byte("f"), shaped)
+	assert.Nil(t, err)
+	_, err = rds.HSet([]byte("live"), []byte("f"), shaped)
+	assert.Nil(t, err)
+	meta, err := rds.findMetadata([]byte("h"), Hash)
+	assert.Nil(t, err)
+	_, err = rds.Del([]byte("h"))
+	assert.Nil(t, err)
+
+	keys, err := rds.Keys(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"live"}, sortedStrings(keys))
+	size, err := rds.DBSize()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, size)
+	cursor, keys, err := rds.Scan(0, nil, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), cursor)
+	assert.Equal(t, []string{"live"}, sortedStrings(keys))
+	for i := 0; i < 20; i++ {
+		key, err := rds.RandomKey()
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("live"), key)
+	}
+
+	// 数据部分在存储引擎中的 key 去掉标记之后也不是用户的 key
+	subKey := (&hashInternalKey{key: []byte("h"), version: meta.version, field: []byte("f")}).encode()
+	for _, key := range [][]byte{subKey, subKey[1:]} {
+		_, err = rds.Get(key)
+		assert.Equal(t, bitcask.ErrKeyNotFound, err)
+		n, err := rds.Exists(key)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, n)
+	}
+}
+
+func TestRedisDataStructure_Del_Concurrent(t *testing.T) {
+	rds := newTestRedisDataStructure(t)
+	key := []byte("hash")
+
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; !stop.Load(); i++ {
+				_, err := rds.HSet(key, []byte(fmt.Sprintf("field-%d-%d", g, i)), []byte("v"))
+				assert.Nil(t, err)
+			}
+		}(g)
+	}
+
+	// DEL 之后 HSET 只能创建新版本的 Hash，在 DEL 之前读取了元数据的 HSET 不能把旧版本的元数据写回，使删除的 field 重新出现
+	deleted := make(map[int64]bool)
+	for len(deleted) < 200 {
+		meta, err := rds.findMetadata(key, Hash)
+		assert.Nil(t, err)
+		if meta.size == 0 {
+			continue
+		}
+		assert.False(t, deleted[meta.version], "deleted hash version %d came back", meta.version)
+		exist, err := rds.Del(key)
+		assert.Nil(t, err)
+		assert.True(t, exist)
+		deleted[meta.version] = true
+	}
+	stop.Store(true)
+	wg.Wait()
+}
+
+func TestRedisDataStructure_Rename(t *testing.T) {
+	rds := newTestRedisDataStructure(t)
+	prepareKeyspace(t, rds)
+
+	ok, err := rds.Expire([]byte("user:1:profile"), time.Hour)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	err = rds.Rename([]byte("user:1:name"), []byte("name"))
+	assert.Nil(t, err)
+	err = rds.Rename([]byte("user:1:profile"), []byte("profile"))
+	assert.Nil(t, err)
+	err = rds.Rename([]byte("user:1:tags"), []byte("tags"))
+	assert.Nil(t, err)
+	// 覆盖已经存在的 key
+	err = rds.Rename([]byte("queue"), []byte("name"))
+	assert.Nil(t, err)
+	err = rds.Rename([]byte("rank"), []byte("r"))
+	assert.Nil(t, err)
+
+	keys, err := rds.Keys(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"name", "profile", "r", "tags"}, sortedStrings(keys))
+
+	// 数据部分都移动到了新的 key 下
+	values, err := rds.HGetAll([]byte("profile"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("age"), []byte("20"), []byte("city"), []byte("beijing")}, values)
+	ttl, exist, err := rds.TTL([]byte("profile"))
+	assert.Nil(t, err)
+	assert.True(t, exist)
+	assert.True(t, ttl > 59*time.Minute)
+	ok, err = rds.SIsMember([]byte("tags"), []byte("a"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	elements, err := rds.LRange([]byte("name"), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("job-1"), []byte("job-2")}, elements)
+	members, err := rds.ZRangeByScore([]byte("r"), ScoreBound{Score: 2}, ScoreBound{Score: 2}, 0, -1, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []ZMember{{Member: []byte("b"), Score: 2}}, members)
+
+	// 旧的 key 和数据部分都被删除了
+	values, err = rds.HGetAll([]byte("user:1:profile"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(values))
+	size, err := rds.DBSize()
+	assert.Nil(t, err)
+	assert.Equal(t, 4, size)
+
+	err = rds.Rename([]byte("none"), []byte("other"))
+	assert.Equal(t, ErrNoSuchKey, err)
+	err = rds.Rename([]byte("expired"), []byte("other"))
+	assert.Equal(t, ErrNoSuchKey, err)
+
+	ok, err = rds.RenameNX([]byte("tags"), []byte("r"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = rds.RenameNX([]byte("tags"), []byte("tags"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = rds.RenameNX([]byte("tags"), []byte("new-tags"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
+
+func TestRedisDataStructure_RandomKey_FlushDB(t *testing.T) {
+	rds := newTestRedisDataStructure(t)
+
+	key, err := rds.RandomKey()
+	assert.Nil(t, err)
+	assert.Nil(t, key)
+
+	prepareKeyspace(t, rds)
+	seen := make(map[string]bool)
+	for i := 0; i < 200; i++ {
+		key, err := rds.RandomKey()
+		assert.Nil(t, err)
+		seen[string(key)] = true
+	}
+	for key := range seen {
+		assert.Contains(t, []string{"queue", "rank", "user:1:name", "user:1:profile", "user:1:tags"}, key)
+	}
+	assert.True(t, len(seen) > 1)
+
+	err = rds.FlushDB()
+	assert.Nil(t, err)
+	// 只保留数据格式的版本号
+	assert.Equal(t, uint(1), rds.db.Stat().KeyNum)
+	_, err = rds.db.Get(dataFormatKey)
+	assert.Nil(t, err)
+	key, err = rds.RandomKey()
+	assert.Nil(t, err)
+	assert.Nil(t, key)
+	size, err := rds.DBSize()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, size)
+}
+
+func TestRedisDataStructure_Move(t *testing.T) {
+	src := newTestRedisDataStructure(t)
+	dst := newTestRedisDataStructure(t)
+	// 停止后台删除过期的 key，下面需要统计剩余的 key 的数量
+	src.expirer.task.close()
+	prepareKeyspace(t, src)
+
+	for _, key := range []string{"user:1:name", "user:1:profile", "user:1:tags", "queue", "rank"} {
+		ok, err := src.Move([]byte(key), dst)
+		assert.Nil(t, err)
+		assert.True(t, ok, key)
+	}
+	// 不存在、已经过期或者已经没有数据的 key 不移动
+	for _, key := range []string{"none", "expired", "empty"} {
+		ok, err := src.Move([]byte(key), dst)
+		assert.Nil(t, err)
+		assert.False(t, ok, key)
+	}
+
+	// 当前数据库中的 key 和数据部分都被删除了，只剩下数据格式的版本号
+	assert.Equal(t, uint(1), src.db.Stat().KeyNum-countRawKeys(t, src, "expired", "empty"))
+	keys, err := dst.Keys(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"queue", "rank", "user:1:name", "user:1:profile", "user:1:tags"}, sortedStrings(keys))
+
+	value, err := dst.Get([]byte("user:1:name"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("alice"), value)
+	values, err := dst.HGetAll([]byte("user:1:profile"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("age"), []byte("20"), []byte("city"), []byte("beijing")}, values)
+	elements, err := dst.LRange([]byte("queue"), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("job-1"), []byte("job-2")}, elements)
+	rank, ok, err := dst.ZRank([]byte("rank"), []byte("b"), false)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), rank)
+
+	// 目标数据库中已经存在时不移动
+	err = src.Set([]byte("user:1:name"), 0, []byte("bob"))
+	assert.Nil(t, err)
+	ok, err = src.Move([]byte("user:1:name"), dst)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	value, err = src.Get([]byte("user:1:name"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bob"), value)
+}
+
+// countRawKeys 返回存储引擎中属于 keys 的 key 的数量，包括所有版本的数据部分
+func countRawKeys(t *testing.T, rds *RedisDataStructure, keys ...string) uint {
+	var n uint
+	for _, key := range keys {
+		if _, err := rds.db.Get(topLevelKey([]byte(key))); err == nil {
+			n++
+		}
+		// 去掉 version，只保留 标记 + key 的长度 + key
+		prefix := versionPrefix([]byte(key), 0)
+		err := rds.scanPrefix(prefix[:len(prefix)-8], false, func([]byte, []byte) bool {
+			n++
+			return true
+		})
+		assert.Nil(t, err)
+	}
+	return n
+}
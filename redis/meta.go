@@ -11,8 +11,23 @@ const (
 	extraListMetaSize = binary.MaxVarintLen64 * 2
 
 	initialListMark = math.MaxUint64 / 2
+
+	// 版本号由时间戳生成，最高位总是 0，按照 score 排序的 key 将版本号的最高位置 1，
+	// 避免和 member 部分的 key（key + version + member）有相同的前缀
+	zsetScoreVersionFlag = 1 << 63
+
+	// 存储引擎中的每个 key 都以一个字节的标记开头，只看 key 就能区分用途，不需要根据值的格式猜测
+	topLevelKeyTag = 0 // 用户的 key，值为 String 类型的值或者元数据
+	subKeyTag      = 1 // Hash、Set、List 和 ZSet 的数据部分
+	internalKeyTag = 2 // 内部使用的 key，不属于任何用户的 key
+
+	// 数据格式的版本号，保存在 dataFormatKey 中，格式不兼容的变化需要增加版本号
+	// 没有版本号的旧数据中 key 没有标记，按照 score 排序的 key 的 score 也不是保持数值顺序的编码，无法直接读取
+	dataFormatVersion = 1
 )
 
+var dataFormatKey = append([]byte{internalKeyTag}, "data-format-version"...)
+
 type metadata struct {
 	dataType byte   // 数据类型
 	expire   int64  // 过期时间
@@ -70,6 +85,47 @@ func decodeMetadata(buf []byte) *metadata {
 	}
 }
 
+// topLevelKey 用户的 key 在存储引擎中的 key，值为 String 类型的值或者元数据
+func topLevelKey(key []byte) []byte {
+	buf := make([]byte, 1+len(key))
+	buf[0] = topLevelKeyTag
+	copy(buf[1:], key)
+	return buf
+}
+
+// versionPrefix Hash、Set、List 和 ZSet 的数据部分的 key 共享的前缀，即 标记 + key 的长度 + key + version
+func versionPrefix(key []byte, version uint64) []byte {
+	return appendVersionPrefix(make([]byte, 0, versionPrefixSize(key)), key, version)
+}
+
+func versionPrefixSize(key []byte) int {
+	return 1 + binary.MaxVarintLen32 + len(key) + 8
+}
+
+// appendVersionPrefix 将数据部分的前缀追加到 buf 之后，key 的长度使得数据部分的 key 可以解析出所属的 key
+func appendVersionPrefix(buf, key []byte, version uint64) []byte {
+	buf = append(buf, subKeyTag)
+	buf = binary.AppendUvarint(buf, uint64(len(key)))
+	buf = append(buf, key...)
+	return binary.LittleEndian.AppendUint64(buf, version)
+}
+
+// decodeSubKey 从数据部分的 key 中解析出所属的 key 和 version，version 中保留 zsetScoreVersionFlag
+// encKey 不是完整的数据部分的 key 时 ok 为 false
+func decodeSubKey(encKey []byte) (key []byte, version uint64, ok bool) {
+	if len(encKey) == 0 || encKey[0] != subKeyTag {
+		return nil, 0, false
+	}
+	keyLen, n := binary.Uvarint(encKey[1:])
+	if n <= 0 || keyLen > uint64(len(encKey)-1-n) || uint64(len(encKey)-1-n)-keyLen < 8 {
+		return nil, 0, false
+	}
+	index := 1 + n
+	key = encKey[index : index+int(keyLen)]
+	index += int(keyLen)
+	return key, binary.LittleEndian.Uint64(encKey[index : index+8]), true
+}
+
 type hashInternalKey struct {
 	key     []byte
 	version int64
@@ -77,21 +133,16 @@ type hashInternalKey struct {
 }
 
 func (hk *hashInternalKey) encode() []byte {
-	buf := make([]byte, len(hk.key)+len(hk.field)+8)
-
-	// key
-	var index = 0
-	copy(buf[index:index+len(hk.key)], hk.key)
-	index += len(hk.key)
-
-	// version
-	binary.LittleEndian.PutUint64(buf[index:index+8], uint64(hk.version))
-	index += 8
-
+	buf := make([]byte, 0, versionPrefixSize(hk.key)+len(hk.field))
+	// 标记 + key + version
+	buf = appendVersionPrefix(buf, hk.key, uint64(hk.version))
 	// field
-	copy(buf[index:index+len(hk.field)], hk.field)
+	return append(buf, hk.field...)
+}
 
-	return buf
+// prefix 同一个 Hash 中所有 field 共享的前缀，即 key + version
+func (hk *hashInternalKey) prefix() []byte {
+	return versionPrefix(hk.key, uint64(hk.version))
 }
 
 type setInternalKey struct {
@@ -101,24 +152,23 @@ type setInternalKey struct {
 }
 
 func (sk *setInternalKey) encode() []byte {
-	buf := make([]byte, len(sk.key)+len(sk.member)+8+4)
-
-	// key
-	var index = 0
-	copy(buf[index:index+len(sk.key)], sk.key)
-	index += len(sk.key)
-
-	// version
-	binary.LittleEndian.PutUint64(buf[index:index+8], uint64(sk.version))
-	index += 8
-
+	buf := make([]byte, 0, versionPrefixSize(sk.key)+len(sk.member)+4)
+	// 标记 + key + version
+	buf = appendVersionPrefix(buf, sk.key, uint64(sk.version))
 	// member
-	copy(buf[index:index+len(sk.member)], sk.member)
-	index += len(sk.member)
-
+	buf = append(buf, sk.member...)
 	// member 长度
-	binary.LittleEndian.PutUint32(buf[index:index+4], uint32(len(sk.member)))
-	return buf
+	return binary.LittleEndian.AppendUint32(buf, uint32(len(sk.member)))
+}
+
+// prefix 同一个 Set 中所有 member 共享的前缀，即 key + version
+func (sk *setInternalKey) prefix() []byte {
+	return versionPrefix(sk.key, uint64(sk.version))
+}
+
+// decodeSetMember 从编码后的 key 中解析出 member，prefixLen 为前缀的长度
+func decodeSetMember(encKey []byte, prefixLen int) []byte {
+	return encKey[prefixLen : len(encKey)-4]
 }
 
 type listInternalKey struct {
@@ -128,21 +178,11 @@ type listInternalKey struct {
 }
 
 func (lk *listInternalKey) encode() []byte {
-	buf := make([]byte, len(lk.key)+8+8)
-	// key
-	var index = 0
-	copy(buf[index:index+len(lk.key)], lk.key)
-	index += len(lk.key)
-
-	// version
-	binary.LittleEndian.PutUint64(buf[index:index+8], uint64(lk.version))
-	index += 8
-
+	buf := make([]byte, 0, versionPrefixSize(lk.key)+8)
+	// 标记 + key + version
+	buf = appendVersionPrefix(buf, lk.key, uint64(lk.version))
 	// index
-	binary.LittleEndian.PutUint64(buf[index:index+8], lk.index)
-	index += 8
-
-	return buf[:index]
+	return binary.LittleEndian.AppendUint64(buf, lk.index)
 }
 
 type zsetInternalKey struct {
@@ -153,48 +193,34 @@ type zsetInternalKey struct {
 }
 
 func (zk *zsetInternalKey) encodeWithMeber() []byte {
-	buf := make([]byte, len(zk.key)+8+len(zk.member))
-
-	// key
-	var index = 0
-	copy(buf[index:index+len(zk.key)], zk.key)
-	index += len(zk.key)
-
-	// version
-	binary.LittleEndian.PutUint64(buf[index:index+8], uint64(zk.version))
-	index += 8
-
+	buf := make([]byte, 0, versionPrefixSize(zk.key)+len(zk.member))
+	// 标记 + key + version
+	buf = appendVersionPrefix(buf, zk.key, uint64(zk.version))
 	// member
-	copy(buf[index:index+len(zk.member)], zk.member)
-	index += len(zk.member)
-
-	return buf[:index]
+	return append(buf, zk.member...)
 }
 
+// encodeWithScore 按照 score 排序的 key，score 使用保持数值顺序的编码，可以按照 score 范围遍历
 func (zk *zsetInternalKey) encodeWithScore() []byte {
-	scoreBuf := utils.Float64ToBytes(zk.score)
-	buf := make([]byte, len(zk.key)+len(zk.member)+len(scoreBuf)+8+4)
-
-	// key
-	var index = 0
-	copy(buf[index:index+len(zk.key)], zk.key)
-	index += len(zk.key)
-
-	// version
-	binary.LittleEndian.PutUint64(buf[index:index+8], uint64(zk.version))
-	index += 8
-
+	scoreBuf := utils.Float64ToOrderedBytes(zk.score)
+	buf := make([]byte, 0, versionPrefixSize(zk.key)+len(scoreBuf)+len(zk.member)+4)
+	// 标记 + key + version，version 的最高位置 1，和 member 部分的前缀区分开
+	buf = appendVersionPrefix(buf, zk.key, uint64(zk.version)|zsetScoreVersionFlag)
 	// socre
-	copy(buf[index:index+len(scoreBuf)], scoreBuf)
-	index += len(scoreBuf)
-
+	buf = append(buf, scoreBuf...)
 	// member
-	copy(buf[index:index+len(zk.member)], zk.member)
-	index += len(zk.member)
-
+	buf = append(buf, zk.member...)
 	// member size
-	binary.LittleEndian.PutUint32(buf[index:index+4], uint32(len(zk.member)))
-	index += 4
+	return binary.LittleEndian.AppendUint32(buf, uint32(len(zk.member)))
+}
 
-	return buf[:index]
+// scorePrefix 同一个 ZSet 中所有按照 score 排序的 key 共享的前缀
+func (zk *zsetInternalKey) scorePrefix() []byte {
+	return versionPrefix(zk.key, uint64(zk.version)|zsetScoreVersionFlag)
+}
+
+// decodeZSetScoreKey 从按照 score 排序的 key 中解析出 score 和 member，prefixLen 为前缀的长度
+func decodeZSetScoreKey(encKey []byte, prefixLen int) (float64, []byte) {
+	score := utils.FloatFromOrderedBytes(encKey[prefixLen : prefixLen+8])
+	return score, encKey[prefixLen+8 : len(encKey)-4]
 }
@@ -3,13 +3,39 @@ package redis
 import (
 	bitcask "SingleKVDataSet"
 	"SingleKVDataSet/utils"
+	"bytes"
 	"encoding/binary"
 	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
 )
 
 var (
-	ErrWrongTypeOperation = errors.New("WEONGTYPE Operation against a key holding the wrong kind of value")
+	ErrWrongTypeOperation  = errors.New("WEONGTYPE Operation against a key holding the wrong kind of value")
+	ErrHashValueNotInteger = errors.New("ERR hash value is not an integer")
+	ErrHashValueNotFloat   = errors.New("ERR hash value is not a float")
+	ErrIncrOverflow        = errors.New("ERR increment or decrement would overflow")
+	ErrIncrNaNOrInfinity   = errors.New("ERR increment would produce NaN or Infinity")
+	ErrNoSuchKey           = errors.New("ERR no such key")
+	ErrIndexOutOfRange     = errors.New("ERR index out of range")
+	ErrScoreNaN            = errors.New("ERR resulting score is not a number (NaN)")
+	ErrValueNotInteger     = errors.New("ERR value is not an integer or out of range")
+	ErrValueNotFloat       = errors.New("ERR value is not a valid float")
+	ErrOffsetOutOfRange    = errors.New("ERR offset is out of range")
+	ErrStringTooLong       = errors.New("ERR string exceeds maximum allowed size (512MB)")
+	ErrDataFormat          = errors.New("data format is not compatible with this version, export the data and write it again")
+)
+
+const (
+	// 没有指定 COUNT 时，SCAN 类命令每次返回的数量
+	defaultScanCount = 10
+
+	// String 类型的值的最大长度，和 Redis 相同
+	maxStringSize = 512 * 1024 * 1024
 )
 
 // Type类型: 0-String | 1-Hash | 2-Set | 3-List | 4-ZSet
@@ -25,7 +51,15 @@ const (
 
 // RedisDataStructure Redis数据结构服务
 type RedisDataStructure struct {
-	db *bitcask.DB
+	db         *bitcask.DB
+	listLock   *sync.Mutex    // 保证列表元数据的读取和更新是原子的，多个客户端同时弹出时不会得到同一个元素
+	stringLock *sync.Mutex    // 保证 INCR 等读取后修改的 String 命令是原子的
+	hashLock   *sync.Mutex    // 保证 Hash 的元数据和 HINCRBY 等读取后修改的命令是原子的
+	setLock    *sync.Mutex    // 保证 Set 的元数据和 member 一起更新，多个客户端同时 SPOP 不会得到同一个 member
+	zsetLock   *sync.Mutex    // 保证 ZSet 的元数据和两种数据部分一起更新，ZINCRBY 不会丢失更新
+	waiters    *listWaiters   // 阻塞在列表上的操作
+	expirer    *activeExpirer // 后台删除过期的 key
+	gc         *subKeyGC      // 后台回收孤立的数据部分
 }
 
 // NewRedisDataStructure 初始化Redis数据结构服务
@@ -34,12 +68,46 @@ func NewRedisDataStructure(options bitcask.Options) (*RedisDataStructure, error)
 	if err != nil {
 		return nil, err
 	}
-	return &RedisDataStructure{
-		db: db,
-	}, nil
+	if err := checkDataFormat(db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	rds := &RedisDataStructure{
+		db:         db,
+		listLock:   new(sync.Mutex),
+		stringLock: new(sync.Mutex),
+		hashLock:   new(sync.Mutex),
+		setLock:    new(sync.Mutex),
+		zsetLock:   new(sync.Mutex),
+		waiters:    newListWaiters(),
+	}
+	rds.startActiveExpire()
+	rds.startSubKeyGC()
+	return rds, nil
+}
+
+// checkDataFormat 检查数据格式的版本号，空的数据库写入当前的版本号
+// 有数据但是没有版本号时是旧格式的数据，和版本号不同时一样返回 ErrDataFormat
+func checkDataFormat(db *bitcask.DB) error {
+	buf, err := db.Get(dataFormatKey)
+	if err == bitcask.ErrKeyNotFound {
+		if db.Stat().KeyNum > 0 {
+			return ErrDataFormat
+		}
+		return db.Put(dataFormatKey, []byte(strconv.Itoa(dataFormatVersion)))
+	}
+	if err != nil {
+		return err
+	}
+	if string(buf) != strconv.Itoa(dataFormatVersion) {
+		return ErrDataFormat
+	}
+	return nil
 }
 
 func (rds *RedisDataStructure) Close() error {
+	rds.expirer.task.close()
+	rds.gc.task.close()
 	return rds.db.Close()
 }
 
@@ -49,26 +117,22 @@ func (rds *RedisDataStructure) Set(key []byte, ttl time.Duration, value []byte)
 		return nil
 	}
 
-	// 编码value: type + expire + payload (数据类型 + 过期时间 + 原始value)
-	buf := make([]byte, binary.MaxVarintLen64+1)
-	buf[0] = String
-	var index = 1
 	var expire int64 = 0
 	if ttl != 0 {
 		expire = time.Now().Add(ttl).UnixNano()
 	}
-	index += binary.PutVarint(buf[index:], expire)
-
-	encValue := make([]byte, index+len(value))
-	copy(encValue[:index], buf[:index])
-	copy(encValue[index:], value)
-
+	// 和 INCR 等读取后修改的命令互斥，避免写入的值被它们覆盖
+	unlock, err := rds.lockStringWrite(key)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	// 编码完成，调用存储引擎接口进行写入
-	return rds.db.Put(key, encValue)
+	return rds.db.Put(topLevelKey(key), encodeStringValue(value, expire))
 }
 
 func (rds *RedisDataStructure) Get(key []byte) ([]byte, error) {
-	encValue, err := rds.db.Get(key)
+	encValue, err := rds.db.Get(topLevelKey(key))
 	if err != nil {
 		return nil, err
 	}
@@ -78,18 +142,327 @@ func (rds *RedisDataStructure) Get(key []byte) ([]byte, error) {
 	if dataType != String {
 		return nil, ErrWrongTypeOperation
 	}
-	var index = 1
-	expire, n := binary.Varint(encValue[index:])
-	index += n
+	expire, value := decodeStringValue(encValue)
 	// 判断key是否过期
 	if expire > 0 && expire < time.Now().UnixNano() {
 		return nil, nil
 	}
-	return encValue[index:], nil
+	return value, nil
+}
+
+// SetOptions SET 命令的可选参数
+type SetOptions struct {
+	ExpireAt time.Time // 过期时间，为零值时不过期
+	KeepTTL  bool      // 保留原有的过期时间
+	NX       bool      // 只在 key 不存在时写入
+	XX       bool      // 只在 key 存在时写入
+	Get      bool      // 返回原有的值，原有的值不是 String 类型时返回错误
+}
+
+// SetWithOptions 按照 opts 写入，返回原有的值和是否写入，只有 opts.Get 为 true 时才返回原有的值
+func (rds *RedisDataStructure) SetWithOptions(key, value []byte, opts SetOptions) ([]byte, bool, error) {
+	unlock, err := rds.lockStringWrite(key)
+	if err != nil {
+		return nil, false, err
+	}
+	defer unlock()
+
+	var old []byte
+	var oldExpire int64
+	if opts.Get || opts.KeepTTL {
+		var err error
+		if old, oldExpire, _, err = rds.getString(key); err != nil && (opts.Get || err != ErrWrongTypeOperation) {
+			return nil, false, err
+		}
+	}
+	if opts.NX || opts.XX {
+		exist, err := rds.exists(key)
+		if err != nil {
+			return nil, false, err
+		}
+		if (opts.NX && exist) || (opts.XX && !exist) {
+			return old, false, nil
+		}
+	}
+
+	var expire int64
+	switch {
+	case opts.KeepTTL:
+		expire = oldExpire
+	case !opts.ExpireAt.IsZero():
+		expire = opts.ExpireAt.UnixNano()
+	}
+	if err := rds.db.Put(topLevelKey(key), encodeStringValue(value, expire)); err != nil {
+		return nil, false, err
+	}
+	return old, true, nil
+}
+
+// SetNX 只在 key 不存在时写入，返回是否写入
+func (rds *RedisDataStructure) SetNX(key, value []byte) (bool, error) {
+	_, ok, err := rds.SetWithOptions(key, value, SetOptions{NX: true})
+	return ok, err
+}
+
+// GetSet 写入新的值并返回原有的值，key 不存在时返回 nil，写入后不再有过期时间
+func (rds *RedisDataStructure) GetSet(key, value []byte) ([]byte, error) {
+	old, _, err := rds.SetWithOptions(key, value, SetOptions{Get: true})
+	return old, err
+}
+
+// GetDel 返回原有的值并删除 key，key 不存在时返回 nil
+func (rds *RedisDataStructure) GetDel(key []byte) ([]byte, error) {
+	rds.stringLock.Lock()
+	defer rds.stringLock.Unlock()
+
+	value, _, exist, err := rds.getString(key)
+	if err != nil || !exist {
+		return nil, err
+	}
+	if err := rds.db.Delete(topLevelKey(key)); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// MSet 写入多个 key，keys 和 values 一一对应，所有 key 在同一个批次中提交
+func (rds *RedisDataStructure) MSet(keys, values [][]byte) error {
+	unlock, err := rds.lockStringWrite(keys...)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return rds.mset(keys, values)
+}
+
+func (rds *RedisDataStructure) mset(keys, values [][]byte) error {
+	wb := rds.newWriteBatch(len(keys))
+	for i, key := range keys {
+		_ = wb.Put(topLevelKey(key), encodeStringValue(values[i], 0))
+	}
+	return wb.Commit()
+}
+
+// MSetNX 只在所有 key 都不存在时写入，返回是否写入
+func (rds *RedisDataStructure) MSetNX(keys, values [][]byte) (bool, error) {
+	unlock, err := rds.lockStringWrite(keys...)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	for _, key := range keys {
+		exist, err := rds.exists(key)
+		if err != nil {
+			return false, err
+		}
+		if exist {
+			return false, nil
+		}
+	}
+	if err := rds.mset(keys, values); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// MGet 获取多个 key 的值，key 不存在或者不是 String 类型时对应的值为 nil
+func (rds *RedisDataStructure) MGet(keys [][]byte) ([][]byte, error) {
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		value, _, exist, err := rds.getString(key)
+		if err == ErrWrongTypeOperation {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if exist {
+			values[i] = value
+		}
+	}
+	return values, nil
+}
+
+// IncrBy 将 key 的值加上 incr，key 不存在时从 0 开始，保留原有的过期时间
+func (rds *RedisDataStructure) IncrBy(key []byte, incr int64) (int64, error) {
+	rds.stringLock.Lock()
+	defer rds.stringLock.Unlock()
+
+	value, expire, exist, err := rds.getString(key)
+	if err != nil {
+		return 0, err
+	}
+	var curr int64
+	if exist {
+		if curr, err = strconv.ParseInt(string(value), 10, 64); err != nil {
+			return 0, ErrValueNotInteger
+		}
+	}
+	if (incr > 0 && curr > math.MaxInt64-incr) || (incr < 0 && curr < math.MinInt64-incr) {
+		return 0, ErrIncrOverflow
+	}
+	curr += incr
+	if err := rds.db.Put(topLevelKey(key), encodeStringValue([]byte(strconv.FormatInt(curr, 10)), expire)); err != nil {
+		return 0, err
+	}
+	return curr, nil
+}
+
+// IncrByFloat 将 key 的值加上浮点数 incr，key 不存在时从 0 开始，保留原有的过期时间
+func (rds *RedisDataStructure) IncrByFloat(key []byte, incr float64) (float64, error) {
+	rds.stringLock.Lock()
+	defer rds.stringLock.Unlock()
+
+	value, expire, exist, err := rds.getString(key)
+	if err != nil {
+		return 0, err
+	}
+	var curr float64
+	if exist {
+		if curr, err = strconv.ParseFloat(string(value), 64); err != nil {
+			return 0, ErrValueNotFloat
+		}
+	}
+	curr += incr
+	if math.IsNaN(curr) || math.IsInf(curr, 0) {
+		return 0, ErrIncrNaNOrInfinity
+	}
+	if err := rds.db.Put(topLevelKey(key), encodeStringValue(utils.Float64ToBytes(curr), expire)); err != nil {
+		return 0, err
+	}
+	return curr, nil
+}
+
+// Append 在原有的值后面追加数据，key 不存在时相当于写入，返回追加后的长度
+func (rds *RedisDataStructure) Append(key, value []byte) (int, error) {
+	rds.stringLock.Lock()
+	defer rds.stringLock.Unlock()
+
+	old, expire, _, err := rds.getString(key)
+	if err != nil {
+		return 0, err
+	}
+	newValue := append(append(make([]byte, 0, len(old)+len(value)), old...), value...)
+	if err := rds.db.Put(topLevelKey(key), encodeStringValue(newValue, expire)); err != nil {
+		return 0, err
+	}
+	return len(newValue), nil
+}
+
+// SetRange 从 offset 开始覆盖原有的值，原有的值不够长时用 0 填充，返回修改后的长度
+func (rds *RedisDataStructure) SetRange(key []byte, offset int, value []byte) (int, error) {
+	if offset < 0 {
+		return 0, ErrOffsetOutOfRange
+	}
+	if offset+len(value) > maxStringSize {
+		return 0, ErrStringTooLong
+	}
+
+	rds.stringLock.Lock()
+	defer rds.stringLock.Unlock()
+
+	old, expire, exist, err := rds.getString(key)
+	if err != nil {
+		return 0, err
+	}
+	// 不存在的 key 写入空数据时不创建
+	if len(value) == 0 {
+		return len(old), nil
+	}
+	newValue := old
+	if end := offset + len(value); end > len(old) {
+		newValue = make([]byte, end)
+		copy(newValue, old)
+	} else if exist {
+		newValue = append([]byte{}, old...)
+	}
+	copy(newValue[offset:], value)
+	if err := rds.db.Put(topLevelKey(key), encodeStringValue(newValue, expire)); err != nil {
+		return 0, err
+	}
+	return len(newValue), nil
+}
+
+// GetRange 返回下标从 start 到 end 的子串，包括 end，负数表示从末尾开始计算
+func (rds *RedisDataStructure) GetRange(key []byte, start, end int64) ([]byte, error) {
+	value, _, _, err := rds.getString(key)
+	if err != nil {
+		return nil, err
+	}
+	size := int64(len(value))
+	if start < 0 {
+		start += size
+	}
+	if end < 0 {
+		end += size
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end >= size {
+		end = size - 1
+	}
+	if start > end || size == 0 {
+		return []byte{}, nil
+	}
+	return value[start : end+1], nil
+}
+
+// StrLen 返回值的长度，key 不存在时返回 0
+func (rds *RedisDataStructure) StrLen(key []byte) (int, error) {
+	value, _, _, err := rds.getString(key)
+	return len(value), err
+}
+
+// getString 读取 String 类型的值和过期时间，key 不存在或者已经过期时 exist 为 false
+func (rds *RedisDataStructure) getString(key []byte) (value []byte, expire int64, exist bool, err error) {
+	encValue, err := rds.db.Get(topLevelKey(key))
+	if err == bitcask.ErrKeyNotFound {
+		return nil, 0, false, nil
+	}
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if isExpired(encValue) {
+		return nil, 0, false, nil
+	}
+	if encValue[0] != String {
+		return nil, 0, false, ErrWrongTypeOperation
+	}
+	expire, value = decodeStringValue(encValue)
+	return value, expire, true, nil
+}
+
+// encodeStringValue 编码value: type + expire + payload (数据类型 + 过期时间 + 原始value)
+func encodeStringValue(value []byte, expire int64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64+1)
+	buf[0] = String
+	var index = 1
+	index += binary.PutVarint(buf[index:], expire)
+
+	encValue := make([]byte, index+len(value))
+	copy(encValue[:index], buf[:index])
+	copy(encValue[index:], value)
+	return encValue
+}
+
+func decodeStringValue(encValue []byte) (int64, []byte) {
+	var index = 1
+	expire, n := binary.Varint(encValue[index:])
+	index += n
+	return expire, encValue[index:]
 }
 
 // ==================== Hash 数据结构 ====================
 func (rds *RedisDataStructure) HSet(key, field, value []byte) (bool, error) {
+	rds.hashLock.Lock()
+	defer rds.hashLock.Unlock()
+	return rds.hSet(key, field, value)
+}
+
+// hSet 调用前需要持有 hashLock
+func (rds *RedisDataStructure) hSet(key, field, value []byte) (bool, error) {
 	// 先查找元数据
 	meta, err := rds.findMetadata(key, Hash)
 	if err != nil {
@@ -114,7 +487,7 @@ func (rds *RedisDataStructure) HSet(key, field, value []byte) (bool, error) {
 	// 不存在则更新元数据
 	if !exist {
 		meta.size++
-		_ = wb.Put(key, meta.encode())
+		_ = wb.Put(topLevelKey(key), meta.encode())
 	}
 	_ = wb.Put(encKey, value)
 	if err := wb.Commit(); err != nil {
@@ -141,6 +514,9 @@ func (rds *RedisDataStructure) HGet(key, field []byte) ([]byte, error) {
 }
 
 func (rds *RedisDataStructure) HDel(key, field []byte) (bool, error) {
+	rds.hashLock.Lock()
+	defer rds.hashLock.Unlock()
+
 	meta, err := rds.findMetadata(key, Hash)
 	if err != nil {
 		return false, err
@@ -165,7 +541,7 @@ func (rds *RedisDataStructure) HDel(key, field []byte) (bool, error) {
 	if exist {
 		wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
 		meta.size--
-		_ = wb.Put(key, meta.encode())
+		_ = wb.Put(topLevelKey(key), meta.encode())
 		_ = wb.Delete(encKey)
 		if err := wb.Commit(); err != nil {
 			return false, err
@@ -174,178 +550,1140 @@ func (rds *RedisDataStructure) HDel(key, field []byte) (bool, error) {
 	return exist, nil
 }
 
-// ==================== Set 数据结构 ====================
-func (rds *RedisDataStructure) SAdd(key, member []byte) (bool, error) {
-	// 查找元数据
-	meta, err := rds.findMetadata(key, Set)
+func (rds *RedisDataStructure) HSetNX(key, field, value []byte) (bool, error) {
+	rds.hashLock.Lock()
+	defer rds.hashLock.Unlock()
+
+	meta, err := rds.findMetadata(key, Hash)
 	if err != nil {
 		return false, err
 	}
 
-	// 构造数据部分得key
-	sk := &setInternalKey{
+	hk := &hashInternalKey{
 		key:     key,
 		version: meta.version,
-		member:  member,
+		field:   field,
 	}
-	var ok bool
-	if _, err := rds.db.Get(sk.encode()); err == bitcask.ErrKeyNotFound {
-		// 不存在的话则更新
-		wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
-		meta.size++
-		_ = wb.Put(key, meta.encode())
-		_ = wb.Put(sk.encode(), nil)
-		if err := wb.Commit(); err != nil {
-			return false, err
-		}
-		ok = true
+	encKey := hk.encode()
+	// 已经存在则不更新
+	if _, err := rds.db.Get(encKey); err != bitcask.ErrKeyNotFound {
+		return false, err
 	}
-	return ok, nil
+
+	wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
+	meta.size++
+	_ = wb.Put(topLevelKey(key), meta.encode())
+	_ = wb.Put(encKey, value)
+	if err := wb.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
-func (rds *RedisDataStructure) SIsMember(key, member []byte) (bool, error) {
-	meta, err := rds.findMetadata(key, Set)
+// HMSet 写入多个 field，fields 和 values 一一对应，返回新增的 field 数量
+func (rds *RedisDataStructure) HMSet(key []byte, fields, values [][]byte) (int, error) {
+	rds.hashLock.Lock()
+	defer rds.hashLock.Unlock()
+
+	meta, err := rds.findMetadata(key, Hash)
 	if err != nil {
-		return false, err
+		return 0, err
 	}
-	if meta.size == 0 {
-		return false, nil
+
+	var added int
+	// 同一个 field 出现多次时只计算一次
+	seen := make(map[string]struct{}, len(fields))
+	wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
+	for i, field := range fields {
+		hk := &hashInternalKey{
+			key:     key,
+			version: meta.version,
+			field:   field,
+		}
+		encKey := hk.encode()
+		if _, ok := seen[string(field)]; !ok {
+			seen[string(field)] = struct{}{}
+			_, err := rds.db.Get(encKey)
+			if err != nil && err != bitcask.ErrKeyNotFound {
+				return 0, err
+			}
+			if err == bitcask.ErrKeyNotFound {
+				added++
+			}
+		}
+		_ = wb.Put(encKey, values[i])
 	}
-	sk := &setInternalKey{
-		key:     key,
-		version: meta.version,
-		member:  member,
+	if added > 0 {
+		meta.size += uint32(added)
+		_ = wb.Put(topLevelKey(key), meta.encode())
 	}
-	_, err = rds.db.Get(sk.encode())
-	if err != nil && err != bitcask.ErrKeyNotFound {
-		return false, err
+	if err := wb.Commit(); err != nil {
+		return 0, err
 	}
-	if err == bitcask.ErrKeyNotFound {
-		return false, nil
+	return added, nil
+}
+
+// HMGet 获取多个 field 的值，不存在的 field 对应的值为 nil
+func (rds *RedisDataStructure) HMGet(key []byte, fields [][]byte) ([][]byte, error) {
+	meta, err := rds.findMetadata(key, Hash)
+	if err != nil {
+		return nil, err
 	}
-	return true, nil
+
+	values := make([][]byte, len(fields))
+	if meta.size == 0 {
+		return values, nil
+	}
+	for i, field := range fields {
+		hk := &hashInternalKey{
+			key:     key,
+			version: meta.version,
+			field:   field,
+		}
+		value, err := rds.db.Get(hk.encode())
+		if err != nil && err != bitcask.ErrKeyNotFound {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
 }
 
-func (rds *RedisDataStructure) SRem(key, member []byte) (bool, error) {
-	meta, err := rds.findMetadata(key, Set)
+func (rds *RedisDataStructure) HExists(key, field []byte) (bool, error) {
+	meta, err := rds.findMetadata(key, Hash)
 	if err != nil {
 		return false, err
 	}
 	if meta.size == 0 {
 		return false, nil
 	}
-	sk := &setInternalKey{
+
+	hk := &hashInternalKey{
 		key:     key,
 		version: meta.version,
-		member:  member,
-	}
-	if _, err := rds.db.Get(sk.encode()); err == bitcask.ErrKeyNotFound {
-		return false, nil
+		field:   field,
 	}
-
-	// 更新
-	wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
-	meta.size--
-	_ = wb.Put(key, meta.encode())
-	_ = wb.Delete(key)
-	if err := wb.Commit(); err != nil {
+	_, err = rds.db.Get(hk.encode())
+	if err == bitcask.ErrKeyNotFound {
 		return false, nil
 	}
-	return true, nil
+	return err == nil, err
 }
 
-// ==================== List 数据结构 ====================
-func (rds *RedisDataStructure) LPush(key, element []byte) (uint32, error) {
+func (rds *RedisDataStructure) HLen(key []byte) (uint32, error) {
+	meta, err := rds.findMetadata(key, Hash)
+	if err != nil {
+		return 0, err
+	}
+	return meta.size, nil
+}
+
+// HGetAll 按照 field 的顺序返回所有的 field 和值，依次交替排列
+func (rds *RedisDataStructure) HGetAll(key []byte) ([][]byte, error) {
+	var res [][]byte
+	err := rds.hashScan(key, true, func(field, value []byte) bool {
+		res = append(res, field, value)
+		return true
+	})
+	return res, err
+}
+
+func (rds *RedisDataStructure) HKeys(key []byte) ([][]byte, error) {
+	var fields [][]byte
+	err := rds.hashScan(key, false, func(field, _ []byte) bool {
+		fields = append(fields, field)
+		return true
+	})
+	return fields, err
+}
+
+func (rds *RedisDataStructure) HVals(key []byte) ([][]byte, error) {
+	var values [][]byte
+	err := rds.hashScan(key, true, func(_, value []byte) bool {
+		values = append(values, value)
+		return true
+	})
+	return values, err
+}
+
+// HIncrBy 将 field 的值加上 incr，field 不存在时从 0 开始
+func (rds *RedisDataStructure) HIncrBy(key, field []byte, incr int64) (int64, error) {
+	rds.hashLock.Lock()
+	defer rds.hashLock.Unlock()
+
+	value, err := rds.HGet(key, field)
+	if err != nil && err != bitcask.ErrKeyNotFound {
+		return 0, err
+	}
+
+	var curr int64
+	if len(value) > 0 {
+		if curr, err = strconv.ParseInt(string(value), 10, 64); err != nil {
+			return 0, ErrHashValueNotInteger
+		}
+	}
+	if (incr > 0 && curr > math.MaxInt64-incr) || (incr < 0 && curr < math.MinInt64-incr) {
+		return 0, ErrIncrOverflow
+	}
+	curr += incr
+	if _, err := rds.hSet(key, field, []byte(strconv.FormatInt(curr, 10))); err != nil {
+		return 0, err
+	}
+	return curr, nil
+}
+
+// HIncrByFloat 将 field 的值加上浮点数 incr，field 不存在时从 0 开始
+func (rds *RedisDataStructure) HIncrByFloat(key, field []byte, incr float64) (float64, error) {
+	rds.hashLock.Lock()
+	defer rds.hashLock.Unlock()
+
+	value, err := rds.HGet(key, field)
+	if err != nil && err != bitcask.ErrKeyNotFound {
+		return 0, err
+	}
+
+	var curr float64
+	if len(value) > 0 {
+		if curr, err = strconv.ParseFloat(string(value), 64); err != nil {
+			return 0, ErrHashValueNotFloat
+		}
+	}
+	curr += incr
+	if math.IsNaN(curr) || math.IsInf(curr, 0) {
+		return 0, ErrIncrNaNOrInfinity
+	}
+	if _, err := rds.hSet(key, field, utils.Float64ToBytes(curr)); err != nil {
+		return 0, err
+	}
+	return curr, nil
+}
+
+// HScan 从 cursor 开始按照 field 的顺序遍历 count 个 field，返回其中匹配 match 的 field 和值，以及下一次遍历的 cursor
+// cursor 为已经遍历过的 field 数量，返回 0 表示遍历结束；match 为空时返回所有 field
+func (rds *RedisDataStructure) HScan(key []byte, cursor uint64, match []byte, count int) (uint64, [][]byte, error) {
+	if count <= 0 {
+		count = defaultScanCount
+	}
+	var res [][]byte
+	var pos uint64
+	var next uint64
+	err := rds.hashScan(key, true, func(field, value []byte) bool {
+		if pos < cursor {
+			pos++
+			return true
+		}
+		// 本次遍历数量已满且后面还有数据
+		if pos == cursor+uint64(count) {
+			next = pos
+			return false
+		}
+		pos++
+		if len(match) == 0 || utils.GlobMatch(match, field) {
+			res = append(res, field, value)
+		}
+		return true
+	})
+	return next, res, err
+}
+
+// hashScan 按照 field 的顺序遍历 Hash，withValue 为 false 时不读取值，fn 返回 false 时停止遍历
+func (rds *RedisDataStructure) hashScan(key []byte, withValue bool, fn func(field, value []byte) bool) error {
+	meta, err := rds.findMetadata(key, Hash)
+	if err != nil {
+		return err
+	}
+	if meta.size == 0 {
+		return nil
+	}
+
+	hk := &hashInternalKey{
+		key:     key,
+		version: meta.version,
+	}
+	prefix := hk.prefix()
+	return rds.scanPrefix(prefix, withValue, func(encKey, value []byte) bool {
+		return fn(encKey[len(prefix):], value)
+	})
+}
+
+// ==================== Set 数据结构 ====================
+func (rds *RedisDataStructure) SAdd(key, member []byte) (bool, error) {
+	rds.setLock.Lock()
+	defer rds.setLock.Unlock()
+
+	// 查找元数据
+	meta, err := rds.findMetadata(key, Set)
+	if err != nil {
+		return false, err
+	}
+
+	// 构造数据部分得key
+	sk := &setInternalKey{
+		key:     key,
+		version: meta.version,
+		member:  member,
+	}
+	var ok bool
+	if _, err := rds.db.Get(sk.encode()); err == bitcask.ErrKeyNotFound {
+		// 不存在的话则更新
+		wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
+		meta.size++
+		_ = wb.Put(topLevelKey(key), meta.encode())
+		_ = wb.Put(sk.encode(), nil)
+		if err := wb.Commit(); err != nil {
+			return false, err
+		}
+		ok = true
+	}
+	return ok, nil
+}
+
+func (rds *RedisDataStructure) SIsMember(key, member []byte) (bool, error) {
+	meta, err := rds.findMetadata(key, Set)
+	if err != nil {
+		return false, err
+	}
+	if meta.size == 0 {
+		return false, nil
+	}
+	sk := &setInternalKey{
+		key:     key,
+		version: meta.version,
+		member:  member,
+	}
+	_, err = rds.db.Get(sk.encode())
+	if err != nil && err != bitcask.ErrKeyNotFound {
+		return false, err
+	}
+	if err == bitcask.ErrKeyNotFound {
+		return false, nil
+	}
+	return true, nil
+}
+
+func (rds *RedisDataStructure) SRem(key, member []byte) (bool, error) {
+	rds.setLock.Lock()
+	defer rds.setLock.Unlock()
+
+	meta, err := rds.findMetadata(key, Set)
+	if err != nil {
+		return false, err
+	}
+	if meta.size == 0 {
+		return false, nil
+	}
+	sk := &setInternalKey{
+		key:     key,
+		version: meta.version,
+		member:  member,
+	}
+	if _, err := rds.db.Get(sk.encode()); err == bitcask.ErrKeyNotFound {
+		return false, nil
+	}
+
+	// 更新
+	wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
+	meta.size--
+	_ = wb.Put(topLevelKey(key), meta.encode())
+	_ = wb.Delete(sk.encode())
+	if err := wb.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (rds *RedisDataStructure) SCard(key []byte) (uint32, error) {
+	meta, err := rds.findMetadata(key, Set)
+	if err != nil {
+		return 0, err
+	}
+	return meta.size, nil
+}
+
+// SMembers 按照 member 的顺序返回所有 member
+func (rds *RedisDataStructure) SMembers(key []byte) ([][]byte, error) {
+	_, members, err := rds.setMembers(key)
+	return members, err
+}
+
+// SPop 随机删除并返回 count 个 member，count 大于 member 数量时删除整个 Set
+func (rds *RedisDataStructure) SPop(key []byte, count uint32) ([][]byte, error) {
+	rds.setLock.Lock()
+	defer rds.setLock.Unlock()
+
+	meta, members, err := rds.setMembers(key)
+	if err != nil || len(members) == 0 || count == 0 {
+		return nil, err
+	}
+
+	if count > uint32(len(members)) {
+		count = uint32(len(members))
+	}
+	// 只需要打乱前 count 个位置
+	for i := 0; i < int(count); i++ {
+		j := i + rand.Intn(len(members)-i)
+		members[i], members[j] = members[j], members[i]
+	}
+	popped := members[:count]
+
+	wb := rds.newWriteBatch(len(popped) + 1)
+	for _, member := range popped {
+		sk := &setInternalKey{
+			key:     key,
+			version: meta.version,
+			member:  member,
+		}
+		_ = wb.Delete(sk.encode())
+	}
+	meta.size -= count
+	_ = wb.Put(topLevelKey(key), meta.encode())
+	if err := wb.Commit(); err != nil {
+		return nil, err
+	}
+	return popped, nil
+}
+
+// SRandMember 随机返回 member，count 为正数时返回不重复的 member，为负数时返回 -count 个可能重复的 member
+func (rds *RedisDataStructure) SRandMember(key []byte, count int) ([][]byte, error) {
+	_, members, err := rds.setMembers(key)
+	if err != nil || len(members) == 0 || count == 0 {
+		return nil, err
+	}
+
+	if count < 0 {
+		res := make([][]byte, -count)
+		for i := range res {
+			res[i] = members[rand.Intn(len(members))]
+		}
+		return res, nil
+	}
+	if count > len(members) {
+		count = len(members)
+	}
+	for i := 0; i < count; i++ {
+		j := i + rand.Intn(len(members)-i)
+		members[i], members[j] = members[j], members[i]
+	}
+	return members[:count], nil
+}
+
+// SMove 将 member 从 source 移动到 destination，两个 Set 的修改在同一个批次中提交
+func (rds *RedisDataStructure) SMove(source, destination, member []byte) (bool, error) {
+	rds.setLock.Lock()
+	defer rds.setLock.Unlock()
+
+	srcMeta, err := rds.findMetadata(source, Set)
+	if err != nil {
+		return false, err
+	}
+	dstMeta, err := rds.findMetadata(destination, Set)
+	if err != nil {
+		return false, err
+	}
+
+	srcKey := &setInternalKey{
+		key:     source,
+		version: srcMeta.version,
+		member:  member,
+	}
+	if srcMeta.size == 0 {
+		return false, nil
+	}
+	if _, err := rds.db.Get(srcKey.encode()); err != nil {
+		if err == bitcask.ErrKeyNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	// 源和目标相同时不需要修改
+	if bytes.Equal(source, destination) {
+		return true, nil
+	}
+
+	wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
+	srcMeta.size--
+	_ = wb.Put(topLevelKey(source), srcMeta.encode())
+	_ = wb.Delete(srcKey.encode())
+
+	dstKey := &setInternalKey{
+		key:     destination,
+		version: dstMeta.version,
+		member:  member,
+	}
+	_, err = rds.db.Get(dstKey.encode())
+	if err != nil && err != bitcask.ErrKeyNotFound {
+		return false, err
+	}
+	if err == bitcask.ErrKeyNotFound {
+		dstMeta.size++
+		_ = wb.Put(topLevelKey(destination), dstMeta.encode())
+		_ = wb.Put(dstKey.encode(), nil)
+	}
+	if err := wb.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SInter 返回所有 Set 的交集
+func (rds *RedisDataStructure) SInter(keys ...[]byte) ([][]byte, error) {
+	return rds.setAlgebra(setInter, keys)
+}
+
+// SUnion 返回所有 Set 的并集
+func (rds *RedisDataStructure) SUnion(keys ...[]byte) ([][]byte, error) {
+	return rds.setAlgebra(setUnion, keys)
+}
+
+// SDiff 返回第一个 Set 和其余 Set 的差集
+func (rds *RedisDataStructure) SDiff(keys ...[]byte) ([][]byte, error) {
+	return rds.setAlgebra(setDiff, keys)
+}
+
+// SInterStore 将交集保存到 destination，返回结果的数量
+func (rds *RedisDataStructure) SInterStore(destination []byte, keys ...[]byte) (uint32, error) {
+	return rds.setAlgebraStore(setInter, destination, keys)
+}
+
+// SUnionStore 将并集保存到 destination，返回结果的数量
+func (rds *RedisDataStructure) SUnionStore(destination []byte, keys ...[]byte) (uint32, error) {
+	return rds.setAlgebraStore(setUnion, destination, keys)
+}
+
+// SDiffStore 将差集保存到 destination，返回结果的数量
+func (rds *RedisDataStructure) SDiffStore(destination []byte, keys ...[]byte) (uint32, error) {
+	return rds.setAlgebraStore(setDiff, destination, keys)
+}
+
+// SScan 从 cursor 开始按照 member 的顺序遍历 count 个 member，返回其中匹配 match 的 member，以及下一次遍历的 cursor
+// cursor 为已经遍历过的 member 数量，返回 0 表示遍历结束；match 为空时返回所有 member
+func (rds *RedisDataStructure) SScan(key []byte, cursor uint64, match []byte, count int) (uint64, [][]byte, error) {
+	if count <= 0 {
+		count = defaultScanCount
+	}
+	var res [][]byte
+	var pos uint64
+	var next uint64
+	err := rds.setScan(key, func(member []byte) bool {
+		if pos < cursor {
+			pos++
+			return true
+		}
+		if pos == cursor+uint64(count) {
+			next = pos
+			return false
+		}
+		pos++
+		if len(match) == 0 || utils.GlobMatch(match, member) {
+			res = append(res, member)
+		}
+		return true
+	})
+	return next, res, err
+}
+
+type setOperation byte
+
+const (
+	setInter setOperation = iota
+	setUnion
+	setDiff
+)
+
+// setAlgebra 计算多个 Set 的交集、并集或差集，结果按照 member 的顺序排列
+func (rds *RedisDataStructure) setAlgebra(op setOperation, keys [][]byte) ([][]byte, error) {
+	// 先读取所有的 Set，任何一个 key 类型不对都返回错误
+	sets := make([][][]byte, len(keys))
+	for i, key := range keys {
+		_, members, err := rds.setMembers(key)
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = members
+	}
+	if len(sets) == 0 {
+		return nil, nil
+	}
+
+	// 统计每个 member 在几个 Set 中出现，以及是否在第一个 Set 中
+	counts := make(map[string]int)
+	var res [][]byte
+	for i, members := range sets {
+		for _, member := range members {
+			n := counts[string(member)]
+			counts[string(member)] = n + 1
+			switch {
+			case op == setUnion && n == 0:
+				res = append(res, member)
+			case op != setUnion && i == 0:
+				res = append(res, member)
+			}
+		}
+	}
+
+	switch op {
+	case setUnion:
+		sort.Slice(res, func(i, j int) bool {
+			return bytes.Compare(res[i], res[j]) < 0
+		})
+		return res, nil
+	case setInter:
+		// 每个 Set 中的 member 都不重复，出现次数等于 Set 数量时说明在所有 Set 中
+		return filterMembers(res, func(member []byte) bool {
+			return counts[string(member)] == len(sets)
+		}), nil
+	default:
+		return filterMembers(res, func(member []byte) bool {
+			return counts[string(member)] == 1
+		}), nil
+	}
+}
+
+// setAlgebraStore 计算结果后写入 destination，destination 原有的数据会被覆盖
+// 元数据和所有 member 在同一个批次中提交，结果为空时删除 destination
+// destination 可能是其他类型的 key，所以需要持有所有类型的锁
+func (rds *RedisDataStructure) setAlgebraStore(op setOperation, destination []byte, keys [][]byte) (uint32, error) {
+	rds.lockAll()
+	defer rds.unlockAll()
+
+	members, err := rds.setAlgebra(op, keys)
+	if err != nil {
+		return 0, err
+	}
+
+	wb := rds.newWriteBatch(len(members) + 1)
+	if len(members) == 0 {
+		_ = wb.Delete(topLevelKey(destination))
+		return 0, wb.Commit()
+	}
+
+	// 使用新的版本号，原有的数据不再可见
+	meta := &metadata{
+		dataType: Set,
+		version:  time.Now().UnixNano(),
+		size:     uint32(len(members)),
+	}
+	_ = wb.Put(topLevelKey(destination), meta.encode())
+	for _, member := range members {
+		sk := &setInternalKey{
+			key:     destination,
+			version: meta.version,
+			member:  member,
+		}
+		_ = wb.Put(sk.encode(), nil)
+	}
+	if err := wb.Commit(); err != nil {
+		return 0, err
+	}
+	return meta.size, nil
+}
+
+func filterMembers(members [][]byte, keep func(member []byte) bool) [][]byte {
+	res := members[:0]
+	for _, member := range members {
+		if keep(member) {
+			res = append(res, member)
+		}
+	}
+	return res
+}
+
+// setMembers 读取 Set 的元数据和所有 member
+func (rds *RedisDataStructure) setMembers(key []byte) (*metadata, [][]byte, error) {
+	meta, err := rds.findMetadata(key, Set)
+	if err != nil {
+		return nil, nil, err
+	}
+	var members [][]byte
+	err = rds.setScanWithMeta(key, meta, func(member []byte) bool {
+		members = append(members, member)
+		return true
+	})
+	return meta, members, err
+}
+
+// setScan 按照 member 的顺序遍历 Set，fn 返回 false 时停止遍历
+func (rds *RedisDataStructure) setScan(key []byte, fn func(member []byte) bool) error {
+	meta, err := rds.findMetadata(key, Set)
+	if err != nil {
+		return err
+	}
+	return rds.setScanWithMeta(key, meta, fn)
+}
+
+func (rds *RedisDataStructure) setScanWithMeta(key []byte, meta *metadata, fn func(member []byte) bool) error {
+	if meta.size == 0 {
+		return nil
+	}
+	sk := &setInternalKey{
+		key:     key,
+		version: meta.version,
+	}
+	prefix := sk.prefix()
+	return rds.scanPrefix(prefix, false, func(encKey, _ []byte) bool {
+		return fn(decodeSetMember(encKey, len(prefix)))
+	})
+}
+
+// ==================== List 数据结构 ====================
+func (rds *RedisDataStructure) LPush(key, element []byte) (uint32, error) {
 	return rds.PushInner(key, element, true)
 }
 
-func (rds *RedisDataStructure) RPush(key, element []byte) (uint32, error) {
-	return rds.PushInner(key, element, false)
+func (rds *RedisDataStructure) RPush(key, element []byte) (uint32, error) {
+	return rds.PushInner(key, element, false)
+}
+
+func (rds *RedisDataStructure) LPop(key []byte) ([]byte, error) {
+	return rds.PopInner(key, true)
+}
+
+func (rds *RedisDataStructure) RPop(key []byte) ([]byte, error) {
+	return rds.PopInner(key, false)
+}
+
+func (rds *RedisDataStructure) PushInner(key, element []byte, isLeft bool) (uint32, error) {
+	rds.listLock.Lock()
+	defer rds.listLock.Unlock()
+
+	// 查找元数据
+	meta, err := rds.findMetadata(key, List)
+	if err != nil {
+		return 0, err
+	}
+
+	// 更新元数据和数据部分
+	wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
+	listPush(wb, key, meta, element, isLeft)
+	_ = wb.Put(topLevelKey(key), meta.encode())
+	if err := wb.Commit(); err != nil {
+		return 0, err
+	}
+	// 唤醒等待这个 key 的阻塞操作
+	rds.waiters.notify(key)
+	return meta.size, nil
+}
+
+func (rds *RedisDataStructure) PopInner(key []byte, isLeft bool) ([]byte, error) {
+	element, _, err := rds.popInner(key, isLeft)
+	return element, err
+}
+
+// popInner 弹出元素，列表为空时 ok 为 false，用于区分空列表和值为空的元素
+func (rds *RedisDataStructure) popInner(key []byte, isLeft bool) (element []byte, ok bool, err error) {
+	rds.listLock.Lock()
+	defer rds.listLock.Unlock()
+
+	// 查找元数据
+	meta, err := rds.findMetadata(key, List)
+	if err != nil {
+		return nil, false, err
+	}
+	if meta.size == 0 {
+		return nil, false, nil
+	}
+
+	wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
+	if element, err = rds.listPop(wb, key, meta, isLeft); err != nil {
+		return nil, false, err
+	}
+	_ = wb.Put(topLevelKey(key), meta.encode())
+	if err := wb.Commit(); err != nil {
+		return nil, false, err
+	}
+	return element, true, nil
+}
+
+// LMove 从 source 的一端弹出元素，写入 destination 的一端，两个列表的修改在同一个批次中提交
+// source 为空时返回 nil
+func (rds *RedisDataStructure) LMove(source, destination []byte, srcLeft, dstLeft bool) ([]byte, error) {
+	element, _, err := rds.lmove(source, destination, srcLeft, dstLeft)
+	return element, err
+}
+
+func (rds *RedisDataStructure) lmove(source, destination []byte, srcLeft, dstLeft bool) ([]byte, bool, error) {
+	rds.listLock.Lock()
+	defer rds.listLock.Unlock()
+
+	srcMeta, err := rds.findMetadata(source, List)
+	if err != nil {
+		return nil, false, err
+	}
+	// 源和目标相同时使用同一份元数据
+	dstMeta := srcMeta
+	if !bytes.Equal(source, destination) {
+		if dstMeta, err = rds.findMetadata(destination, List); err != nil {
+			return nil, false, err
+		}
+	}
+	if srcMeta.size == 0 {
+		return nil, false, nil
+	}
+
+	wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
+	element, err := rds.listPop(wb, source, srcMeta, srcLeft)
+	if err != nil {
+		return nil, false, err
+	}
+	listPush(wb, destination, dstMeta, element, dstLeft)
+	_ = wb.Put(topLevelKey(source), srcMeta.encode())
+	_ = wb.Put(topLevelKey(destination), dstMeta.encode())
+	if err := wb.Commit(); err != nil {
+		return nil, false, err
+	}
+	rds.waiters.notify(destination)
+	return element, true, nil
+}
+
+// listPush 将写入元素的操作加入批次，并更新元数据
+func listPush(wb *bitcask.WriteBatch, key []byte, meta *metadata, element []byte, isLeft bool) {
+	// 构造数据部分的key
+	lk := &listInternalKey{
+		key:     key,
+		version: meta.version,
+	}
+	if isLeft {
+		lk.index = meta.head - 1
+	} else {
+		lk.index = meta.tail
+	}
+	_ = wb.Put(lk.encode(), element)
+
+	meta.size++
+	if isLeft {
+		meta.head--
+	} else {
+		meta.tail++
+	}
+}
+
+// listPop 读取一端的元素，将删除操作加入批次，并更新元数据
+func (rds *RedisDataStructure) listPop(wb *bitcask.WriteBatch, key []byte, meta *metadata, isLeft bool) ([]byte, error) {
+	lk := &listInternalKey{
+		key:     key,
+		version: meta.version,
+	}
+	if isLeft {
+		lk.index = meta.head
+	} else {
+		lk.index = meta.tail - 1
+	}
+	element, err := rds.db.Get(lk.encode())
+	if err != nil {
+		return nil, err
+	}
+
+	// 同时删除数据部分，只有 [head, tail) 范围内的 index 有数据
+	_ = wb.Delete(lk.encode())
+	meta.size--
+	if isLeft {
+		meta.head++
+	} else {
+		meta.tail--
+	}
+	return element, nil
+}
+
+func (rds *RedisDataStructure) LLen(key []byte) (uint32, error) {
+	meta, err := rds.findMetadata(key, List)
+	if err != nil {
+		return 0, err
+	}
+	return meta.size, nil
+}
+
+// LIndex 获取下标为 index 的元素，负数表示从尾部开始计算，超出范围时返回 nil
+func (rds *RedisDataStructure) LIndex(key []byte, index int64) ([]byte, error) {
+	meta, err := rds.findMetadata(key, List)
+	if err != nil {
+		return nil, err
+	}
+	pos, ok := listPosition(meta, index)
+	if !ok {
+		return nil, nil
+	}
+	return rds.listGet(key, meta, pos)
 }
 
-func (rds *RedisDataStructure) LPop(key []byte) ([]byte, error) {
-	return rds.PopInner(key, true)
-}
+// LRange 获取下标从 start 到 stop 的元素，包括 stop，负数表示从尾部开始计算
+func (rds *RedisDataStructure) LRange(key []byte, start, stop int64) ([][]byte, error) {
+	meta, err := rds.findMetadata(key, List)
+	if err != nil {
+		return nil, err
+	}
+	start, stop, ok := listRange(meta, start, stop)
+	if !ok {
+		return nil, nil
+	}
 
-func (rds *RedisDataStructure) RPop(key []byte) ([]byte, error) {
-	return rds.PopInner(key, false)
+	elements := make([][]byte, 0, stop-start+1)
+	for i := start; i <= stop; i++ {
+		element, err := rds.listGet(key, meta, meta.head+uint64(i))
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, element)
+	}
+	return elements, nil
 }
 
-func (rds *RedisDataStructure) PushInner(key, element []byte, isLeft bool) (uint32, error) {
-	// 查找元数据
+// LSet 修改下标为 index 的元素
+func (rds *RedisDataStructure) LSet(key []byte, index int64, element []byte) error {
+	rds.listLock.Lock()
+	defer rds.listLock.Unlock()
+
 	meta, err := rds.findMetadata(key, List)
 	if err != nil {
-		return 0, err
+		return err
+	}
+	if meta.size == 0 {
+		return ErrNoSuchKey
+	}
+	pos, ok := listPosition(meta, index)
+	if !ok {
+		return ErrIndexOutOfRange
 	}
 
-	// 构造数据部分的key
 	lk := &listInternalKey{
 		key:     key,
 		version: meta.version,
+		index:   pos,
 	}
+	return rds.db.Put(lk.encode(), element)
+}
 
-	if isLeft {
-		lk.index = meta.head - 1
-	} else {
-		lk.index = meta.tail
+// LTrim 只保留下标从 start 到 stop 的元素，范围之外的元素和元数据在同一个批次中删除和更新
+func (rds *RedisDataStructure) LTrim(key []byte, start, stop int64) error {
+	rds.listLock.Lock()
+	defer rds.listLock.Unlock()
+
+	meta, err := rds.findMetadata(key, List)
+	if err != nil {
+		return err
+	}
+	if meta.size == 0 {
+		return nil
 	}
 
-	// 更新元数据和数据部分
-	wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
-	meta.size++
-	if isLeft {
+	start, stop, ok := listRange(meta, start, stop)
+	newHead, newTail := meta.head+uint64(start), meta.head+uint64(stop)+1
+	// 范围为空时删除所有元素
+	if !ok {
+		newHead, newTail = meta.tail, meta.tail
+	}
+
+	wb := rds.newWriteBatch(int(meta.size) + 1)
+	for pos := meta.head; pos < meta.tail; pos++ {
+		if pos >= newHead && pos < newTail {
+			continue
+		}
+		lk := &listInternalKey{
+			key:     key,
+			version: meta.version,
+			index:   pos,
+		}
+		_ = wb.Delete(lk.encode())
+	}
+	meta.head, meta.tail = newHead, newTail
+	meta.size = uint32(newTail - newHead)
+	_ = wb.Put(topLevelKey(key), meta.encode())
+	return wb.Commit()
+}
+
+// LInsert 在第一个等于 pivot 的元素之前或之后插入元素，返回插入后的长度
+// 没有找到 pivot 时返回 -1，列表不存在时返回 0
+// 插入位置之前和之后的元素中，移动较少的一侧，为新元素空出位置，所有修改在同一个批次中提交
+func (rds *RedisDataStructure) LInsert(key []byte, before bool, pivot, element []byte) (int64, error) {
+	rds.listLock.Lock()
+	defer rds.listLock.Unlock()
+
+	meta, err := rds.findMetadata(key, List)
+	if err != nil {
+		return 0, err
+	}
+	if meta.size == 0 {
+		return 0, nil
+	}
+
+	elements, err := rds.listElements(key, meta)
+	if err != nil {
+		return 0, err
+	}
+	p := -1
+	for i, e := range elements {
+		if bytes.Equal(e, pivot) {
+			p = i
+			break
+		}
+	}
+	if p < 0 {
+		return -1, nil
+	}
+	// 新元素插入后的下标
+	if !before {
+		p++
+	}
+
+	wb := rds.newWriteBatch(len(elements) + 2)
+	if p < len(elements)-p {
+		// 前面的元素向 head 方向移动一位
 		meta.head--
+		for i := 0; i < p; i++ {
+			lk := &listInternalKey{key: key, version: meta.version, index: meta.head + uint64(i)}
+			_ = wb.Put(lk.encode(), elements[i])
+		}
 	} else {
+		// 后面的元素向 tail 方向移动一位
+		for i := p; i < len(elements); i++ {
+			lk := &listInternalKey{key: key, version: meta.version, index: meta.head + uint64(i) + 1}
+			_ = wb.Put(lk.encode(), elements[i])
+		}
 		meta.tail++
 	}
-	_ = wb.Put(key, meta.encode())
+	lk := &listInternalKey{key: key, version: meta.version, index: meta.head + uint64(p)}
 	_ = wb.Put(lk.encode(), element)
+	meta.size++
+	_ = wb.Put(topLevelKey(key), meta.encode())
 	if err := wb.Commit(); err != nil {
 		return 0, err
 	}
-	return meta.size, nil
+	return int64(meta.size), nil
 }
 
-func (rds *RedisDataStructure) PopInner(key []byte, isLeft bool) ([]byte, error) {
-	// 查找元数据
+// LRem 删除等于 element 的元素，返回删除的数量
+// count 大于 0 时从头部开始删除 count 个，小于 0 时从尾部开始删除 -count 个，等于 0 时删除所有
+// 删除后将剩余的元素向 head 方向紧凑排列，第一个被删除的位置之前的元素不需要移动
+func (rds *RedisDataStructure) LRem(key []byte, count int64, element []byte) (uint32, error) {
+	rds.listLock.Lock()
+	defer rds.listLock.Unlock()
+
 	meta, err := rds.findMetadata(key, List)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 	if meta.size == 0 {
-		return nil, nil
+		return 0, nil
 	}
 
-	lk := &listInternalKey{
-		key:     key,
-		version: meta.version,
+	elements, err := rds.listElements(key, meta)
+	if err != nil {
+		return 0, err
 	}
-	if isLeft {
-		lk.index = meta.head
-	} else {
-		lk.index = meta.tail - 1
+	removed := make([]bool, len(elements))
+	var n int64
+	limit := count
+	if limit < 0 {
+		limit = -limit
 	}
-	element, err := rds.db.Get(lk.encode())
-	if err != nil {
-		return nil, err
+	for i := range elements {
+		// count 小于 0 时从尾部开始查找
+		j := i
+		if count < 0 {
+			j = len(elements) - 1 - i
+		}
+		if bytes.Equal(elements[j], element) {
+			removed[j] = true
+			n++
+			if n == limit {
+				break
+			}
+		}
+	}
+	if n == 0 {
+		return 0, nil
 	}
 
-	meta.size--
-	if isLeft {
-		meta.head++
-	} else {
-		meta.tail--
+	wb := rds.newWriteBatch(len(elements) + 1)
+	var kept uint64
+	var moved bool
+	for i, e := range elements {
+		if removed[i] {
+			moved = true
+			continue
+		}
+		if moved {
+			lk := &listInternalKey{key: key, version: meta.version, index: meta.head + kept}
+			_ = wb.Put(lk.encode(), e)
+		}
+		kept++
+	}
+	// 删除紧凑排列之后多出来的位置
+	for pos := meta.head + kept; pos < meta.tail; pos++ {
+		lk := &listInternalKey{key: key, version: meta.version, index: pos}
+		_ = wb.Delete(lk.encode())
 	}
+	meta.tail = meta.head + kept
+	meta.size = uint32(kept)
+	_ = wb.Put(topLevelKey(key), meta.encode())
+	if err := wb.Commit(); err != nil {
+		return 0, err
+	}
+	return uint32(n), nil
+}
 
-	if err := rds.db.Put(key, meta.encode()); err != nil {
-		return nil, err
+// listPosition 将下标转换为数据部分的 index，负数表示从尾部开始计算
+func listPosition(meta *metadata, index int64) (uint64, bool) {
+	if index < 0 {
+		index += int64(meta.size)
+	}
+	if index < 0 || index >= int64(meta.size) {
+		return 0, false
 	}
+	return meta.head + uint64(index), true
+}
 
-	return element, nil
+// listRange 将 start 和 stop 转换为列表范围内的下标，范围为空时返回 false
+func listRange(meta *metadata, start, stop int64) (int64, int64, bool) {
+	size := int64(meta.size)
+	if start < 0 {
+		start += size
+	}
+	if stop < 0 {
+		stop += size
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= size {
+		stop = size - 1
+	}
+	if start > stop || start >= size {
+		return 0, 0, false
+	}
+	return start, stop, true
+}
+
+func (rds *RedisDataStructure) listGet(key []byte, meta *metadata, pos uint64) ([]byte, error) {
+	lk := &listInternalKey{
+		key:     key,
+		version: meta.version,
+		index:   pos,
+	}
+	return rds.db.Get(lk.encode())
+}
+
+// listElements 按顺序读取列表的所有元素
+func (rds *RedisDataStructure) listElements(key []byte, meta *metadata) ([][]byte, error) {
+	elements := make([][]byte, 0, meta.size)
+	for pos := meta.head; pos < meta.tail; pos++ {
+		element, err := rds.listGet(key, meta, pos)
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, element)
+	}
+	return elements, nil
 }
 
 // ==================== ZSet 数据结构 ====================
 func (rds *RedisDataStructure) ZAdd(key []byte, score float64, member []byte) (bool, error) {
+	rds.zsetLock.Lock()
+	defer rds.zsetLock.Unlock()
+	return rds.zAdd(key, score, member)
+}
+
+// zAdd 调用前需要持有 zsetLock，读取旧的 score 和删除旧的按 score 排序的 key 之间不能有其他写入
+func (rds *RedisDataStructure) zAdd(key []byte, score float64, member []byte) (bool, error) {
 	meta, err := rds.findMetadata(key, ZSet)
 	if err != nil {
 		return false, err
@@ -380,7 +1718,7 @@ func (rds *RedisDataStructure) ZAdd(key []byte, score float64, member []byte) (b
 	wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
 	if !exist {
 		meta.size++
-		_ = wb.Put(key, meta.encode())
+		_ = wb.Put(topLevelKey(key), meta.encode())
 	}
 	if exist {
 		oldKey := &zsetInternalKey{
@@ -405,7 +1743,7 @@ func (rds *RedisDataStructure) ZScore(key []byte, member []byte) (float64, error
 		return -1, err
 	}
 	if meta.size == 0 {
-		return -1, nil
+		return -1, bitcask.ErrKeyNotFound
 	}
 
 	// 构造数据部分的key
@@ -421,15 +1759,332 @@ func (rds *RedisDataStructure) ZScore(key []byte, member []byte) (float64, error
 	return utils.FloatFromBytes(value), nil
 }
 
+// ZMember ZSet 中的 member 和对应的 score
+type ZMember struct {
+	Member []byte
+	Score  float64
+}
+
+// ScoreBound score 范围的边界，Exclusive 为 true 时不包括边界本身
+type ScoreBound struct {
+	Score     float64
+	Exclusive bool
+}
+
+func (rds *RedisDataStructure) ZCard(key []byte) (uint32, error) {
+	meta, err := rds.findMetadata(key, ZSet)
+	if err != nil {
+		return 0, err
+	}
+	return meta.size, nil
+}
+
+// ZRem 删除 member，返回删除的数量
+func (rds *RedisDataStructure) ZRem(key []byte, members ...[]byte) (uint32, error) {
+	rds.zsetLock.Lock()
+	defer rds.zsetLock.Unlock()
+
+	meta, err := rds.findMetadata(key, ZSet)
+	if err != nil {
+		return 0, err
+	}
+	if meta.size == 0 {
+		return 0, nil
+	}
+
+	var removed uint32
+	seen := make(map[string]struct{}, len(members))
+	wb := rds.newWriteBatch(len(members)*2 + 1)
+	for _, member := range members {
+		if _, ok := seen[string(member)]; ok {
+			continue
+		}
+		seen[string(member)] = struct{}{}
+
+		zk := &zsetInternalKey{
+			key:     key,
+			version: meta.version,
+			member:  member,
+		}
+		value, err := rds.db.Get(zk.encodeWithMeber())
+		if err == bitcask.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		zk.score = utils.FloatFromBytes(value)
+		_ = wb.Delete(zk.encodeWithMeber())
+		_ = wb.Delete(zk.encodeWithScore())
+		removed++
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	meta.size -= removed
+	_ = wb.Put(topLevelKey(key), meta.encode())
+	if err := wb.Commit(); err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// ZIncrBy 将 member 的 score 加上 incr，member 不存在时从 0 开始，返回新的 score
+func (rds *RedisDataStructure) ZIncrBy(key []byte, incr float64, member []byte) (float64, error) {
+	rds.zsetLock.Lock()
+	defer rds.zsetLock.Unlock()
+
+	score, err := rds.ZScore(key, member)
+	if err == bitcask.ErrKeyNotFound {
+		score = 0
+	} else if err != nil {
+		return 0, err
+	}
+
+	score += incr
+	if math.IsNaN(score) {
+		return 0, ErrScoreNaN
+	}
+	if _, err := rds.zAdd(key, score, member); err != nil {
+		return 0, err
+	}
+	return score, nil
+}
+
+// ZRange 按照 score 从小到大的顺序返回排名从 start 到 stop 的 member，包括 stop，负数表示从末尾开始计算
+// reverse 为 true 时按照 score 从大到小的顺序排名，score 相同时按照 member 排序
+func (rds *RedisDataStructure) ZRange(key []byte, start, stop int64, reverse bool) ([]ZMember, error) {
+	meta, err := rds.findMetadata(key, ZSet)
+	if err != nil {
+		return nil, err
+	}
+	start, stop, ok := listRange(meta, start, stop)
+	if !ok {
+		return nil, nil
+	}
+
+	var res []ZMember
+	var rank int64
+	err = rds.zsetScan(key, meta, nil, reverse, func(zm ZMember) bool {
+		if rank >= start {
+			res = append(res, zm)
+		}
+		rank++
+		return rank <= stop
+	})
+	return res, err
+}
+
+// ZRangeByScore 返回 score 在 min 和 max 之间的 member，跳过前 offset 个，最多返回 count 个，count 小于 0 时返回所有
+// reverse 为 true 时从 max 开始按照 score 从大到小的顺序返回
+func (rds *RedisDataStructure) ZRangeByScore(key []byte, min, max ScoreBound, offset, count int, reverse bool) ([]ZMember, error) {
+	var res []ZMember
+	if count == 0 {
+		return res, nil
+	}
+	err := rds.zsetScanByScore(key, min, max, reverse, func(zm ZMember) bool {
+		if offset > 0 {
+			offset--
+			return true
+		}
+		res = append(res, zm)
+		return count < 0 || len(res) < count
+	})
+	return res, err
+}
+
+// ZCount 返回 score 在 min 和 max 之间的 member 数量
+func (rds *RedisDataStructure) ZCount(key []byte, min, max ScoreBound) (int, error) {
+	var count int
+	err := rds.zsetScanByScore(key, min, max, false, func(ZMember) bool {
+		count++
+		return true
+	})
+	return count, err
+}
+
+// ZRank 返回 member 按照 score 从小到大的排名，reverse 为 true 时按照从大到小排名，member 不存在时 ok 为 false
+func (rds *RedisDataStructure) ZRank(key, member []byte, reverse bool) (rank int64, ok bool, err error) {
+	meta, err := rds.findMetadata(key, ZSet)
+	if err != nil {
+		return 0, false, err
+	}
+	if meta.size == 0 {
+		return 0, false, nil
+	}
+
+	zk := &zsetInternalKey{
+		key:     key,
+		version: meta.version,
+		member:  member,
+	}
+	value, err := rds.db.Get(zk.encodeWithMeber())
+	if err == bitcask.ErrKeyNotFound {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	// 排名等于按照顺序排在它前面的 member 数量
+	score := utils.FloatFromBytes(value)
+	err = rds.zsetScan(key, meta, nil, reverse, func(zm ZMember) bool {
+		if zm.Score == score && bytes.Equal(zm.Member, member) {
+			return false
+		}
+		rank++
+		return true
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return rank, true, nil
+}
+
+// zsetScanByScore 按照 score 的顺序遍历 score 在 min 和 max 之间的 member，reverse 为 true 时从 max 开始
+func (rds *RedisDataStructure) zsetScanByScore(key []byte, min, max ScoreBound, reverse bool, fn func(zm ZMember) bool) error {
+	meta, err := rds.findMetadata(key, ZSet)
+	if err != nil {
+		return err
+	}
+	from := min.Score
+	if reverse {
+		from = max.Score
+	}
+	return rds.zsetScan(key, meta, &from, reverse, func(zm ZMember) bool {
+		// 起点一侧的边界只需要跳过等于边界的 member，遇到另一侧的边界时结束遍历
+		aboveMin := zm.Score > min.Score || (!min.Exclusive && zm.Score == min.Score)
+		belowMax := zm.Score < max.Score || (!max.Exclusive && zm.Score == max.Score)
+		if reverse {
+			if !aboveMin {
+				return false
+			}
+			if !belowMax {
+				return true
+			}
+		} else {
+			if !belowMax {
+				return false
+			}
+			if !aboveMin {
+				return true
+			}
+		}
+		return fn(zm)
+	})
+}
+
+// zsetScan 按照 score 的顺序遍历 ZSet，from 不为空时从这个 score 开始，reverse 为 true 时从大到小遍历
+func (rds *RedisDataStructure) zsetScan(key []byte, meta *metadata, from *float64, reverse bool, fn func(zm ZMember) bool) error {
+	if meta.size == 0 {
+		return nil
+	}
+	zk := &zsetInternalKey{
+		key:     key,
+		version: meta.version,
+	}
+	prefix := zk.scorePrefix()
+
+	var seek []byte
+	if from != nil {
+		scoreBuf := utils.Float64ToOrderedBytes(*from)
+		if reverse {
+			// 反向遍历时定位到下一个 score 之前，包括等于 from 的所有 member
+			scoreBuf = nextKey(scoreBuf)
+		}
+		if scoreBuf != nil {
+			seek = append(append([]byte{}, prefix...), scoreBuf...)
+		}
+	}
+	return rds.scanKeys(prefix, seek, reverse, func(encKey []byte) bool {
+		score, member := decodeZSetScoreKey(encKey, len(prefix))
+		return fn(ZMember{Member: member, Score: score})
+	})
+}
+
+// scanKeys 按照 key 的顺序遍历以 prefix 开头的 key，seek 不为空时从 seek 开始，reverse 为 true 时反向遍历
+// 传给 fn 的 key 是复制出来的，fn 返回 false 时停止遍历
+func (rds *RedisDataStructure) scanKeys(prefix, seek []byte, reverse bool, fn func(key []byte) bool) error {
+	iter := rds.db.NewIterator(bitcask.IteratorOptions{Reverse: reverse})
+	defer iter.Close()
+
+	if seek == nil {
+		seek = prefix
+		if reverse {
+			// 反向遍历时从前缀范围之后的第一个 key 开始
+			seek = nextKey(prefix)
+		}
+	}
+	if seek == nil {
+		iter.Rewind()
+	} else {
+		iter.Seek(seek)
+	}
+	// 反向 Seek 会定位到小于等于 seek 的 key，跳过刚好等于 seek 且不在前缀范围内的 key
+	if reverse && iter.Valid() && !bytes.HasPrefix(iter.Key(), prefix) && bytes.Equal(iter.Key(), seek) {
+		iter.Next()
+	}
+	for ; iter.Valid() && bytes.HasPrefix(iter.Key(), prefix); iter.Next() {
+		if !fn(append([]byte{}, iter.Key()...)) {
+			break
+		}
+	}
+	return nil
+}
+
+// nextKey 返回大于所有以 key 开头的 key 的最小 key，key 的所有字节都是 0xff 时返回 nil
+func nextKey(key []byte) []byte {
+	next := append([]byte{}, key...)
+	for i := len(next) - 1; i >= 0; i-- {
+		if next[i] < 0xff {
+			next[i]++
+			return next[:i+1]
+		}
+	}
+	return nil
+}
+
+// newWriteBatch 新建至少能容纳 n 条数据的批次，需要原子写入大量数据时使用
+func (rds *RedisDataStructure) newWriteBatch(n int) *bitcask.WriteBatch {
+	opts := bitcask.DefaultWriteBatchOptions
+	if uint(n) > opts.MaxBatchNum {
+		opts.MaxBatchNum = uint(n)
+	}
+	return rds.db.NewWriteBatch(opts)
+}
+
+// scanPrefix 按照 key 的顺序遍历以 prefix 开头的数据部分，withValue 为 false 时不读取值，fn 返回 false 时停止遍历
+// 传给 fn 的 key 和 value 都是复制出来的，可以直接保存
+func (rds *RedisDataStructure) scanPrefix(prefix []byte, withValue bool, fn func(key, value []byte) bool) error {
+	// 不使用迭代器的 Prefix 选项，它会在前缀范围之后继续查找匹配的 key
+	iter := rds.db.NewIterator(bitcask.DefaultIteratorOptions)
+	defer iter.Close()
+	// 定位到前缀的起点，遇到第一个不匹配的 key 时说明前缀范围已经遍历完了
+	for iter.Seek(prefix); iter.Valid() && bytes.HasPrefix(iter.Key(), prefix); iter.Next() {
+		var value []byte
+		if withValue {
+			var err error
+			if value, err = iter.Value(); err != nil {
+				return err
+			}
+		}
+		if !fn(append([]byte{}, iter.Key()...), value) {
+			break
+		}
+	}
+	return nil
+}
+
 func (rds *RedisDataStructure) findMetadata(key []byte, dataType redisDataType) (*metadata, error) {
-	metaBuf, err := rds.db.Get(key)
+	metaBuf, err := rds.db.Get(topLevelKey(key))
 	if err != nil && err != bitcask.ErrKeyNotFound {
 		return nil, err
 	}
 
 	var exist = true
 	var meta *metadata
-	if err == bitcask.ErrKeyNotFound {
+	// 已经过期的 key 不论是什么类型都当作不存在，先判断过期时间再判断数据类型
+	if err == bitcask.ErrKeyNotFound || isExpired(metaBuf) {
 		exist = false
 	} else {
 		meta = decodeMetadata(metaBuf)
@@ -437,10 +2092,6 @@ func (rds *RedisDataStructure) findMetadata(key []byte, dataType redisDataType)
 		if meta.dataType != dataType {
 			return nil, ErrWrongTypeOperation
 		}
-		// 判断过期时间
-		if meta.expire != 0 && meta.expire <= time.Now().UnixNano() {
-			exist = false
-		}
 	}
 	if !exist {
 		meta = &metadata{
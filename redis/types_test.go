@@ -3,12 +3,50 @@ package redis
 import (
 	bitcask "SingleKVDataSet"
 	"SingleKVDataSet/utils"
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"math"
 	"os"
+	"sync"
 	"testing"
 	"time"
 )
 
+func TestNewRedisDataStructure_DataFormat(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	opts.DirPath = t.TempDir()
+
+	// 空的数据库写入当前的版本号，之后可以重新打开
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+	err = rds.Set([]byte("key"), 0, []byte("value"))
+	assert.Nil(t, err)
+	assert.Nil(t, rds.Close())
+	rds, err = NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+	value, err := rds.Get([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+	assert.Nil(t, rds.Close())
+
+	// 版本号不同
+	db, err := bitcask.Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put(dataFormatKey, []byte("0")))
+	assert.Nil(t, db.Close())
+	_, err = NewRedisDataStructure(opts)
+	assert.Equal(t, ErrDataFormat, err)
+
+	// 没有版本号的旧数据
+	opts.DirPath = t.TempDir()
+	db, err = bitcask.Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("key"), encodeStringValue([]byte("value"), 0)))
+	assert.Nil(t, db.Close())
+	_, err = NewRedisDataStructure(opts)
+	assert.Equal(t, ErrDataFormat, err)
+}
+
 func TestRedisDataStructure_Get(t *testing.T) {
 	opts := bitcask.DefaultOptions
 	dir, _ := os.MkdirTemp("../TestingFile", "bitcask-go-string-get")
@@ -43,14 +81,16 @@ func TestRedisDataStructure_Del_Type(t *testing.T) {
 	assert.Nil(t, err)
 
 	// del
-	err = rds.Del(utils.GetTestKey(11))
+	exist, err := rds.Del(utils.GetTestKey(11))
 	assert.Nil(t, err)
+	assert.False(t, exist)
 
 	err = rds.Set(utils.GetTestKey(1), 0, utils.RandomValue(256))
 	assert.Nil(t, err)
 
-	err = rds.Del(utils.GetTestKey(1))
+	exist, err = rds.Del(utils.GetTestKey(1))
 	assert.Nil(t, err)
+	assert.True(t, exist)
 
 	_, err = rds.Get(utils.GetTestKey(1))
 	//t.Log(err)
@@ -65,6 +105,233 @@ func TestRedisDataStructure_Del_Type(t *testing.T) {
 	assert.Equal(t, typ, String)
 }
 
+func TestRedisDataStructure_SetWithOptions(t *testing.T) {
+	rds := newTestRedisDataStructure(t)
+
+	// NX 只在 key 不存在时写入
+	_, ok, err := rds.SetWithOptions(utils.GetTestKey(1), []byte("a"), SetOptions{NX: true})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = rds.SetNX(utils.GetTestKey(1), []byte("b"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// XX 只在 key 存在时写入
+	_, ok, err = rds.SetWithOptions(utils.GetTestKey(2), []byte("a"), SetOptions{XX: true})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	_, ok, err = rds.SetWithOptions(utils.GetTestKey(1), []byte("b"), SetOptions{XX: true, ExpireAt: time.Now().Add(time.Hour)})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// GET 返回原有的值，KEEPTTL 保留原有的过期时间
+	old, ok, err := rds.SetWithOptions(utils.GetTestKey(1), []byte("c"), SetOptions{Get: true, KeepTTL: true})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("b"), old)
+	_, expire, exist, err := rds.getString(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.True(t, exist)
+	assert.True(t, expire > time.Now().UnixNano())
+
+	// 已经过期的 key 当作不存在
+	_, ok, err = rds.SetWithOptions(utils.GetTestKey(3), []byte("a"), SetOptions{ExpireAt: time.Now().Add(-time.Second)})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	old, ok, err = rds.SetWithOptions(utils.GetTestKey(3), []byte("b"), SetOptions{NX: true, Get: true})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Nil(t, old)
+
+	// GET 遇到其他类型时返回错误
+	_, err = rds.HSet(utils.GetTestKey(4), []byte("field"), []byte("value"))
+	assert.Nil(t, err)
+	_, err = rds.GetSet(utils.GetTestKey(4), []byte("a"))
+	assert.Equal(t, ErrWrongTypeOperation, err)
+
+	// GETDEL
+	value, err := rds.GetDel(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("c"), value)
+	value, err = rds.GetDel(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Nil(t, value)
+}
+
+func TestRedisDataStructure_Set_Lock(t *testing.T) {
+	rds := newTestRedisDataStructure(t)
+
+	// INCR 等命令读取和写入之间持有 stringLock，Set 需要等待它们完成
+	rds.stringLock.Lock()
+	done := make(chan error)
+	go func() {
+		done <- rds.Set(utils.GetTestKey(1), 0, []byte("value"))
+	}()
+	select {
+	case <-done:
+		t.Fatal("Set should wait for stringLock")
+	case <-time.After(20 * time.Millisecond):
+	}
+	rds.stringLock.Unlock()
+	assert.Nil(t, <-done)
+}
+
+func TestRedisDataStructure_Set_OtherType_Lock(t *testing.T) {
+	rds := newTestRedisDataStructure(t)
+	_, err := rds.HSet(utils.GetTestKey(1), []byte("field"), []byte("v"))
+	assert.Nil(t, err)
+
+	// 覆盖 Hash 时需要等待 HSET 等命令完成，否则它们会把读取到的元数据写回，覆盖写入的值
+	rds.hashLock.Lock()
+	done := make(chan error, 2)
+	go func() {
+		done <- rds.Set(utils.GetTestKey(1), 0, []byte("value"))
+	}()
+	go func() {
+		done <- rds.MSet([][]byte{utils.GetTestKey(2), utils.GetTestKey(1)}, [][]byte{[]byte("a"), []byte("value")})
+	}()
+	select {
+	case <-done:
+		t.Fatal("Set should wait for hashLock when it replaces a hash")
+	case <-time.After(20 * time.Millisecond):
+	}
+	// 覆盖 String 或者不存在的 key 不需要等待其他类型的锁
+	assert.Nil(t, rds.Set(utils.GetTestKey(3), 0, []byte("value")))
+	rds.hashLock.Unlock()
+	assert.Nil(t, <-done)
+	assert.Nil(t, <-done)
+
+	value, err := rds.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+	_, err = rds.HGet(utils.GetTestKey(1), []byte("field"))
+	assert.Equal(t, ErrWrongTypeOperation, err)
+}
+
+func TestRedisDataStructure_MSet_MGet(t *testing.T) {
+	rds := newTestRedisDataStructure(t)
+
+	err := rds.MSet([][]byte{utils.GetTestKey(1), utils.GetTestKey(2)}, [][]byte{[]byte("a"), []byte("b")})
+	assert.Nil(t, err)
+
+	// 只要有一个 key 存在就不写入
+	ok, err := rds.MSetNX([][]byte{utils.GetTestKey(2), utils.GetTestKey(3)}, [][]byte{[]byte("c"), []byte("c")})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = rds.MSetNX([][]byte{utils.GetTestKey(3), utils.GetTestKey(4)}, [][]byte{[]byte("c"), []byte("d")})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	_, err = rds.SAdd(utils.GetTestKey(5), []byte("member"))
+	assert.Nil(t, err)
+	values, err := rds.MGet([][]byte{utils.GetTestKey(1), utils.GetTestKey(2), utils.GetTestKey(3),
+		utils.GetTestKey(4), utils.GetTestKey(5), utils.GetTestKey(6)})
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d"), nil, nil}, values)
+}
+
+func TestRedisDataStructure_IncrBy(t *testing.T) {
+	rds := newTestRedisDataStructure(t)
+
+	res, err := rds.IncrBy(utils.GetTestKey(1), 10)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), res)
+	res, err = rds.IncrBy(utils.GetTestKey(1), -25)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-15), res)
+
+	// 溢出时返回错误，原有的值不变
+	err = rds.Set(utils.GetTestKey(2), time.Hour, []byte("9223372036854775807"))
+	assert.Nil(t, err)
+	_, err = rds.IncrBy(utils.GetTestKey(2), 1)
+	assert.Equal(t, ErrIncrOverflow, err)
+	res, err = rds.IncrBy(utils.GetTestKey(2), -7)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(math.MaxInt64-7), res)
+	// 保留原有的过期时间
+	_, expire, _, err := rds.getString(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.True(t, expire > 0)
+
+	err = rds.Set(utils.GetTestKey(3), 0, []byte("1.5"))
+	assert.Nil(t, err)
+	_, err = rds.IncrBy(utils.GetTestKey(3), 1)
+	assert.Equal(t, ErrValueNotInteger, err)
+
+	f, err := rds.IncrByFloat(utils.GetTestKey(3), 0.25)
+	assert.Nil(t, err)
+	assert.Equal(t, 1.75, f)
+	value, err := rds.Get(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1.75"), value)
+
+	err = rds.Set(utils.GetTestKey(4), 0, []byte("abc"))
+	assert.Nil(t, err)
+	_, err = rds.IncrByFloat(utils.GetTestKey(4), 1)
+	assert.Equal(t, ErrValueNotFloat, err)
+	_, err = rds.IncrByFloat(utils.GetTestKey(5), math.Inf(1))
+	assert.Equal(t, ErrIncrNaNOrInfinity, err)
+}
+
+func TestRedisDataStructure_Append_Range(t *testing.T) {
+	rds := newTestRedisDataStructure(t)
+
+	n, err := rds.Append(utils.GetTestKey(1), []byte("Hello"))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	n, err = rds.Append(utils.GetTestKey(1), []byte(" World"))
+	assert.Nil(t, err)
+	assert.Equal(t, 11, n)
+
+	n, err = rds.SetRange(utils.GetTestKey(1), 6, []byte("Redis"))
+	assert.Nil(t, err)
+	assert.Equal(t, 11, n)
+	value, err := rds.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("Hello Redis"), value)
+
+	// 不够长时用 0 填充
+	n, err = rds.SetRange(utils.GetTestKey(2), 3, []byte("ab"))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	value, err = rds.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 'a', 'b'}, value)
+
+	// 写入空数据时不创建 key
+	n, err = rds.SetRange(utils.GetTestKey(3), 10, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+	_, err = rds.Get(utils.GetTestKey(3))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+	_, err = rds.SetRange(utils.GetTestKey(3), -1, []byte("a"))
+	assert.Equal(t, ErrOffsetOutOfRange, err)
+
+	tests := []struct {
+		start, end int64
+		expected   string
+	}{
+		{0, 4, "Hello"},
+		{-5, -1, "Redis"},
+		{0, -1, "Hello Redis"},
+		{-100, 100, "Hello Redis"},
+		{5, 3, ""},
+		{20, 30, ""},
+	}
+	for _, test := range tests {
+		value, err := rds.GetRange(utils.GetTestKey(1), test.start, test.end)
+		assert.Nil(t, err)
+		assert.Equal(t, test.expected, string(value), "%d %d", test.start, test.end)
+	}
+
+	n, err = rds.StrLen(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, 11, n)
+	n, err = rds.StrLen(utils.GetTestKey(4))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+}
+
 func TestRedisDataStructure_HSet_HGet(t *testing.T) {
 	opts := bitcask.DefaultOptions
 	dir, _ := os.MkdirTemp("../TestingFile", "bitcask-go-hash-get")
@@ -140,6 +407,174 @@ func TestRedisDataStructure_HDel(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestRedisDataStructure_HMSet_HGetAll(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("../TestingFile", "bitcask-go-hash-getall")
+	opts.DirPath = dir
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+
+	all, err := rds.HGetAll(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Nil(t, all)
+
+	fields := [][]byte{[]byte("f3"), []byte("f1"), []byte("f2"), []byte("f1")}
+	values := [][]byte{[]byte("v3"), []byte("old"), []byte("v2"), []byte("v1")}
+	added, err := rds.HMSet(utils.GetTestKey(1), fields, values)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, added)
+	// 相邻的 key 不会被遍历到
+	_, err = rds.HSet(utils.GetTestKey(2), []byte("f0"), []byte("other"))
+	assert.Nil(t, err)
+
+	size, err := rds.HLen(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(3), size)
+
+	all, err = rds.HGetAll(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("f1"), []byte("v1"), []byte("f2"), []byte("v2"), []byte("f3"), []byte("v3")}, all)
+	keys, err := rds.HKeys(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("f1"), []byte("f2"), []byte("f3")}, keys)
+	vals, err := rds.HVals(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("v1"), []byte("v2"), []byte("v3")}, vals)
+
+	res, err := rds.HMGet(utils.GetTestKey(1), [][]byte{[]byte("f2"), []byte("not-exist")})
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("v2"), nil}, res)
+
+	exist, err := rds.HExists(utils.GetTestKey(1), []byte("f1"))
+	assert.Nil(t, err)
+	assert.True(t, exist)
+	exist, err = rds.HExists(utils.GetTestKey(1), []byte("not-exist"))
+	assert.Nil(t, err)
+	assert.False(t, exist)
+
+	ok, err := rds.HSetNX(utils.GetTestKey(1), []byte("f1"), []byte("new"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = rds.HSetNX(utils.GetTestKey(1), []byte("f4"), []byte("v4"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	size, err = rds.HLen(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(4), size)
+
+	_, err = rds.HGetAll(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	err = rds.Set(utils.GetTestKey(3), 0, []byte("string"))
+	assert.Nil(t, err)
+	_, err = rds.HGetAll(utils.GetTestKey(3))
+	assert.Equal(t, ErrWrongTypeOperation, err)
+}
+
+func TestRedisDataStructure_HIncrBy(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("../TestingFile", "bitcask-go-hash-incrby")
+	opts.DirPath = dir
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+
+	res1, err := rds.HIncrBy(utils.GetTestKey(1), []byte("count"), 5)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), res1)
+	res2, err := rds.HIncrBy(utils.GetTestKey(1), []byte("count"), -7)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-2), res2)
+
+	_, err = rds.HSet(utils.GetTestKey(1), []byte("max"), []byte("9223372036854775807"))
+	assert.Nil(t, err)
+	_, err = rds.HIncrBy(utils.GetTestKey(1), []byte("max"), 1)
+	assert.Equal(t, ErrIncrOverflow, err)
+
+	_, err = rds.HSet(utils.GetTestKey(1), []byte("name"), []byte("bitcask"))
+	assert.Nil(t, err)
+	_, err = rds.HIncrBy(utils.GetTestKey(1), []byte("name"), 1)
+	assert.Equal(t, ErrHashValueNotInteger, err)
+	_, err = rds.HIncrByFloat(utils.GetTestKey(1), []byte("name"), 1)
+	assert.Equal(t, ErrHashValueNotFloat, err)
+
+	res3, err := rds.HIncrByFloat(utils.GetTestKey(1), []byte("count"), 0.5)
+	assert.Nil(t, err)
+	assert.Equal(t, -1.5, res3)
+	value, err := rds.HGet(utils.GetTestKey(1), []byte("count"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("-1.5"), value)
+}
+
+func TestRedisDataStructure_HIncrBy_Concurrent(t *testing.T) {
+	rds := newTestRedisDataStructure(t)
+
+	// 多个客户端同时对同一个 field 做加法，每次加法都不会丢失
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				_, err := rds.HIncrBy(utils.GetTestKey(1), []byte("count"), 1)
+				assert.Nil(t, err)
+				_, err = rds.HIncrByFloat(utils.GetTestKey(1), []byte("float"), 0.5)
+				assert.Nil(t, err)
+				// 同时写入新的 field，元数据中的数量也不会丢失
+				_, err = rds.HSetNX(utils.GetTestKey(1), []byte(fmt.Sprintf("field-%d-%d", g, i)), []byte("v"))
+				assert.Nil(t, err)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	value, err := rds.HGet(utils.GetTestKey(1), []byte("count"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1600"), value)
+	value, err = rds.HGet(utils.GetTestKey(1), []byte("float"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("800"), value)
+	size, err := rds.HLen(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(8*200+2), size)
+}
+
+func TestRedisDataStructure_HScan(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("../TestingFile", "bitcask-go-hash-scan")
+	opts.DirPath = dir
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 25; i++ {
+		_, err := rds.HSet(utils.GetTestKey(1), []byte(fmt.Sprintf("field-%02d", i)), []byte("value"))
+		assert.Nil(t, err)
+	}
+
+	// 按照 cursor 分批遍历所有 field
+	var cursor uint64
+	var fields []string
+	for {
+		next, res, err := rds.HScan(utils.GetTestKey(1), cursor, nil, 10)
+		assert.Nil(t, err)
+		assert.True(t, len(res) <= 20)
+		for i := 0; i < len(res); i += 2 {
+			fields = append(fields, string(res[i]))
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	assert.Equal(t, 25, len(fields))
+	assert.Equal(t, "field-00", fields[0])
+	assert.Equal(t, "field-24", fields[24])
+
+	next, res, err := rds.HScan(utils.GetTestKey(1), 0, []byte("field-1?"), 100)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), next)
+	assert.Equal(t, 20, len(res))
+	assert.Equal(t, []byte("field-10"), res[0])
+}
+
 func TestRedisDataStructure_SIsMember(t *testing.T) {
 	opts := bitcask.DefaultOptions
 	dir, _ := os.MkdirTemp("../TestingFile", "bitcask-go-set-SIsMember")
@@ -222,6 +657,319 @@ func TestRedisDataStructure_SRem(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestRedisDataStructure_SMembers(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("../TestingFile", "bitcask-go-set-smembers")
+	opts.DirPath = dir
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+
+	for _, member := range []string{"c", "a", "b"} {
+		_, err := rds.SAdd(utils.GetTestKey(1), []byte(member))
+		assert.Nil(t, err)
+	}
+	_, err = rds.SAdd(utils.GetTestKey(2), []byte("other"))
+	assert.Nil(t, err)
+
+	members, err := rds.SMembers(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b"), []byte("c")}, members)
+
+	// 删除 member 后元数据和数据部分保持一致
+	ok, err := rds.SRem(utils.GetTestKey(1), []byte("b"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	size, err := rds.SCard(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2), size)
+	members, err = rds.SMembers(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("c")}, members)
+
+	rand1, err := rds.SRandMember(utils.GetTestKey(1), 5)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(rand1))
+	rand2, err := rds.SRandMember(utils.GetTestKey(1), -5)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(rand2))
+
+	popped, err := rds.SPop(utils.GetTestKey(1), 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(popped))
+	isMember, err := rds.SIsMember(utils.GetTestKey(1), popped[0])
+	assert.Nil(t, err)
+	assert.False(t, isMember)
+	popped, err = rds.SPop(utils.GetTestKey(1), 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(popped))
+	size, err = rds.SCard(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), size)
+
+	next, res, err := rds.SScan(utils.GetTestKey(2), 0, []byte("oth*"), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), next)
+	assert.Equal(t, [][]byte{[]byte("other")}, res)
+}
+
+func TestRedisDataStructure_SMove(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("../TestingFile", "bitcask-go-set-smove")
+	opts.DirPath = dir
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+
+	_, err = rds.SAdd(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	_, err = rds.SAdd(utils.GetTestKey(1), []byte("b"))
+	assert.Nil(t, err)
+
+	ok, err := rds.SMove(utils.GetTestKey(1), utils.GetTestKey(2), []byte("not-exist"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = rds.SMove(utils.GetTestKey(1), utils.GetTestKey(2), []byte("a"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	members1, err := rds.SMembers(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("b")}, members1)
+	members2, err := rds.SMembers(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a")}, members2)
+
+	err = rds.Set(utils.GetTestKey(3), 0, []byte("string"))
+	assert.Nil(t, err)
+	_, err = rds.SMove(utils.GetTestKey(1), utils.GetTestKey(3), []byte("b"))
+	assert.Equal(t, ErrWrongTypeOperation, err)
+}
+
+func TestRedisDataStructure_SPop_SRandMember(t *testing.T) {
+	rds := newTestRedisDataStructure(t)
+
+	var all []string
+	for i := 0; i < 10; i++ {
+		member := fmt.Sprintf("member-%d", i)
+		all = append(all, member)
+		_, err := rds.SAdd(utils.GetTestKey(1), []byte(member))
+		assert.Nil(t, err)
+	}
+
+	// count 为正数时返回不重复的 member，不修改 Set
+	members, err := rds.SRandMember(utils.GetTestKey(1), 4)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(members))
+	assert.Equal(t, 4, distinctCount(members))
+	assert.Subset(t, all, sortedStrings(members))
+	// count 为负数时可以重复
+	members, err = rds.SRandMember(utils.GetTestKey(1), -30)
+	assert.Nil(t, err)
+	assert.Equal(t, 30, len(members))
+	assert.Subset(t, all, sortedStrings(members))
+	size, err := rds.SCard(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(10), size)
+
+	members, err = rds.SRandMember(utils.GetTestKey(1), 0)
+	assert.Nil(t, err)
+	assert.Empty(t, members)
+	members, err = rds.SPop(utils.GetTestKey(1), 0)
+	assert.Nil(t, err)
+	assert.Empty(t, members)
+	members, err = rds.SRandMember(utils.GetTestKey(2), 3)
+	assert.Nil(t, err)
+	assert.Empty(t, members)
+	members, err = rds.SPop(utils.GetTestKey(2), 3)
+	assert.Nil(t, err)
+	assert.Empty(t, members)
+
+	// 弹出的 member 不重复，剩余的 member 和元数据保持一致
+	popped, err := rds.SPop(utils.GetTestKey(1), 3)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, distinctCount(popped))
+	rest, err := rds.SMembers(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, 7, len(rest))
+	assert.Equal(t, all, sortedStrings(append(rest, popped...)))
+	size, err = rds.SCard(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(7), size)
+
+	// 不是 Set 的 key 返回类型错误
+	err = rds.Set(utils.GetTestKey(3), 0, []byte("string"))
+	assert.Nil(t, err)
+	_, err = rds.SPop(utils.GetTestKey(3), 1)
+	assert.Equal(t, ErrWrongTypeOperation, err)
+	_, err = rds.SRandMember(utils.GetTestKey(3), 1)
+	assert.Equal(t, ErrWrongTypeOperation, err)
+	_, _, err = rds.SScan(utils.GetTestKey(3), 0, nil, 0)
+	assert.Equal(t, ErrWrongTypeOperation, err)
+}
+
+// distinctCount 返回不重复的元素数量
+func distinctCount(values [][]byte) int {
+	seen := make(map[string]struct{}, len(values))
+	for _, value := range values {
+		seen[string(value)] = struct{}{}
+	}
+	return len(seen)
+}
+
+func TestRedisDataStructure_SScan(t *testing.T) {
+	rds := newTestRedisDataStructure(t)
+
+	var all []string
+	for i := 0; i < 25; i++ {
+		member := fmt.Sprintf("member-%02d", i)
+		all = append(all, member)
+		_, err := rds.SAdd(utils.GetTestKey(1), []byte(member))
+		assert.Nil(t, err)
+	}
+
+	// 每次遍历 count 个 member，cursor 为 0 时遍历结束
+	var scanned [][]byte
+	var cursor uint64
+	var rounds int
+	for {
+		next, members, err := rds.SScan(utils.GetTestKey(1), cursor, nil, 10)
+		assert.Nil(t, err)
+		scanned = append(scanned, members...)
+		rounds++
+		if next == 0 {
+			break
+		}
+		assert.Equal(t, cursor+10, next)
+		cursor = next
+	}
+	assert.Equal(t, 3, rounds)
+	assert.Equal(t, all, sortedStrings(scanned))
+
+	next, members, err := rds.SScan(utils.GetTestKey(1), 0, []byte("member-1?"), 100)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), next)
+	assert.Equal(t, 10, len(members))
+	next, members, err = rds.SScan(utils.GetTestKey(2), 0, nil, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), next)
+	assert.Empty(t, members)
+}
+
+func TestRedisDataStructure_Set_Concurrent(t *testing.T) {
+	rds := newTestRedisDataStructure(t)
+
+	// 多个客户端同时写入不同的 member，元数据中的数量不会丢失
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				ok, err := rds.SAdd(utils.GetTestKey(1), []byte(fmt.Sprintf("member-%d-%d", g, i)))
+				assert.Nil(t, err)
+				assert.True(t, ok)
+			}
+		}(g)
+	}
+	wg.Wait()
+	size, err := rds.SCard(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(8*200), size)
+
+	// 多个客户端同时弹出，每个 member 只会被弹出一次
+	var mu sync.Mutex
+	popped := make(map[string]int)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				members, err := rds.SPop(utils.GetTestKey(1), 3)
+				assert.Nil(t, err)
+				if len(members) == 0 {
+					return
+				}
+				mu.Lock()
+				for _, member := range members {
+					popped[string(member)]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 8*200, len(popped))
+	for member, n := range popped {
+		assert.Equal(t, 1, n, member)
+	}
+	size, err = rds.SCard(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), size)
+	members, err := rds.SMembers(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Empty(t, members)
+}
+
+func TestRedisDataStructure_SetAlgebra(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("../TestingFile", "bitcask-go-set-algebra")
+	opts.DirPath = dir
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+
+	for _, member := range []string{"a", "b", "c", "d"} {
+		_, err := rds.SAdd(utils.GetTestKey(1), []byte(member))
+		assert.Nil(t, err)
+	}
+	for _, member := range []string{"c", "d", "e"} {
+		_, err := rds.SAdd(utils.GetTestKey(2), []byte(member))
+		assert.Nil(t, err)
+	}
+
+	inter, err := rds.SInter(utils.GetTestKey(1), utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("c"), []byte("d")}, inter)
+	union, err := rds.SUnion(utils.GetTestKey(1), utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d"), []byte("e")}, union)
+	diff, err := rds.SDiff(utils.GetTestKey(1), utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b")}, diff)
+	// 不存在的 key 作为空集合
+	inter, err = rds.SInter(utils.GetTestKey(1), utils.GetTestKey(9))
+	assert.Nil(t, err)
+	assert.Empty(t, inter)
+
+	// 结果覆盖 destination 原有的数据
+	_, err = rds.SAdd(utils.GetTestKey(3), []byte("old"))
+	assert.Nil(t, err)
+	n, err := rds.SInterStore(utils.GetTestKey(3), utils.GetTestKey(1), utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2), n)
+	members, err := rds.SMembers(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("c"), []byte("d")}, members)
+
+	// destination 也可以是参与计算的 key
+	n, err = rds.SUnionStore(utils.GetTestKey(1), utils.GetTestKey(1), utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(5), n)
+	size, err := rds.SCard(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(5), size)
+
+	// 结果为空时删除 destination
+	n, err = rds.SDiffStore(utils.GetTestKey(3), utils.GetTestKey(2), utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), n)
+	_, err = rds.Type(utils.GetTestKey(3))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+
+	err = rds.Set(utils.GetTestKey(4), 0, []byte("string"))
+	assert.Nil(t, err)
+	_, err = rds.SUnion(utils.GetTestKey(1), utils.GetTestKey(4))
+	assert.Equal(t, ErrWrongTypeOperation, err)
+}
+
 func TestRedisDataStructure_List_LPop(t *testing.T) {
 	opts := bitcask.DefaultOptions
 	dir, _ := os.MkdirTemp("../TestingFile", "bitcask-go-List-Lpop")
@@ -294,6 +1042,128 @@ func TestRedisDataStructure_List_RPop(t *testing.T) {
 	assert.Equal(t, val, []byte("val-1"))
 }
 
+func TestRedisDataStructure_List_LRange(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("../TestingFile", "bitcask-go-list-lrange")
+	opts.DirPath = dir
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+
+	res, err := rds.LRange(utils.GetTestKey(1), 0, -1)
+	assert.Nil(t, err)
+	assert.Empty(t, res)
+
+	for _, e := range []string{"c", "b", "a"} {
+		_, err := rds.LPush(utils.GetTestKey(1), []byte(e))
+		assert.Nil(t, err)
+	}
+	for _, e := range []string{"d", "e"} {
+		_, err := rds.RPush(utils.GetTestKey(1), []byte(e))
+		assert.Nil(t, err)
+	}
+
+	size, err := rds.LLen(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(5), size)
+	res, err = rds.LRange(utils.GetTestKey(1), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d"), []byte("e")}, res)
+	res, err = rds.LRange(utils.GetTestKey(1), -2, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("d"), []byte("e")}, res)
+	res, err = rds.LRange(utils.GetTestKey(1), 3, 1)
+	assert.Nil(t, err)
+	assert.Empty(t, res)
+
+	e1, err := rds.LIndex(utils.GetTestKey(1), -1)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("e"), e1)
+	e2, err := rds.LIndex(utils.GetTestKey(1), 5)
+	assert.Nil(t, err)
+	assert.Nil(t, e2)
+
+	err = rds.LSet(utils.GetTestKey(1), 1, []byte("B"))
+	assert.Nil(t, err)
+	err = rds.LSet(utils.GetTestKey(1), 10, []byte("B"))
+	assert.Equal(t, ErrIndexOutOfRange, err)
+	err = rds.LSet(utils.GetTestKey(2), 0, []byte("B"))
+	assert.Equal(t, ErrNoSuchKey, err)
+
+	err = rds.LTrim(utils.GetTestKey(1), 1, -2)
+	assert.Nil(t, err)
+	res, err = rds.LRange(utils.GetTestKey(1), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("B"), []byte("c"), []byte("d")}, res)
+	// 弹出的元素不再可见
+	e3, err := rds.LPop(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("B"), e3)
+
+	err = rds.LTrim(utils.GetTestKey(1), 5, 10)
+	assert.Nil(t, err)
+	size, err = rds.LLen(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), size)
+}
+
+func TestRedisDataStructure_List_LInsert_LRem(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("../TestingFile", "bitcask-go-list-linsert")
+	opts.DirPath = dir
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+
+	n, err := rds.LInsert(utils.GetTestKey(1), true, []byte("a"), []byte("x"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), n)
+
+	for _, e := range []string{"a", "b", "c", "d"} {
+		_, err := rds.RPush(utils.GetTestKey(1), []byte(e))
+		assert.Nil(t, err)
+	}
+	n, err = rds.LInsert(utils.GetTestKey(1), true, []byte("not-exist"), []byte("x"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-1), n)
+
+	// 分别在靠近头部和尾部的位置插入，移动不同一侧的元素
+	n, err = rds.LInsert(utils.GetTestKey(1), false, []byte("a"), []byte("x"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), n)
+	n, err = rds.LInsert(utils.GetTestKey(1), true, []byte("d"), []byte("x"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), n)
+	n, err = rds.LInsert(utils.GetTestKey(1), true, []byte("a"), []byte("x"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), n)
+	res, err := rds.LRange(utils.GetTestKey(1), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("x"), []byte("a"), []byte("x"), []byte("b"), []byte("c"), []byte("x"), []byte("d")}, res)
+
+	removed, err := rds.LRem(utils.GetTestKey(1), -1, []byte("x"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), removed)
+	res, err = rds.LRange(utils.GetTestKey(1), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("x"), []byte("a"), []byte("x"), []byte("b"), []byte("c"), []byte("d")}, res)
+
+	removed, err = rds.LRem(utils.GetTestKey(1), 0, []byte("x"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2), removed)
+	res, err = rds.LRange(utils.GetTestKey(1), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d")}, res)
+
+	// 紧凑之后两端仍然可以正常写入和弹出
+	_, err = rds.RPush(utils.GetTestKey(1), []byte("e"))
+	assert.Nil(t, err)
+	e, err := rds.RPop(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("e"), e)
+	e, err = rds.RPop(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("d"), e)
+}
+
 func TestRedisDataStructure_ZScore(t *testing.T) {
 	opts := bitcask.DefaultOptions
 	dir, _ := os.MkdirTemp("../TestingFile", "bitcask-go-zset")
@@ -326,3 +1196,160 @@ func TestRedisDataStructure_ZScore(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, float64(998), val)
 }
+
+func TestRedisDataStructure_ZRange(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("../TestingFile", "bitcask-go-zset-range")
+	opts.DirPath = dir
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+
+	// 包括负数的 score 按照数值排序
+	scores := map[string]float64{"a": -10.5, "b": -2, "c": 0, "d": 1.5, "e": 30, "f": 30}
+	for member, score := range scores {
+		_, err := rds.ZAdd(utils.GetTestKey(1), score, []byte(member))
+		assert.Nil(t, err)
+	}
+	_, err = rds.ZAdd(utils.GetTestKey(2), 1, []byte("other"))
+	assert.Nil(t, err)
+
+	size, err := rds.ZCard(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(6), size)
+
+	res, err := rds.ZRange(utils.GetTestKey(1), 0, -1, false)
+	assert.Nil(t, err)
+	var members []string
+	for _, zm := range res {
+		members = append(members, string(zm.Member))
+		assert.Equal(t, scores[string(zm.Member)], zm.Score)
+	}
+	assert.Equal(t, []string{"a", "b", "c", "d", "e", "f"}, members)
+
+	res, err = rds.ZRange(utils.GetTestKey(1), 0, 1, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []ZMember{{Member: []byte("f"), Score: 30}, {Member: []byte("e"), Score: 30}}, res)
+	res, err = rds.ZRange(utils.GetTestKey(1), -2, -2, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []ZMember{{Member: []byte("e"), Score: 30}}, res)
+
+	rank, ok, err := rds.ZRank(utils.GetTestKey(1), []byte("b"), false)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), rank)
+	rank, ok, err = rds.ZRank(utils.GetTestKey(1), []byte("b"), true)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(4), rank)
+	_, ok, err = rds.ZRank(utils.GetTestKey(1), []byte("not-exist"), false)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// 更新 score 之后排序随之改变
+	_, err = rds.ZAdd(utils.GetTestKey(1), -100, []byte("f"))
+	assert.Nil(t, err)
+	res, err = rds.ZRange(utils.GetTestKey(1), 0, 0, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []ZMember{{Member: []byte("f"), Score: -100}}, res)
+	score, err := rds.ZIncrBy(utils.GetTestKey(1), 200, []byte("f"))
+	assert.Nil(t, err)
+	assert.Equal(t, float64(100), score)
+	res, err = rds.ZRange(utils.GetTestKey(1), -1, -1, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []ZMember{{Member: []byte("f"), Score: 100}}, res)
+	score, err = rds.ZIncrBy(utils.GetTestKey(1), 1, []byte("g"))
+	assert.Nil(t, err)
+	assert.Equal(t, float64(1), score)
+
+	removed, err := rds.ZRem(utils.GetTestKey(1), []byte("a"), []byte("g"), []byte("not-exist"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2), removed)
+	res, err = rds.ZRange(utils.GetTestKey(1), 0, -1, false)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(res))
+	assert.Equal(t, []byte("b"), res[0].Member)
+	_, err = rds.ZScore(utils.GetTestKey(1), []byte("a"))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+}
+
+func TestRedisDataStructure_ZIncrBy_Concurrent(t *testing.T) {
+	rds := newTestRedisDataStructure(t)
+
+	// 多个客户端同时修改同一个 member 的 score，加法不会丢失，也不会留下旧的按 score 排序的 key
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				_, err := rds.ZIncrBy(utils.GetTestKey(1), 1, []byte("count"))
+				assert.Nil(t, err)
+				_, err = rds.ZAdd(utils.GetTestKey(1), float64(g*200+i), []byte("other"))
+				assert.Nil(t, err)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	score, err := rds.ZScore(utils.GetTestKey(1), []byte("count"))
+	assert.Nil(t, err)
+	assert.Equal(t, float64(1600), score)
+	size, err := rds.ZCard(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2), size)
+	res, err := rds.ZRange(utils.GetTestKey(1), 0, -1, false)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(res))
+}
+
+func TestRedisDataStructure_ZRangeByScore(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("../TestingFile", "bitcask-go-zset-range-score")
+	opts.DirPath = dir
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+
+	for i := -5; i <= 5; i++ {
+		_, err := rds.ZAdd(utils.GetTestKey(1), float64(i), []byte(fmt.Sprintf("m%d", i)))
+		assert.Nil(t, err)
+	}
+
+	scoresOf := func(res []ZMember) []float64 {
+		var scores []float64
+		for _, zm := range res {
+			scores = append(scores, zm.Score)
+		}
+		return scores
+	}
+
+	res, err := rds.ZRangeByScore(utils.GetTestKey(1), ScoreBound{Score: -2}, ScoreBound{Score: 1}, 0, -1, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{-2, -1, 0, 1}, scoresOf(res))
+	res, err = rds.ZRangeByScore(utils.GetTestKey(1), ScoreBound{Score: -2, Exclusive: true}, ScoreBound{Score: 1, Exclusive: true}, 0, -1, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{-1, 0}, scoresOf(res))
+	res, err = rds.ZRangeByScore(utils.GetTestKey(1), ScoreBound{Score: -2}, ScoreBound{Score: 1}, 0, -1, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{1, 0, -1, -2}, scoresOf(res))
+	res, err = rds.ZRangeByScore(utils.GetTestKey(1), ScoreBound{Score: -2, Exclusive: true}, ScoreBound{Score: 1, Exclusive: true}, 0, -1, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{0, -1}, scoresOf(res))
+
+	// LIMIT
+	res, err = rds.ZRangeByScore(utils.GetTestKey(1), ScoreBound{Score: math.Inf(-1)}, ScoreBound{Score: math.Inf(1)}, 2, 3, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{-3, -2, -1}, scoresOf(res))
+	res, err = rds.ZRangeByScore(utils.GetTestKey(1), ScoreBound{Score: math.Inf(-1)}, ScoreBound{Score: math.Inf(1)}, 1, 2, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{4, 3}, scoresOf(res))
+	res, err = rds.ZRangeByScore(utils.GetTestKey(1), ScoreBound{Score: 10}, ScoreBound{Score: 20}, 0, -1, false)
+	assert.Nil(t, err)
+	assert.Empty(t, res)
+
+	count, err := rds.ZCount(utils.GetTestKey(1), ScoreBound{Score: 0, Exclusive: true}, ScoreBound{Score: math.Inf(1)})
+	assert.Nil(t, err)
+	assert.Equal(t, 5, count)
+	count, err = rds.ZCount(utils.GetTestKey(2), ScoreBound{Score: 0}, ScoreBound{Score: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+}
@@ -0,0 +1,162 @@
+package redis
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// listWaiter 一个阻塞在若干个列表上的操作
+type listWaiter struct {
+	keys  [][]byte
+	ready chan struct{} // 列表有新元素时收到通知，容量为 1，多次通知只保留一次
+}
+
+// listWaiters 每个 key 上按照阻塞的先后顺序排队的操作
+// 只有队列头部的操作可以弹出元素，写入元素时唤醒队列头部的操作，它离开队列后再唤醒下一个，保证先阻塞的先得到元素
+// 被唤醒的操作没有弹出元素时重新等待，并保留在队列头部
+type listWaiters struct {
+	queues map[string][]*listWaiter
+	lock   *sync.Mutex
+}
+
+func newListWaiters() *listWaiters {
+	return &listWaiters{
+		queues: make(map[string][]*listWaiter),
+		lock:   new(sync.Mutex),
+	}
+}
+
+func (lw *listWaiters) add(w *listWaiter) {
+	lw.lock.Lock()
+	defer lw.lock.Unlock()
+	for _, key := range w.keys {
+		lw.queues[string(key)] = append(lw.queues[string(key)], w)
+	}
+}
+
+func (lw *listWaiters) remove(w *listWaiter) {
+	lw.lock.Lock()
+	defer lw.lock.Unlock()
+	for _, key := range w.keys {
+		queue := lw.queues[string(key)]
+		for i, other := range queue {
+			if other == w {
+				queue = append(queue[:i], queue[i+1:]...)
+				break
+			}
+		}
+		if len(queue) == 0 {
+			delete(lw.queues, string(key))
+		} else {
+			lw.queues[string(key)] = queue
+		}
+	}
+}
+
+// isHead w 是否在 key 的队列头部，只有队列头部的操作可以从这个 key 弹出元素
+func (lw *listWaiters) isHead(w *listWaiter, key []byte) bool {
+	lw.lock.Lock()
+	defer lw.lock.Unlock()
+	queue := lw.queues[string(key)]
+	return len(queue) > 0 && queue[0] == w
+}
+
+// notify key 上写入了新元素，唤醒队列头部的操作
+func (lw *listWaiters) notify(key []byte) {
+	lw.lock.Lock()
+	defer lw.lock.Unlock()
+	if queue := lw.queues[string(key)]; len(queue) > 0 {
+		select {
+		case queue[0].ready <- struct{}{}:
+		default:
+			// 已经被通知过了，还没有处理
+		}
+	}
+}
+
+// BLPop 从第一个不为空的列表头部弹出元素，所有列表都为空时阻塞，直到有新元素或者超时
+// timeout 为 0 时一直阻塞，超时返回的 key 和元素都为 nil
+// ctx 取消时（例如客户端断开连接）离开等待队列并返回 ctx.Err()，不会再弹出元素
+func (rds *RedisDataStructure) BLPop(ctx context.Context, keys [][]byte, timeout time.Duration) ([]byte, []byte, error) {
+	return rds.blockingPop(ctx, keys, true, timeout)
+}
+
+// BRPop 从第一个不为空的列表尾部弹出元素，和 BLPop 一样阻塞
+func (rds *RedisDataStructure) BRPop(ctx context.Context, keys [][]byte, timeout time.Duration) ([]byte, []byte, error) {
+	return rds.blockingPop(ctx, keys, false, timeout)
+}
+
+// BLMove LMove 的阻塞版本，source 为空时阻塞，直到有新元素或者超时，超时返回 nil
+func (rds *RedisDataStructure) BLMove(ctx context.Context, source, destination []byte, srcLeft, dstLeft bool, timeout time.Duration) ([]byte, error) {
+	var element []byte
+	err := rds.block(ctx, [][]byte{source}, timeout, func(w *listWaiter) (bool, error) {
+		if !rds.waiters.isHead(w, source) {
+			return false, nil
+		}
+		var ok bool
+		var err error
+		element, ok, err = rds.lmove(source, destination, srcLeft, dstLeft)
+		return ok, err
+	})
+	return element, err
+}
+
+func (rds *RedisDataStructure) blockingPop(ctx context.Context, keys [][]byte, isLeft bool, timeout time.Duration) ([]byte, []byte, error) {
+	var key, element []byte
+	err := rds.block(ctx, keys, timeout, func(w *listWaiter) (bool, error) {
+		for _, k := range keys {
+			if !rds.waiters.isHead(w, k) {
+				continue
+			}
+			e, ok, err := rds.popInner(k, isLeft)
+			if err != nil || ok {
+				key, element = k, e
+				return ok, err
+			}
+		}
+		return false, nil
+	})
+	return key, element, err
+}
+
+// block 反复执行 try，直到成功、出错、超时或者 ctx 被取消
+// 先加入等待队列再执行 try，避免错过两者之间写入的元素
+func (rds *RedisDataStructure) block(ctx context.Context, keys [][]byte, timeout time.Duration, try func(w *listWaiter) (bool, error)) error {
+	w := &listWaiter{keys: keys, ready: make(chan struct{}, 1)}
+	rds.waiters.add(w)
+	defer rds.leave(w)
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for {
+		// 被唤醒时客户端可能已经断开，弹出的元素没有人接收，直接离开队列让给后面的操作
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if ok, err := try(w); ok || err != nil {
+			return err
+		}
+		select {
+		case <-w.ready:
+		case <-expired:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// leave 离开等待队列，唤醒后面等待的操作，包括没有处理的通知
+func (rds *RedisDataStructure) leave(w *listWaiter) {
+	rds.waiters.remove(w)
+	for _, key := range w.keys {
+		if size, err := rds.LLen(key); err == nil && size > 0 {
+			rds.waiters.notify(key)
+		}
+	}
+}
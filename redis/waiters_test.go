@@ -0,0 +1,201 @@
+package redis
+
+import (
+	bitcask "SingleKVDataSet"
+	"SingleKVDataSet/utils"
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestRedisDataStructure(t *testing.T) *RedisDataStructure {
+	opts := bitcask.DefaultOptions
+	opts.DirPath = t.TempDir()
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		_ = rds.Close()
+	})
+	return rds
+}
+
+// waitQueueLen 等待 key 上排队的操作数量达到 n
+func waitQueueLen(rds *RedisDataStructure, key []byte, n int) {
+	for {
+		rds.waiters.lock.Lock()
+		l := len(rds.waiters.queues[string(key)])
+		rds.waiters.lock.Unlock()
+		if l >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRedisDataStructure_BLPop(t *testing.T) {
+	rds := newTestRedisDataStructure(t)
+
+	// 有元素时直接返回，按照 key 的顺序查找
+	_, err := rds.RPush(utils.GetTestKey(2), []byte("a"))
+	assert.Nil(t, err)
+	key, element, err := rds.BLPop(context.Background(), [][]byte{utils.GetTestKey(1), utils.GetTestKey(2)}, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(2), key)
+	assert.Equal(t, []byte("a"), element)
+
+	// 超时返回 nil
+	start := time.Now()
+	key, element, err = rds.BRPop(context.Background(), [][]byte{utils.GetTestKey(1)}, 50*time.Millisecond)
+	assert.Nil(t, err)
+	assert.Nil(t, key)
+	assert.Nil(t, element)
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+	assert.Equal(t, 0, len(rds.waiters.queues))
+
+	// 阻塞直到写入新元素，timeout 为 0 时一直阻塞
+	done := make(chan []byte)
+	go func() {
+		_, element, err := rds.BLPop(context.Background(), [][]byte{utils.GetTestKey(1), utils.GetTestKey(3)}, 0)
+		assert.Nil(t, err)
+		done <- element
+	}()
+	waitQueueLen(rds, utils.GetTestKey(3), 1)
+	_, err = rds.LPush(utils.GetTestKey(3), []byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), <-done)
+
+	size, err := rds.LLen(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), size)
+}
+
+func TestRedisDataStructure_BLPop_FIFO(t *testing.T) {
+	rds := newTestRedisDataStructure(t)
+
+	// 先阻塞的操作先得到元素
+	results := make([]chan []byte, 5)
+	for i := range results {
+		results[i] = make(chan []byte, 1)
+		go func(i int) {
+			_, element, err := rds.BLPop(context.Background(), [][]byte{utils.GetTestKey(1)}, 5*time.Second)
+			assert.Nil(t, err)
+			results[i] <- element
+		}(i)
+		waitQueueLen(rds, utils.GetTestKey(1), i+1)
+	}
+	for i := range results {
+		_, err := rds.RPush(utils.GetTestKey(1), []byte(fmt.Sprintf("element-%d", i)))
+		assert.Nil(t, err)
+	}
+	for i := range results {
+		assert.Equal(t, []byte(fmt.Sprintf("element-%d", i)), <-results[i])
+	}
+}
+
+func TestRedisDataStructure_BLPop_Cancel(t *testing.T) {
+	rds := newTestRedisDataStructure(t)
+
+	// ctx 取消之后离开等待队列，元素留给后面的操作
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := rds.BLPop(ctx, [][]byte{utils.GetTestKey(1)}, 0)
+		errs <- err
+	}()
+	waitQueueLen(rds, utils.GetTestKey(1), 1)
+	cancel()
+	assert.Equal(t, context.Canceled, <-errs)
+	rds.waiters.lock.Lock()
+	assert.Equal(t, 0, len(rds.waiters.queues[string(utils.GetTestKey(1))]))
+	rds.waiters.lock.Unlock()
+
+	_, err := rds.RPush(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	_, element, err := rds.BLPop(context.Background(), [][]byte{utils.GetTestKey(1)}, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), element)
+}
+
+func TestRedisDataStructure_BLPop_Concurrent(t *testing.T) {
+	rds := newTestRedisDataStructure(t)
+
+	// 多个消费者同时弹出，每个元素只会被弹出一次
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	received := make(map[string]int)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				_, element, err := rds.BRPop(context.Background(), [][]byte{utils.GetTestKey(1)}, 200*time.Millisecond)
+				assert.Nil(t, err)
+				if element == nil {
+					return
+				}
+				lock.Lock()
+				received[string(element)]++
+				lock.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < 500; i++ {
+		_, err := rds.LPush(utils.GetTestKey(1), []byte(fmt.Sprintf("element-%d", i)))
+		assert.Nil(t, err)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 500, len(received))
+	for element, n := range received {
+		assert.Equal(t, 1, n, element)
+	}
+}
+
+func TestRedisDataStructure_BLMove(t *testing.T) {
+	rds := newTestRedisDataStructure(t)
+
+	res, err := rds.BLMove(context.Background(), utils.GetTestKey(1), utils.GetTestKey(2), true, false, 10*time.Millisecond)
+	assert.Nil(t, err)
+	assert.Nil(t, res)
+
+	done := make(chan []byte)
+	go func() {
+		res, err := rds.BLMove(context.Background(), utils.GetTestKey(1), utils.GetTestKey(2), true, false, 5*time.Second)
+		assert.Nil(t, err)
+		done <- res
+	}()
+	waitQueueLen(rds, utils.GetTestKey(1), 1)
+	_, err = rds.RPush(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	_, err = rds.RPush(utils.GetTestKey(1), []byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), <-done)
+
+	elements, err := rds.LRange(utils.GetTestKey(2), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a")}, elements)
+
+	// 源和目标相同时轮转列表
+	_, err = rds.RPush(utils.GetTestKey(1), []byte("c"))
+	assert.Nil(t, err)
+	res, err = rds.LMove(utils.GetTestKey(1), utils.GetTestKey(1), true, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), res)
+	elements, err = rds.LRange(utils.GetTestKey(1), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("c"), []byte("b")}, elements)
+
+	// 等待目标列表的操作被 LMove 唤醒
+	go func() {
+		_, element, err := rds.BLPop(context.Background(), [][]byte{utils.GetTestKey(3)}, 5*time.Second)
+		assert.Nil(t, err)
+		done <- element
+	}()
+	waitQueueLen(rds, utils.GetTestKey(3), 1)
+	_, err = rds.LMove(utils.GetTestKey(1), utils.GetTestKey(3), false, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), <-done)
+}
@@ -1,6 +1,10 @@
 package utils
 
-import "strconv"
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+)
 
 func FloatFromBytes(val []byte) float64 {
 	f, _ := strconv.ParseFloat(string(val), 64)
@@ -10,3 +14,31 @@ func FloatFromBytes(val []byte) float64 {
 func Float64ToBytes(val float64) []byte {
 	return []byte(strconv.FormatFloat(val, 'f', -1, 64))
 }
+
+// Float64ToOrderedBytes 将浮点数编码为 8 字节，编码结果按字节比较的顺序和数值顺序相同，负数也是如此
+// 正数将符号位置 1，负数将所有位取反，-0 和 0 编码为相同的结果
+func Float64ToOrderedBytes(val float64) []byte {
+	if val == 0 {
+		val = 0
+	}
+	bits := math.Float64bits(val)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, bits)
+	return buf
+}
+
+// FloatFromOrderedBytes 解码 Float64ToOrderedBytes 编码的浮点数
+func FloatFromOrderedBytes(buf []byte) float64 {
+	bits := binary.BigEndian.Uint64(buf)
+	if bits&(1<<63) != 0 {
+		bits &^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits)
+}
@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"sort"
+	"testing"
+)
+
+func TestFloat64ToOrderedBytes(t *testing.T) {
+	values := []float64{math.Inf(-1), -math.MaxFloat64, -1e10, -2.5, -1, -math.SmallestNonzeroFloat64, 0, math.SmallestNonzeroFloat64, 0.5, 1, 3.75, 1e10, math.MaxFloat64, math.Inf(1)}
+	encoded := make([][]byte, len(values))
+	for i, v := range values {
+		encoded[i] = Float64ToOrderedBytes(v)
+		assert.Equal(t, 8, len(encoded[i]))
+		assert.Equal(t, v, FloatFromOrderedBytes(encoded[i]))
+	}
+	// 编码结果的字节顺序和数值顺序相同
+	assert.True(t, sort.SliceIsSorted(encoded, func(i, j int) bool {
+		return bytes.Compare(encoded[i], encoded[j]) < 0
+	}))
+	for i := 1; i < len(encoded); i++ {
+		assert.True(t, bytes.Compare(encoded[i-1], encoded[i]) < 0, "%v %v", values[i-1], values[i])
+	}
+
+	// -0 和 0 编码结果相同
+	assert.Equal(t, Float64ToOrderedBytes(0), Float64ToOrderedBytes(math.Copysign(0, -1)))
+}
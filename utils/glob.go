@@ -0,0 +1,92 @@
+package utils
+
+// GlobMatch 判断 str 是否匹配 Redis 风格的 glob 模式，用于 SCAN 和 KEYS 等命令的 MATCH 参数
+// 支持 * ? [abc] [^abc] [a-z] 以及使用 \ 转义特殊字符
+func GlobMatch(pattern, str []byte) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			// 连续的 * 等价于一个
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(str); i++ {
+				if GlobMatch(pattern[1:], str[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(str) == 0 {
+				return false
+			}
+			str = str[1:]
+		case '[':
+			if len(str) == 0 {
+				return false
+			}
+			var matched bool
+			matched, pattern = matchClass(pattern[1:], str[0])
+			if !matched {
+				return false
+			}
+			str = str[1:]
+			continue
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(str) == 0 || pattern[0] != str[0] {
+				return false
+			}
+			str = str[1:]
+		}
+		pattern = pattern[1:]
+	}
+	return len(str) == 0
+}
+
+// matchClass 匹配 [...] 字符集合，pattern 从 [ 之后开始，返回是否匹配和 ] 之后剩余的模式
+func matchClass(pattern []byte, c byte) (bool, []byte) {
+	not := len(pattern) > 0 && pattern[0] == '^'
+	if not {
+		pattern = pattern[1:]
+	}
+	var matched bool
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) >= 2:
+			if pattern[1] == c {
+				matched = true
+			}
+			pattern = pattern[2:]
+		case len(pattern) >= 3 && pattern[1] == '-' && pattern[2] != ']':
+			start, end := pattern[0], pattern[2]
+			if start > end {
+				start, end = end, start
+			}
+			if c >= start && c <= end {
+				matched = true
+			}
+			pattern = pattern[3:]
+		default:
+			if pattern[0] == c {
+				matched = true
+			}
+			pattern = pattern[1:]
+		}
+	}
+	// 没有闭合的 ] 时，和 Redis 一样把模式的结尾当作集合的结尾
+	if len(pattern) > 0 {
+		pattern = pattern[1:]
+	}
+	if not {
+		matched = !matched
+	}
+	return matched, pattern
+}